@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- Agencies ---
+// An agency account invites agents by email; an agent signed in with that address accepts the
+// invitation and becomes a member (agency_members). An agent belongs to at most one agency. The
+// agency can then read its members' clients, policies, tasks and commissions through
+// /api/agency/members/{agentId}/..., but not change them. When an agent leaves or is removed, the
+// agency's departure policy decides what happens to their book: "keep" leaves it with the agent,
+// "transfer" moves every client, with its policies, tasks, communications, documents and AI
+// drafts, to the agency account or to another member the agency names.
+
+const (
+	agencyInvitationTTL     = 14 * 24 * time.Hour
+	departurePolicyKeep     = "keep"
+	departurePolicyTransfer = "transfer"
+)
+
+// Invitation statuses. "expired" is never stored; it is reported for pending invitations past
+// their expiry.
+const (
+	invitationPending  = "pending"
+	invitationAccepted = "accepted"
+	invitationDeclined = "declined"
+	invitationRevoked  = "revoked"
+	invitationExpired  = "expired"
+)
+
+// errInvalidTransferTarget is returned when a departing agent's book is to go to someone other
+// than another member of the same agency.
+var errInvalidTransferTarget = errors.New("transfer target is not another member of the agency")
+
+type agencyInvitationEmailData struct {
+	AgencyName string
+	Link       string
+	ExpiresIn  string
+}
+
+// normalizeInvitationEmail is how invitation addresses are stored and matched.
+func normalizeInvitationEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// agencyDisplayName returns the agency's name from its profile, or its email address.
+func agencyDisplayName(agencyUserID int64) string {
+	if profile, err := stores.Agents.GetProfile(agencyUserID); err == nil && profile.AgencyName.Valid && profile.AgencyName.String != "" {
+		return profile.AgencyName.String
+	}
+	if user, err := stores.Users.GetByID(agencyUserID); err == nil {
+		return user.Email
+	}
+	return "An agency"
+}
+
+func sendAgencyInvitationEmail(email string, agencyUserID int64) error {
+	link, err := url.JoinPath(config.FrontendURL, "agency-invitations")
+	if err != nil {
+		return fmt.Errorf("failed to build invitation link: %w", err)
+	}
+	_, err = queueTemplatedEmail(0, []string{email}, "agency_invitation", agencyInvitationEmailData{
+		AgencyName: agencyDisplayName(agencyUserID),
+		Link:       link,
+		ExpiresIn:  "14 days",
+	})
+	return err
+}
+
+// withInvitationStatus reports pending invitations past their expiry as expired.
+func withInvitationStatus(invitations []AgencyInvitation, now time.Time) []AgencyInvitation {
+	for i := range invitations {
+		if invitations[i].Status == invitationPending && !invitations[i].ExpiresAt.After(now) {
+			invitations[i].Status = invitationExpired
+		}
+	}
+	return invitations
+}
+
+// departureTransferTarget decides who receives a departing agent's book under the agency's
+// policy: 0 to leave it with the agent, otherwise transferTo if the agency named a member, or the
+// agency account itself.
+func departureTransferTarget(agencyUserID, agentUserID, transferTo int64) (int64, error) {
+	settings, err := stores.Agencies.GetSettings(agencyUserID)
+	if err != nil {
+		return 0, err
+	}
+	if settings.DeparturePolicy != departurePolicyTransfer {
+		return 0, nil
+	}
+	if transferTo == 0 {
+		return agencyUserID, nil
+	}
+	if transferTo == agentUserID {
+		return 0, errInvalidTransferTarget
+	}
+	member, err := stores.Agencies.AgencyOf(transferTo)
+	if err == sql.ErrNoRows || (err == nil && member.AgencyUserID != agencyUserID) {
+		return 0, errInvalidTransferTarget
+	}
+	if err != nil {
+		return 0, err
+	}
+	return transferTo, nil
+}
+
+// removeAgencyMember takes agentUserID out of the agency, moving their book per the departure
+// policy, and returns who received it (0 if the agent kept it).
+func removeAgencyMember(agencyUserID, agentUserID, transferTo int64) (int64, error) {
+	target, err := departureTransferTarget(agencyUserID, agentUserID, transferTo)
+	if err != nil {
+		return 0, err
+	}
+	if err := stores.Agencies.RemoveMember(agencyUserID, agentUserID, target); err != nil {
+		return 0, err
+	}
+	if target != 0 {
+		log.Printf("AGENCY: Agent %d left agency %d; clients transferred to user %d", agentUserID, agencyUserID, target)
+		logActivity(agentUserID, "agency_left", fmt.Sprintf("Left agency; clients transferred to user %d", target), "")
+	} else {
+		log.Printf("AGENCY: Agent %d left agency %d and kept their clients", agentUserID, agencyUserID)
+		logActivity(agentUserID, "agency_left", "Left agency; clients kept", "")
+	}
+	logActivity(agencyUserID, "agency_member_left", fmt.Sprintf("Agent %d left the agency", agentUserID), fmt.Sprintf("%d", agentUserID))
+	return target, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAgencyMembership(t *testing.T) {
+	s := newTestServer(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret-pass"), bcrypt.MinCost)
+	seed := func(email, userType string) (int64, string) {
+		id, err := stores.Users.Create(User{Email: email, PasswordHash: string(hash), UserType: userType, IsVerified: true})
+		if err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+		return id, tokenFor(t, id, userType)
+	}
+	agencyID, agency := seed("agency@example.com", "agency")
+	_, otherAgency := seed("other-agency@example.com", "agency")
+	agentID, agent := seed("agent@example.com", "agent")
+	colleagueID, colleague := seed("colleague@example.com", "agent")
+	client := s.createClient(agent, map[string]interface{}{"name": "Asha Rao", "email": "asha@example.com"})
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/tasks", client.ID), agent, map[string]string{"description": "Call back"}, http.StatusCreated, nil)
+
+	// Agents can't use the agency endpoints, and an agency sees nothing of non-members.
+	s.doJSON(http.MethodPost, "/api/agency/invitations", agent, map[string]string{"email": "colleague@example.com"}, http.StatusForbidden, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/agency/members/%d/clients", agentID), agency, nil, http.StatusNotFound, nil)
+
+	// Invite, then accept; a second pending invitation to the same address is refused.
+	var inv AgencyInvitation
+	s.doJSON(http.MethodPost, "/api/agency/invitations", agency, map[string]string{"email": " Agent@Example.com "}, http.StatusCreated, &inv)
+	if inv.Email != "agent@example.com" || inv.Status != invitationPending {
+		t.Fatalf("invitation = %+v", inv)
+	}
+	s.doJSON(http.MethodPost, "/api/agency/invitations", agency, map[string]string{"email": "agent@example.com"}, http.StatusConflict, nil)
+	var mine []AgencyInvitation
+	s.doJSON(http.MethodGet, "/api/agents/agency-invitations", agent, nil, http.StatusOK, &mine)
+	if len(mine) != 1 || mine[0].AgencyEmail != "agency@example.com" {
+		t.Fatalf("agent's invitations = %+v", mine)
+	}
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/agents/agency-invitations/%d/accept", inv.ID), colleague, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/agents/agency-invitations/%d/accept", inv.ID), agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/agents/agency-invitations/%d/accept", inv.ID), agent, nil, http.StatusNotFound, nil)
+
+	// An agent belongs to one agency at a time.
+	var other AgencyInvitation
+	s.doJSON(http.MethodPost, "/api/agency/invitations", otherAgency, map[string]string{"email": "agent@example.com"}, http.StatusCreated, &other)
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/agents/agency-invitations/%d/accept", other.ID), agent, nil, http.StatusConflict, nil)
+
+	// The agency reads the member's book but can't change it.
+	var members []AgencyMember
+	s.doJSON(http.MethodGet, "/api/agency/members", agency, nil, http.StatusOK, &members)
+	if len(members) != 1 || members[0].AgentUserID != agentID || members[0].Email != "agent@example.com" {
+		t.Fatalf("members = %+v", members)
+	}
+	var clients []Client
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/agency/members/%d/clients", agentID), agency, nil, http.StatusOK, &clients)
+	if len(clients) != 1 || clients[0].ID != client.ID {
+		t.Fatalf("member clients = %+v", clients)
+	}
+	var tasks PaginatedResponse
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/agency/members/%d/tasks", agentID), agency, nil, http.StatusOK, &tasks)
+	if tasks.TotalItems != 1 {
+		t.Fatalf("member tasks = %+v", tasks)
+	}
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/agency/members/%d/commissions", agentID), agency, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/agency/members/%d/clients", agentID), otherAgency, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodPut, fmt.Sprintf("/api/clients/%d", client.ID), agency, map[string]interface{}{"name": "Changed"}, http.StatusNotFound, nil)
+
+	// Under the default "keep" policy a departing agent keeps their clients.
+	s.doJSON(http.MethodPost, "/api/agents/agency/leave", agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, "/api/agents/agency", agent, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodGet, "/api/clients", agent, nil, http.StatusOK, &clients)
+	if len(clients) != 1 {
+		t.Fatalf("agent kept %d clients, want 1", len(clients))
+	}
+
+	// Under "transfer", removing a member moves their book to the member the agency names.
+	for _, id := range []int64{agentID, colleagueID} {
+		if err := stores.Agencies.AddMember(agencyID, id); err != nil {
+			t.Fatalf("add member: %v", err)
+		}
+	}
+	s.doJSON(http.MethodPut, "/api/agency/settings", agency, map[string]string{"departurePolicy": "sell"}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPut, "/api/agency/settings", agency, map[string]string{"departurePolicy": departurePolicyTransfer}, http.StatusOK, nil)
+	s.doJSON(http.MethodDelete, fmt.Sprintf("/api/agency/members/%d", agentID), agency, map[string]int64{"transferToAgentId": agentID}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodDelete, fmt.Sprintf("/api/agency/members/%d", agentID), agency, map[string]int64{"transferToAgentId": colleagueID}, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, "/api/clients", agent, nil, http.StatusOK, &clients)
+	if len(clients) != 0 {
+		t.Fatalf("departed agent still has %d clients", len(clients))
+	}
+	var transferred []Task
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/tasks", client.ID), colleague, nil, http.StatusOK, &transferred)
+	if len(transferred) != 1 {
+		t.Fatalf("colleague sees tasks %+v, want the transferred one", transferred)
+	}
+	var history []EntityChange
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/history", client.ID), colleague, nil, http.StatusOK, &history)
+	if len(history) == 0 {
+		t.Fatal("the transferred client's history stayed with the departed agent")
+	}
+}
+
+func TestAgencyInvitationExpiry(t *testing.T) {
+	s := newTestServer(t)
+	agencyID, err := stores.Users.Create(User{Email: "agency@example.com", UserType: "agency", IsVerified: true})
+	if err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	agentID, err := stores.Users.Create(User{Email: "agent@example.com", UserType: "agent", IsVerified: true})
+	if err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	id, err := stores.Agencies.CreateInvitation(AgencyInvitation{AgencyUserID: agencyID, Email: "agent@example.com", ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("create invitation: %v", err)
+	}
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/agents/agency-invitations/%d/accept", id), tokenFor(t, agentID, "agent"), nil, http.StatusNotFound, nil)
+	var invitations []AgencyInvitation
+	s.doJSON(http.MethodGet, "/api/agency/invitations", tokenFor(t, agencyID, "agency"), nil, http.StatusOK, &invitations)
+	if len(invitations) != 1 || invitations[0].Status != invitationExpired {
+		t.Fatalf("invitations = %+v", invitations)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// --- AI Client Recommendations ---
+// The portal recommendation is generated once per set of inputs and stored in ai_recommendations,
+// keyed by client and a hash of the profile fields and coverage estimation the prompt uses. Nothing
+// is shown to the client until the agent approves it (optionally after editing). A pinned
+// recommendation keeps being shown after the inputs change; otherwise the portal shows the
+// approved recommendation for the current inputs, or none while a new one awaits review.
+
+// Bump when the prompt changes so every client's recommendation is regenerated.
+const clientRecommendationPromptVersion = "client-recommendation-v1"
+
+// recommendationInputs are the client fields the recommendation depends on.
+type recommendationInputs struct {
+	PromptVersion string             `json:"promptVersion"`
+	Dob           sql.NullString     `json:"dob"`
+	City          sql.NullString     `json:"city"`
+	Income        sql.NullFloat64    `json:"income"`
+	MaritalStatus sql.NullString     `json:"maritalStatus"`
+	JobProfile    sql.NullString     `json:"jobProfile"`
+	Dependents    sql.NullInt64      `json:"dependents"`
+	Liability     sql.NullFloat64    `json:"liability"`
+	HousingType   sql.NullString     `json:"housingType"`
+	VehicleCount  sql.NullInt64      `json:"vehicleCount"`
+	VehicleType   sql.NullString     `json:"vehicleType"`
+	VehicleCost   sql.NullFloat64    `json:"vehicleCost"`
+	Estimation    CoverageEstimation `json:"estimation"`
+}
+
+// recommendationInputHash fingerprints everything that goes into a client's recommendation.
+func recommendationInputHash(client Client, estimation CoverageEstimation) string {
+	inputs, _ := json.Marshal(recommendationInputs{ // Plain values; Marshal cannot fail
+		PromptVersion: clientRecommendationPromptVersion,
+		Dob:           client.Dob,
+		City:          client.City,
+		Income:        client.Income,
+		MaritalStatus: client.MaritalStatus,
+		JobProfile:    client.JobProfile,
+		Dependents:    client.Dependents,
+		Liability:     client.Liability,
+		HousingType:   client.HousingType,
+		VehicleCount:  client.VehicleCount,
+		VehicleType:   client.VehicleType,
+		VehicleCost:   client.VehicleCost,
+		Estimation:    estimation,
+	})
+	sum := sha256.Sum256(inputs)
+	return hex.EncodeToString(sum[:])
+}
+
+// storeAiRecommendation saves a freshly generated recommendation as a draft for review. If another
+// request stored one for the same inputs first, that one is returned instead.
+func storeAiRecommendation(client Client, inputHash string, reply LLMResponse) (*AiRecommendation, error) {
+	id, err := stores.Recommendations.Create(AiRecommendation{
+		ClientID:      client.ID,
+		AgentUserID:   client.AgentUserID,
+		InputHash:     inputHash,
+		GeneratedText: strings.TrimSpace(reply.Text),
+		Model:         reply.Model,
+		PromptVersion: clientRecommendationPromptVersion,
+	})
+	if errors.Is(err, errDuplicateRecord) {
+		return stores.Recommendations.GetByInputHash(client.ID, inputHash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store AI recommendation: %w", err)
+	}
+	log.Printf("AI RECOMMENDATION: Stored draft %d for client %d", id, client.ID)
+	return stores.Recommendations.GetByID(id, client.ID, client.AgentUserID)
+}
+
+// publishedAiRecommendation returns the recommendation the client may see, or nil if the agent
+// hasn't approved one for the current inputs and nothing is pinned.
+func publishedAiRecommendation(client Client, estimation CoverageEstimation) (*AiRecommendation, error) {
+	rec, err := stores.Recommendations.Published(client.ID, recommendationInputHash(client, estimation))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
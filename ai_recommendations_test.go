@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAiRecommendationReviewAndCaching(t *testing.T) {
+	s := newTestServer(t)
+	const agentID = 3
+	token := tokenFor(t, agentID, "agent")
+	c := s.createClient(token, map[string]interface{}{"name": "Meera Iyer", "status": "Active", "income": 1200000})
+	if err := stores.PortalTokens.Store("portal-token", c.ID, agentID, time.Hour); err != nil {
+		t.Fatalf("seed portal token: %v", err)
+	}
+	fake := &fakeLLMClient{Reply: func(LLMRequest) string { return "Consider more health cover." }}
+	llm = fake
+
+	portalText := func() string {
+		var view PublicClientView
+		s.doJSON(http.MethodGet, "/api/portal/client/portal-token/", "", nil, http.StatusOK, &view)
+		return view.AiRecommendation
+	}
+	type recommendationResponse struct {
+		Current   AiRecommendation
+		Published *AiRecommendation
+	}
+	recPath := fmt.Sprintf("/api/clients/%d/ai-recommendation", c.ID)
+	reviewPath := func(id int64) string { return fmt.Sprintf("/api/clients/%d/ai-recommendations/%d", c.ID, id) }
+
+	// The portal never generates text and shows nothing until the agent approves it.
+	if text := portalText(); text != "" || len(fake.Requests()) != 0 {
+		t.Fatalf("portal shows %q after %d LLM calls before review", text, len(fake.Requests()))
+	}
+
+	var first recommendationResponse
+	s.doJSON(http.MethodGet, recPath, token, nil, http.StatusOK, &first)
+	s.doJSON(http.MethodGet, recPath, token, nil, http.StatusOK, &first)
+	if first.Current.GeneratedText != "Consider more health cover." || first.Current.Approved || first.Published != nil || len(fake.Requests()) != 1 {
+		t.Fatalf("recommendation = %+v after %d LLM calls", first, len(fake.Requests()))
+	}
+
+	s.doJSON(http.MethodPut, reviewPath(first.Current.ID), token, map[string]interface{}{"text": "Talk to me about health cover.", "approved": true}, http.StatusOK, nil)
+	if text := portalText(); text != "Talk to me about health cover." {
+		t.Fatalf("portal shows %q after approval", text)
+	}
+
+	// Changing the inputs hides the approved text until the new draft is reviewed, unless it is pinned.
+	s.doJSON(http.MethodPut, fmt.Sprintf("/api/clients/%d", c.ID), token, map[string]interface{}{"name": "Meera Iyer", "status": "Active", "income": 2400000}, http.StatusOK, nil)
+	if text := portalText(); text != "" {
+		t.Fatalf("portal shows stale text %q", text)
+	}
+	var second recommendationResponse
+	s.doJSON(http.MethodGet, recPath, token, nil, http.StatusOK, &second)
+	if second.Current.ID == first.Current.ID || len(fake.Requests()) != 2 {
+		t.Fatalf("inputs changed but recommendation %d was reused after %d LLM calls", second.Current.ID, len(fake.Requests()))
+	}
+	s.doJSON(http.MethodPut, reviewPath(first.Current.ID), token, map[string]interface{}{"pinned": true}, http.StatusOK, nil)
+	s.doJSON(http.MethodPut, reviewPath(second.Current.ID), token, map[string]interface{}{"approved": true}, http.StatusOK, nil)
+	if text := portalText(); text != "Talk to me about health cover." {
+		t.Fatalf("portal shows %q, want the pinned text", text)
+	}
+	s.doJSON(http.MethodPut, reviewPath(first.Current.ID), token, map[string]interface{}{"pinned": false}, http.StatusOK, nil)
+	if text := portalText(); text != "Consider more health cover." {
+		t.Fatalf("portal shows %q, want the approved current text", text)
+	}
+
+	var history []AiRecommendation
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/ai-recommendations", c.ID), token, nil, http.StatusOK, &history)
+	if len(history) != 2 || history[0].ID != second.Current.ID {
+		t.Fatalf("history = %+v", history)
+	}
+	other := tokenFor(t, 4, "agent")
+	s.doJSON(http.MethodGet, recPath, other, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodPut, reviewPath(first.Current.ID), other, map[string]interface{}{"approved": false}, http.StatusNotFound, nil)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- AI Task Suggestions ---
+// The model is asked for schema-constrained JSON rather than free text, and every suggestion is
+// validated before it is queued in task_suggestions for the agent to review. Suggestions that fail
+// validation are returned to the caller with the reason instead of being dropped silently. Only
+// suggestions the agent accepts become tasks.
+
+const (
+	maxSuggestedTaskLength  = 500
+	maxSuggestPromptClients = 100 // Clients listed in the agent-wide prompt
+	maxBulkAcceptIDs        = 100
+
+	// Bump a prompt version whenever its wording or schema changes so acceptance rates can be compared.
+	clientTaskPromptVersion = "client-tasks-v1"
+	agentTaskPromptVersion  = "agent-tasks-v1"
+)
+
+// suggestedTaskSchema describes the reply {"tasks": [SuggestedTask, ...]}. The root is an object
+// because OpenAI's structured outputs do not accept a bare array. requireClientID is set when the
+// model must say which client each task is for.
+func suggestedTaskSchema(requireClientID bool) map[string]interface{} {
+	required := []string{"description", "dueDate", "isUrgent", "rationale"}
+	if requireClientID {
+		required = append(required, "clientId")
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"tasks": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"description": map[string]interface{}{"type": "string", "description": "What the agent should do, in one sentence"},
+						"clientId":    map[string]interface{}{"type": "integer", "description": "ID of the client the task is about"},
+						"dueDate":     map[string]interface{}{"type": "string", "description": "Due date as YYYY-MM-DD, or empty"},
+						"isUrgent":    map[string]interface{}{"type": "boolean"},
+						"rationale":   map[string]interface{}{"type": "string", "description": "Why the agent should do this, based on the data provided"},
+					},
+					"required": required,
+				},
+			},
+		},
+		"required": []string{"tasks"},
+	}
+}
+
+// RejectedSuggestion is an AI-suggested task that was not queued for review, and why.
+type RejectedSuggestion struct {
+	Index      int           `json:"index"` // Position in the model's reply
+	Suggestion SuggestedTask `json:"suggestion"`
+	Reason     string        `json:"reason"`
+}
+
+// parseSuggestedTasks decodes a reply produced with suggestedTaskSchema.
+func parseSuggestedTasks(text string) ([]SuggestedTask, error) {
+	var reply struct {
+		Tasks []SuggestedTask `json:"tasks"`
+	}
+	if err := json.Unmarshal([]byte(text), &reply); err != nil {
+		return nil, fmt.Errorf("failed to parse suggested tasks: %w", err)
+	}
+	return reply.Tasks, nil
+}
+
+// validateSuggestedTask turns a suggestion into a task for agentUserID, or returns the reason it
+// can't be one. clientID is the client the suggestions were requested for, or 0 when the model
+// has to name the client itself.
+func validateSuggestedTask(st SuggestedTask, agentUserID, clientID int64) (Task, string) {
+	description := strings.TrimSpace(st.Description)
+	if description == "" {
+		return Task{}, "description is empty"
+	}
+	if len(description) > maxSuggestedTaskLength {
+		return Task{}, fmt.Sprintf("description is longer than %d characters", maxSuggestedTaskLength)
+	}
+	dueDate := strings.TrimSpace(st.DueDate)
+	if dueDate != "" {
+		if _, err := time.Parse("2006-01-02", dueDate); err != nil {
+			return Task{}, fmt.Sprintf("dueDate %q is not a valid YYYY-MM-DD date", dueDate)
+		}
+	}
+
+	switch {
+	case clientID != 0 && st.ClientID != nil && *st.ClientID != clientID:
+		return Task{}, fmt.Sprintf("clientId %d does not match the requested client", *st.ClientID)
+	case clientID == 0 && st.ClientID == nil:
+		return Task{}, "clientId is missing"
+	case clientID == 0:
+		if _, err := stores.Clients.GetByID(*st.ClientID, agentUserID); err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("ERROR: Checking client %d for suggested task: %v", *st.ClientID, err)
+			}
+			return Task{}, fmt.Sprintf("client %d was not found", *st.ClientID)
+		}
+		clientID = *st.ClientID
+	}
+
+	return Task{
+		ClientID:    clientID,
+		AgentUserID: agentUserID,
+		Description: description,
+		DueDate:     sql.NullString{String: dueDate, Valid: dueDate != ""},
+		IsUrgent:    st.IsUrgent,
+	}, ""
+}
+
+// queueTaskSuggestions validates every suggestion and stores the valid ones for review, returning
+// the queued suggestions and the rejected ones.
+func queueTaskSuggestions(suggestions []SuggestedTask, agentUserID, clientID int64, model, promptVersion string) ([]TaskSuggestion, []RejectedSuggestion) {
+	queued := []TaskSuggestion{}
+	rejected := []RejectedSuggestion{}
+	for i, st := range suggestions {
+		task, reason := validateSuggestedTask(st, agentUserID, clientID)
+		if reason == "" {
+			suggestion := TaskSuggestion{
+				AgentUserID:   agentUserID,
+				ClientID:      task.ClientID,
+				Description:   task.Description,
+				DueDate:       task.DueDate,
+				IsUrgent:      task.IsUrgent,
+				Rationale:     sql.NullString{String: strings.TrimSpace(st.Rationale), Valid: strings.TrimSpace(st.Rationale) != ""},
+				Model:         model,
+				PromptVersion: promptVersion,
+				Status:        "pending",
+			}
+			id, err := stores.Suggestions.Create(suggestion)
+			if err == nil {
+				suggestion.ID = id
+				queued = append(queued, suggestion)
+				continue
+			}
+			log.Printf("ERROR: Failed to store task suggestion for client %d: %v. Suggestion: %+v", task.ClientID, err, st)
+			reason = "suggestion could not be saved"
+		}
+		log.Printf("AI TASK SUGGEST: Rejected suggestion %d for agent %d: %s", i, agentUserID, reason)
+		rejected = append(rejected, RejectedSuggestion{Index: i, Suggestion: st, Reason: reason})
+	}
+	return queued, rejected
+}
+
+// TaskSuggestionEdits are the changes an agent may make while accepting a suggestion.
+type TaskSuggestionEdits struct {
+	Description *string `json:"description"`
+	DueDate     *string `json:"dueDate"`
+	IsUrgent    *bool   `json:"isUrgent"`
+}
+
+// acceptTaskSuggestion applies edits to a pending suggestion, validates the result like a fresh
+// suggestion and turns it into a task. A non-empty reason means the edited task is invalid.
+func acceptTaskSuggestion(suggestion *TaskSuggestion, edits TaskSuggestionEdits) (task Task, reason string, err error) {
+	if suggestion.Status != "pending" {
+		return Task{}, "", errSuggestionReviewed
+	}
+	st := SuggestedTask{Description: suggestion.Description, DueDate: suggestion.DueDate.String, IsUrgent: suggestion.IsUrgent}
+	if edits.Description != nil {
+		st.Description = *edits.Description
+	}
+	if edits.DueDate != nil {
+		st.DueDate = *edits.DueDate
+	}
+	if edits.IsUrgent != nil {
+		st.IsUrgent = *edits.IsUrgent
+	}
+	task, reason = validateSuggestedTask(st, suggestion.AgentUserID, suggestion.ClientID)
+	if reason != "" {
+		return Task{}, reason, nil
+	}
+	edited := task.Description != suggestion.Description || task.DueDate != suggestion.DueDate || task.IsUrgent != suggestion.IsUrgent
+	task.ID, err = stores.Suggestions.Accept(suggestion.ID, suggestion.AgentUserID, task, edited, time.Now())
+	if err != nil {
+		return Task{}, "", err
+	}
+	recordChanges(EntityChange{AgentUserID: suggestion.AgentUserID, ClientID: task.ClientID, Entity: changeEntityTask, EntityID: fmt.Sprint(task.ID),
+		Action: changeActionCreate, ActorUserID: sql.NullInt64{Int64: suggestion.AgentUserID, Valid: true}, Source: changeSourceAPI}, diffFields(Task{}, task))
+	logActivity(suggestion.AgentUserID, "task_suggestion_accepted", fmt.Sprintf("Accepted AI suggested task '%s'", task.Description), fmt.Sprintf("%d", task.ClientID))
+	return task, "", nil
+}
+
+// suggestionTally accumulates SuggestionStats per prompt version.
+type suggestionTally map[string]*SuggestionStats
+
+func (t suggestionTally) add(promptVersion, status string, edited bool, count int) {
+	stats, ok := t[promptVersion]
+	if !ok {
+		stats = &SuggestionStats{PromptVersion: promptVersion}
+		t[promptVersion] = stats
+	}
+	stats.Total += count
+	switch status {
+	case "pending":
+		stats.Pending += count
+	case "accepted":
+		stats.Accepted += count
+		if edited {
+			stats.Edited += count
+		}
+	case "rejected":
+		stats.Rejected += count
+	}
+}
+
+// stats returns the tallies ordered by prompt version, with acceptance rates filled in.
+func (t suggestionTally) stats() []SuggestionStats {
+	versions := make([]string, 0, len(t))
+	for version := range t {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	result := make([]SuggestionStats, 0, len(versions))
+	for _, version := range versions {
+		stats := *t[version]
+		if reviewed := stats.Accepted + stats.Rejected; reviewed > 0 {
+			stats.AcceptanceRate = float64(stats.Accepted) / float64(reviewed)
+		}
+		result = append(result, stats)
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type suggestTasksResponse struct {
+	Suggestions []TaskSuggestion
+	Rejected    []RejectedSuggestion
+}
+
+func TestSuggestClientTasksQueuesValidSuggestions(t *testing.T) {
+	s := newTestServer(t)
+	token := tokenFor(t, 3, "agent")
+	c := s.createClient(token, map[string]interface{}{"name": "Meera Iyer", "status": "Lead"})
+	fake := &fakeLLMClient{Reply: func(req LLMRequest) string {
+		return fmt.Sprintf(`{"tasks": [
+			{"description": "Call Meera about health cover", "dueDate": "2025-07-01", "isUrgent": true, "rationale": "No health policy on file"},
+			{"description": "Send brochure", "dueDate": "next week", "isUrgent": false, "rationale": ""},
+			{"description": "Follow up", "clientId": %d, "dueDate": "", "isUrgent": false, "rationale": ""}
+		]}`, c.ID+1)
+	}}
+	llm = fake
+
+	var resp suggestTasksResponse
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/suggest-tasks", c.ID), token, nil, http.StatusOK, &resp)
+	if len(resp.Suggestions) != 1 {
+		t.Fatalf("suggestions = %+v", resp.Suggestions)
+	}
+	got := resp.Suggestions[0]
+	if got.Status != "pending" || got.Rationale.String != "No health policy on file" || got.Model != "fake" || got.PromptVersion != clientTaskPromptVersion {
+		t.Fatalf("queued suggestion = %+v", got)
+	}
+	if len(resp.Rejected) != 2 || resp.Rejected[0].Index != 1 || resp.Rejected[1].Reason != fmt.Sprintf("clientId %d does not match the requested client", c.ID+1) {
+		t.Fatalf("rejected = %+v", resp.Rejected)
+	}
+	if req := fake.Requests()[0]; req.JSONSchema == nil {
+		t.Fatal("prompt was sent without a response schema")
+	}
+
+	// Nothing becomes a task until the agent accepts it.
+	var tasks []Task
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/tasks", c.ID), token, nil, http.StatusOK, &tasks)
+	if len(tasks) != 0 {
+		t.Fatalf("tasks = %+v before review", tasks)
+	}
+
+	llm = &fakeLLMClient{Reply: func(LLMRequest) string { return "Sure! Here are some tasks." }}
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/suggest-tasks", c.ID), token, nil, http.StatusBadGateway, nil)
+	llm = unconfiguredLLMClient{}
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/suggest-tasks", c.ID), token, nil, http.StatusServiceUnavailable, nil)
+}
+
+func TestSuggestAgentTasksChecksClientOwnership(t *testing.T) {
+	s := newTestServer(t)
+	token := tokenFor(t, 3, "agent")
+	mine := s.createClient(token, map[string]interface{}{"name": "Meera Iyer", "status": "Lead"})
+	theirs := s.createClient(tokenFor(t, 4, "agent"), map[string]interface{}{"name": "Someone Else"})
+	llm = &fakeLLMClient{Reply: func(LLMRequest) string {
+		return fmt.Sprintf(`{"tasks": [
+			{"description": "Pitch term life", "clientId": %d, "dueDate": "2025-07-01", "isUrgent": false, "rationale": "Young family"},
+			{"description": "Poach a client", "clientId": %d, "dueDate": "2025-07-01", "isUrgent": false, "rationale": ""},
+			{"description": "Unassigned", "dueDate": "2025-07-01", "isUrgent": false, "rationale": ""}
+		]}`, mine.ID, theirs.ID)
+	}}
+
+	var resp suggestTasksResponse
+	s.doJSON(http.MethodPost, "/api/agents/suggest-tasks", token, nil, http.StatusOK, &resp)
+	if len(resp.Suggestions) != 1 || resp.Suggestions[0].ClientID != mine.ID || resp.Suggestions[0].PromptVersion != agentTaskPromptVersion {
+		t.Fatalf("suggestions = %+v", resp.Suggestions)
+	}
+	reasons := []string{fmt.Sprintf("client %d was not found", theirs.ID), "clientId is missing"}
+	if len(resp.Rejected) != 2 || resp.Rejected[0].Reason != reasons[0] || resp.Rejected[1].Reason != reasons[1] {
+		t.Fatalf("rejected = %+v", resp.Rejected)
+	}
+}
+
+func TestTaskSuggestionReview(t *testing.T) {
+	s := newTestServer(t)
+	token := tokenFor(t, 3, "agent")
+	c := s.createClient(token, map[string]interface{}{"name": "Meera Iyer", "status": "Lead"})
+	llm = &fakeLLMClient{Reply: func(LLMRequest) string {
+		return `{"tasks": [
+			{"description": "Call about health cover", "dueDate": "2025-07-01", "isUrgent": false, "rationale": "r1"},
+			{"description": "Send brochure", "dueDate": "", "isUrgent": false, "rationale": "r2"},
+			{"description": "Ask for referrals", "dueDate": "", "isUrgent": false, "rationale": "r3"},
+			{"description": "Review motor policy", "dueDate": "", "isUrgent": false, "rationale": "r4"}
+		]}`
+	}}
+	var resp suggestTasksResponse
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/suggest-tasks", c.ID), token, nil, http.StatusOK, &resp)
+	if len(resp.Suggestions) != 4 {
+		t.Fatalf("suggestions = %+v", resp.Suggestions)
+	}
+	call, brochure, referrals, motor := resp.Suggestions[0], resp.Suggestions[1], resp.Suggestions[2], resp.Suggestions[3]
+
+	// Accept with edits; an invalid edit is refused and leaves the suggestion pending.
+	acceptPath := fmt.Sprintf("/api/task-suggestions/%d/accept", call.ID)
+	s.doJSON(http.MethodPost, acceptPath, token, map[string]interface{}{"dueDate": "soon"}, http.StatusBadRequest, nil)
+	var task Task
+	s.doJSON(http.MethodPost, acceptPath, token, map[string]interface{}{"isUrgent": true}, http.StatusCreated, &task)
+	if task.ID == 0 || task.ClientID != c.ID || !task.IsUrgent || task.Description != "Call about health cover" {
+		t.Fatalf("accepted task = %+v", task)
+	}
+	s.doJSON(http.MethodPost, acceptPath, token, nil, http.StatusConflict, nil)
+
+	// Another agent can neither see nor act on the suggestion.
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/task-suggestions/%d/accept", brochure.ID), tokenFor(t, 4, "agent"), nil, http.StatusNotFound, nil)
+
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/task-suggestions/%d/reject", brochure.ID), token, map[string]string{"reason": "Already sent"}, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/task-suggestions/%d/accept", brochure.ID), token, nil, http.StatusConflict, nil)
+
+	var bulk struct {
+		Accepted []Task
+		Failed   []struct {
+			ID     int64
+			Reason string
+		}
+	}
+	s.doJSON(http.MethodPost, "/api/task-suggestions/bulk-accept", token, map[string]interface{}{"ids": []int64{referrals.ID, call.ID, 9999}}, http.StatusOK, &bulk)
+	if len(bulk.Accepted) != 1 || bulk.Accepted[0].Description != "Ask for referrals" || len(bulk.Failed) != 2 ||
+		bulk.Failed[0].Reason != "already reviewed" || bulk.Failed[1].Reason != "not found" {
+		t.Fatalf("bulk accept = %+v", bulk)
+	}
+
+	var pending []TaskSuggestion
+	s.doJSON(http.MethodGet, "/api/task-suggestions?status=pending", token, nil, http.StatusOK, &pending)
+	if len(pending) != 1 || pending[0].ID != motor.ID {
+		t.Fatalf("pending = %+v", pending)
+	}
+	var tasks []Task
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/tasks", c.ID), token, nil, http.StatusOK, &tasks)
+	if len(tasks) != 2 {
+		t.Fatalf("tasks = %+v, want the two accepted suggestions", tasks)
+	}
+
+	var stats []SuggestionStats
+	s.doJSON(http.MethodGet, "/api/task-suggestions/stats", token, nil, http.StatusOK, &stats)
+	want := SuggestionStats{PromptVersion: clientTaskPromptVersion, Total: 4, Pending: 1, Accepted: 2, Edited: 1, Rejected: 1, AcceptanceRate: 2.0 / 3}
+	if len(stats) != 1 || stats[0] != want {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+	s.doJSON(http.MethodGet, "/api/task-suggestions/stats?scope=all", token, nil, http.StatusForbidden, nil)
+}
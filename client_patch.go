@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// --- Client Versions & Patches ---
+// Each client carries a version that goes up with every edit. It's sent as the client's ETag, and a
+// PUT or PATCH with an If-Match header only applies if the client is still at the version it names;
+// otherwise the request fails with 412, so two devices editing the same client don't overwrite each
+// other's changes unseen. PATCH takes a JSON Merge Patch (RFC 7396) of the client's payload: the
+// fields it names are set, null clears them, and the rest keep their values.
+
+// clientETag is the ETag of a client at its current version.
+func clientETag(client *Client) string {
+	return `"` + strconv.FormatInt(client.Version, 10) + `"`
+}
+
+// setClientETag sets the ETag header of a response carrying client.
+func setClientETag(w http.ResponseWriter, client *Client) {
+	w.Header().Set("ETag", clientETag(client))
+}
+
+// ifMatchVersion checks the request's If-Match header against the client and returns the version
+// an update must find: the client's, or 0 (any) without the header or with "*". It answers 412
+// and returns false if no tag matches.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request, client *Client) (int64, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == clientETag(client) {
+			return client.Version, true
+		}
+	}
+	setClientETag(w, client)
+	respondError(w, http.StatusPreconditionFailed, "Client has been modified since it was read; reload it and try again")
+	return 0, false
+}
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) to target; both are decoded JSON.
+func mergePatch(target, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = map[string]interface{}{}
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(doc, name)
+		} else {
+			doc[name] = mergePatch(doc[name], value)
+		}
+	}
+	return doc
+}
+
+// clientPayloadOf returns the payload that would save client as it is, leaving out its custom fields.
+func clientPayloadOf(client Client) ClientPayload {
+	float := func(v sql.NullFloat64) *float64 {
+		if !v.Valid {
+			return nil
+		}
+		return &v.Float64
+	}
+	integer := func(v sql.NullInt64) *int64 {
+		if !v.Valid {
+			return nil
+		}
+		return &v.Int64
+	}
+	return ClientPayload{Name: client.Name, Email: client.Email.String, Phone: client.Phone.String, Dob: client.Dob.String,
+		Address: client.Address.String, Status: client.Status, Tags: client.Tags.String, Income: float(client.Income),
+		MaritalStatus: client.MaritalStatus.String, City: client.City.String, JobProfile: client.JobProfile.String,
+		Dependents: integer(client.Dependents), Liability: float(client.Liability), HousingType: client.HousingType.String,
+		VehicleCount: integer(client.VehicleCount), VehicleType: client.VehicleType.String, VehicleCost: float(client.VehicleCost)}
+}
+
+// patchClientPayload applies a merge patch to the client's payload. The patch's customFields go
+// through as they are, since an update only changes the custom fields it names anyway.
+func patchClientPayload(client Client, patch []byte) (ClientPayload, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(patch, &fields); err != nil || fields == nil {
+		return ClientPayload{}, fmt.Errorf("the patch must be a JSON object")
+	}
+	customFields, hasCustomFields := fields["customFields"]
+	delete(fields, "customFields")
+
+	current, err := json.Marshal(clientPayloadOf(client))
+	if err != nil {
+		return ClientPayload{}, fmt.Errorf("failed to encode client: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return ClientPayload{}, fmt.Errorf("failed to decode client: %w", err)
+	}
+	patched, err := json.Marshal(mergePatch(doc, fields))
+	if err != nil {
+		return ClientPayload{}, fmt.Errorf("failed to encode patched client: %w", err)
+	}
+	var payload ClientPayload
+	if err := json.Unmarshal(patched, &payload); err != nil {
+		return ClientPayload{}, fmt.Errorf("invalid field in patch: %w", err)
+	}
+	if hasCustomFields {
+		values, ok := customFields.(map[string]interface{})
+		if !ok && customFields != nil {
+			return ClientPayload{}, fmt.Errorf("customFields must be an object")
+		}
+		payload.CustomFields = values
+	}
+	return payload, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	for _, tc := range []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`["a","b"]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	} {
+		var target, patch interface{}
+		json.Unmarshal([]byte(tc.target), &target)
+		json.Unmarshal([]byte(tc.patch), &patch)
+		got, _ := json.Marshal(mergePatch(target, patch))
+		if string(got) != tc.want {
+			t.Errorf("mergePatch(%s, %s) = %s, want %s", tc.target, tc.patch, got, tc.want)
+		}
+	}
+}
+
+func TestPatchClient(t *testing.T) {
+	s := newTestServer(t)
+	agent := tokenFor(t, 1, "agent")
+	created := s.createClient(agent, map[string]interface{}{"name": "Asha Rao", "email": "asha@example.com", "city": "Pune", "income": 900000, "status": "Lead"})
+	path := fmt.Sprintf("/api/clients/%d", created.ID)
+	send := func(method, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("Authorization", "Bearer "+agent)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := s.do(http.MethodGet, path, agent, nil)
+	etag := rec.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("new client's ETag = %q", etag)
+	}
+
+	// The patch sets the fields it names, clears those set to null and leaves the rest.
+	rec = send(http.MethodPatch, etag, `{"status": "Active", "city": null}`)
+	var got Client
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil {
+		t.Fatalf("patch: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if got.Status != "Active" || got.City.Valid || got.Email.String != "asha@example.com" || got.Income.Float64 != 900000 || got.Version != 2 {
+		t.Fatalf("patched client = %+v", got)
+	}
+	if rec.Header().Get("ETag") != `"2"` || got.LastContactedAt.Valid {
+		t.Fatalf("after patch: ETag %q, last contacted %v", rec.Header().Get("ETag"), got.LastContactedAt)
+	}
+
+	// A second device still holding the first version is turned away, whether it patches or puts.
+	if rec = send(http.MethodPatch, etag, `{"name": "Asha R."}`); rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("stale patch: status %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec = send(http.MethodPut, etag, `{"name": "Asha R."}`); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale put: status %d", rec.Code)
+	}
+	if rec = send(http.MethodPatch, `"9", W/"2"`, `{"tags": "vip"}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch matching a listed tag: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	for _, body := range []string{`{"name": null}`, `{"income": "lots"}`, `[]`, `{"customFields": 3}`} {
+		if rec = send(http.MethodPatch, "*", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("patch %s: status %d", body, rec.Code)
+		}
+	}
+
+	// Logging a communication is what counts as contact, and it doesn't move the version.
+	s.doJSON(http.MethodPost, path+"/communications", agent, map[string]interface{}{"type": "Call", "summary": "Renewal"}, http.StatusCreated, nil)
+	rec = s.do(http.MethodGet, path, agent, nil)
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if !got.LastContactedAt.Valid || got.Tags.String != "vip" || rec.Header().Get("ETag") != `"3"` {
+		t.Fatalf("client after contact: ETag %q, %+v", rec.Header().Get("ETag"), got)
+	}
+
+	var history []EntityChange
+	s.doJSON(http.MethodGet, path+"/history", agent, nil, http.StatusOK, &history)
+	if len(history) == 0 || history[0].Field != "tags" || history[0].Action != changeActionUpdate {
+		t.Fatalf("history after patches = %+v", history)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Custom Fields ---
+// Agents track attributes the schema doesn't have (smoker, height, employer, nominee) as custom
+// fields instead of new columns. An agent defines fields for their own book, and an agency defines
+// fields that all of its agents see. Each field has a type its values are checked against; values
+// live in custom_field_values in a canonical text form and come back in the client's or policy's
+// customFields, keyed by the field key. The key also names the field in list filters (?cf.<key>=)
+// and in CSV import and export.
+
+// What a custom field can be attached to.
+const (
+	customFieldEntityClient = "client"
+	customFieldEntityPolicy = "policy"
+)
+
+// Custom field types.
+const (
+	customFieldText    = "text"
+	customFieldNumber  = "number"
+	customFieldDate    = "date"
+	customFieldEnum    = "enum"
+	customFieldBoolean = "boolean"
+)
+
+var customFieldEntities = []string{customFieldEntityClient, customFieldEntityPolicy}
+var customFieldTypes = []string{customFieldText, customFieldNumber, customFieldDate, customFieldEnum, customFieldBoolean}
+
+const maxCustomFieldValueLength = 1000
+
+// customFieldFilterPrefix starts the query parameters that filter the client list by a custom field.
+const customFieldFilterPrefix = "cf."
+
+var customFieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+type CustomFieldPayload struct {
+	Entity   string   `json:"entity"`
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"`
+	Options  []string `json:"options"`
+	Required bool     `json:"required"`
+}
+
+// definition validates the payload and returns the definition it describes. Entity, key and type
+// are only read when creating; updates change the label, options and required flag.
+func (p CustomFieldPayload) definition() (CustomFieldDefinition, error) {
+	def := CustomFieldDefinition{
+		Entity:   strings.ToLower(strings.TrimSpace(p.Entity)),
+		Key:      strings.TrimSpace(p.Key),
+		Label:    strings.TrimSpace(p.Label),
+		Type:     strings.ToLower(strings.TrimSpace(p.Type)),
+		Options:  []string{},
+		Required: p.Required,
+	}
+	if !slices.Contains(customFieldEntities, def.Entity) {
+		return def, fmt.Errorf("entity must be one of %s", strings.Join(customFieldEntities, ", "))
+	}
+	if !customFieldKeyPattern.MatchString(def.Key) {
+		return def, fmt.Errorf("key must start with a lowercase letter and contain only lowercase letters, digits and underscores (at most 64)")
+	}
+	if def.Entity == customFieldEntityClient && slices.Contains(clientCSVColumns, normalizeCSVHeader(def.Key)) {
+		return def, fmt.Errorf("key '%s' is already a client column", def.Key)
+	}
+	if def.Label == "" {
+		def.Label = def.Key
+	}
+	if !slices.Contains(customFieldTypes, def.Type) {
+		return def, fmt.Errorf("type must be one of %s", strings.Join(customFieldTypes, ", "))
+	}
+	for _, option := range p.Options {
+		if option = strings.TrimSpace(option); option != "" && !slices.Contains(def.Options, option) {
+			def.Options = append(def.Options, option)
+		}
+	}
+	if def.Type == customFieldEnum && len(def.Options) == 0 {
+		return def, fmt.Errorf("an enum field needs options")
+	}
+	if def.Type != customFieldEnum && len(def.Options) > 0 {
+		return def, fmt.Errorf("only enum fields take options")
+	}
+	return def, nil
+}
+
+// parseCustomFieldValue checks a value against the field's type and returns its canonical text:
+// numbers as Go formats them, dates as YYYY-MM-DD, booleans as "true"/"false" and enum values as
+// the option they match. A nil or empty value returns "".
+func parseCustomFieldValue(def CustomFieldDefinition, value interface{}) (string, error) {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		text = strings.TrimSpace(v)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		text = strconv.FormatBool(v)
+	default:
+		return "", fmt.Errorf("custom field '%s' must be a string, number or boolean", def.Key)
+	}
+	if text == "" {
+		return "", nil
+	}
+	switch def.Type {
+	case customFieldText:
+		if len(text) > maxCustomFieldValueLength {
+			return "", fmt.Errorf("custom field '%s' is longer than %d characters", def.Key, maxCustomFieldValueLength)
+		}
+	case customFieldNumber:
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return "", fmt.Errorf("custom field '%s' must be a number", def.Key)
+		}
+		text = strconv.FormatFloat(n, 'f', -1, 64)
+	case customFieldDate:
+		date, err := time.Parse("2006-01-02", normalizeDob(text))
+		if err != nil {
+			return "", fmt.Errorf("custom field '%s' must be a date (YYYY-MM-DD)", def.Key)
+		}
+		text = date.Format("2006-01-02")
+	case customFieldEnum:
+		i := slices.IndexFunc(def.Options, func(option string) bool { return strings.EqualFold(option, text) })
+		if i < 0 {
+			return "", fmt.Errorf("custom field '%s' must be one of %s", def.Key, strings.Join(def.Options, ", "))
+		}
+		text = def.Options[i]
+	case customFieldBoolean:
+		switch strings.ToLower(text) {
+		case "true", "yes", "y", "1":
+			text = "true"
+		case "false", "no", "n", "0":
+			text = "false"
+		default:
+			return "", fmt.Errorf("custom field '%s' must be true or false", def.Key)
+		}
+	}
+	return text, nil
+}
+
+// validateCustomFields checks values by field key against defs and returns them in canonical
+// form, "" for the fields to clear. When creating, every required field must have a value;
+// otherwise a required field can't be cleared.
+func validateCustomFields(defs []CustomFieldDefinition, values map[string]interface{}, creating bool) (map[string]string, error) {
+	canonical := map[string]string{}
+	for key, value := range values {
+		i := slices.IndexFunc(defs, func(def CustomFieldDefinition) bool { return def.Key == key })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown custom field '%s'", key)
+		}
+		text, err := parseCustomFieldValue(defs[i], value)
+		if err != nil {
+			return nil, err
+		}
+		canonical[key] = text
+	}
+	for _, def := range defs {
+		text, given := canonical[def.Key]
+		if def.Required && text == "" && (creating || given) {
+			return nil, fmt.Errorf("custom field '%s' is required", def.Key)
+		}
+	}
+	return canonical, nil
+}
+
+// customFieldDefinitions returns the definitions agentUserID sees for entity.
+func customFieldDefinitions(agentUserID int64, entity string) ([]CustomFieldDefinition, error) {
+	defs, err := stores.CustomFields.ListDefinitions(agentUserID, entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load custom fields: %w", err)
+	}
+	return defs, nil
+}
+
+// payloadCustomFields validates a payload's custom fields against the entity's fields the agent
+// sees, answering 400 if they don't fit. See validateCustomFields.
+func payloadCustomFields(w http.ResponseWriter, agentUserID int64, entity string, values map[string]interface{}, creating bool) (map[string]string, bool) {
+	defs, err := customFieldDefinitions(agentUserID, entity)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to load custom fields")
+		return nil, false
+	}
+	canonical, err := validateCustomFields(defs, values, creating)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if creating {
+		maps.DeleteFunc(canonical, func(_, value string) bool { return value == "" })
+	}
+	return canonical, true
+}
+
+// customFieldFilters reads the ?cf.<key>=<value> filters of a client list request.
+func customFieldFilters(defs []CustomFieldDefinition, query map[string][]string) (map[string]string, error) {
+	filters := map[string]string{}
+	for param, values := range query {
+		key, ok := strings.CutPrefix(param, customFieldFilterPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		i := slices.IndexFunc(defs, func(def CustomFieldDefinition) bool { return def.Key == key })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown custom field '%s'", key)
+		}
+		text, err := parseCustomFieldValue(defs[i], values[0])
+		if err != nil {
+			return nil, err
+		}
+		if text != "" {
+			filters[key] = text
+		}
+	}
+	return filters, nil
+}
+
+// --- Client CSV ---
+
+// clientCSVColumns are the client columns of CSV import and export, as normalizeCSVHeader gives
+// them; clientCSVHeaders are the headers export writes for them. Custom fields follow, headed by
+// their keys.
+var clientCSVColumns = []string{"name", "email", "phone", "dob", "address", "status", "tags", "income", "maritalstatus",
+	"city", "jobprofile", "dependents", "liability", "housingtype", "vehiclecount", "vehicletype", "vehiclecost",
+	"leadsource", "campaign"}
+var clientCSVHeaders = []string{"Name", "Email", "Phone", "DOB", "Address", "Status", "Tags", "Income", "Marital Status",
+	"City", "Job Profile", "Dependents", "Liability", "Housing Type", "Vehicle Count", "Vehicle Type", "Vehicle Cost",
+	"Lead Source", "Campaign"}
+
+// normalizeCSVHeader makes "Marital Status", "marital_status" and "MaritalStatus" the same column.
+func normalizeCSVHeader(header string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "_", "").Replace(strings.TrimSpace(header)))
+}
+
+// customFieldForCSVHeader returns the index in defs of the field a CSV column holds, matched by
+// key or label, or -1.
+func customFieldForCSVHeader(defs []CustomFieldDefinition, header string) int {
+	header = normalizeCSVHeader(header)
+	return slices.IndexFunc(defs, func(def CustomFieldDefinition) bool {
+		return normalizeCSVHeader(def.Key) == header || normalizeCSVHeader(def.Label) == header
+	})
+}
+
+// csvFormulaPrefixes start a cell that spreadsheets evaluate as a formula.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvSafeCell neutralizes a value a spreadsheet would run as a formula, such as
+// =HYPERLINK(...) in a client's name, by prefixing it with a quote. Numbers are left alone.
+func csvSafeCell(value string) string {
+	if value == "" || !strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+// csvCellValue undoes csvSafeCell, so an export imports back unchanged.
+func csvCellValue(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+// clientCSVRecord returns the client's row for export, in the order of clientCSVHeaders and then
+// defs, with every cell made safe to open in a spreadsheet.
+func clientCSVRecord(client Client, defs []CustomFieldDefinition) []string {
+	float := func(v sql.NullFloat64) string {
+		if !v.Valid {
+			return ""
+		}
+		return strconv.FormatFloat(v.Float64, 'f', -1, 64)
+	}
+	integer := func(v sql.NullInt64) string {
+		if !v.Valid {
+			return ""
+		}
+		return strconv.FormatInt(v.Int64, 10)
+	}
+	record := []string{client.Name, client.Email.String, client.Phone.String, client.Dob.String, client.Address.String,
+		client.Status, client.Tags.String, float(client.Income), client.MaritalStatus.String, client.City.String,
+		client.JobProfile.String, integer(client.Dependents), float(client.Liability), client.HousingType.String,
+		integer(client.VehicleCount), client.VehicleType.String, float(client.VehicleCost),
+		client.LeadSource.String, client.Campaign.String}
+	for _, def := range defs {
+		record = append(record, client.CustomFields[def.Key])
+	}
+	for i, value := range record {
+		record[i] = csvSafeCell(value)
+	}
+	return record
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCustomFieldValue(t *testing.T) {
+	risk := CustomFieldDefinition{Key: "risk", Type: customFieldEnum, Options: []string{"Low", "High"}}
+	for _, tc := range []struct {
+		def   CustomFieldDefinition
+		value interface{}
+		want  string
+		ok    bool
+	}{
+		{CustomFieldDefinition{Type: customFieldText}, "  Infosys ", "Infosys", true},
+		{CustomFieldDefinition{Type: customFieldNumber}, "172.50", "172.5", true},
+		{CustomFieldDefinition{Type: customFieldNumber}, 180.0, "180", true},
+		{CustomFieldDefinition{Type: customFieldNumber}, "tall", "", false},
+		{CustomFieldDefinition{Type: customFieldDate}, "12/04/1985", "1985-04-12", true},
+		{CustomFieldDefinition{Type: customFieldDate}, "2026-02-30", "", false},
+		{risk, "high", "High", true},
+		{risk, "medium", "", false},
+		{CustomFieldDefinition{Type: customFieldBoolean}, "Yes", "true", true},
+		{CustomFieldDefinition{Type: customFieldBoolean}, false, "false", true},
+		{CustomFieldDefinition{Type: customFieldBoolean}, "maybe", "", false},
+		{CustomFieldDefinition{Type: customFieldNumber}, nil, "", true},
+		{CustomFieldDefinition{Type: customFieldText}, []string{"a"}, "", false},
+	} {
+		got, err := parseCustomFieldValue(tc.def, tc.value)
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("parseCustomFieldValue(%s, %v) = %q, %v; want %q (ok %v)", tc.def.Type, tc.value, got, err, tc.want, tc.ok)
+		}
+	}
+
+	defs := []CustomFieldDefinition{risk, {Key: "smoker", Type: customFieldBoolean, Required: true}}
+	if _, err := validateCustomFields(defs, map[string]interface{}{"risk": "low"}, true); err == nil {
+		t.Error("missing required field accepted on create")
+	}
+	if got, err := validateCustomFields(defs, map[string]interface{}{"risk": nil}, false); err != nil || fmt.Sprint(got) != "map[risk:]" {
+		t.Errorf("clearing an optional field = %v, %v", got, err)
+	}
+	if _, err := validateCustomFields(defs, map[string]interface{}{"smoker": ""}, false); err == nil {
+		t.Error("clearing a required field accepted")
+	}
+	if _, err := validateCustomFields(defs, map[string]interface{}{"height": 170}, false); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	s := newTestServer(t)
+	seed := func(email, userType string) int64 {
+		id, err := stores.Users.Create(User{Email: email, UserType: userType, IsVerified: true})
+		if err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+		return id
+	}
+	agencyID, agentID := seed("agency@example.com", "agency"), seed("agent@example.com", "agent")
+	if err := stores.Agencies.AddMember(agencyID, agentID); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	agency, agent := tokenFor(t, agencyID, "agency"), tokenFor(t, agentID, "agent")
+
+	// The agency's field is every agent's; keys are unique among the fields an agent sees.
+	field := func(token string, def map[string]interface{}, want int) {
+		s.doJSON(http.MethodPost, "/api/custom-fields", token, def, want, nil)
+	}
+	field(agency, map[string]interface{}{"entity": "client", "key": "smoker", "type": "boolean"}, http.StatusCreated)
+	field(agent, map[string]interface{}{"entity": "client", "key": "smoker", "type": "text"}, http.StatusConflict)
+	field(agent, map[string]interface{}{"entity": "client", "key": "city", "type": "text"}, http.StatusBadRequest)
+	field(agent, map[string]interface{}{"entity": "client", "key": "Risk", "type": "text"}, http.StatusBadRequest)
+	field(agent, map[string]interface{}{"entity": "client", "key": "risk", "type": "enum"}, http.StatusBadRequest)
+	var risk CustomFieldDefinition
+	s.doJSON(http.MethodPost, "/api/custom-fields", agent, map[string]interface{}{
+		"entity": "client", "key": "risk", "label": "Risk Level", "type": "enum", "options": []string{"Low", "High"},
+	}, http.StatusCreated, &risk)
+	field(agent, map[string]interface{}{"entity": "client", "key": "height_cm", "type": "number"}, http.StatusCreated)
+	field(agent, map[string]interface{}{"entity": "policy", "key": "nominee", "type": "text", "required": true}, http.StatusCreated)
+	var defs []CustomFieldDefinition
+	s.doJSON(http.MethodGet, "/api/custom-fields?entity=client", agent, nil, http.StatusOK, &defs)
+	if len(defs) != 3 || defs[0].Key != "smoker" || defs[0].OwnerUserID != agencyID {
+		t.Fatalf("agent's client fields = %+v", defs)
+	}
+	s.doJSON(http.MethodPut, fmt.Sprintf("/api/custom-fields/%d", defs[0].ID), agent, map[string]interface{}{"label": "Smoker"}, http.StatusNotFound, nil)
+
+	// Values are checked against the field's type and come back in their canonical form.
+	client := func(payload map[string]interface{}, want int) Client {
+		var c Client
+		s.doJSON(http.MethodPost, "/api/clients", agent, payload, want, &c)
+		return c
+	}
+	client(map[string]interface{}{"name": "Bad", "phone": "9000000001", "customFields": map[string]interface{}{"risk": "medium"}}, http.StatusBadRequest)
+	client(map[string]interface{}{"name": "Bad", "phone": "9000000001", "customFields": map[string]interface{}{"weight": 70}}, http.StatusBadRequest)
+	rajesh := client(map[string]interface{}{"name": "Rajesh", "phone": "9000000002",
+		"customFields": map[string]interface{}{"smoker": "yes", "risk": "high", "height_cm": 172}}, http.StatusCreated)
+	if fmt.Sprint(rajesh.CustomFields) != "map[height_cm:172 risk:High smoker:true]" {
+		t.Fatalf("created client's custom fields = %v", rajesh.CustomFields)
+	}
+	client(map[string]interface{}{"name": "Asha", "phone": "9000000003", "customFields": map[string]interface{}{"risk": "Low"}}, http.StatusCreated)
+
+	var list []Client
+	s.doJSON(http.MethodGet, "/api/clients?cf.risk=high", agent, nil, http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != rajesh.ID || list[0].CustomFields["smoker"] != "true" {
+		t.Fatalf("clients with high risk = %+v", list)
+	}
+	s.doJSON(http.MethodGet, "/api/clients?cf.risk=low&cf.smoker=yes", agent, nil, http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("low-risk smokers = %+v", list)
+	}
+	s.doJSON(http.MethodGet, "/api/clients?cf.weight=70", agent, nil, http.StatusBadRequest, nil)
+
+	// An update leaves out fields it doesn't mention and clears those set to null.
+	clientPath := fmt.Sprintf("/api/clients/%d", rajesh.ID)
+	s.doJSON(http.MethodPut, clientPath, agent, map[string]interface{}{"name": "Rajesh", "phone": "9000000002",
+		"customFields": map[string]interface{}{"risk": nil}}, http.StatusOK, nil)
+	var got Client
+	s.doJSON(http.MethodGet, clientPath, agent, nil, http.StatusOK, &got)
+	if fmt.Sprint(got.CustomFields) != "map[height_cm:172 smoker:true]" {
+		t.Fatalf("updated client's custom fields = %v", got.CustomFields)
+	}
+
+	policy := map[string]interface{}{"policyNumber": "P-1", "status": "Active", "startDate": "2026-01-01", "endDate": "2027-01-01"}
+	s.doJSON(http.MethodPost, clientPath+"/policies", agent, policy, http.StatusBadRequest, nil)
+	policy["customFields"] = map[string]interface{}{"nominee": "Priya"}
+	s.doJSON(http.MethodPost, clientPath+"/policies", agent, policy, http.StatusCreated, nil)
+	var policies []Policy
+	s.doJSON(http.MethodGet, clientPath+"/policies", agent, nil, http.StatusOK, &policies)
+	if len(policies) != 1 || policies[0].CustomFields["nominee"] != "Priya" {
+		t.Fatalf("policies = %+v", policies)
+	}
+
+	// CSV import maps columns to fields by key or label; export writes them back by key.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("clientFile", "clients.csv")
+	if err != nil {
+		t.Fatalf("form file: %v", err)
+	}
+	fw.Write([]byte("Name,Phone,Smoker,Risk Level\nKamla,9000000004,no,LOW\nRavi,9000000005,no,unknown\n"))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/clients/bulk-upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+agent)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	var result BulkUploadResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.SuccessCount != 1 || result.FailureCount != 1 {
+		t.Fatalf("import: status %d, result %+v", rec.Code, result)
+	}
+
+	rec = s.do(http.MethodGet, "/api/clients/export", agent, nil)
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if rec.Code != http.StatusOK || err != nil || len(records) != 4 {
+		t.Fatalf("export: status %d, %d records, %v", rec.Code, len(records), err)
+	}
+	header, kamla := records[0], records[3]
+	if n := len(clientCSVHeaders); fmt.Sprint(header[n:]) != "[smoker risk height_cm]" || kamla[0] != "Kamla" || fmt.Sprint(kamla[n:]) != "[false Low ]" {
+		t.Fatalf("export header %v, last row %v", header, kamla)
+	}
+
+	// Deleting a field deletes its values.
+	s.doJSON(http.MethodDelete, fmt.Sprintf("/api/custom-fields/%d", risk.ID), agency, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodDelete, fmt.Sprintf("/api/custom-fields/%d", risk.ID), agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, "/api/clients", agent, nil, http.StatusOK, &list)
+	for _, c := range list {
+		if _, ok := c.CustomFields["risk"]; ok {
+			t.Fatalf("client %s still has a risk: %v", c.Name, c.CustomFields)
+		}
+	}
+}
+
+func TestExportEscapesFormulas(t *testing.T) {
+	for value, want := range map[string]string{
+		`=HYPERLINK("http://evil.example","Click")`: `'=HYPERLINK("http://evil.example","Click")`,
+		"+91 90000 00001": "'+91 90000 00001", "@SUM(A1)": "'@SUM(A1)", "\tcmd": "'\tcmd", "-250000": "-250000", "Asha": "Asha", "": "",
+	} {
+		if got := csvSafeCell(value); got != want || csvCellValue(got) != value {
+			t.Errorf("csvSafeCell(%q) = %q, read back as %q", value, got, csvCellValue(got))
+		}
+	}
+
+	s := newTestServer(t)
+	agent := tokenFor(t, 9, "agent")
+	s.createClient(agent, map[string]interface{}{"name": `=HYPERLINK("http://evil.example","Click")`, "phone": "9000000001", "city": "@Pune"})
+	rec := s.do(http.MethodGet, "/api/clients/export", agent, nil)
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if rec.Code != http.StatusOK || err != nil || len(records) != 2 {
+		t.Fatalf("export: status %d, %d records, %v", rec.Code, len(records), err)
+	}
+	if row := records[1]; row[0] != `'=HYPERLINK("http://evil.example","Click")` || row[9] != "'@Pune" {
+		t.Errorf("exported row = %q", row)
+	}
+}
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS agent_goals;
+DROP TABLE IF EXISTS agent_insurer_details;
+DROP TABLE IF EXISTS agent_profiles;
+DROP TABLE IF EXISTS client_portal_tokens;
+DROP TABLE IF EXISTS agent_insurer_pocs;
+DROP TABLE IF EXISTS activity_log;
+DROP TABLE IF EXISTS client_segments;
+DROP TABLE IF EXISTS marketing_content;
+DROP TABLE IF EXISTS marketing_templates;
+DROP TABLE IF EXISTS marketing_campaigns;
+DROP TABLE IF EXISTS documents;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS communications;
+DROP TABLE IF EXISTS policies;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS clients;
+DROP TABLE IF EXISTS notices;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,246 @@
+-- Baseline schema, previously created inline by setupDatabase on every boot.
+
+CREATE TABLE IF NOT EXISTS users (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    user_type VARCHAR(10) NOT NULL CHECK(user_type IN ('agent', 'agency')),
+    is_verified BOOLEAN NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS tokens (
+    user_id INT NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    purpose VARCHAR(20) NOT NULL CHECK(purpose IN ('verification', 'reset')),
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, purpose),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS notices (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    category VARCHAR(100),
+    posted_by VARCHAR(100),
+    is_important BOOLEAN NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS clients (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    phone VARCHAR(50),
+    dob VARCHAR(20), -- Consider DATE type if format is guaranteed
+    address TEXT,
+    status VARCHAR(20) CHECK(status IN ('Lead', 'Active', 'Lapsed')) NOT NULL,
+    tags TEXT,
+    last_contacted_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    income DECIMAL(15, 2),
+    marital_status VARCHAR(50),
+    city VARCHAR(100),
+    job_profile VARCHAR(100),
+    dependents INT,
+    liability DECIMAL(15, 2),
+    housing_type VARCHAR(50),
+    vehicle_count INT,
+    vehicle_type VARCHAR(100),
+    vehicle_cost DECIMAL(15, 2),
+    UNIQUE(agent_user_id, email),
+    UNIQUE(agent_user_id, phone),
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS products (
+    id VARCHAR(100) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    category VARCHAR(100) NOT NULL,
+    insurer VARCHAR(100) NOT NULL,
+    description TEXT,
+    status VARCHAR(50) DEFAULT 'Active',
+    features TEXT,
+    eligibility TEXT,
+    term VARCHAR(100),
+    exclusions TEXT,
+    room_rent VARCHAR(100),
+    premium_indication VARCHAR(255),
+    insurer_logo_url VARCHAR(2083),
+    brochure_url VARCHAR(2083),
+    wording_url VARCHAR(2083),
+    claim_form_url VARCHAR(2083),
+    upfront_commission_percentage DOUBLE DEFAULT 0.0,
+    trail_commission_percentage DOUBLE DEFAULT 0.0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS policies (
+    id VARCHAR(100) PRIMARY KEY,
+    client_id INT NOT NULL,
+    agent_user_id INT NOT NULL,
+    product_id VARCHAR(100),
+    policy_number VARCHAR(100) NOT NULL,
+    insurer VARCHAR(100),
+    premium DECIMAL(15, 2),
+    sum_insured DECIMAL(15, 2),
+    start_date VARCHAR(20),
+    end_date VARCHAR(20),
+    status VARCHAR(50),
+    policy_doc_url VARCHAR(2083),
+    upfront_commission_amount DECIMAL(15, 2) DEFAULT 0.0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE SET NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS communications (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    client_id INT NOT NULL,
+    agent_user_id INT NOT NULL,
+    type VARCHAR(50),
+    timestamp TIMESTAMP NULL,
+    summary TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    client_id INT NOT NULL,
+    agent_user_id INT NOT NULL,
+    description TEXT NOT NULL,
+    due_date VARCHAR(20),
+    is_urgent BOOLEAN DEFAULT 0,
+    is_completed BOOLEAN DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS documents (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    client_id INT NOT NULL,
+    agent_user_id INT NOT NULL,
+    title VARCHAR(255),
+    document_type VARCHAR(100),
+    file_url VARCHAR(2083) NOT NULL,
+    uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS marketing_campaigns (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(50),
+    target_segment_name VARCHAR(255),
+    sent_at TIMESTAMP NULL DEFAULT NULL,
+    stats_opens INT DEFAULT 0,
+    stats_clicks INT DEFAULT 0,
+    stats_leads INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS marketing_templates (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(50),
+    category VARCHAR(100),
+    preview_text TEXT,
+    content MEDIUMTEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS marketing_content (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    title VARCHAR(255) NOT NULL,
+    content_type VARCHAR(50),
+    description TEXT,
+    gcs_url VARCHAR(2083) NOT NULL,
+    thumbnail_url VARCHAR(2083),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS client_segments (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    criteria TEXT,
+    client_count INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS activity_log (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    activity_type VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL,
+    related_id VARCHAR(100),
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS agent_insurer_pocs (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    insurer_name VARCHAR(255) NOT NULL,
+    poc_email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(agent_user_id, insurer_name)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS client_portal_tokens (
+    token VARCHAR(255) PRIMARY KEY,
+    client_id INT NOT NULL,
+    agent_user_id INT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_client_portal_tokens_expiry ON client_portal_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS agent_profiles (
+    user_id INT PRIMARY KEY,
+    mobile VARCHAR(50),
+    gender VARCHAR(20),
+    postal_address TEXT,
+    agency_name VARCHAR(255),
+    pan VARCHAR(20) UNIQUE,
+    bank_name VARCHAR(100),
+    bank_account_no VARCHAR(50),
+    bank_ifsc VARCHAR(20),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS agent_insurer_details (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    insurer_name VARCHAR(255) NOT NULL,
+    agent_code VARCHAR(100),
+    spoc_email VARCHAR(255),
+    commission_percentage DOUBLE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(agent_user_id, insurer_name)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS agent_goals (
+    user_id INT PRIMARY KEY,
+    target_income DECIMAL(15, 2),
+    target_period VARCHAR(50), -- e.g., "2025-Q2", "2025-Annual"
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
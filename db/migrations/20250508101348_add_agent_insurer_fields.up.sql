@@ -1,25 +1,30 @@
+-- Per-agent insurer relations (agent code, SPOC, commission rates) joined with the
+-- product catalogue. Supersedes agent_insurer_pocs and agent_insurer_details.
 CREATE TABLE IF NOT EXISTS agent_insurer_relations (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    agent_user_id TEXT,
-    insurer_name TEXT,
-    agent_code TEXT,
-    spoc_email TEXT,
-    upfront_commission_percentage REAL,
-    total_commission_percentage REAL,
-    name TEXT,
-    category TEXT,
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    insurer_name VARCHAR(255) NOT NULL,
+    agent_code VARCHAR(100),
+    spoc_email VARCHAR(255),
+    upfront_commission_percentage DOUBLE,
+    trail_commission_percentage DOUBLE,
+    name VARCHAR(255) NOT NULL, -- Product name
+    category VARCHAR(100) NOT NULL, -- Product category
     description TEXT,
-    status TEXT,
+    status VARCHAR(50) NOT NULL, -- Product status
     features TEXT,
     eligibility TEXT,
-    term TEXT,
+    term VARCHAR(100),
     exclusions TEXT,
-    room_rent TEXT,
-    premium_indication TEXT,
-    insurer_logo_url TEXT,
-    brochure_url TEXT,
-    wording_url TEXT,
-    claim_form_url TEXT,
-    updated_at TIMESTAMP,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
+    room_rent VARCHAR(100),
+    premium_indication VARCHAR(255),
+    insurer_logo_url VARCHAR(2083),
+    brochure_url VARCHAR(2083),
+    wording_url VARCHAR(2083),
+    claim_form_url VARCHAR(2083),
+    product_id VARCHAR(100), -- Reference to products.id
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(agent_user_id, insurer_name, product_id)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS agent_insurer_pocs (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    insurer_name VARCHAR(255) NOT NULL,
+    poc_email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(agent_user_id, insurer_name)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS agent_insurer_details (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    insurer_name VARCHAR(255) NOT NULL,
+    agent_code VARCHAR(100),
+    spoc_email VARCHAR(255),
+    commission_percentage DOUBLE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(agent_user_id, insurer_name)
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,4 @@
+-- agent_insurer_pocs and agent_insurer_details were replaced by agent_insurer_relations.
+-- setupDatabase used to create and immediately drop them on every boot.
+DROP TABLE IF EXISTS agent_insurer_pocs;
+DROP TABLE IF EXISTS agent_insurer_details;
@@ -0,0 +1,17 @@
+-- Outgoing email queue drained by the outbox worker (mailer.go).
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NULL, -- Agent the email was sent on behalf of; NULL for account emails
+    recipients TEXT NOT NULL, -- Comma-separated
+    subject VARCHAR(998) NOT NULL,
+    body MEDIUMTEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'sending', 'sent', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE SET NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_email_outbox_due ON email_outbox (status, next_attempt_at);
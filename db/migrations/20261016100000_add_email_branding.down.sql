@@ -0,0 +1,3 @@
+ALTER TABLE email_outbox DROP COLUMN text_body;
+ALTER TABLE agent_profiles DROP COLUMN email_signature;
+ALTER TABLE agent_profiles DROP COLUMN logo_url;
@@ -0,0 +1,4 @@
+-- Per-agent branding for templated email, and the plain-text alternative of queued email.
+ALTER TABLE agent_profiles ADD COLUMN logo_url VARCHAR(2083);
+ALTER TABLE agent_profiles ADD COLUMN email_signature TEXT;
+ALTER TABLE email_outbox ADD COLUMN text_body MEDIUMTEXT;
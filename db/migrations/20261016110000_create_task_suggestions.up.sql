@@ -0,0 +1,24 @@
+-- AI-proposed tasks waiting for the agent to accept or reject them (ai_tasks.go).
+CREATE TABLE IF NOT EXISTS task_suggestions (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    client_id INT NOT NULL,
+    description TEXT NOT NULL,
+    due_date VARCHAR(20),
+    is_urgent BOOLEAN NOT NULL DEFAULT 0,
+    rationale TEXT,
+    model VARCHAR(100) NOT NULL,
+    prompt_version VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'accepted', 'rejected')),
+    edited BOOLEAN NOT NULL DEFAULT 0, -- Accepted with changes
+    reject_reason TEXT,
+    task_id INT NULL, -- Task created on acceptance
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE SET NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_task_suggestions_agent_status ON task_suggestions (agent_user_id, status);
+CREATE INDEX idx_task_suggestions_prompt_version ON task_suggestions (prompt_version, status);
@@ -0,0 +1,16 @@
+-- Income goal plans (goal_plan.go). The baseline and plan are stored as JSON so each plan can be
+-- compared with later ones even after the computation changes; prompt_version says which one.
+CREATE TABLE IF NOT EXISTS goal_plans (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    target_income DECIMAL(15, 2) NOT NULL,
+    target_period VARCHAR(50) NOT NULL,
+    baseline_json TEXT NOT NULL,
+    plan_json TEXT NOT NULL,
+    model VARCHAR(100), -- NULL when only the baseline could be computed
+    prompt_version VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_goal_plans_agent_created ON goal_plans (agent_user_id, created_at);
@@ -0,0 +1,19 @@
+-- Portal recommendations (ai_recommendations.go), generated once per client and input hash and
+-- shown to the client only after the agent approves or pins them.
+CREATE TABLE IF NOT EXISTS ai_recommendations (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    client_id INT NOT NULL,
+    agent_user_id INT NOT NULL,
+    input_hash CHAR(64) NOT NULL, -- SHA-256 of the profile fields, coverage estimation and prompt version
+    generated_text TEXT NOT NULL,
+    edited_text TEXT, -- The agent's version, shown instead of generated_text
+    is_approved BOOLEAN NOT NULL DEFAULT 0,
+    is_pinned BOOLEAN NOT NULL DEFAULT 0, -- Shown even after the inputs change; at most one per client
+    model VARCHAR(100) NOT NULL,
+    prompt_version VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP NULL DEFAULT NULL,
+    UNIQUE(client_id, input_hash),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
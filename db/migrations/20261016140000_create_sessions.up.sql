@@ -0,0 +1,19 @@
+-- Signed-in devices behind refresh tokens (sessions.go). Access tokens carry the session id, so
+-- revoking a row signs that device out.
+CREATE TABLE IF NOT EXISTS sessions (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    refresh_token_hash CHAR(64) NOT NULL UNIQUE, -- SHA-256 of the current refresh token
+    previous_token_hash CHAR(64), -- The token rotated out last, to detect reuse
+    device_name VARCHAR(100),
+    user_agent VARCHAR(255),
+    ip_address VARCHAR(45),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_sessions_user ON sessions (user_id, revoked_at);
+CREATE INDEX idx_sessions_previous_token ON sessions (previous_token_hash);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS agency_settings;
+DROP TABLE IF EXISTS agency_members;
+DROP TABLE IF EXISTS two_factor_recovery_codes;
+DROP TABLE IF EXISTS two_factor;
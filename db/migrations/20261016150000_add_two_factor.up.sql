@@ -0,0 +1,38 @@
+-- TOTP two-factor authentication (two_factor.go). The secret stays pending until the first code
+-- is confirmed; last_used_step stops a code from being replayed within its window.
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id INT PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL, -- Base32, as shown to the authenticator app
+    is_enabled BOOLEAN NOT NULL DEFAULT 0,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    enabled_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS two_factor_recovery_codes (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL, -- SHA-256 of the normalised code
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    UNIQUE(user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Agents working under an agency; agency settings such as the 2FA requirement apply to them.
+CREATE TABLE IF NOT EXISTS agency_members (
+    agent_user_id INT PRIMARY KEY,
+    agency_user_id INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (agency_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_agency_members_agency ON agency_members (agency_user_id);
+
+CREATE TABLE IF NOT EXISTS agency_settings (
+    agency_user_id INT PRIMARY KEY,
+    require_two_factor BOOLEAN NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (agency_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS tokens;
+CREATE TABLE IF NOT EXISTS tokens (
+    user_id INT NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    purpose VARCHAR(20) NOT NULL CHECK(purpose IN ('verification', 'reset')),
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, purpose),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE users DROP COLUMN locked_until;
+ALTER TABLE users DROP COLUMN failed_login_attempts;
@@ -0,0 +1,17 @@
+-- Progressive lockout after failed logins (lockout.go).
+ALTER TABLE users ADD COLUMN failed_login_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TIMESTAMP NULL DEFAULT NULL;
+
+-- Verification and reset tokens are now "selector.verifier": the selector finds the row and only
+-- a SHA-256 of the verifier is stored (selector_tokens.go). Outstanding bcrypt-hashed tokens can't
+-- be looked up that way, so the table is recreated; links sent before this migration stop working.
+DROP TABLE IF EXISTS tokens;
+CREATE TABLE IF NOT EXISTS tokens (
+    selector CHAR(24) PRIMARY KEY,
+    user_id INT NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    purpose VARCHAR(20) NOT NULL CHECK(purpose IN ('verification', 'reset')),
+    expires_at TIMESTAMP NOT NULL,
+    UNIQUE(user_id, purpose),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
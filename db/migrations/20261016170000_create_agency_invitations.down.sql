@@ -0,0 +1,2 @@
+ALTER TABLE agency_settings DROP COLUMN departure_policy;
+DROP TABLE IF EXISTS agency_invitations;
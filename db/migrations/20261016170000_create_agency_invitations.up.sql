@@ -0,0 +1,18 @@
+-- Agencies invite agents by email; accepting adds a row to agency_members (agency.go).
+CREATE TABLE IF NOT EXISTS agency_invitations (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agency_user_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL, -- Lowercased
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'accepted', 'declined', 'revoked')),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    responded_at TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (agency_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_agency_invitations_agency ON agency_invitations (agency_user_id, status);
+CREATE INDEX idx_agency_invitations_email ON agency_invitations (email, status);
+
+-- What happens to a departing agent's clients: 'keep' leaves them with the agent, 'transfer' moves
+-- them (with their policies, tasks and history) to the agency or an agent it names.
+ALTER TABLE agency_settings ADD COLUMN departure_policy VARCHAR(20) NOT NULL DEFAULT 'keep';
@@ -0,0 +1,96 @@
+-- Roles and the permissions they grant (rbac.go, which defines the same set). Agency accounts are
+-- always owner; agents without a user_roles row are agent.
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(100) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(50) NOT NULL,
+    permission VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role, permission),
+    FOREIGN KEY (role) REFERENCES roles(name) ON DELETE CASCADE,
+    FOREIGN KEY (permission) REFERENCES permissions(name) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Roles agencies have given their member agents.
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INT PRIMARY KEY,
+    role VARCHAR(50) NOT NULL,
+    assigned_by INT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (role) REFERENCES roles(name),
+    FOREIGN KEY (assigned_by) REFERENCES users(id) ON DELETE SET NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT INTO roles (name, description) VALUES
+    ('agent', 'Works their own book of clients'),
+    ('auditor', 'Read-only access for audits and compliance'),
+    ('back_office', 'Processes clients, policies and tasks across the agency'),
+    ('manager', 'Runs the agency''s team and products'),
+    ('owner', 'The agency account, with every permission');
+
+INSERT INTO permissions (name, description) VALUES
+    ('clients.read', 'View clients, their communications and documents'),
+    ('clients.write', 'Create and edit clients, their communications and documents'),
+    ('policies.read', 'View policies and renewals'),
+    ('policies.write', 'Create policies'),
+    ('tasks.read', 'View tasks and AI task suggestions'),
+    ('tasks.write', 'Create, update and review tasks'),
+    ('commissions.view', 'View commissions and sales performance'),
+    ('products.manage', 'Add insurance products'),
+    ('marketing.manage', 'Run marketing campaigns and segments'),
+    ('agency.settings.manage', 'Change agency settings'),
+    ('agency.members.manage', 'Invite and remove agency members and assign their roles'),
+    ('agency.books.read', 'View member agents'' clients, policies, tasks and commissions');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('agent', 'clients.read'),
+    ('agent', 'clients.write'),
+    ('agent', 'policies.read'),
+    ('agent', 'policies.write'),
+    ('agent', 'tasks.read'),
+    ('agent', 'tasks.write'),
+    ('agent', 'commissions.view'),
+    ('agent', 'marketing.manage'),
+    ('auditor', 'clients.read'),
+    ('auditor', 'policies.read'),
+    ('auditor', 'tasks.read'),
+    ('auditor', 'commissions.view'),
+    ('auditor', 'agency.books.read'),
+    ('back_office', 'clients.read'),
+    ('back_office', 'clients.write'),
+    ('back_office', 'policies.read'),
+    ('back_office', 'policies.write'),
+    ('back_office', 'tasks.read'),
+    ('back_office', 'tasks.write'),
+    ('back_office', 'agency.books.read'),
+    ('manager', 'clients.read'),
+    ('manager', 'clients.write'),
+    ('manager', 'policies.read'),
+    ('manager', 'policies.write'),
+    ('manager', 'tasks.read'),
+    ('manager', 'tasks.write'),
+    ('manager', 'commissions.view'),
+    ('manager', 'products.manage'),
+    ('manager', 'marketing.manage'),
+    ('manager', 'agency.members.manage'),
+    ('manager', 'agency.books.read'),
+    ('owner', 'clients.read'),
+    ('owner', 'clients.write'),
+    ('owner', 'policies.read'),
+    ('owner', 'policies.write'),
+    ('owner', 'tasks.read'),
+    ('owner', 'tasks.write'),
+    ('owner', 'commissions.view'),
+    ('owner', 'products.manage'),
+    ('owner', 'marketing.manage'),
+    ('owner', 'agency.settings.manage'),
+    ('owner', 'agency.members.manage'),
+    ('owner', 'agency.books.read');
@@ -0,0 +1,5 @@
+ALTER TABLE documents DROP COLUMN deleted_at;
+ALTER TABLE tasks DROP COLUMN deleted_at;
+ALTER TABLE communications DROP COLUMN deleted_at;
+ALTER TABLE policies DROP COLUMN deleted_at;
+ALTER TABLE clients DROP COLUMN deleted_at;
@@ -0,0 +1,7 @@
+-- Deleting a client moves it to the trash with its policies, communications, tasks and documents.
+-- Trashed rows keep deleted_at until they are restored or purged (trash.go).
+ALTER TABLE clients ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE policies ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE communications ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE tasks ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE documents ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
@@ -0,0 +1,15 @@
+-- Audit trail of duplicate clients folded into another (duplicates.go). The merged-away client is
+-- deleted, so its last state is kept here as JSON.
+CREATE TABLE IF NOT EXISTS client_merges (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    surviving_client_id INT NOT NULL,
+    merged_client_id INT NOT NULL,
+    merged_client TEXT NOT NULL,
+    fields_taken VARCHAR(1000) NOT NULL DEFAULT '', -- Comma-separated fields the survivor took from the merged client
+    records_moved INT NOT NULL DEFAULT 0,
+    merged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_client_merges_agent ON client_merges (agent_user_id, merged_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS policy_insured_members;
+DROP TABLE IF EXISTS household_members;
+DROP TABLE IF EXISTS households;
@@ -0,0 +1,31 @@
+-- Families an agent's clients belong to (households.go). A member is either one of the agent's
+-- clients or just a name, e.g. a child insured under a parent's family floater.
+CREATE TABLE IF NOT EXISTS households (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS household_members (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    household_id INT NOT NULL,
+    client_id INT NULL DEFAULT NULL,
+    name VARCHAR(255) NOT NULL, -- Copied from the client, kept if the client is purged
+    relationship VARCHAR(20) NOT NULL, -- self, spouse, child, parent, sibling, parent_in_law or other
+    dob VARCHAR(20),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(client_id), -- A client belongs to at most one household
+    FOREIGN KEY (household_id) REFERENCES households(id) ON DELETE CASCADE,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE SET NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- The members a policy insures, e.g. everyone on a family floater.
+CREATE TABLE IF NOT EXISTS policy_insured_members (
+    policy_id VARCHAR(100) NOT NULL,
+    member_id INT NOT NULL,
+    PRIMARY KEY (policy_id, member_id),
+    FOREIGN KEY (policy_id) REFERENCES policies(id) ON DELETE CASCADE,
+    FOREIGN KEY (member_id) REFERENCES household_members(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,28 @@
+-- Extra client and policy attributes an agent or agency defines (custom_fields.go).
+CREATE TABLE IF NOT EXISTS custom_field_definitions (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    owner_user_id INT NOT NULL, -- The agent, or an agency defining the field for all its agents
+    entity VARCHAR(20) NOT NULL, -- client or policy
+    field_key VARCHAR(64) NOT NULL, -- Name in JSON, list filters and CSV headers
+    label VARCHAR(255) NOT NULL,
+    field_type VARCHAR(20) NOT NULL, -- text, number, date, enum or boolean
+    options TEXT, -- JSON array of the values an enum allows
+    required BOOLEAN NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(owner_user_id, entity, field_key),
+    FOREIGN KEY (owner_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- One row per field set on a client or a policy, holding the value in its canonical text form.
+CREATE TABLE IF NOT EXISTS custom_field_values (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    field_id INT NOT NULL,
+    client_id INT NULL DEFAULT NULL,
+    policy_id VARCHAR(100) NULL DEFAULT NULL,
+    value VARCHAR(1000) NOT NULL,
+    UNIQUE(field_id, client_id),
+    UNIQUE(field_id, policy_id),
+    FOREIGN KEY (field_id) REFERENCES custom_field_definitions(id) ON DELETE CASCADE,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (policy_id) REFERENCES policies(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,21 @@
+-- Field-level history of clients, policies and tasks (history.go): one row per field a mutation
+-- changed, or a single row with an empty field for a change with no field diff (e.g. a delete).
+CREATE TABLE IF NOT EXISTS entity_changes (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    agent_user_id INT NOT NULL,
+    client_id INT NOT NULL, -- The client itself, or the one the policy or task is filed under
+    entity VARCHAR(20) NOT NULL, -- client, policy or task
+    entity_id VARCHAR(100) NOT NULL,
+    action VARCHAR(20) NOT NULL, -- create, update, delete, restore or merge
+    field VARCHAR(100) NOT NULL DEFAULT '', -- JSON name of the field, customFields.<key> for a custom field
+    old_value TEXT,
+    new_value TEXT,
+    actor_user_id INT NULL DEFAULT NULL, -- NULL when nobody signed in made the change (onboarding)
+    source VARCHAR(20) NOT NULL, -- api, bulk_import or onboarding
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_user_id) REFERENCES users(id) ON DELETE SET NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_entity_changes_client ON entity_changes (client_id, changed_at);
@@ -0,0 +1,4 @@
+-- version goes up with every edit of a client; clients are served with it as their ETag, and
+-- conditional updates (If-Match) only apply to the version they name.
+ALTER TABLE clients ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE clients ADD COLUMN updated_at TIMESTAMP NULL DEFAULT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS client_stages;
+DROP TABLE IF EXISTS pipeline_stages;
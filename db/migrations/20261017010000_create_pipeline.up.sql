@@ -0,0 +1,31 @@
+-- Sales pipeline stages an agent or agency configures (pipeline.go). Without rows of their own,
+-- agents use their agency's stages, or the default pipeline.
+CREATE TABLE IF NOT EXISTS pipeline_stages (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    owner_user_id INT NOT NULL, -- The agent, or an agency configuring the pipeline of its agents
+    stage_key VARCHAR(50) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    position INT NOT NULL,
+    outcome VARCHAR(10) NOT NULL DEFAULT 'open', -- open, won or lost
+    next_stages TEXT, -- JSON array of the stage keys a client can move on to
+    UNIQUE(owner_user_id, stage_key),
+    FOREIGN KEY (owner_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- The stages each client has been through. The row without left_at is the client's current stage.
+CREATE TABLE IF NOT EXISTS client_stages (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    client_id INT NOT NULL,
+    agent_user_id INT NOT NULL,
+    stage_key VARCHAR(50) NOT NULL,
+    entered_at TIMESTAMP NOT NULL,
+    left_at TIMESTAMP NULL DEFAULT NULL,
+    deal_value DECIMAL(12, 2) NULL DEFAULT NULL, -- Expected annual premium of the deal
+    lost_reason VARCHAR(255) NULL DEFAULT NULL, -- Set when entering a lost stage
+    changed_by INT NULL DEFAULT NULL,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (changed_by) REFERENCES users(id) ON DELETE SET NULL
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_client_stages_client ON client_stages (client_id, left_at);
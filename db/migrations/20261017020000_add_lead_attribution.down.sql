@@ -0,0 +1,7 @@
+ALTER TABLE clients DROP COLUMN referred_by_client_id;
+ALTER TABLE clients DROP COLUMN utm_content;
+ALTER TABLE clients DROP COLUMN utm_term;
+ALTER TABLE clients DROP COLUMN utm_medium;
+ALTER TABLE clients DROP COLUMN utm_source;
+ALTER TABLE clients DROP COLUMN campaign;
+ALTER TABLE clients DROP COLUMN lead_source;
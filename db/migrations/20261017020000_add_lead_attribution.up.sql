@@ -0,0 +1,11 @@
+-- Where each lead came from (lead_sources.go): the channel, the marketing campaign and the UTM
+-- parameters of the onboarding link it used, and the existing client who referred it.
+ALTER TABLE clients ADD COLUMN lead_source VARCHAR(20) NULL DEFAULT NULL;
+-- campaign holds utm_campaign for leads from onboarding links.
+ALTER TABLE clients ADD COLUMN campaign VARCHAR(100) NULL DEFAULT NULL;
+ALTER TABLE clients ADD COLUMN utm_source VARCHAR(100) NULL DEFAULT NULL;
+ALTER TABLE clients ADD COLUMN utm_medium VARCHAR(100) NULL DEFAULT NULL;
+ALTER TABLE clients ADD COLUMN utm_term VARCHAR(100) NULL DEFAULT NULL;
+ALTER TABLE clients ADD COLUMN utm_content VARCHAR(100) NULL DEFAULT NULL;
+-- The stores clear it when the referrer is purged and repoint it when the referrer is merged away.
+ALTER TABLE clients ADD COLUMN referred_by_client_id INT NULL DEFAULT NULL;
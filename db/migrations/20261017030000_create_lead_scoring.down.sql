@@ -0,0 +1,4 @@
+ALTER TABLE client_portal_tokens DROP COLUMN last_visited_at;
+ALTER TABLE client_portal_tokens DROP COLUMN visit_count;
+DROP TABLE IF EXISTS client_lead_scores;
+DROP TABLE IF EXISTS lead_scoring_weights;
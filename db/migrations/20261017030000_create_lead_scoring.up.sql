@@ -0,0 +1,25 @@
+-- Lead scoring weights an agent or agency configures (lead_scoring.go). Without a row of their own,
+-- agents use their agency's weights, or the defaults.
+CREATE TABLE IF NOT EXISTS lead_scoring_weights (
+    owner_user_id INT PRIMARY KEY, -- The agent, or an agency configuring the weights of its agents
+    weights TEXT NOT NULL, -- JSON object of the weight of each factor
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (owner_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Each client's latest lead score, recomputed when something it depends on changes.
+CREATE TABLE IF NOT EXISTS client_lead_scores (
+    client_id INT PRIMARY KEY,
+    agent_user_id INT NOT NULL,
+    score INT NOT NULL, -- 0 to 100
+    factors TEXT NOT NULL, -- JSON array of each factor's contribution
+    computed_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_client_lead_scores_agent ON client_lead_scores (agent_user_id, score);
+
+-- Portal visits count towards a lead's engagement.
+ALTER TABLE client_portal_tokens ADD COLUMN visit_count INT NOT NULL DEFAULT 0;
+ALTER TABLE client_portal_tokens ADD COLUMN last_visited_at TIMESTAMP NULL DEFAULT NULL;
@@ -0,0 +1,4 @@
+-- Archived clients become Lapsed, the nearest status the old check allows.
+UPDATE clients SET status = 'Lapsed' WHERE status = 'Archived';
+ALTER TABLE clients DROP CHECK chk_clients_status;
+ALTER TABLE clients ADD CONSTRAINT clients_chk_1 CHECK (status IN ('Lead', 'Active', 'Lapsed'));
@@ -0,0 +1,54 @@
+-- SQLite can't alter a CHECK constraint, so clients is rebuilt with the one that allows Archived.
+-- Foreign keys are off meanwhile so dropping the old table doesn't cascade to the rows that
+-- reference it. The columns are those of every earlier migration, in order.
+PRAGMA foreign_keys = OFF;
+CREATE TABLE clients_rebuilt (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    agent_user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    phone VARCHAR(50),
+    dob VARCHAR(20),
+    address TEXT,
+    status VARCHAR(20) CHECK(status IN ('Lead', 'Active', 'Lapsed', 'Archived')) NOT NULL,
+    tags TEXT,
+    last_contacted_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    income DECIMAL(15, 2),
+    marital_status VARCHAR(50),
+    city VARCHAR(100),
+    job_profile VARCHAR(100),
+    dependents INT,
+    liability DECIMAL(15, 2),
+    housing_type VARCHAR(50),
+    vehicle_count INT,
+    vehicle_type VARCHAR(100),
+    vehicle_cost DECIMAL(15, 2),
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+    version INT NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+    lead_source VARCHAR(20) NULL DEFAULT NULL,
+    campaign VARCHAR(100) NULL DEFAULT NULL,
+    utm_source VARCHAR(100) NULL DEFAULT NULL,
+    utm_medium VARCHAR(100) NULL DEFAULT NULL,
+    utm_term VARCHAR(100) NULL DEFAULT NULL,
+    utm_content VARCHAR(100) NULL DEFAULT NULL,
+    referred_by_client_id INT NULL DEFAULT NULL,
+    UNIQUE(agent_user_id, email),
+    UNIQUE(agent_user_id, phone),
+    FOREIGN KEY (agent_user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+INSERT INTO clients_rebuilt (
+    id, agent_user_id, name, email, phone, dob, address, status, tags, last_contacted_at,
+    created_at, income, marital_status, city, job_profile, dependents, liability, housing_type,
+    vehicle_count, vehicle_type, vehicle_cost, deleted_at, version, updated_at, lead_source,
+    campaign, utm_source, utm_medium, utm_term, utm_content, referred_by_client_id
+) SELECT
+    id, agent_user_id, name, email, phone, dob, address, status, tags, last_contacted_at,
+    created_at, income, marital_status, city, job_profile, dependents, liability, housing_type,
+    vehicle_count, vehicle_type, vehicle_cost, deleted_at, version, updated_at, lead_source,
+    campaign, utm_source, utm_medium, utm_term, utm_content, referred_by_client_id
+FROM clients;
+DROP TABLE clients;
+ALTER TABLE clients_rebuilt RENAME TO clients;
+PRAGMA foreign_keys = ON;
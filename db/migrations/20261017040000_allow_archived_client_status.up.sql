@@ -0,0 +1,4 @@
+-- Archiving a client (trash.go) sets its status to Archived, which the initial schema's CHECK
+-- rejects on MySQL 8.0.16+. MySQL named that unnamed column check clients_chk_1.
+ALTER TABLE clients DROP CHECK clients_chk_1;
+ALTER TABLE clients ADD CONSTRAINT chk_clients_status CHECK (status IN ('Lead', 'Active', 'Lapsed', 'Archived'));
@@ -0,0 +1,8 @@
+//go:build sqlite
+
+package main
+
+// Registers the CGO-free SQLite driver used by DB_DRIVER=sqlite. It is kept behind a build
+// tag so production images don't carry it: go build -tags sqlite. go test -tags sqlite also runs
+// the SQL stores against it (store_sqlite_test.go).
+import _ "modernc.org/sqlite"
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// --- SQL Dialects ---
+// Production runs on MySQL. DB_DRIVER=sqlite runs the same stores and migrations against a
+// single SQLite file so the backend can be started locally or in CI without a database server.
+// Migrations and queries are written in MySQL syntax; the few constructs SQLite doesn't
+// understand are rewritten here rather than maintained twice.
+
+type sqlDialect struct {
+	name       string // value of DB_DRIVER
+	driverName string // database/sql driver the dialect is registered under
+}
+
+var (
+	dialectMySQL  = sqlDialect{name: "mysql", driverName: "mysql"}
+	dialectSQLite = sqlDialect{name: "sqlite", driverName: "sqlite"}
+)
+
+// dbDialect is the dialect of the global db, set by openDatabase.
+var dbDialect = dialectMySQL
+
+// dialectFor maps a DB_DRIVER value to its dialect.
+func dialectFor(driver string) (sqlDialect, error) {
+	switch driver {
+	case dialectMySQL.name:
+		return dialectMySQL, nil
+	case dialectSQLite.name:
+		return dialectSQLite, nil
+	default:
+		return sqlDialect{}, fmt.Errorf("unsupported DB_DRIVER %q (expected mysql or sqlite)", driver)
+	}
+}
+
+// checkDriverRegistered fails early with a useful message when the binary was built without the driver.
+func (d sqlDialect) checkDriverRegistered() error {
+	if slices.Contains(sql.Drivers(), d.driverName) {
+		return nil
+	}
+	if d == dialectSQLite {
+		return fmt.Errorf("DB_DRIVER=sqlite requires a binary built with the SQLite driver (go build -tags sqlite)")
+	}
+	return fmt.Errorf("database driver %q is not registered", d.driverName)
+}
+
+// upsertClause returns the clause appended to an INSERT so that a row colliding on keyColumns
+// has updateColumns overwritten with the inserted values instead.
+func (d sqlDialect) upsertClause(keyColumns []string, updateColumns ...string) string {
+	assignments := make([]string, len(updateColumns))
+	if d == dialectSQLite {
+		for i, col := range updateColumns {
+			assignments[i] = fmt.Sprintf("%s = excluded.%s", col, col)
+		}
+		return fmt.Sprintf("ON CONFLICT(%s) DO UPDATE SET %s", strings.Join(keyColumns, ", "), strings.Join(assignments, ", "))
+	}
+	for i, col := range updateColumns {
+		assignments[i] = fmt.Sprintf("%s = VALUES(%s)", col, col)
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+}
+
+// tableExistsQuery returns a query taking the table name and yielding a count > 0 when it exists.
+func (d sqlDialect) tableExistsQuery() string {
+	if d == dialectSQLite {
+		return `SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	}
+	return `
+		SELECT COUNT(1)
+		FROM INFORMATION_SCHEMA.TABLES
+		WHERE TABLE_SCHEMA = DATABASE()
+		AND TABLE_NAME = ?`
+}
+
+var (
+	ddlAutoIncrementPK = regexp.MustCompile(`(?i)\bINT\s+PRIMARY\s+KEY\s+AUTO_INCREMENT\b`)
+	ddlTableOptions    = regexp.MustCompile(`(?i)\)\s*DEFAULT\s+CHARSET\s*=\s*\w+(\s+COLLATE\s*=\s*\w+)?\s*$`)
+	ddlOnUpdate        = regexp.MustCompile(`(?i)\s+ON\s+UPDATE\s+CURRENT_TIMESTAMP\b`)
+)
+
+// translateDDL rewrites a MySQL DDL statement for the dialect. Migrations must stay within what
+// this handles: AUTO_INCREMENT only as "INT PRIMARY KEY AUTO_INCREMENT", one column per
+// ALTER TABLE ... ADD COLUMN, and indexes via CREATE INDEX rather than ALTER TABLE.
+// On SQLite, "ON UPDATE CURRENT_TIMESTAMP" is dropped, so updated_at is only set when written explicitly.
+func (d sqlDialect) translateDDL(stmt string) string {
+	if d != dialectSQLite {
+		return stmt
+	}
+	stmt = ddlAutoIncrementPK.ReplaceAllString(stmt, "INTEGER PRIMARY KEY AUTOINCREMENT")
+	stmt = ddlTableOptions.ReplaceAllString(stmt, ")")
+	stmt = ddlOnUpdate.ReplaceAllString(stmt, "")
+	return stmt
+}
+
+// defaultSQLiteDSN is used when DB_DRIVER=sqlite and DB_DSN is unset. Foreign keys are off by
+// default in SQLite, and the busy timeout lets concurrent requests wait for the write lock.
+const defaultSQLiteDSN = "file:clientwise.db?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
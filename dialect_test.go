@@ -0,0 +1,39 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+// Every script SQLite runs must stay within the MySQL subset translateDDL knows how to rewrite.
+func TestMigrationsTranslateToSQLite(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	mysqlOnly := regexp.MustCompile(`(?i)AUTO_INCREMENT|CHARSET|COLLATE|ON\s+UPDATE|ENGINE\s*=|\bMODIFY\b|ADD\s+(UNIQUE\s+)?(INDEX|KEY)\b`)
+	for _, m := range migrations {
+		for _, script := range []string{m.upScript(dialectSQLite), m.downScript(dialectSQLite)} {
+			for _, stmt := range splitSQLStatements(script) {
+				if found := mysqlOnly.FindString(dialectSQLite.translateDDL(stmt)); found != "" {
+					t.Errorf("migration %d_%s: %q survives SQLite translation in:\n%s", m.Version, m.Name, found, stmt)
+				}
+			}
+		}
+	}
+}
+
+func TestUpsertClause(t *testing.T) {
+	tests := []struct {
+		dialect sqlDialect
+		want    string
+	}{
+		{dialectMySQL, "ON DUPLICATE KEY UPDATE token_hash = VALUES(token_hash), expires_at = VALUES(expires_at)"},
+		{dialectSQLite, "ON CONFLICT(user_id, purpose) DO UPDATE SET token_hash = excluded.token_hash, expires_at = excluded.expires_at"},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.upsertClause([]string{"user_id", "purpose"}, "token_hash", "expires_at"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.dialect.name, got, tt.want)
+		}
+	}
+}
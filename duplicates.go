@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// --- Duplicate Clients ---
+// The UNIQUE(agent_user_id, email/phone) constraints only stop exact repeats, so the same person
+// arriving through onboarding, a CSV upload and manual entry can end up as "R. Kumar" and
+// "Rajesh Kumar" with differently written phone numbers. findDuplicateClients compares clients on
+// normalized email, phone (E.164) and a fuzzy name with the same date of birth; the agent then
+// merges each group into one surviving client (ClientStore.Merge), which is audited.
+
+// Why two clients were flagged as duplicates.
+const (
+	duplicateByEmail   = "email"
+	duplicateByPhone   = "phone"
+	duplicateByNameDob = "name_dob"
+)
+
+// defaultPhoneCountryCode is assumed for numbers written without one.
+const defaultPhoneCountryCode = "91"
+
+// DuplicateGroup is a set of clients that look like the same person, oldest first.
+type DuplicateGroup struct {
+	Clients []Client `json:"clients"`
+	Reasons []string `json:"reasons"`
+}
+
+// MergeClientsPayload names the client to fold into the one in the URL. By default the survivor
+// keeps its own values and only fills its blanks from the duplicate; UseDuplicate lists fields
+// (by their JSON names) to take from the duplicate regardless.
+type MergeClientsPayload struct {
+	DuplicateID  int64    `json:"duplicateId"`
+	UseDuplicate []string `json:"useDuplicate"`
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// normalizePhone returns the number in E.164 form, or "" if it has too few digits to compare.
+// Numbers without a country code are taken to be in defaultPhoneCountryCode.
+func normalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	d := digits.String()
+	switch {
+	case strings.HasPrefix(phone, "+"):
+	case strings.HasPrefix(d, "00"):
+		d = d[2:]
+	case len(d) == 11 && strings.HasPrefix(d, "0"):
+		d = defaultPhoneCountryCode + d[1:]
+	case len(d) == 10:
+		d = defaultPhoneCountryCode + d
+	}
+	if len(d) < 8 || len(d) > 15 {
+		return ""
+	}
+	return "+" + d
+}
+
+// normalizeDob brings the usual ways of writing a date of birth to YYYY-MM-DD.
+func normalizeDob(dob string) string {
+	dob = strings.TrimSpace(dob)
+	for _, layout := range []string{"2006-01-02", time.RFC3339, "02/01/2006", "02-01-2006", "02.01.2006", "2006/01/02"} {
+		if t, err := time.Parse(layout, dob); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return dob
+}
+
+func nameTokens(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return !unicode.IsLetter(r) })
+}
+
+// namesMatch reports whether two names could be the same person: the surnames agree and every
+// other part of the shorter name matches a part of the longer one, in order, as the same word, an
+// initial or a one-letter typo. "R. Kumar" matches "Rajesh Kumar" and "Rajesh Kumaar".
+func namesMatch(a, b string) bool {
+	x, y := nameTokens(a), nameTokens(b)
+	if len(x) == 0 || len(y) == 0 {
+		return false
+	}
+	if len(x) > len(y) {
+		x, y = y, x
+	}
+	if !tokensMatch(x[len(x)-1], y[len(y)-1]) || len(x[len(x)-1]) == 1 {
+		return false
+	}
+	j := 0
+	for _, tok := range x[:len(x)-1] {
+		for j < len(y)-1 && !tokensMatch(tok, y[j]) {
+			j++
+		}
+		if j == len(y)-1 {
+			return false
+		}
+		j++
+	}
+	return true
+}
+
+func tokensMatch(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if len(a) == 1 || len(b) == 1 {
+		return a[0] == b[0]
+	}
+	return len(a) >= 4 && len(b) >= 4 && editDistance(a, b) <= 1
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// findDuplicateClients groups the clients that look like the same person. Clients that match no
+// one are left out.
+func findDuplicateClients(clients []Client) []DuplicateGroup {
+	parent := make([]int, len(clients))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	type match struct {
+		client int
+		reason string
+	}
+	var matches []match
+	link := func(a, b int, reason string) {
+		matches = append(matches, match{a, reason})
+		parent[find(a)] = find(b)
+	}
+
+	byEmail, byPhone, byDob := map[string][]int{}, map[string][]int{}, map[string][]int{}
+	for i, c := range clients {
+		if email := normalizeEmail(c.Email.String); email != "" {
+			byEmail[email] = append(byEmail[email], i)
+		}
+		if phone := normalizePhone(c.Phone.String); phone != "" {
+			byPhone[phone] = append(byPhone[phone], i)
+		}
+		if dob := normalizeDob(c.Dob.String); dob != "" {
+			byDob[dob] = append(byDob[dob], i)
+		}
+	}
+	for _, group := range byEmail {
+		for _, i := range group[1:] {
+			link(group[0], i, duplicateByEmail)
+		}
+	}
+	for _, group := range byPhone {
+		for _, i := range group[1:] {
+			link(group[0], i, duplicateByPhone)
+		}
+	}
+	for _, group := range byDob {
+		for x, i := range group {
+			for _, j := range group[x+1:] {
+				if namesMatch(clients[i].Name, clients[j].Name) {
+					link(i, j, duplicateByNameDob)
+				}
+			}
+		}
+	}
+
+	members, groupReasons := map[int][]Client{}, map[int]map[string]bool{}
+	for _, m := range matches {
+		root := find(m.client)
+		if groupReasons[root] == nil {
+			groupReasons[root] = map[string]bool{}
+		}
+		groupReasons[root][m.reason] = true
+	}
+	for i, c := range clients {
+		if root := find(i); groupReasons[root] != nil {
+			members[root] = append(members[root], c)
+		}
+	}
+	groups := []DuplicateGroup{}
+	for root, group := range members {
+		sort.SliceStable(group, func(i, j int) bool {
+			if !group[i].CreatedAt.Equal(group[j].CreatedAt) {
+				return group[i].CreatedAt.Before(group[j].CreatedAt)
+			}
+			return group[i].ID < group[j].ID
+		})
+		var why []string
+		for reason := range groupReasons[root] {
+			why = append(why, reason)
+		}
+		sort.Strings(why)
+		groups = append(groups, DuplicateGroup{Clients: group, Reasons: why})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Clients[0].ID < groups[j].Clients[0].ID })
+	return groups
+}
+
+// unmergedClientFields are the Client fields (by JSON name) that always stay the survivor's. Custom
+// fields are merged by the store, which moves the duplicate's values for the fields the survivor has none for.
+var unmergedClientFields = map[string]bool{"id": true, "agentUserId": true, "lastContactedAt": true, "createdAt": true, "customFields": true,
+	"version": true, "updatedAt": true, "-": true}
+
+// mergeClientFields returns survivor with the duplicate's values for its blank fields and for the
+// fields named in useDuplicate, and the names of the fields it took.
+func mergeClientFields(survivor, duplicate Client, useDuplicate []string) (Client, []string, error) {
+	forced := map[string]bool{}
+	for _, name := range useDuplicate {
+		forced[name] = true
+	}
+	merged := survivor
+	mv, dv := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(duplicate)
+	taken := []string{}
+	for i := 0; i < mv.NumField(); i++ {
+		name, _, _ := strings.Cut(mv.Type().Field(i).Tag.Get("json"), ",")
+		if name == "" || unmergedClientFields[name] {
+			continue
+		}
+		useDup := forced[name]
+		delete(forced, name)
+		field, dup := mv.Field(i), dv.Field(i)
+		if dup.IsZero() || (!useDup && !field.IsZero()) {
+			continue
+		}
+		field.Set(dup)
+		taken = append(taken, name)
+	}
+	for name := range forced {
+		return Client{}, nil, fmt.Errorf("unknown or unmergeable client field '%s'", name)
+	}
+	return merged, taken, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	for in, want := range map[string]string{
+		"98765 43210":        "+919876543210",
+		"098765-43210":       "+919876543210",
+		"+91 (987) 654-3210": "+919876543210",
+		"0091 9876543210":    "+919876543210",
+		"919876543210":       "+919876543210",
+		"+1 415 555 0100":    "+14155550100",
+		"12345":              "",
+	} {
+		if got := normalizePhone(in); got != want {
+			t.Errorf("normalizePhone(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNamesMatch(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"R. Kumar", "Rajesh Kumar", true},
+		{"rajesh kumar", "Rajesh  KUMAR", true},
+		{"Rajesh Kumaar", "Rajesh Kumar", true},
+		{"Rajesh K. Sharma", "R Sharma", true},
+		{"Rajesh Kumar", "Suresh Kumar", false},
+		{"Rajesh Kumar", "Rajesh Sharma", false},
+		{"R. K.", "Ravi Kapoor", false},
+	} {
+		if got := namesMatch(tc.a, tc.b); got != tc.want {
+			t.Errorf("namesMatch(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestFindDuplicateClients(t *testing.T) {
+	str := func(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+	clients := []Client{
+		{ID: 1, Name: "Rajesh Kumar", Phone: str("98765 43210"), Dob: str("1985-04-12")},
+		{ID: 2, Name: "R. Kumar", Phone: str("+91-98765-43210")},
+		{ID: 3, Name: "Raj Kumar", Dob: str("12/04/1985")},
+		{ID: 4, Name: "Asha Rao", Email: str("Asha@Example.com ")},
+		{ID: 5, Name: "Asha R.", Email: str("asha@example.com")},
+		{ID: 6, Name: "Suresh Kumar", Dob: str("1985-04-12")},
+	}
+	groups := findDuplicateClients(clients)
+	if len(groups) != 2 {
+		t.Fatalf("groups = %+v", groups)
+	}
+	ids := func(g DuplicateGroup) string {
+		s := ""
+		for _, c := range g.Clients {
+			s += fmt.Sprint(c.ID, " ")
+		}
+		return s
+	}
+	if got := ids(groups[0]); got != "1 2 " || fmt.Sprint(groups[0].Reasons) != "[phone]" {
+		t.Errorf("first group = %s %v", got, groups[0].Reasons)
+	}
+	if got := ids(groups[1]); got != "4 5 " || fmt.Sprint(groups[1].Reasons) != "[email]" {
+		t.Errorf("second group = %s %v", got, groups[1].Reasons)
+	}
+
+	// "Raj" is not "Rajesh", but with the same birthday an initial is enough.
+	clients[2].Name = "R Kumar"
+	groups = findDuplicateClients(clients)
+	if got := ids(groups[0]); got != "1 2 3 " || fmt.Sprint(groups[0].Reasons) != "[name_dob phone]" {
+		t.Errorf("first group = %s %v", got, groups[0].Reasons)
+	}
+}
+
+func TestMergeClients(t *testing.T) {
+	s := newTestServer(t)
+	agent := tokenFor(t, 1, "agent")
+	survivor := s.createClient(agent, map[string]interface{}{"name": "Rajesh Kumar", "phone": "9876543210", "city": "Pune"})
+	duplicate := s.createClient(agent, map[string]interface{}{"name": "R. Kumar", "phone": "+91 98765 43210", "email": "rajesh@example.com", "city": "Mumbai"})
+	s.createClient(agent, map[string]interface{}{"name": "Asha Rao", "email": "asha@example.com"})
+	dupPath := fmt.Sprintf("/api/clients/%d", duplicate.ID)
+	s.doJSON(http.MethodPost, dupPath+"/tasks", agent, map[string]string{"description": "Call back"}, http.StatusCreated, nil)
+	s.doJSON(http.MethodPost, dupPath+"/communications", agent, map[string]string{"type": "Call", "summary": "Intro"}, http.StatusCreated, nil)
+
+	var groups []DuplicateGroup
+	s.doJSON(http.MethodGet, "/api/clients/duplicates", agent, nil, http.StatusOK, &groups)
+	if len(groups) != 1 || len(groups[0].Clients) != 2 {
+		t.Fatalf("duplicates = %+v", groups)
+	}
+
+	mergePath := fmt.Sprintf("/api/clients/%d/merge", survivor.ID)
+	s.doJSON(http.MethodPost, mergePath, agent, map[string]interface{}{"duplicateId": survivor.ID}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPost, mergePath, agent, map[string]interface{}{"duplicateId": duplicate.ID, "useDuplicate": []string{"agentUserId"}}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPost, mergePath, tokenFor(t, 2, "agent"), map[string]interface{}{"duplicateId": duplicate.ID}, http.StatusNotFound, nil)
+
+	var result struct {
+		Client Client
+		Merge  ClientMerge
+	}
+	s.doJSON(http.MethodPost, mergePath, agent, map[string]interface{}{"duplicateId": duplicate.ID, "useDuplicate": []string{"city"}}, http.StatusOK, &result)
+	got := result.Client
+	if got.Name != "Rajesh Kumar" || got.Phone.String != "9876543210" || got.Email.String != "rajesh@example.com" || got.City.String != "Mumbai" {
+		t.Fatalf("merged client = %+v", got)
+	}
+	if result.Merge.RecordsMoved != 2 || fmt.Sprint(result.Merge.FieldsTaken) != "[email city]" {
+		t.Fatalf("merge = %+v", result.Merge)
+	}
+	s.doJSON(http.MethodGet, dupPath, agent, nil, http.StatusNotFound, nil)
+	var tasks []Task
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/tasks", survivor.ID), agent, nil, http.StatusOK, &tasks)
+	if len(tasks) != 1 {
+		t.Fatalf("survivor tasks = %+v", tasks)
+	}
+	var merges []ClientMerge
+	s.doJSON(http.MethodGet, "/api/clients/merges", agent, nil, http.StatusOK, &merges)
+	if len(merges) != 1 || merges[0].MergedClientID != duplicate.ID || len(merges[0].MergedClient) == 0 {
+		t.Fatalf("merge history = %+v", merges)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// --- Email Templates ---
+// Every email is rendered from templates/email: <name>.html and <name>.txt define "content"
+// (the .txt also defines "subject") and are wrapped in layout.html / layout.txt together with
+// the partials (header, signature, footer). Templates are compiled into the binary;
+// MAIL_TEMPLATE_DIR points at a directory with the same layout to override them without a rebuild.
+
+//go:embed templates/email
+var embeddedEmailTemplates embed.FS
+
+// EmailBranding is how an email is signed: the agent's agency for mail sent on their behalf,
+// ClientWise itself for account emails.
+type EmailBranding struct {
+	Name         string `json:"name"`
+	LogoURL      string `json:"logoUrl"`
+	Signature    string `json:"signature"`
+	ContactEmail string `json:"contactEmail"`
+	Phone        string `json:"phone"`
+}
+
+// SignatureLines splits a multi-line signature for the HTML partial.
+func (b EmailBranding) SignatureLines() []string {
+	return strings.Split(strings.ReplaceAll(b.Signature, "\r\n", "\n"), "\n")
+}
+
+// emailView is the value every template is executed with.
+type emailView struct {
+	Brand EmailBranding
+	Data  interface{}
+}
+
+// Template data, one type per template family.
+type linkEmailData struct {
+	Link      string
+	ExpiresIn string
+}
+
+type loginEmailData struct {
+	Email string
+	Time  time.Time
+}
+
+type proposalEmailData struct {
+	Insurer     string
+	AgentName   string
+	AgentCode   string
+	ProductID   string
+	ProductName string
+	ClientName  string
+	ClientEmail string
+	ClientPhone string
+	ClientDOB   string
+	ClientCity  string
+}
+
+// emailTemplateSamples lists every template with the data the preview endpoint renders it with.
+var emailTemplateSamples = map[string]interface{}{
+	"verification":       linkEmailData{Link: "https://app.goclientwise.in/verify?token=sample-token", ExpiresIn: "24 hours"},
+	"welcome":            nil,
+	"password_reset":     linkEmailData{Link: "https://app.goclientwise.in/reset-password?token=sample-token", ExpiresIn: "1 hour"},
+	"login_notification": loginEmailData{Email: "agent@example.com", Time: time.Date(2025, 5, 8, 10, 30, 0, 0, time.UTC)},
+	"agency_invitation":  agencyInvitationEmailData{AgencyName: "Sharma Insurance Services", Link: "https://app.goclientwise.in/agency-invitations", ExpiresIn: "14 days"},
+	"proposal_request": proposalEmailData{
+		Insurer: "Star Health", AgentName: "Sharma Insurance Services", AgentCode: "AG-10293",
+		ProductID: "STAR-FHO", ProductName: "Family Health Optima", ClientName: "Rajesh Kumar",
+		ClientEmail: "rajesh@example.com", ClientPhone: "9876543210", ClientDOB: "1985-04-12", ClientCity: "Pune",
+	},
+}
+
+type renderedEmail struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+type emailTemplateRegistry struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// emailTemplates is the registry used to render outgoing mail.
+var emailTemplates = mustLoadEmailTemplates(embeddedEmailTemplates, "templates/email")
+
+func mustLoadEmailTemplates(fsys fs.FS, dir string) *emailTemplateRegistry {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	registry, err := loadEmailTemplates(sub)
+	if err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+// loadEmailTemplates parses the layout, partials and every template in emailTemplateSamples from fsys.
+func loadEmailTemplates(fsys fs.FS) (*emailTemplateRegistry, error) {
+	htmlBase, err := htmltemplate.ParseFS(fsys, "layout.html", "partials/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML email layout: %w", err)
+	}
+	textBase, err := texttemplate.ParseFS(fsys, "layout.txt", "partials/*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse text email layout: %w", err)
+	}
+
+	registry := &emailTemplateRegistry{html: map[string]*htmltemplate.Template{}, text: map[string]*texttemplate.Template{}}
+	for name := range emailTemplateSamples {
+		htmlBody, err := fs.ReadFile(fsys, name+".html")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read email template %s: %w", name, err)
+		}
+		textBody, err := fs.ReadFile(fsys, name+".txt")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read email template %s: %w", name, err)
+		}
+		htmlTmpl, err := htmlBase.Clone()
+		if err == nil {
+			_, err = htmlTmpl.Parse(string(htmlBody))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse email template %s.html: %w", name, err)
+		}
+		textTmpl, err := textBase.Clone()
+		if err == nil {
+			_, err = textTmpl.Parse(string(textBody))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse email template %s.txt: %w", name, err)
+		}
+		if textTmpl.Lookup("subject") == nil {
+			return nil, fmt.Errorf("email template %s.txt does not define a subject", name)
+		}
+		registry.html[name] = htmlTmpl.Option("missingkey=error")
+		registry.text[name] = textTmpl.Option("missingkey=error")
+	}
+	return registry, nil
+}
+
+// Names returns the registered template names in alphabetical order.
+func (r *emailTemplateRegistry) Names() []string {
+	names := make([]string, 0, len(r.html))
+	for name := range r.html {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render produces the subject, HTML and plain-text bodies of a template.
+func (r *emailTemplateRegistry) Render(name string, brand EmailBranding, data interface{}) (renderedEmail, error) {
+	htmlTmpl, ok := r.html[name]
+	if !ok {
+		return renderedEmail{}, fmt.Errorf("unknown email template %q", name)
+	}
+	view := emailView{Brand: brand, Data: data}
+	var subject, html, text bytes.Buffer
+	if err := r.text[name].ExecuteTemplate(&subject, "subject", view); err != nil {
+		return renderedEmail{}, fmt.Errorf("failed to render subject of %s: %w", name, err)
+	}
+	if err := htmlTmpl.ExecuteTemplate(&html, "layout", view); err != nil {
+		return renderedEmail{}, fmt.Errorf("failed to render %s.html: %w", name, err)
+	}
+	if err := r.text[name].ExecuteTemplate(&text, "layout", view); err != nil {
+		return renderedEmail{}, fmt.Errorf("failed to render %s.txt: %w", name, err)
+	}
+	return renderedEmail{Subject: strings.TrimSpace(subject.String()), HTML: html.String(), Text: strings.TrimSpace(text.String()) + "\n"}, nil
+}
+
+// platformBranding signs account emails (verification, password reset, ...).
+func platformBranding() EmailBranding {
+	return EmailBranding{Name: "ClientWise", ContactEmail: config.Email.EmailFrom}
+}
+
+// brandingForAgent builds the agent's branding from their profile, falling back to
+// ClientWise defaults for anything they haven't filled in.
+func brandingForAgent(agentUserID int64) EmailBranding {
+	brand := platformBranding()
+	if user, err := stores.Users.GetByID(agentUserID); err == nil {
+		brand.ContactEmail = user.Email
+	}
+	profile, err := stores.Agents.GetProfile(agentUserID)
+	if err != nil {
+		return brand
+	}
+	if profile.AgencyName.Valid && profile.AgencyName.String != "" {
+		brand.Name = profile.AgencyName.String
+	}
+	brand.LogoURL = profile.LogoURL.String
+	brand.Signature = profile.EmailSignature.String
+	brand.Phone = profile.Mobile.String
+	return brand
+}
+
+// queueTemplatedEmail renders a template with the sender's branding and queues it. agentUserID
+// is the agent sending it, or 0 for account emails, which are branded as ClientWise.
+func queueTemplatedEmail(agentUserID int64, to []string, templateName string, data interface{}) (int64, error) {
+	brand := platformBranding()
+	if agentUserID != 0 {
+		brand = brandingForAgent(agentUserID)
+	}
+	email, err := emailTemplates.Render(templateName, brand, data)
+	if err != nil {
+		return 0, err
+	}
+	return queueEmail(agentUserID, to, email)
+}
+
+// useEmailTemplateDir replaces the embedded templates with the ones in dir (MAIL_TEMPLATE_DIR).
+func useEmailTemplateDir(dir string) error {
+	registry, err := loadEmailTemplates(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+	emailTemplates = registry
+	log.Printf("MAIL: Using email templates from %s\n", dir)
+	return nil
+}
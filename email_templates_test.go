@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEmailTemplatesRenderWithSampleData(t *testing.T) {
+	brand := EmailBranding{Name: "Sharma Insurance Services", Signature: "Anita Sharma\nCertified Advisor"}
+	for _, name := range emailTemplates.Names() {
+		rendered, err := emailTemplates.Render(name, brand, emailTemplateSamples[name])
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if rendered.Subject == "" || strings.Contains(rendered.Subject, "\n") {
+			t.Errorf("%s: bad subject %q", name, rendered.Subject)
+		}
+		if !strings.Contains(rendered.HTML, "Anita Sharma<br>Certified Advisor") {
+			t.Errorf("%s: HTML is missing the signature:\n%s", name, rendered.HTML)
+		}
+		if !strings.Contains(rendered.Text, "Anita Sharma\nCertified Advisor") || strings.Contains(rendered.Text, "<p>") {
+			t.Errorf("%s: unexpected text body:\n%s", name, rendered.Text)
+		}
+	}
+}
+
+func TestEmailTemplatesEscapeHTML(t *testing.T) {
+	data := emailTemplateSamples["proposal_request"].(proposalEmailData)
+	data.ClientName = `<script>alert("x")</script>`
+	rendered, err := emailTemplates.Render("proposal_request", platformBranding(), data)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if strings.Contains(rendered.HTML, "<script>") {
+		t.Fatalf("client name was not escaped in HTML:\n%s", rendered.HTML)
+	}
+	if rendered.Subject != `Insurance Proposal Request for Client: <script>alert("x")</script>` {
+		t.Fatalf("subject = %q", rendered.Subject)
+	}
+}
+
+func TestEmailPreviewUsesAgentBranding(t *testing.T) {
+	s := newTestServer(t)
+	token := tokenFor(t, 7, "agent")
+	s.doJSON(http.MethodPut, "/api/agents/profile", token, map[string]string{
+		"agencyName":     "Sharma Insurance Services",
+		"logoUrl":        "https://cdn.example.com/sharma.png",
+		"emailSignature": "Anita Sharma\nCertified Advisor",
+	}, http.StatusOK, nil)
+
+	var list struct{ Templates []string }
+	s.doJSON(http.MethodGet, "/api/email-templates", token, nil, http.StatusOK, &list)
+	if len(list.Templates) != len(emailTemplateSamples) {
+		t.Fatalf("templates = %v", list.Templates)
+	}
+
+	var preview renderedEmail
+	s.doJSON(http.MethodGet, "/api/email-templates/proposal_request/preview", token, nil, http.StatusOK, &preview)
+	for _, want := range []string{`src="https://cdn.example.com/sharma.png"`, "Anita Sharma<br>Certified Advisor", "Rajesh Kumar"} {
+		if !strings.Contains(preview.HTML, want) {
+			t.Errorf("preview HTML is missing %q", want)
+		}
+	}
+
+	rec := s.do(http.MethodGet, "/api/email-templates/welcome/preview?format=html", token, nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("html preview: status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	s.doJSON(http.MethodGet, "/api/email-templates/nope/preview", token, nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Goal Plans ---
+// A goal plan compares the agent's income goal with what they have actually earned. The numbers
+// are computed deterministically from the agent's own data (computeGoalBaseline); the LLM only adds
+// the qualitative fields, in the response schema prototyped in test_prompt.py. Every plan is
+// stored so agents can compare how their position changes over time.
+
+const (
+	goalPlanPromptVersion = "goal-plan-v1"
+	// Share of leads assumed to convert when the agent has no converted clients to learn from.
+	defaultLeadConversionRate = 0.25
+)
+
+// goalPlanSchema is the response schema from test_prompt.py.
+var goalPlanSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"new_clients_to_add":           map[string]interface{}{"type": "number"},
+		"total_policy_pending_be_sold": map[string]interface{}{"type": "number"},
+		"shortfall_income_this_month":  map[string]interface{}{"type": "number"},
+		"net_shortfall_till_year":      map[string]interface{}{"type": "number"},
+		"top_pros":                     map[string]interface{}{"type": "string"},
+		"things_to_improve":            map[string]interface{}{"type": "string"},
+		"clients_segments_to_focus":    map[string]interface{}{"type": "string"},
+	},
+	"required": []string{"new_clients_to_add", "total_policy_pending_be_sold", "shortfall_income_this_month", "net_shortfall_till_year", "top_pros", "things_to_improve", "clients_segments_to_focus"},
+}
+
+var goalPeriodPattern = regexp.MustCompile(`^(\d{4})(?:-(Q[1-4]|H[12]|0[1-9]|1[0-2]|ANNUAL|YEAR|YEARLY))?$`)
+
+// goalPeriodRange resolves an AgentGoal.TargetPeriod ("2025-Q2", "2025-Annual", "2025-H1",
+// "2025-06" or "2025") to its first and last day.
+func goalPeriodRange(period string) (time.Time, time.Time, error) {
+	match := goalPeriodPattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(period)))
+	if match == nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("unsupported goal period %q (expected e.g. 2025-Annual, 2025-Q2, 2025-H1 or 2025-06)", period)
+	}
+	year, _ := strconv.Atoi(match[1])
+	startMonth, months := 1, 12
+	switch suffix := match[2]; {
+	case strings.HasPrefix(suffix, "Q"):
+		startMonth, months = int(suffix[1]-'0')*3-2, 3
+	case strings.HasPrefix(suffix, "H"):
+		startMonth, months = int(suffix[1]-'0')*6-5, 6
+	case suffix != "" && suffix[0] >= '0' && suffix[0] <= '9':
+		startMonth, _ = strconv.Atoi(suffix)
+		months = 1
+	}
+	start := time.Date(year, time.Month(startMonth), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, months, -1), nil
+}
+
+// computeGoalBaseline derives the plan's numbers from the goal, the agent's clients, their
+// policy counts and commission records. It is a pure function of its inputs.
+func computeGoalBaseline(goal AgentGoal, clients []Client, monthly []MonthlySalesData, commissions []Policy, now time.Time) (GoalPlanBaseline, GoalPlanInsights, error) {
+	if !goal.TargetIncome.Valid || goal.TargetIncome.Float64 <= 0 || !goal.TargetPeriod.Valid {
+		return GoalPlanBaseline{}, GoalPlanInsights{}, fmt.Errorf("no income goal set")
+	}
+	start, end, err := goalPeriodRange(goal.TargetPeriod.String)
+	if err != nil {
+		return GoalPlanBaseline{}, GoalPlanInsights{}, err
+	}
+	periodMonths := (end.Year()-start.Year())*12 + int(end.Month()-start.Month()) + 1
+
+	b := GoalPlanBaseline{
+		TargetIncome:        goal.TargetIncome.Float64,
+		TargetPeriod:        goal.TargetPeriod.String,
+		PeriodStart:         start.Format("2006-01-02"),
+		PeriodEnd:           end.Format("2006-01-02"),
+		MonthlyPolicyCounts: monthly,
+		Assumptions:         []string{"Commission is counted in the month the policy was recorded, as in the commissions report."},
+	}
+	b.MonthlyTarget = roundMoney(b.TargetIncome / float64(periodMonths))
+	b.AnnualTarget = roundMoney(b.MonthlyTarget * 12)
+
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	yearStart := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+	historyStart := monthStart.AddDate(-1, 0, 0)
+	var historyTotal float64
+	for _, p := range commissions {
+		if !p.UpfrontCommissionAmount.Valid || p.CreatedAt.After(now) {
+			continue
+		}
+		amount := p.UpfrontCommissionAmount.Float64
+		if !p.CreatedAt.Before(monthStart) {
+			b.EarnedThisMonth += amount
+		}
+		if !p.CreatedAt.Before(yearStart) {
+			b.EarnedThisYear += amount
+		}
+		if !p.CreatedAt.Before(start) && p.CreatedAt.Before(end.AddDate(0, 0, 1)) {
+			b.EarnedInPeriod += amount
+		}
+		if !p.CreatedAt.Before(historyStart) && amount > 0 {
+			b.PoliciesLast12Months++
+			historyTotal += amount
+		}
+	}
+	b.EarnedThisMonth, b.EarnedThisYear, b.EarnedInPeriod = roundMoney(b.EarnedThisMonth), roundMoney(b.EarnedThisYear), roundMoney(b.EarnedInPeriod)
+	if b.PoliciesLast12Months > 0 {
+		b.AvgCommissionPerPolicy = roundMoney(historyTotal / float64(b.PoliciesLast12Months))
+	}
+
+	for _, c := range clients {
+		switch strings.ToLower(c.Status) {
+		case "lead":
+			b.LeadCount++
+		case "active":
+			b.ActiveCount++
+		case "lapsed":
+			b.LapsedCount++
+		}
+	}
+	b.LeadConversionRate = defaultLeadConversionRate
+	if b.ActiveCount > 0 {
+		b.LeadConversionRate = math.Round(float64(b.ActiveCount)/float64(b.ActiveCount+b.LeadCount)*100) / 100
+	} else {
+		b.Assumptions = append(b.Assumptions, fmt.Sprintf("No active clients yet; assuming %.0f%% of leads convert.", defaultLeadConversionRate*100))
+	}
+
+	plan := GoalPlanInsights{
+		ShortfallIncomeThisMonth: roundMoney(math.Max(0, b.MonthlyTarget-b.EarnedThisMonth)),
+		NetShortfallTillYear:     roundMoney(math.Max(0, b.AnnualTarget-b.EarnedThisYear)),
+	}
+	if b.AvgCommissionPerPolicy > 0 {
+		plan.TotalPolicyPendingBeSold = math.Ceil(plan.NetShortfallTillYear / b.AvgCommissionPerPolicy)
+		expectedFromLeads := math.Floor(float64(b.LeadCount) * b.LeadConversionRate)
+		plan.NewClientsToAdd = math.Max(0, plan.TotalPolicyPendingBeSold-expectedFromLeads)
+	} else {
+		b.Assumptions = append(b.Assumptions, "No commission in the last 12 months, so the number of policies needed cannot be estimated.")
+	}
+	return b, plan, nil
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// generateGoalPlanInsights asks the LLM for the qualitative fields. The numeric fields of the
+// reply are discarded in favour of the baseline.
+func generateGoalPlanInsights(ctx context.Context, baseline GoalPlanBaseline, plan GoalPlanInsights) (GoalPlanInsights, string, error) {
+	facts, err := json.Marshal(map[string]interface{}{"baseline": baseline, "computedPlan": plan})
+	if err != nil {
+		return plan, "", fmt.Errorf("failed to marshal goal plan facts: %w", err)
+	}
+	reply, err := llm.Generate(ctx, LLMRequest{
+		System: "You are a sales coach for insurance agents in India who use the ClientWise CRM. Be specific, practical and encouraging. Amounts are in rupees.",
+		Prompt: "Here is my income goal and how my business is doing, as JSON: " + string(facts) +
+			" Copy the numbers from computedPlan unchanged. In top_pros say what is going well, in things_to_improve what to change," +
+			" and in clients_segments_to_focus which client segments (by status, need or product line) to prioritise to close the shortfall.",
+		JSONSchema:  goalPlanSchema,
+		Temperature: 0.05,
+		MaxTokens:   800,
+	})
+	if err != nil {
+		return plan, "", err
+	}
+	var insights GoalPlanInsights
+	if err := json.Unmarshal([]byte(reply.Text), &insights); err != nil {
+		return plan, "", fmt.Errorf("failed to parse goal plan insights: %w", err)
+	}
+	plan.TopPros = strings.TrimSpace(insights.TopPros)
+	plan.ThingsToImprove = strings.TrimSpace(insights.ThingsToImprove)
+	plan.ClientsSegmentsToFocus = strings.TrimSpace(insights.ClientsSegmentsToFocus)
+	return plan, reply.Model, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGoalPeriodRange(t *testing.T) {
+	cases := map[string][2]string{
+		"2025-Annual": {"2025-01-01", "2025-12-31"},
+		"2025":        {"2025-01-01", "2025-12-31"},
+		"2025-Q2":     {"2025-04-01", "2025-06-30"},
+		"2024-h2":     {"2024-07-01", "2024-12-31"},
+		"2024-02":     {"2024-02-01", "2024-02-29"},
+	}
+	for period, want := range cases {
+		start, end, err := goalPeriodRange(period)
+		if err != nil || start.Format("2006-01-02") != want[0] || end.Format("2006-01-02") != want[1] {
+			t.Errorf("goalPeriodRange(%q) = %v, %v, %v; want %v", period, start, end, err, want)
+		}
+	}
+	if _, _, err := goalPeriodRange("next year"); err == nil {
+		t.Error("goalPeriodRange accepted an unsupported period")
+	}
+}
+
+func TestComputeGoalBaseline(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	goal := AgentGoal{TargetIncome: sql.NullFloat64{Float64: 1200000, Valid: true}, TargetPeriod: sql.NullString{String: "2025-Annual", Valid: true}}
+	commission := func(amount float64, created string) Policy {
+		at, _ := time.Parse("2006-01-02", created)
+		return Policy{UpfrontCommissionAmount: sql.NullFloat64{Float64: amount, Valid: true}, CreatedAt: at}
+	}
+	commissions := []Policy{
+		commission(30000, "2025-06-02"),
+		commission(50000, "2025-03-10"),
+		commission(20000, "2024-09-01"),
+		commission(99999, "2023-01-01"), // Outside every window
+		{CreatedAt: now},                // No commission recorded
+	}
+	clients := []Client{{Status: "Lead"}, {Status: "lead"}, {Status: "Active"}, {Status: "active"}, {Status: "Lapsed"}}
+
+	baseline, plan, err := computeGoalBaseline(goal, clients, nil, commissions, now)
+	if err != nil {
+		t.Fatalf("computeGoalBaseline: %v", err)
+	}
+	if baseline.MonthlyTarget != 100000 || baseline.EarnedThisMonth != 30000 || baseline.EarnedThisYear != 80000 ||
+		baseline.PoliciesLast12Months != 3 || baseline.AvgCommissionPerPolicy != 33333.33 ||
+		baseline.LeadCount != 2 || baseline.ActiveCount != 2 || baseline.LapsedCount != 1 || baseline.LeadConversionRate != 0.5 {
+		t.Fatalf("baseline = %+v", baseline)
+	}
+	// 1,120,000 short needs 34 policies at ~33,333 each; one is expected from the two leads.
+	want := GoalPlanInsights{NewClientsToAdd: 33, TotalPolicyPendingBeSold: 34, ShortfallIncomeThisMonth: 70000, NetShortfallTillYear: 1120000}
+	if plan != want {
+		t.Fatalf("plan = %+v, want %+v", plan, want)
+	}
+
+	// With no history the policy count can't be estimated, and the plan says so.
+	baseline, plan, _ = computeGoalBaseline(goal, nil, nil, nil, now)
+	if plan.TotalPolicyPendingBeSold != 0 || plan.NetShortfallTillYear != 1200000 || len(baseline.Assumptions) != 3 {
+		t.Fatalf("baseline %+v, plan %+v without history", baseline, plan)
+	}
+	if _, _, err := computeGoalBaseline(AgentGoal{}, nil, nil, nil, now); err == nil {
+		t.Fatal("computeGoalBaseline accepted an agent without a goal")
+	}
+}
+
+func TestGoalPlanFlow(t *testing.T) {
+	s := newTestServer(t)
+	const agentID = 3
+	token := tokenFor(t, agentID, "agent")
+	s.doJSON(http.MethodPost, "/api/agents/goal-plan", token, nil, http.StatusBadRequest, nil)
+
+	period := fmt.Sprintf("%d-Annual", time.Now().Year())
+	s.doJSON(http.MethodPut, "/api/agents/goals", token, map[string]interface{}{"targetIncome": 1200000, "targetPeriod": period}, http.StatusOK, nil)
+	c := s.createClient(token, map[string]interface{}{"name": "Meera Iyer", "status": "Active"})
+	if _, err := stores.Policies.Create(Policy{ClientID: c.ID, AgentUserID: agentID, PolicyNumber: "L-1", Status: "Active",
+		UpfrontCommissionAmount: sql.NullFloat64{Float64: 10000, Valid: true}}); err != nil {
+		t.Fatalf("seed policy: %v", err)
+	}
+	fake := &fakeLLMClient{Reply: func(LLMRequest) string {
+		return `{"new_clients_to_add": 5, "total_policy_pending_be_sold": 5, "shortfall_income_this_month": 1, "net_shortfall_till_year": 1,
+			"top_pros": "Strong retention", "things_to_improve": "Add more leads", "clients_segments_to_focus": "Young families"}`
+	}}
+	llm = fake
+
+	var plan GoalPlan
+	s.doJSON(http.MethodPost, "/api/agents/goal-plan", token, nil, http.StatusCreated, &plan)
+	// The numbers come from the baseline, not the model.
+	want := GoalPlanInsights{NewClientsToAdd: 119, TotalPolicyPendingBeSold: 119, ShortfallIncomeThisMonth: 90000, NetShortfallTillYear: 1190000,
+		TopPros: "Strong retention", ThingsToImprove: "Add more leads", ClientsSegmentsToFocus: "Young families"}
+	if plan.ID == 0 || plan.Plan != want || plan.Model.String != "fake" || plan.PromptVersion != goalPlanPromptVersion {
+		t.Fatalf("plan = %+v", plan)
+	}
+	if req := fake.Requests()[0]; req.JSONSchema == nil {
+		t.Fatal("prompt was sent without a response schema")
+	}
+
+	// Without the LLM the computed figures are still saved.
+	llm = unconfiguredLLMClient{}
+	var fallback GoalPlan
+	s.doJSON(http.MethodPost, "/api/agents/goal-plan", token, nil, http.StatusCreated, &fallback)
+	if fallback.Model.Valid || fallback.InsightsError == "" || fallback.Plan.NetShortfallTillYear != 1190000 || fallback.Plan.TopPros != "" {
+		t.Fatalf("fallback plan = %+v", fallback)
+	}
+
+	var plans []GoalPlan
+	s.doJSON(http.MethodGet, "/api/agents/goal-plans", token, nil, http.StatusOK, &plans)
+	if len(plans) != 2 || plans[0].ID != fallback.ID || plans[1].Plan != want {
+		t.Fatalf("plans = %+v", plans)
+	}
+	var stored GoalPlan
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/agents/goal-plans/%d", plan.ID), token, nil, http.StatusOK, &stored)
+	if stored.Baseline.TargetPeriod != period || stored.Baseline.EarnedThisYear != 10000 {
+		t.Fatalf("stored baseline = %+v", stored.Baseline)
+	}
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/agents/goal-plans/%d", plan.ID), tokenFor(t, 4, "agent"), nil, http.StatusNotFound, nil)
+}
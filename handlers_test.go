@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// The suite drives the real chi router end to end, backed by the in-memory stores.
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard) // handlers log every step; keep test output readable
+	jwtSecretKey = []byte("test-secret")
+	config.AccessTokenTTL = time.Hour
+	config.RefreshTokenTTL = 24 * time.Hour
+	os.Exit(m.Run())
+}
+
+type testServer struct {
+	t       *testing.T
+	handler http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	stores = newMemoryStores()
+	llm = &fakeLLMClient{}
+	rateLimiter = newMemoryRateLimitStore()
+	return &testServer{t: t, handler: newRouter()}
+}
+
+// tokenFor starts a session and mints its access token the same way handleLogin does.
+func tokenFor(t *testing.T, userID int64, userType string) string {
+	t.Helper()
+	now := time.Now()
+	sessionID, err := stores.Sessions.Create(Session{UserID: userID, RefreshTokenHash: generateSimpleID(32), LastUsedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	signed, _, err := signAccessToken(userID, userType, sessionID, now)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func (s *testServer) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("marshal body: %v", err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// doJSON performs the request, asserts the status and decodes the response into out (if non-nil).
+func (s *testServer) doJSON(method, path, token string, body interface{}, wantStatus int, out interface{}) {
+	s.t.Helper()
+	rec := s.do(method, path, token, body)
+	if rec.Code != wantStatus {
+		s.t.Fatalf("%s %s: status = %d, want %d; body: %s", method, path, rec.Code, wantStatus, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: decode response: %v; body: %s", method, path, err, rec.Body.String())
+		}
+	}
+}
+
+func (s *testServer) createClient(token string, payload map[string]interface{}) Client {
+	s.t.Helper()
+	var c Client
+	s.doJSON(http.MethodPost, "/api/clients", token, payload, http.StatusCreated, &c)
+	if c.ID == 0 {
+		s.t.Fatalf("created client has no ID")
+	}
+	return c
+}
+
+func TestProtectedRoutesRequireAuth(t *testing.T) {
+	s := newTestServer(t)
+
+	if rec := s.do(http.MethodGet, "/api/clients", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("missing token: status = %d, want 401", rec.Code)
+	}
+	if rec := s.do(http.MethodGet, "/api/clients", "not-a-jwt", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("garbage token: status = %d, want 401", rec.Code)
+	}
+	if rec := s.do(http.MethodPost, "/api/products", tokenFor(t, 1, "agent"), map[string]string{"id": "P1"}); rec.Code != http.StatusForbidden {
+		t.Fatalf("agent creating product: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestClientLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	token := tokenFor(t, 7, "agent")
+
+	// Optional numeric fields are omitted on purpose: they must map to NULL, not panic.
+	created := s.createClient(token, map[string]interface{}{"name": "Asha Rao", "email": "asha@example.com", "status": "Lead"})
+	s.createClient(token, map[string]interface{}{"name": "Vikram Shah", "phone": "9999900000", "status": "Active", "income": 1200000})
+
+	var got Client
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d", created.ID), token, nil, http.StatusOK, &got)
+	if got.Name != "Asha Rao" || got.Email.String != "asha@example.com" || got.Income.Valid {
+		t.Fatalf("unexpected client: %+v", got)
+	}
+
+	var list []Client
+	s.doJSON(http.MethodGet, "/api/clients?search=asha", token, nil, http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != created.ID {
+		t.Fatalf("search: got %d clients, want only %d", len(list), created.ID)
+	}
+	s.doJSON(http.MethodGet, "/api/clients?status=Active", token, nil, http.StatusOK, &list)
+	if len(list) != 1 || list[0].Name != "Vikram Shah" {
+		t.Fatalf("status filter: got %+v", list)
+	}
+
+	s.doJSON(http.MethodPut, fmt.Sprintf("/api/clients/%d", created.ID), token,
+		map[string]interface{}{"name": "Asha R.", "email": "asha@example.com", "status": "Active", "city": "Pune", "dependents": 2},
+		http.StatusOK, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d", created.ID), token, nil, http.StatusOK, &got)
+	if got.Name != "Asha R." || got.City.String != "Pune" || got.Dependents.Int64 != 2 || got.LastContactedAt.Valid {
+		t.Fatalf("update not applied: %+v", got)
+	}
+}
+
+func TestClientsAreScopedToAgent(t *testing.T) {
+	s := newTestServer(t)
+	owner, other := tokenFor(t, 1, "agent"), tokenFor(t, 2, "agent")
+	c := s.createClient(owner, map[string]interface{}{"name": "Private Client", "email": "p@example.com"})
+
+	path := fmt.Sprintf("/api/clients/%d", c.ID)
+	s.doJSON(http.MethodGet, path, other, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodPut, path, other, map[string]interface{}{"name": "Hijacked"}, http.StatusNotFound, nil)
+
+	var list []Client
+	s.doJSON(http.MethodGet, "/api/clients", other, nil, http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("other agent sees %d clients, want 0", len(list))
+	}
+	s.doJSON(http.MethodGet, path+"/policies", other, nil, http.StatusNotFound, nil)
+}
+
+func TestCreatePolicyCommission(t *testing.T) {
+	s := newTestServer(t)
+	const agentID = 3
+	token := tokenFor(t, agentID, "agent")
+	c := s.createClient(token, map[string]interface{}{"name": "Policy Holder", "email": "ph@example.com"})
+
+	// Agent-specific rate wins over the product rate.
+	if err := stores.Insurers.SetRelations(agentID, []AgentInsurerRelation{{
+		InsurerName: "Acme Life", ProductID: "P-LIFE", Name: "Acme Term",
+		UpfrontCommissionPercentage: nullFloat64FromPtr(ptrFloat(15)),
+	}}); err != nil {
+		t.Fatalf("seed relation: %v", err)
+	}
+	if err := stores.Products.Create(Product{ID: "P-HEALTH", Name: "Care", Insurer: "Care Health", Status: "Active",
+		UpfrontCommissionPercentage: nullFloat64FromPtr(ptrFloat(10))}); err != nil {
+		t.Fatalf("seed product: %v", err)
+	}
+
+	path := fmt.Sprintf("/api/clients/%d/policies", c.ID)
+	var p Policy
+	s.doJSON(http.MethodPost, path, token, map[string]interface{}{
+		"productId": "P-LIFE", "policyNumber": "L-1", "insurer": "acme life", "premium": 10000,
+		"startDate": "2025-01-01", "endDate": "2026-01-01", "status": "Active",
+	}, http.StatusCreated, &p)
+	if !p.UpfrontCommissionAmount.Valid || p.UpfrontCommissionAmount.Float64 != 1500 {
+		t.Fatalf("agent rate commission = %+v, want 1500", p.UpfrontCommissionAmount)
+	}
+
+	s.doJSON(http.MethodPost, path, token, map[string]interface{}{
+		"productId": "P-HEALTH", "policyNumber": "H-1", "insurer": "Care Health", "premium": 2500,
+		"startDate": "2025-02-01", "endDate": "2026-02-01", "status": "Active",
+	}, http.StatusCreated, &p)
+	if !p.UpfrontCommissionAmount.Valid || p.UpfrontCommissionAmount.Float64 != 250 {
+		t.Fatalf("product rate commission = %+v, want 250", p.UpfrontCommissionAmount)
+	}
+
+	s.doJSON(http.MethodPost, path, token, map[string]interface{}{"policyNumber": "X"}, http.StatusBadRequest, nil)
+
+	var policies []Policy
+	s.doJSON(http.MethodGet, path, token, nil, http.StatusOK, &policies)
+	if len(policies) != 2 || policies[0].PolicyNumber != "H-1" {
+		t.Fatalf("policies = %+v, want H-1 then L-1 (end date desc)", policies)
+	}
+
+	var records []Policy
+	s.doJSON(http.MethodGet, "/api/commissions", token, nil, http.StatusOK, &records)
+	if len(records) != 2 {
+		t.Fatalf("commission records = %d, want 2", len(records))
+	}
+}
+
+func TestTaskFlow(t *testing.T) {
+	s := newTestServer(t)
+	token := tokenFor(t, 4, "agent")
+	c := s.createClient(token, map[string]interface{}{"name": "Task Client", "phone": "12345"})
+	path := fmt.Sprintf("/api/clients/%d/tasks", c.ID)
+
+	var first, second Task
+	s.doJSON(http.MethodPost, path, token, map[string]interface{}{"description": "Call back", "dueDate": "2025-06-01"}, http.StatusCreated, &first)
+	s.doJSON(http.MethodPost, path, token, map[string]interface{}{"description": "Send quote", "isUrgent": true}, http.StatusCreated, &second)
+	s.doJSON(http.MethodPost, path, token, map[string]interface{}{}, http.StatusBadRequest, nil)
+
+	var pending []Task
+	s.doJSON(http.MethodGet, path, token, nil, http.StatusOK, &pending)
+	if len(pending) != 2 || pending[0].ID != second.ID {
+		t.Fatalf("pending tasks = %+v, want urgent task first", pending)
+	}
+
+	s.doJSON(http.MethodPut, "/api/task/status", token, map[string]interface{}{"taskId": first.ID, "status": "completed"}, http.StatusOK, nil)
+	s.doJSON(http.MethodPut, "/api/task/status", token, map[string]interface{}{"taskId": 99999, "status": "completed"}, http.StatusNotFound, nil)
+
+	var page PaginatedResponse
+	s.doJSON(http.MethodGet, "/api/tasks?status=completed", token, nil, http.StatusOK, &page)
+	if page.TotalItems != 1 {
+		t.Fatalf("completed tasks = %d, want 1", page.TotalItems)
+	}
+
+	var dashboard []Task
+	s.doJSON(http.MethodGet, "/api/dashboard/tasks", token, nil, http.StatusOK, &dashboard)
+	if len(dashboard) != 1 || dashboard[0].ID != second.ID {
+		t.Fatalf("dashboard tasks = %+v, want only the open task", dashboard)
+	}
+}
+
+func TestCommunicationsAndDashboard(t *testing.T) {
+	s := newTestServer(t)
+	token := tokenFor(t, 5, "agent")
+	c := s.createClient(token, map[string]interface{}{"name": "Lead One", "email": "l1@example.com", "status": "Lead"})
+	path := fmt.Sprintf("/api/clients/%d/communications", c.ID)
+
+	s.doJSON(http.MethodPost, path, token, map[string]interface{}{"type": "Call", "summary": "Intro call", "timestamp": "2025-03-01T10:00:00Z"}, http.StatusCreated, nil)
+	s.doJSON(http.MethodPost, path, token, map[string]interface{}{"type": "Email", "summary": "Sent brochure", "timestamp": "2025-03-02T10:00:00Z"}, http.StatusCreated, nil)
+
+	var comms []Communication
+	s.doJSON(http.MethodGet, path, token, nil, http.StatusOK, &comms)
+	if len(comms) != 2 || comms[0].Summary != "Sent brochure" {
+		t.Fatalf("communications = %+v, want newest first", comms)
+	}
+
+	var metrics DashboardMetrics
+	s.doJSON(http.MethodGet, "/api/dashboard/metrics", token, nil, http.StatusOK, &metrics)
+	if metrics.NewLeadsThisWeek != 1 {
+		t.Fatalf("new leads = %d, want 1", metrics.NewLeadsThisWeek)
+	}
+}
+
+func TestPublicOnboarding(t *testing.T) {
+	s := newTestServer(t)
+	payload := map[string]interface{}{"name": "Walk In", "email": "walkin@example.com"}
+
+	s.doJSON(http.MethodPost, "/api/onboard?agentId=9", "", payload, http.StatusCreated, nil)
+	s.doJSON(http.MethodPost, "/api/onboard?agentId=9", "", payload, http.StatusConflict, nil)
+	s.doJSON(http.MethodPost, "/api/onboard", "", payload, http.StatusBadRequest, nil)
+
+	var list []Client
+	s.doJSON(http.MethodGet, "/api/clients", tokenFor(t, 9, "agent"), nil, http.StatusOK, &list)
+	if len(list) != 1 || list[0].Status != "Lead" {
+		t.Fatalf("onboarded clients = %+v, want one Lead", list)
+	}
+}
+
+func TestBulkUploadIsAllOrNothing(t *testing.T) {
+	s := newTestServer(t)
+	token := tokenFor(t, 6, "agent")
+	s.createClient(token, map[string]interface{}{"name": "Existing", "email": "dup@example.com"})
+
+	upload := func(csv string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		fw, err := mw.CreateFormFile("clientFile", "clients.csv")
+		if err != nil {
+			t.Fatalf("form file: %v", err)
+		}
+		fw.Write([]byte(csv))
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/clients/bulk-upload", &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := upload("Name,Email\nNew One,new1@example.com\nClash,dup@example.com\n")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("duplicate row: status = %d, want 500; body: %s", rec.Code, rec.Body.String())
+	}
+	var list []Client
+	s.doJSON(http.MethodGet, "/api/clients", token, nil, http.StatusOK, &list)
+	if len(list) != 1 {
+		t.Fatalf("after failed import: %d clients, want 1", len(list))
+	}
+
+	rec = upload("Name,Email\nNew One,new1@example.com\n,missing-name@example.com\nNew Two,new2@example.com\n")
+	var result BulkUploadResult
+	if rec.Code != http.StatusOK {
+		t.Fatalf("valid import: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode result: %v", err)
+	}
+	if result.SuccessCount != 2 || result.FailureCount != 1 {
+		t.Fatalf("result = %+v, want 2 imported and 1 rejected", result)
+	}
+}
+
+func ptrFloat(f float64) *float64 { return &f }
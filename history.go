@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Change History ---
+// Every mutation of a client, policy or task is recorded field by field in entity_changes, with
+// who made it and through what, so an agent can tell when a client's income or status changed and
+// who changed it. Handlers diff the record before and after the mutation and record the result
+// once the mutation has succeeded; a failure to record is logged rather than failing the request.
+// A client's history includes its policies' and tasks', and shows in its timeline.
+
+// Entities with a change history.
+const (
+	changeEntityClient = "client"
+	changeEntityPolicy = "policy"
+	changeEntityTask   = "task"
+)
+
+// Change actions.
+const (
+	changeActionCreate  = "create"
+	changeActionUpdate  = "update"
+	changeActionDelete  = "delete" // Moved to the trash
+	changeActionRestore = "restore"
+	changeActionMerge   = "merge"
+)
+
+// Where a change came from.
+const (
+	changeSourceAPI        = "api"
+	changeSourceBulkImport = "bulk_import"
+	changeSourceOnboarding = "onboarding"
+)
+
+// untrackedFields are the fields (by JSON name) a diff leaves out: keys, and timestamps the store
+// sets itself.
+var untrackedFields = map[string]bool{"id": true, "clientId": true, "agentUserId": true, "createdAt": true,
+	"updatedAt": true, "lastContactedAt": true, "completedAt": true, "version": true, "-": true}
+
+// changeValue renders a field value for the history; NULL and empty values are null.
+func changeValue(v interface{}) sql.NullString {
+	if valuer, ok := v.(driver.Valuer); ok {
+		v, _ = valuer.Value()
+	}
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return sql.NullString{}
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		s = v.Format(time.RFC3339)
+	default:
+		s = fmt.Sprint(v)
+	}
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// diffFields compares two values of the same struct type and returns a change (Field, OldValue
+// and NewValue) for each tracked field that differs. Custom fields are compared key by key, as
+// customFields.<key>. Diffing against the zero value lists a new record's fields.
+func diffFields(before, after interface{}) []EntityChange {
+	bv, av := reflect.ValueOf(before), reflect.ValueOf(after)
+	changes := []EntityChange{}
+	for i := 0; i < av.NumField(); i++ {
+		name, _, _ := strings.Cut(av.Type().Field(i).Tag.Get("json"), ",")
+		if name == "" || untrackedFields[name] {
+			continue
+		}
+		if fields, ok := av.Field(i).Interface().(map[string]string); ok {
+			old := bv.Field(i).Interface().(map[string]string)
+			keys := append(slices.Collect(maps.Keys(old)), slices.Collect(maps.Keys(fields))...)
+			slices.Sort(keys)
+			for _, key := range slices.Compact(keys) {
+				if old[key] != fields[key] {
+					changes = append(changes, EntityChange{Field: name + "." + key, OldValue: changeValue(old[key]), NewValue: changeValue(fields[key])})
+				}
+			}
+			continue
+		}
+		old, value := changeValue(bv.Field(i).Interface()), changeValue(av.Field(i).Interface())
+		if old != value {
+			changes = append(changes, EntityChange{Field: name, OldValue: old, NewValue: value})
+		}
+	}
+	return changes
+}
+
+// apiChange starts the record of a change the signed-in user made through the API.
+func apiChange(r *http.Request, clientID, agentUserID int64, entity, entityID, action string) EntityChange {
+	actor, _ := getUserIDFromContext(r.Context())
+	return EntityChange{AgentUserID: agentUserID, ClientID: clientID, Entity: entity, EntityID: entityID, Action: action,
+		ActorUserID: sql.NullInt64{Int64: actor, Valid: actor != 0}, Source: changeSourceAPI}
+}
+
+// recordChanges saves fields (from diffFields) as changes made by change. A mutation without
+// field changes is recorded as change alone, except for updates, which record nothing then.
+func recordChanges(change EntityChange, fields []EntityChange) {
+	change.ChangedAt = time.Now()
+	if len(fields) == 0 {
+		if change.Action == changeActionUpdate {
+			return
+		}
+		fields = []EntityChange{{}}
+	}
+	changes := make([]EntityChange, len(fields))
+	for i, field := range fields {
+		changes[i] = change
+		changes[i].Field, changes[i].OldValue, changes[i].NewValue = field.Field, field.OldValue, field.NewValue
+	}
+	if err := stores.Changes.Record(changes); err != nil {
+		log.Printf("ERROR: Failed to record %s of %s %s: %v", change.Action, change.Entity, change.EntityID, err)
+	}
+}
+
+// TimelineEntry is an event in a client's timeline: a communication, a task created, or a change
+// to the client or its policies and tasks. Details holds the Communication, Task or changes.
+type TimelineEntry struct {
+	Type      string      `json:"type"` // communication, task or change
+	Timestamp time.Time   `json:"timestamp"`
+	Summary   string      `json:"summary"`
+	Details   interface{} `json:"details"`
+}
+
+// clientTimeline merges a client's communications, tasks and changes, newest first. The changes
+// one mutation made are one entry.
+func clientTimeline(comms []Communication, tasks []Task, changes []EntityChange) []TimelineEntry {
+	timeline := []TimelineEntry{}
+	for _, comm := range comms {
+		timeline = append(timeline, TimelineEntry{Type: "communication", Timestamp: comm.Timestamp,
+			Summary: fmt.Sprintf("%s: %s", comm.Type, comm.Summary), Details: comm})
+	}
+	for _, task := range tasks {
+		timeline = append(timeline, TimelineEntry{Type: "task", Timestamp: task.CreatedAt, Summary: "Task: " + task.Description, Details: task})
+	}
+	for i := 0; i < len(changes); {
+		c := changes[i]
+		group := []EntityChange{}
+		fields := []string{}
+		for ; i < len(changes) && sameMutation(changes[i], c); i++ {
+			group = append(group, changes[i])
+			if changes[i].Field != "" {
+				fields = append(fields, changes[i].Field)
+			}
+		}
+		summary := fmt.Sprintf("%s %s %s", strings.ToUpper(c.Action[:1])+c.Action[1:], c.Entity, c.EntityID)
+		if c.Action == changeActionUpdate || c.Action == changeActionMerge {
+			summary += ": " + strings.Join(fields, ", ")
+		}
+		if c.Source != changeSourceAPI {
+			summary += fmt.Sprintf(" (%s)", strings.ReplaceAll(c.Source, "_", " "))
+		}
+		timeline = append(timeline, TimelineEntry{Type: "change", Timestamp: c.ChangedAt, Summary: summary, Details: group})
+	}
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].Timestamp.After(timeline[j].Timestamp) })
+	return timeline
+}
+
+// sameMutation reports whether two changes were recorded together.
+func sameMutation(a, b EntityChange) bool {
+	return a.Entity == b.Entity && a.EntityID == b.EntityID && a.Action == b.Action && a.ChangedAt.Equal(b.ChangedAt)
+}
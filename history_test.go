@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	before := Client{ID: 1, Name: "Rajesh", Status: "Lead", Income: sql.NullFloat64{Float64: 1200000, Valid: true},
+		CustomFields: map[string]string{"risk": "Low", "smoker": "false"}}
+	after := before
+	after.ID, after.Status, after.Income = 2, "Active", sql.NullFloat64{}
+	after.CustomFields = map[string]string{"smoker": "false", "height_cm": "172"}
+	var got []string
+	for _, c := range diffFields(before, after) {
+		got = append(got, fmt.Sprintf("%s %s->%s", c.Field, c.OldValue.String, c.NewValue.String))
+	}
+	want := "[status Lead->Active income 1200000-> customFields.height_cm ->172 customFields.risk Low->]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("diffFields = %v, want %v", got, want)
+	}
+	if changes := diffFields(Task{}, Task{ID: 3, Description: "Call"}); len(changes) != 1 || changes[0].Field != "description" || changes[0].OldValue.Valid {
+		t.Errorf("new task's fields = %+v", changes)
+	}
+}
+
+func TestClientHistory(t *testing.T) {
+	s := newTestServer(t)
+	agent, other := tokenFor(t, 1, "agent"), tokenFor(t, 2, "agent")
+	rajesh := s.createClient(agent, map[string]interface{}{"name": "Rajesh", "phone": "9000000001", "status": "Lead", "income": 1200000})
+	clientPath := fmt.Sprintf("/api/clients/%d", rajesh.ID)
+
+	s.doJSON(http.MethodPut, clientPath, agent, map[string]interface{}{"name": "Rajesh", "phone": "9000000001", "status": "Active", "income": 1500000}, http.StatusOK, nil)
+	var task Task
+	s.doJSON(http.MethodPost, clientPath+"/tasks", agent, map[string]interface{}{"description": "Renewal call"}, http.StatusCreated, &task)
+	s.doJSON(http.MethodPut, "/api/task/status", agent, map[string]interface{}{"taskId": task.ID, "status": "completed"}, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, clientPath+"/policies", agent, map[string]interface{}{"policyNumber": "P-1", "status": "Active",
+		"startDate": "2026-01-01", "endDate": "2027-01-01", "premium": 25000}, http.StatusCreated, nil)
+	s.doJSON(http.MethodPost, clientPath+"/archive", agent, nil, http.StatusOK, nil)
+
+	var history []EntityChange
+	s.doJSON(http.MethodGet, clientPath+"/history", other, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodGet, clientPath+"/history", agent, nil, http.StatusOK, &history)
+	find := func(entity, action, field string) *EntityChange {
+		for i, c := range history {
+			if c.Entity == entity && c.Action == action && c.Field == field {
+				return &history[i]
+			}
+		}
+		t.Fatalf("no %s %s of %s in %+v", action, entity, field, history)
+		return nil
+	}
+	if c := find(changeEntityClient, changeActionUpdate, "income"); c.OldValue.String != "1200000" || c.NewValue.String != "1500000" ||
+		c.ActorUserID.Int64 != 1 || c.Source != changeSourceAPI {
+		t.Errorf("income change = %+v", c)
+	}
+	if c := find(changeEntityClient, changeActionUpdate, "status"); c.OldValue.String != "Active" || c.NewValue.String != clientStatusArchived {
+		t.Errorf("newest status change = %+v", c)
+	}
+	if c := find(changeEntityTask, changeActionUpdate, "isCompleted"); c.OldValue.String != "false" || c.NewValue.String != "true" {
+		t.Errorf("task completion = %+v", c)
+	}
+	find(changeEntityPolicy, changeActionCreate, "premium")
+	find(changeEntityClient, changeActionCreate, "name")
+	for _, c := range history {
+		if c.Field == "lastContactedAt" || (c.Action == changeActionUpdate && c.Field == "phone") {
+			t.Errorf("unexpected change %+v", c)
+		}
+	}
+
+	// The timeline shows the task and one entry per mutation.
+	var timeline []TimelineEntry
+	s.doJSON(http.MethodGet, clientPath+"/timeline", agent, nil, http.StatusOK, &timeline)
+	var summaries []string
+	for _, entry := range timeline {
+		summaries = append(summaries, entry.Summary)
+	}
+	joined := strings.Join(summaries, "|")
+	if !strings.HasPrefix(joined, fmt.Sprintf("Update client %d: status", rajesh.ID)) || !strings.Contains(joined, "Task: Renewal call") ||
+		strings.Count(joined, "Create client") != 1 {
+		t.Errorf("timeline = %v", summaries)
+	}
+
+	// Imported clients record where they came from; a merge brings the duplicate's history along.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("clientFile", "clients.csv")
+	if err != nil {
+		t.Fatalf("form file: %v", err)
+	}
+	fw.Write([]byte("Name,Email\nRajesh K,rajesh@example.com\n"))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/clients/bulk-upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+agent)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var list []Client
+	s.doJSON(http.MethodGet, "/api/clients?search=rajesh@example.com", agent, nil, http.StatusOK, &list)
+	if len(list) != 1 {
+		t.Fatalf("imported clients = %+v", list)
+	}
+	imported := list[0]
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/history", imported.ID), agent, nil, http.StatusOK, &history)
+	if len(history) == 0 || history[0].Source != changeSourceBulkImport {
+		t.Fatalf("imported client's history = %+v", history)
+	}
+
+	s.doJSON(http.MethodPost, clientPath+"/merge", agent, map[string]interface{}{"duplicateId": imported.ID, "useDuplicate": []string{"email"}}, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, clientPath+"/history", agent, nil, http.StatusOK, &history)
+	if c := find(changeEntityClient, changeActionMerge, "email"); c.NewValue.String != "rajesh@example.com" {
+		t.Errorf("merge change = %+v", c)
+	}
+	if c := find(changeEntityClient, changeActionCreate, "email"); c.EntityID != fmt.Sprint(imported.ID) || c.Source != changeSourceBulkImport {
+		t.Errorf("duplicate's history after merge = %+v", c)
+	}
+}
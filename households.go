@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// --- Households ---
+// Health cover in India is mostly bought as a family floater, so an agent needs to see the family
+// rather than one client with a count of dependents. A household groups some of the agent's
+// clients with relatives who aren't clients (a child, an elderly parent), each with their
+// relationship. A policy names the members it insures, and a household's policies are the ones its
+// members hold or are insured under. estimateHouseholdCoverage sizes one floater for the family.
+
+// The relationships a household member can have, to the household's "self".
+const (
+	relationshipSelf        = "self"
+	relationshipSpouse      = "spouse"
+	relationshipChild       = "child"
+	relationshipParent      = "parent"
+	relationshipSibling     = "sibling"
+	relationshipParentInLaw = "parent_in_law"
+	relationshipOther       = "other"
+)
+
+var householdRelationships = []string{relationshipSelf, relationshipSpouse, relationshipChild, relationshipParent,
+	relationshipSibling, relationshipParentInLaw, relationshipOther}
+
+type HouseholdPayload struct {
+	Name    string                   `json:"name"`
+	Members []HouseholdMemberPayload `json:"members"`
+}
+
+// HouseholdMemberPayload adds either one of the agent's clients (ClientID) or a named relative.
+type HouseholdMemberPayload struct {
+	ClientID     *int64  `json:"clientId"`
+	Name         string  `json:"name"`
+	Relationship string  `json:"relationship"`
+	Dob          *string `json:"dob"`
+}
+
+type InsuredMembersPayload struct {
+	MemberIDs []int64 `json:"memberIds"`
+}
+
+// member validates the payload and returns the member it describes.
+func (p HouseholdMemberPayload) member() (HouseholdMember, error) {
+	member := HouseholdMember{Relationship: strings.ToLower(strings.TrimSpace(p.Relationship))}
+	if !slices.Contains(householdRelationships, member.Relationship) {
+		return HouseholdMember{}, fmt.Errorf("relationship must be one of %s", strings.Join(householdRelationships, ", "))
+	}
+	if p.ClientID != nil {
+		if *p.ClientID <= 0 {
+			return HouseholdMember{}, fmt.Errorf("invalid client ID %d", *p.ClientID)
+		}
+		member.ClientID = sql.NullInt64{Int64: *p.ClientID, Valid: true}
+	} else if member.Name = strings.TrimSpace(p.Name); member.Name == "" {
+		return HouseholdMember{}, fmt.Errorf("a member needs a clientId or a name")
+	}
+	if p.Dob != nil && strings.TrimSpace(*p.Dob) != "" {
+		member.Dob = sql.NullString{String: normalizeDob(*p.Dob), Valid: true}
+	}
+	return member, nil
+}
+
+// checkSingleSelf returns an error if members, together with the household's existing ones, have
+// more than one "self".
+func checkSingleSelf(existing, members []HouseholdMember) error {
+	selves := 0
+	for _, member := range slices.Concat(existing, members) {
+		if member.Relationship == relationshipSelf {
+			selves++
+		}
+	}
+	if selves > 1 {
+		return fmt.Errorf("a household has only one member with relationship '%s'", relationshipSelf)
+	}
+	return nil
+}
+
+// scopedHousehold loads the {householdId} of the request for the signed-in agent, answering 404
+// if it isn't theirs.
+func scopedHousehold(w http.ResponseWriter, r *http.Request) (*Household, bool) {
+	agentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "Auth error")
+		return nil, false
+	}
+	householdID, err := strconv.ParseInt(chi.URLParam(r, "householdId"), 10, 64)
+	if err != nil || householdID <= 0 {
+		respondError(w, http.StatusBadRequest, "Invalid household ID")
+		return nil, false
+	}
+	household, err := stores.Households.GetByID(householdID, agentUserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Household not found or not owned by agent")
+			return nil, false
+		}
+		log.Printf("ERROR: Failed to get household %d for agent %d: %v", householdID, agentUserID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to retrieve household")
+		return nil, false
+	}
+	return household, true
+}
+
+// MemberCoverageEstimation is a member's own estimate; Estimation is null for members who aren't
+// clients, as there is nothing to estimate from.
+type MemberCoverageEstimation struct {
+	MemberID     int64               `json:"memberId"`
+	Name         string              `json:"name"`
+	Relationship string              `json:"relationship"`
+	Estimation   *CoverageEstimation `json:"estimation"`
+}
+
+// HouseholdCoverageEstimation sizes health as one family floater and life and motor as the totals
+// of the members' own covers.
+type HouseholdCoverageEstimation struct {
+	CoverageEstimation
+	HouseholdID int64                      `json:"householdId"`
+	Members     []MemberCoverageEstimation `json:"members"`
+}
+
+// PortalHousehold is what the client portal shows of the client's family.
+type PortalHousehold struct {
+	Household
+	Policies           []HouseholdPolicy           `json:"policies"`
+	CoverageEstimation HouseholdCoverageEstimation `json:"coverageEstimation"`
+}
+
+// estimateHouseholdCoverage runs estimateCoverage for each member who is a client (found in
+// clients by ID) and once for the family as a whole: one client standing for the household, with
+// the members' incomes, liabilities and vehicles added up, the city of "self" (or of the first
+// member who has one), the eldest member's date of birth and everyone else as a dependent. That
+// gives the floater and the motor total; life cover is per earner, so it is the members' sum.
+func estimateHouseholdCoverage(household Household, clients map[int64]Client) HouseholdCoverageEstimation {
+	estimation := HouseholdCoverageEstimation{HouseholdID: household.ID, Members: []MemberCoverageEstimation{}}
+	family := Client{Dependents: sql.NullInt64{Int64: int64(max(len(household.Members)-1, 0)), Valid: true}}
+	addFloat := func(total *sql.NullFloat64, v sql.NullFloat64) {
+		if v.Valid {
+			total.Float64 += v.Float64
+			total.Valid = true
+		}
+	}
+	eldest := -1
+	life := 0.0
+	lifeNotes := []string{}
+	for _, member := range household.Members {
+		memberEstimation := MemberCoverageEstimation{MemberID: member.ID, Name: member.Name, Relationship: member.Relationship}
+		if dob := normalizeDob(member.Dob.String); member.Dob.Valid && calculateAge(dob) > eldest {
+			eldest = calculateAge(dob)
+			family.Dob = sql.NullString{String: dob, Valid: true}
+		}
+		if client, ok := clients[member.ClientID.Int64]; ok && member.ClientID.Valid {
+			own := estimateCoverage(client)
+			memberEstimation.Estimation = &own
+			addFloat(&family.Income, client.Income)
+			addFloat(&family.Liability, client.Liability)
+			addFloat(&family.VehicleCost, client.VehicleCost)
+			if client.VehicleCount.Valid {
+				family.VehicleCount.Int64 += client.VehicleCount.Int64
+				family.VehicleCount.Valid = true
+			}
+			if client.City.Valid && client.City.String != "" && (!family.City.Valid || member.Relationship == relationshipSelf) {
+				family.City = client.City
+			}
+			if own.Life.Amount > 0 {
+				life += own.Life.Amount
+				lifeNotes = append(lifeNotes, fmt.Sprintf("%.2f Crores for %s.", own.Life.Amount, member.Name))
+			}
+		}
+		estimation.Members = append(estimation.Members, memberEstimation)
+	}
+
+	floater := estimateCoverage(family)
+	estimation.Health = floater.Health
+	estimation.Health.Notes = append([]string{fmt.Sprintf("Family floater for %d members.", len(household.Members))}, floater.Health.Notes...)
+	estimation.Motor = floater.Motor
+	estimation.Life = EstimatedCoverage{Amount: math.Round(life*100) / 100, Unit: floater.Life.Unit, Notes: lifeNotes}
+	if life > 0 {
+		estimation.Life.Notes = append([]string{"Each earning member needs their own term cover; total of the members' estimates."}, lifeNotes...)
+	} else {
+		estimation.Life.Notes = append(lifeNotes, "Insufficient data for estimation.")
+	}
+	return estimation
+}
+
+// householdCoverage fetches the household's clients and estimates its cover. Clients that can't
+// be fetched are estimated as plain members.
+func householdCoverage(household Household) HouseholdCoverageEstimation {
+	clients := map[int64]Client{}
+	for _, member := range household.Members {
+		if !member.ClientID.Valid {
+			continue
+		}
+		client, err := stores.Clients.GetByID(member.ClientID.Int64, household.AgentUserID)
+		if err != nil {
+			log.Printf("WARN: Could not fetch client %d of household %d for estimation: %v", member.ClientID.Int64, household.ID, err)
+			continue
+		}
+		clients[client.ID] = *client
+	}
+	return estimateHouseholdCoverage(household, clients)
+}
+
+// portalHousehold returns the client's household as the portal shows it, or nil if the client has
+// none or it can't be loaded.
+func portalHousehold(clientID, agentUserID int64) *PortalHousehold {
+	household, err := stores.Households.GetByClient(clientID, agentUserID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("WARN: Failed to fetch household for portal view (Client %d): %v", clientID, err)
+		}
+		return nil
+	}
+	policies, err := stores.Households.ListPolicies(household.ID, agentUserID)
+	if err != nil {
+		log.Printf("WARN: Failed to fetch household policies for portal view (Client %d): %v", clientID, err)
+		policies = []HouseholdPolicy{}
+	}
+	return &PortalHousehold{Household: *household, Policies: policies, CoverageEstimation: householdCoverage(*household)}
+}
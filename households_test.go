@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEstimateHouseholdCoverage(t *testing.T) {
+	income := func(v float64) sql.NullFloat64 { return sql.NullFloat64{Float64: v, Valid: true} }
+	household := Household{ID: 7, Members: []HouseholdMember{
+		{ID: 1, ClientID: sql.NullInt64{Int64: 10, Valid: true}, Name: "Rajesh", Relationship: relationshipSelf},
+		{ID: 2, ClientID: sql.NullInt64{Int64: 11, Valid: true}, Name: "Priya", Relationship: relationshipSpouse},
+		{ID: 3, Name: "Aarav", Relationship: relationshipChild, Dob: sql.NullString{String: "2015-06-01", Valid: true}},
+		{ID: 4, Name: "Kamla", Relationship: relationshipParent, Dob: sql.NullString{String: "01/01/1950", Valid: true}},
+	}}
+	clients := map[int64]Client{
+		10: {ID: 10, Name: "Rajesh", Income: income(1500000), City: sql.NullString{String: "Pune", Valid: true}},
+		11: {ID: 11, Name: "Priya", Income: income(1000000), City: sql.NullString{String: "Mumbai", Valid: true}},
+	}
+	est := estimateHouseholdCoverage(household, clients)
+
+	// 5 base + 4 for 25L of income + 3 for the other members + 5 for Kamla's age; Rajesh is "self",
+	// so his city (not a metro) counts.
+	if est.Health.Amount != 17 {
+		t.Errorf("floater = %v Lakhs, notes %v", est.Health.Amount, est.Health.Notes)
+	}
+	if want := 2.25 + 1.5; est.Life.Amount != want {
+		t.Errorf("life = %v Crores, want %v", est.Life.Amount, want)
+	}
+	if len(est.Members) != 4 || est.Members[0].Estimation == nil || est.Members[2].Estimation != nil {
+		t.Fatalf("members = %+v", est.Members)
+	}
+	if est.Members[1].Estimation.Health.Amount != 11 {
+		t.Errorf("Priya's own health estimate = %v", est.Members[1].Estimation.Health.Amount)
+	}
+}
+
+func TestHouseholds(t *testing.T) {
+	s := newTestServer(t)
+	const agentID = 1
+	agent, other := tokenFor(t, agentID, "agent"), tokenFor(t, 2, "agent")
+	rajesh := s.createClient(agent, map[string]interface{}{"name": "Rajesh Kumar", "phone": "9876543210", "income": 1500000})
+	priya := s.createClient(agent, map[string]interface{}{"name": "Priya Kumar", "phone": "9876543211"})
+	stranger := s.createClient(agent, map[string]interface{}{"name": "Asha Rao", "email": "asha@example.com"})
+
+	s.doJSON(http.MethodPost, "/api/households", agent, map[string]interface{}{"name": "Kumars", "members": []map[string]interface{}{
+		{"clientId": rajesh.ID, "relationship": "self"}, {"name": "Aarav", "relationship": "self"},
+	}}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPost, "/api/households", other, map[string]interface{}{"name": "Kumars", "members": []map[string]interface{}{
+		{"clientId": rajesh.ID, "relationship": "self"},
+	}}, http.StatusNotFound, nil)
+
+	var household Household
+	s.doJSON(http.MethodPost, "/api/households", agent, map[string]interface{}{"name": "Kumars", "members": []map[string]interface{}{
+		{"clientId": rajesh.ID, "relationship": "self"},
+		{"clientId": priya.ID, "relationship": "Spouse"},
+		{"name": "Aarav", "relationship": "child", "dob": "2015-06-01"},
+	}}, http.StatusCreated, &household)
+	if len(household.Members) != 3 || household.Members[0].Name != "Rajesh Kumar" || household.Members[1].Relationship != relationshipSpouse {
+		t.Fatalf("household = %+v", household)
+	}
+	self, child := household.Members[0], household.Members[2]
+	householdPath := fmt.Sprintf("/api/households/%d", household.ID)
+
+	// A client is in one household at most, and households are the agent's own.
+	s.doJSON(http.MethodPost, "/api/households", agent, map[string]interface{}{"name": "Again", "members": []map[string]interface{}{
+		{"clientId": priya.ID, "relationship": "self"},
+	}}, http.StatusConflict, nil)
+	s.doJSON(http.MethodGet, householdPath, other, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodPost, householdPath+"/members", other, map[string]interface{}{"name": "Mallory", "relationship": "other"}, http.StatusNotFound, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/household", stranger.ID), agent, nil, http.StatusNotFound, nil)
+
+	// Rajesh's family floater insures him and Aarav; Priya holds a policy of her own.
+	policy := map[string]interface{}{"policyNumber": "FF-1", "status": "Active", "startDate": "2026-01-01", "endDate": "2027-01-01", "sumInsured": 1500000}
+	var floater Policy
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/policies", rajesh.ID), agent, policy, http.StatusCreated, &floater)
+	policy["policyNumber"], policy["endDate"] = "T-1", "2026-12-01"
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/policies", priya.ID), agent, policy, http.StatusCreated, nil)
+	policy["policyNumber"] = "X-1"
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/policies", stranger.ID), agent, policy, http.StatusCreated, nil)
+
+	insuredPath := fmt.Sprintf("/api/clients/%d/policies/%s/insured-members", rajesh.ID, floater.ID)
+	var outsider Household
+	s.doJSON(http.MethodPost, "/api/households", agent, map[string]interface{}{"name": "Raos", "members": []map[string]interface{}{
+		{"clientId": stranger.ID, "relationship": "self"},
+	}}, http.StatusCreated, &outsider)
+	s.doJSON(http.MethodPut, insuredPath, agent, map[string]interface{}{"memberIds": []int64{self.ID, outsider.Members[0].ID}}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPut, fmt.Sprintf("/api/clients/%d/policies/%s/insured-members", priya.ID, floater.ID), agent,
+		map[string]interface{}{"memberIds": []int64{self.ID}}, http.StatusNotFound, nil)
+	s.doJSON(http.MethodPut, insuredPath, agent, map[string]interface{}{"memberIds": []int64{self.ID, child.ID, child.ID}}, http.StatusOK, nil)
+
+	var policies []HouseholdPolicy
+	s.doJSON(http.MethodGet, householdPath+"/policies", agent, nil, http.StatusOK, &policies)
+	if len(policies) != 2 || policies[0].PolicyNumber != "FF-1" || fmt.Sprint(policies[0].InsuredMemberIDs) != fmt.Sprint([]int64{self.ID, child.ID}) ||
+		policies[1].PolicyNumber != "T-1" || len(policies[1].InsuredMemberIDs) != 0 {
+		t.Fatalf("household policies = %+v", policies)
+	}
+
+	// Each change of the insured members is in the policyholder's history; a repeat is not.
+	s.doJSON(http.MethodPut, insuredPath, agent, map[string]interface{}{"memberIds": []int64{child.ID}}, http.StatusOK, nil)
+	s.doJSON(http.MethodPut, insuredPath, agent, map[string]interface{}{"memberIds": []int64{child.ID}}, http.StatusOK, nil)
+	var history []EntityChange
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/history", rajesh.ID), agent, nil, http.StatusOK, &history)
+	var insured []string
+	for _, c := range history {
+		if c.Entity == changeEntityPolicy && c.EntityID == floater.ID && c.Field == "insuredMemberIds" {
+			insured = append(insured, c.OldValue.String+" -> "+c.NewValue.String)
+		}
+	}
+	if want := []string{fmt.Sprintf("%d,%d -> %d", self.ID, child.ID, child.ID), fmt.Sprintf(" -> %d,%d", self.ID, child.ID)}; !slices.Equal(insured, want) {
+		t.Errorf("insured member changes = %q, want %q", insured, want)
+	}
+	s.doJSON(http.MethodPut, insuredPath, agent, map[string]interface{}{"memberIds": []int64{self.ID, child.ID}}, http.StatusOK, nil)
+
+	// Priya's portal shows the whole family's policies.
+	if err := stores.PortalTokens.Store("family-token", priya.ID, agentID, time.Hour); err != nil {
+		t.Fatalf("seed portal token: %v", err)
+	}
+	var view PublicClientView
+	s.doJSON(http.MethodGet, "/api/portal/client/family-token/", "", nil, http.StatusOK, &view)
+	if view.Household == nil || view.Household.ID != household.ID || len(view.Household.Policies) != 2 || len(view.Household.CoverageEstimation.Members) != 3 {
+		t.Fatalf("portal household = %+v", view.Household)
+	}
+
+	// Removing the child drops it from the floater; trashing a client leaves a plain member.
+	s.doJSON(http.MethodDelete, fmt.Sprintf("%s/members/%d", householdPath, child.ID), agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodDelete, fmt.Sprintf("/api/clients/%d", priya.ID), agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/household", rajesh.ID), agent, nil, http.StatusOK, &household)
+	if len(household.Members) != 2 || household.Members[1].ClientID.Valid || household.Members[1].Name != "Priya Kumar" {
+		t.Fatalf("household after changes = %+v", household)
+	}
+	s.doJSON(http.MethodGet, householdPath+"/policies", agent, nil, http.StatusOK, &policies)
+	if len(policies) != 1 || fmt.Sprint(policies[0].InsuredMemberIDs) != fmt.Sprint([]int64{self.ID}) {
+		t.Fatalf("household policies after changes = %+v", policies)
+	}
+
+	s.doJSON(http.MethodDelete, householdPath, agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/household", rajesh.ID), agent, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d", rajesh.ID), agent, nil, http.StatusOK, nil)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- Lead Scoring ---
+// Every client gets a score from 0 to 100 saying how promising a lead it is, so agents can work
+// the best ones first. The score adds up weighted factors: the client's profile (income,
+// dependents, liabilities, vehicles, age), how engaged they are (how recently they were contacted,
+// portal visits, uploaded documents) and how much of the cover estimateCoverage suggests they
+// don't have yet. Each factor has a strength from 0 to 1; the score is the weighted average of
+// the strengths. A client's score is recomputed whenever something it depends on changes, and is
+// stored with every factor's contribution so the agent can see why a lead ranks where it does.
+// Weights come from the same places as the pipeline: the agent's own, else their agency's, else
+// the defaults.
+
+const (
+	leadFactorIncome        = "income"
+	leadFactorDependents    = "dependents"
+	leadFactorLiability     = "liability"
+	leadFactorVehicle       = "vehicle"
+	leadFactorAge           = "age"
+	leadFactorRecentContact = "recent_contact"
+	leadFactorPortalVisits  = "portal_visits"
+	leadFactorDocuments     = "documents"
+	leadFactorCoverageGap   = "coverage_gap"
+)
+
+// leadScoreFactors are the factors in the order a score's breakdown lists them.
+var leadScoreFactors = []string{leadFactorIncome, leadFactorDependents, leadFactorLiability, leadFactorVehicle, leadFactorAge,
+	leadFactorRecentContact, leadFactorPortalVisits, leadFactorDocuments, leadFactorCoverageGap}
+
+const maxLeadFactorWeight = 100
+
+// Where a factor reaches full strength.
+const (
+	leadFullIncome      = 2500000.0 // Annual income, ₹
+	leadFullDependents  = 3
+	leadFullLiability   = 5000000.0
+	leadFullVehicleCost = 1500000.0
+	leadFullVisits      = 3
+	leadFullDocuments   = 3
+)
+
+// Orders of GET /api/clients, by its sort parameter.
+const (
+	clientSortNewest = "created" // The default
+	clientSortScore  = "score"   // Highest lead score first, unscored clients last
+)
+
+var clientSorts = []string{clientSortNewest, clientSortScore}
+
+// LeadScoreWeights is the weight of each factor, by factor name.
+type LeadScoreWeights map[string]int
+
+func defaultLeadScoreWeights() LeadScoreWeights {
+	return LeadScoreWeights{leadFactorIncome: 15, leadFactorDependents: 10, leadFactorLiability: 10, leadFactorVehicle: 5, leadFactorAge: 10,
+		leadFactorRecentContact: 15, leadFactorPortalVisits: 10, leadFactorDocuments: 10, leadFactorCoverageGap: 15}
+}
+
+// LeadScoreWeightsPayload replaces the signed-in user's weights. Factors it leaves out keep their
+// default weight; no weights at all reverts to the ones they'd inherit.
+type LeadScoreWeightsPayload struct {
+	Weights map[string]int `json:"weights"`
+}
+
+// weights validates the payload and returns the weights it describes, nil to revert.
+func (p LeadScoreWeightsPayload) weights() (LeadScoreWeights, error) {
+	if len(p.Weights) == 0 {
+		return nil, nil
+	}
+	weights := defaultLeadScoreWeights()
+	for factor, weight := range p.Weights {
+		if !slices.Contains(leadScoreFactors, factor) {
+			return nil, fmt.Errorf("unknown factor '%s', factors are %s", factor, strings.Join(leadScoreFactors, ", "))
+		}
+		if weight < 0 || weight > maxLeadFactorWeight {
+			return nil, fmt.Errorf("the weight of %s must be between 0 and %d", factor, maxLeadFactorWeight)
+		}
+		weights[factor] = weight
+	}
+	if weights.total() == 0 {
+		return nil, fmt.Errorf("at least one factor needs a weight")
+	}
+	return weights, nil
+}
+
+func (w LeadScoreWeights) total() int {
+	total := 0
+	for _, factor := range leadScoreFactors {
+		total += w[factor]
+	}
+	return total
+}
+
+// agentLeadScoreWeights returns the weights a user's clients are scored with and where they come from.
+func agentLeadScoreWeights(userID int64) (LeadScoreWeights, string, error) {
+	weights, err := stores.LeadScores.GetWeights(userID)
+	if err == nil {
+		return weights, pipelineSourceOwn, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, "", fmt.Errorf("failed to load lead scoring weights of user %d: %w", userID, err)
+	}
+	member, err := stores.Agencies.AgencyOf(userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", fmt.Errorf("failed to load agency of user %d: %w", userID, err)
+	}
+	if err == nil {
+		weights, err = stores.LeadScores.GetWeights(member.AgencyUserID)
+		if err == nil {
+			return weights, pipelineSourceAgency, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, "", fmt.Errorf("failed to load lead scoring weights of agency %d: %w", member.AgencyUserID, err)
+		}
+	}
+	return defaultLeadScoreWeights(), pipelineSourceDefault, nil
+}
+
+// leadEngagement is what a client's engagement factors are computed from.
+type leadEngagement struct {
+	LastContact  time.Time // Zero if the client was never contacted
+	PortalVisits int
+	Documents    int
+	CoverInForce float64 // Sum insured of the client's active policies
+}
+
+// clientEngagement gathers the client's engagement from its communications, portal visits,
+// documents and policies.
+func clientEngagement(client Client) (leadEngagement, error) {
+	var e leadEngagement
+	if client.LastContactedAt.Valid {
+		e.LastContact = client.LastContactedAt.Time
+	}
+	comms, err := stores.Communications.ListByClient(client.ID, client.AgentUserID)
+	if err != nil {
+		return e, fmt.Errorf("failed to list communications of client %d: %w", client.ID, err)
+	}
+	for _, comm := range comms {
+		if comm.Timestamp.After(e.LastContact) {
+			e.LastContact = comm.Timestamp
+		}
+	}
+	if e.PortalVisits, err = stores.PortalTokens.CountVisits(client.ID, client.AgentUserID); err != nil {
+		return e, fmt.Errorf("failed to count portal visits of client %d: %w", client.ID, err)
+	}
+	docs, err := stores.Documents.ListByClient(client.ID, client.AgentUserID)
+	if err != nil {
+		return e, fmt.Errorf("failed to list documents of client %d: %w", client.ID, err)
+	}
+	e.Documents = len(docs)
+	policies, err := stores.Policies.ListByClient(client.ID, client.AgentUserID)
+	if err != nil {
+		return e, fmt.Errorf("failed to list policies of client %d: %w", client.ID, err)
+	}
+	for _, p := range policies {
+		if strings.EqualFold(p.Status, "Active") {
+			e.CoverInForce += p.SumInsured
+		}
+	}
+	return e, nil
+}
+
+// leadFactorStrength returns how strongly the client shows a factor, from 0 to 1, and why.
+func leadFactorStrength(factor string, client Client, e leadEngagement, now time.Time) (float64, string) {
+	switch factor {
+	case leadFactorIncome:
+		if !client.Income.Valid || client.Income.Float64 <= 0 {
+			return 0, "Income not recorded"
+		}
+		return math.Min(client.Income.Float64/leadFullIncome, 1), fmt.Sprintf("Annual income of ₹%.0f", client.Income.Float64)
+	case leadFactorDependents:
+		if !client.Dependents.Valid || client.Dependents.Int64 <= 0 {
+			return 0, "No dependents recorded"
+		}
+		return math.Min(float64(client.Dependents.Int64)/leadFullDependents, 1), fmt.Sprintf("Dependents: %d", client.Dependents.Int64)
+	case leadFactorLiability:
+		if !client.Liability.Valid || client.Liability.Float64 <= 0 {
+			return 0, "No liabilities recorded"
+		}
+		return math.Min(client.Liability.Float64/leadFullLiability, 1), fmt.Sprintf("Liabilities of ₹%.0f to protect", client.Liability.Float64)
+	case leadFactorVehicle:
+		if !client.VehicleCost.Valid || client.VehicleCost.Float64 <= 0 {
+			return 0, "No vehicle recorded"
+		}
+		return math.Min(client.VehicleCost.Float64/leadFullVehicleCost, 1), fmt.Sprintf("Vehicles worth ₹%.0f", client.VehicleCost.Float64)
+	case leadFactorAge:
+		age := 0
+		if client.Dob.Valid {
+			age = calculateAge(client.Dob.String)
+		}
+		switch {
+		case age <= 0:
+			return 0, "Date of birth not recorded"
+		case age >= 25 && age <= 45:
+			return 1, fmt.Sprintf("Aged %d, when most cover is bought", age)
+		case age >= 18 && age <= 60:
+			return 0.6, fmt.Sprintf("Aged %d", age)
+		default:
+			return 0.2, fmt.Sprintf("Aged %d, cover is harder to place", age)
+		}
+	case leadFactorRecentContact:
+		if e.LastContact.IsZero() {
+			return 0, "Never contacted"
+		}
+		detail := "Last contacted on " + e.LastContact.Format("2006-01-02")
+		switch days := now.Sub(e.LastContact).Hours() / 24; {
+		case days <= 7:
+			return 1, detail
+		case days <= 30:
+			return 0.6, detail
+		case days <= 90:
+			return 0.3, detail
+		default:
+			return 0, detail
+		}
+	case leadFactorPortalVisits:
+		if e.PortalVisits == 0 {
+			return 0, "Never visited the client portal"
+		}
+		return math.Min(float64(e.PortalVisits)/leadFullVisits, 1), fmt.Sprintf("Client portal visits: %d", e.PortalVisits)
+	case leadFactorDocuments:
+		if e.Documents == 0 {
+			return 0, "No documents uploaded"
+		}
+		return math.Min(float64(e.Documents)/leadFullDocuments, 1), fmt.Sprintf("Documents uploaded: %d", e.Documents)
+	case leadFactorCoverageGap:
+		estimation := estimateCoverage(client)
+		needed := estimation.Health.Amount*100000 + estimation.Life.Amount*10000000 // Lakhs and Crores
+		if needed <= 0 {
+			return 0, "Not enough details to estimate the cover needed"
+		}
+		gap := math.Max(needed-e.CoverInForce, 0)
+		return gap / needed, fmt.Sprintf("₹%.0f of the estimated ₹%.0f health and life cover isn't in force", gap, needed)
+	}
+	return 0, ""
+}
+
+// computeLeadScore scores the client with weights, listing every factor's contribution.
+func computeLeadScore(client Client, e leadEngagement, weights LeadScoreWeights, now time.Time) LeadScore {
+	score := LeadScore{ClientID: client.ID, AgentUserID: client.AgentUserID, Factors: make([]LeadScoreFactor, 0, len(leadScoreFactors)), ComputedAt: now}
+	points := 0.0
+	for _, factor := range leadScoreFactors {
+		strength, detail := leadFactorStrength(factor, client, e, now)
+		weight := weights[factor]
+		points += float64(weight) * strength
+		score.Factors = append(score.Factors, LeadScoreFactor{Factor: factor, Weight: weight, Strength: roundMoney(strength),
+			Points: roundMoney(float64(weight) * strength), Detail: detail})
+	}
+	if total := weights.total(); total > 0 {
+		score.Score = int(math.Round(points * 100 / float64(total)))
+	}
+	return score
+}
+
+// scoreClient recomputes and stores the client's lead score.
+func scoreClient(client Client, weights LeadScoreWeights) (*LeadScore, error) {
+	engagement, err := clientEngagement(client)
+	if err != nil {
+		return nil, err
+	}
+	score := computeLeadScore(client, engagement, weights, time.Now())
+	if err := stores.LeadScores.Save(score); err != nil {
+		return nil, fmt.Errorf("failed to save lead score of client %d: %w", client.ID, err)
+	}
+	return &score, nil
+}
+
+// refreshLeadScore rescores a client after something its score depends on changed. A failure is
+// only logged: the change itself went through, and the next one rescores the client.
+func refreshLeadScore(clientID, agentUserID int64) {
+	client, err := stores.Clients.GetByID(clientID, agentUserID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load client %d to rescore it: %v", clientID, err)
+		return
+	}
+	weights, _, err := agentLeadScoreWeights(agentUserID)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+	if _, err := scoreClient(*client, weights); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
+
+// rescoreAgentClients rescores all of the agent's clients, returning how many were scored.
+func rescoreAgentClients(agentUserID int64) (int, error) {
+	weights, _, err := agentLeadScoreWeights(agentUserID)
+	if err != nil {
+		return 0, err
+	}
+	clients, err := stores.Clients.ListAll(agentUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list clients of agent %d: %w", agentUserID, err)
+	}
+	for _, client := range clients {
+		if _, err := scoreClient(client, weights); err != nil {
+			return 0, err
+		}
+	}
+	return len(clients), nil
+}
+
+// rescoreAfterWeightsChange rescores the clients whose weights changed with the user's: the user's
+// own and, for an agency, those of its agents who haven't configured weights of their own.
+func rescoreAfterWeightsChange(userID int64) (int, error) {
+	agents := []int64{userID}
+	members, err := stores.Agencies.ListMembers(userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list members of agency %d: %w", userID, err)
+	}
+	for _, member := range members {
+		if _, err := stores.LeadScores.GetWeights(member.AgentUserID); err == sql.ErrNoRows {
+			agents = append(agents, member.AgentUserID)
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to load lead scoring weights of user %d: %w", member.AgentUserID, err)
+		}
+	}
+	rescored := 0
+	for _, agentUserID := range agents {
+		n, err := rescoreAgentClients(agentUserID)
+		if err != nil {
+			return rescored, err
+		}
+		rescored += n
+	}
+	return rescored, nil
+}
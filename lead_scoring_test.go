@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLeadScoreWeightsPayload(t *testing.T) {
+	weights, err := LeadScoreWeightsPayload{Weights: map[string]int{leadFactorIncome: 40, leadFactorVehicle: 0}}.weights()
+	if err != nil || weights[leadFactorIncome] != 40 || weights[leadFactorVehicle] != 0 || weights[leadFactorAge] != 10 {
+		t.Fatalf("weights = %v, %v", weights, err)
+	}
+	if weights, err := (LeadScoreWeightsPayload{}).weights(); err != nil || weights != nil {
+		t.Errorf("empty weights = %v, %v", weights, err)
+	}
+	zero := map[string]int{}
+	for _, factor := range leadScoreFactors {
+		zero[factor] = 0
+	}
+	for name, bad := range map[string]map[string]int{
+		"unknown factor": {"city": 10},
+		"negative":       {leadFactorIncome: -1},
+		"too heavy":      {leadFactorIncome: maxLeadFactorWeight + 1},
+		"all zero":       zero,
+	} {
+		if _, err := (LeadScoreWeightsPayload{Weights: bad}).weights(); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestComputeLeadScore(t *testing.T) {
+	now := time.Now()
+	client := Client{ID: 1, AgentUserID: 9,
+		Income:     sql.NullFloat64{Float64: 1250000, Valid: true},
+		Dependents: sql.NullInt64{Int64: 3, Valid: true},
+		Dob:        sql.NullString{String: now.AddDate(-30, -1, 0).Format("2006-01-02"), Valid: true},
+	}
+	// Estimated cover: 9 Lakhs health and 1.88 Crores life, half of it in force.
+	engagement := leadEngagement{LastContact: now.Add(-72 * time.Hour), PortalVisits: 1, CoverInForce: 9850000}
+	score := computeLeadScore(client, engagement, defaultLeadScoreWeights(), now)
+	if score.Score != 53 || len(score.Factors) != len(leadScoreFactors) {
+		t.Fatalf("score = %+v", score)
+	}
+	var got []string
+	for _, f := range score.Factors {
+		got = append(got, fmt.Sprintf("%s:%.2f", f.Factor, f.Points))
+	}
+	if fmt.Sprint(got) != "[income:7.50 dependents:10.00 liability:0.00 vehicle:0.00 age:10.00 recent_contact:15.00 portal_visits:3.33 documents:0.00 coverage_gap:7.50]" {
+		t.Errorf("factors = %v", got)
+	}
+	if f := score.Factors[3]; f.Strength != 0 || f.Detail != "No vehicle recorded" {
+		t.Errorf("vehicle factor = %+v", f)
+	}
+
+	// Only the weighted factors count, and a stale contact counts for nothing.
+	engagement.LastContact = now.AddDate(0, -6, 0)
+	incomeOnly := LeadScoreWeights{leadFactorIncome: 50, leadFactorRecentContact: 50}
+	if score := computeLeadScore(client, engagement, incomeOnly, now); score.Score != 25 {
+		t.Errorf("score with custom weights = %+v", score)
+	}
+}
+
+func TestLeadScoring(t *testing.T) {
+	s := newTestServer(t)
+	seed := func(email, userType string) int64 {
+		id, err := stores.Users.Create(User{Email: email, UserType: userType, IsVerified: true})
+		if err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+		return id
+	}
+	agencyID, agentID := seed("agency@example.com", "agency"), seed("agent@example.com", "agent")
+	if err := stores.Agencies.AddMember(agencyID, agentID); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	agency, agent := tokenFor(t, agencyID, "agency"), tokenFor(t, agentID, "agent")
+
+	rich := s.createClient(agent, map[string]interface{}{"name": "Rich", "phone": "9000000001", "income": 2500000, "dependents": 2,
+		"dob": time.Now().AddDate(-35, -1, 0).Format("2006-01-02")})
+	cold := s.createClient(agent, map[string]interface{}{"name": "Cold", "phone": "9000000002"})
+	score := func(id int64) LeadScore {
+		var sc LeadScore
+		s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/score", id), agent, nil, http.StatusOK, &sc)
+		return sc
+	}
+	factor := func(sc LeadScore, name string) LeadScoreFactor {
+		for _, f := range sc.Factors {
+			if f.Factor == name {
+				return f
+			}
+		}
+		t.Fatalf("score has no %s factor: %+v", name, sc)
+		return LeadScoreFactor{}
+	}
+	coldScore := score(cold.ID)
+	if richScore := score(rich.ID); richScore.Score <= coldScore.Score || factor(richScore, leadFactorIncome).Strength != 1 {
+		t.Fatalf("scores on creation: rich %+v, cold %+v", richScore, coldScore)
+	}
+
+	// The list sorts by score on request, unscored clients last.
+	unscored := Client{AgentUserID: agentID, Name: "Unscored", Status: "Lead"}
+	if _, err := stores.Clients.Create(unscored); err != nil {
+		t.Fatalf("create unscored client: %v", err)
+	}
+	var list []Client
+	s.doJSON(http.MethodGet, "/api/clients?sort=score", agent, nil, http.StatusOK, &list)
+	if len(list) != 3 || list[0].ID != rich.ID || list[0].LeadScore.Int64 != int64(score(rich.ID).Score) || list[1].ID != cold.ID || list[2].LeadScore.Valid {
+		t.Fatalf("clients by score = %+v", list)
+	}
+	s.doJSON(http.MethodGet, "/api/clients", agent, nil, http.StatusOK, &list)
+	if list[0].Name != "Unscored" {
+		t.Errorf("default order = %+v", list)
+	}
+	s.doJSON(http.MethodGet, "/api/clients?sort=name", agent, nil, http.StatusBadRequest, nil)
+
+	// Engagement rescores the client: being contacted, visiting the portal.
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/communications", cold.ID), agent,
+		map[string]interface{}{"type": "Call", "summary": "Intro call"}, http.StatusCreated, nil)
+	contacted := score(cold.ID)
+	if contacted.Score <= coldScore.Score || factor(contacted, leadFactorRecentContact).Strength != 1 {
+		t.Fatalf("score after a call = %+v", contacted)
+	}
+	if err := stores.PortalTokens.Store("portal-token", cold.ID, agentID, time.Hour); err != nil {
+		t.Fatalf("store portal token: %v", err)
+	}
+	s.doJSON(http.MethodGet, "/api/portal/client/portal-token", "", nil, http.StatusOK, nil)
+	if f := factor(score(cold.ID), leadFactorPortalVisits); f.Strength != 0.33 || f.Detail != "Client portal visits: 1" {
+		t.Errorf("portal visits factor = %+v", f)
+	}
+
+	// The agency's weights apply to its agents, rescoring their clients.
+	weightsPath := "/api/lead-scoring/weights"
+	s.doJSON(http.MethodPut, weightsPath, agency, map[string]interface{}{"weights": map[string]int{"city": 5}}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPut, weightsPath, agency, map[string]interface{}{"weights": map[string]int{leadFactorIncome: 60}}, http.StatusOK, nil)
+	var weights struct {
+		Source  string           `json:"source"`
+		Weights LeadScoreWeights `json:"weights"`
+	}
+	s.doJSON(http.MethodGet, weightsPath, agent, nil, http.StatusOK, &weights)
+	if weights.Source != pipelineSourceAgency || weights.Weights[leadFactorIncome] != 60 {
+		t.Fatalf("agent's weights = %+v", weights)
+	}
+	if f := factor(score(rich.ID), leadFactorIncome); f.Weight != 60 || f.Points != 60 {
+		t.Errorf("income factor after the agency's change = %+v", f)
+	}
+
+	// An agent's own weights take over, until they revert to the agency's.
+	s.doJSON(http.MethodPut, weightsPath, agent, map[string]interface{}{"weights": map[string]int{leadFactorIncome: 0}}, http.StatusOK, &weights)
+	if weights.Source != pipelineSourceOwn || factor(score(rich.ID), leadFactorIncome).Points != 0 {
+		t.Fatalf("agent's own weights = %+v", weights)
+	}
+	s.doJSON(http.MethodPut, weightsPath, agent, map[string]interface{}{"weights": nil}, http.StatusOK, &weights)
+	if weights.Source != pipelineSourceAgency {
+		t.Fatalf("weights after reverting = %+v", weights)
+	}
+	var recomputed map[string]int
+	s.doJSON(http.MethodPost, "/api/lead-scoring/recompute", agent, nil, http.StatusOK, &recomputed)
+	if recomputed["rescored"] != 3 || factor(score(rich.ID), leadFactorIncome).Weight != 60 {
+		t.Errorf("recompute = %v", recomputed)
+	}
+
+	// A merged-away client's score goes with it.
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/merge", rich.ID), agent, map[string]interface{}{"duplicateId": cold.ID}, http.StatusOK, nil)
+	if _, err := stores.LeadScores.Get(cold.ID, agentID); err != sql.ErrNoRows {
+		t.Errorf("merged client's score: %v", err)
+	}
+	if portal := factor(score(rich.ID), leadFactorPortalVisits); portal.Strength != 0.33 {
+		t.Errorf("survivor's portal visits = %+v", portal)
+	}
+}
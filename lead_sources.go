@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- Lead Attribution ---
+// Every client records where it came from: the channel (lead source), the marketing campaign and,
+// for leads from a public onboarding link, the link's UTM parameters. A lead an existing client
+// referred points to that client, so the referrer can be credited. Attribution is set when the
+// client is created and can be corrected later, except for the UTM parameters. The reports break
+// the agent's leads down by source or referrer, with how many became customers and the premium
+// their policies bring in.
+
+const (
+	leadSourceManual     = "manual"
+	leadSourceOnboarding = "onboarding_form"
+	leadSourceBulkImport = "bulk_import"
+	leadSourceReferral   = "referral"
+	leadSourceCampaign   = "campaign"
+	leadSourceOther      = "other"
+)
+
+var leadSources = []string{leadSourceManual, leadSourceOnboarding, leadSourceBulkImport, leadSourceReferral, leadSourceCampaign, leadSourceOther}
+
+// leadSourceUnknown is the report key of clients created before lead sources were recorded.
+const leadSourceUnknown = "unknown"
+
+const maxAttributionLength = 100 // Characters, as the VARCHAR(100) columns count them
+
+// LeadAttributionPayload sets a client's lead source, campaign and referrer. Without a source, a
+// referred client's is referral.
+type LeadAttributionPayload struct {
+	LeadSource         string `json:"leadSource"`
+	Campaign           string `json:"campaign"`
+	ReferredByClientID *int64 `json:"referredByClientId"` // null or 0 for none
+}
+
+// applyLeadAttribution sets the payload's attribution on client, using fallbackSource when the
+// payload names no source and no referrer. The referrer must be another client of the same agent.
+// It answers 400 and returns false if the payload isn't valid.
+func applyLeadAttribution(w http.ResponseWriter, client *Client, payload LeadAttributionPayload, fallbackSource string) bool {
+	source := strings.ToLower(strings.TrimSpace(payload.LeadSource))
+	referrer := int64(0)
+	if payload.ReferredByClientID != nil {
+		referrer = *payload.ReferredByClientID
+	}
+	if source == "" && referrer != 0 {
+		source = leadSourceReferral
+	}
+	if source == "" {
+		source = fallbackSource
+	}
+	if source != "" && !slices.Contains(leadSources, source) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("leadSource must be one of %s", strings.Join(leadSources, ", ")))
+		return false
+	}
+	campaign := strings.TrimSpace(payload.Campaign)
+	if utf8.RuneCountInString(campaign) > maxAttributionLength {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("campaign can be at most %d characters", maxAttributionLength))
+		return false
+	}
+	if referrer != 0 {
+		if referrer == client.ID {
+			respondError(w, http.StatusBadRequest, "A client can't refer themselves")
+			return false
+		}
+		if _, err := stores.Clients.GetByID(referrer, client.AgentUserID); err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("ERROR: Failed to get referrer %d of agent %d: %v", referrer, client.AgentUserID, err)
+			}
+			respondError(w, http.StatusBadRequest, "referredByClientId must be one of your clients")
+			return false
+		}
+	}
+	client.LeadSource = sql.NullString{String: source, Valid: source != ""}
+	client.Campaign = sql.NullString{String: campaign, Valid: campaign != ""}
+	client.ReferredByClientID = sql.NullInt64{Int64: referrer, Valid: referrer != 0}
+	return true
+}
+
+// onboardingAttribution reads the attribution of a lead from the query string of its onboarding
+// link: the UTM parameters, and ref, the ID of the client who shared the link. A ref that isn't
+// one of the agent's clients is ignored rather than failing the submission.
+func onboardingAttribution(client *Client, query url.Values) {
+	param := func(name string) sql.NullString {
+		value := strings.TrimSpace(query.Get(name))
+		if runes := []rune(value); len(runes) > maxAttributionLength {
+			value = string(runes[:maxAttributionLength]) // By characters, so a multi-byte one isn't cut in half
+		}
+		return sql.NullString{String: value, Valid: value != ""}
+	}
+	client.LeadSource = sql.NullString{String: leadSourceOnboarding, Valid: true}
+	client.Campaign, client.UTMSource, client.UTMMedium = param("utm_campaign"), param("utm_source"), param("utm_medium")
+	client.UTMTerm, client.UTMContent = param("utm_term"), param("utm_content")
+	ref := param("ref")
+	if !ref.Valid {
+		return
+	}
+	referrer, err := strconv.ParseInt(ref.String, 10, 64)
+	if err != nil || referrer <= 0 {
+		log.Printf("WARN: Ignoring invalid referrer '%s' on onboarding link of agent %d", ref.String, client.AgentUserID)
+		return
+	}
+	if _, err := stores.Clients.GetByID(referrer, client.AgentUserID); err != nil {
+		log.Printf("WARN: Ignoring referrer %d on onboarding link of agent %d: %v", referrer, client.AgentUserID, err)
+		return
+	}
+	client.ReferredByClientID = sql.NullInt64{Int64: referrer, Valid: true}
+	client.LeadSource.String = leadSourceReferral
+}
+
+// leadReportGroupings are the ways the lead source report can group clients, by the by parameter.
+var leadReportGroupings = map[string]func(Client) sql.NullString{
+	"source":     func(c Client) sql.NullString { return c.LeadSource },
+	"campaign":   func(c Client) sql.NullString { return c.Campaign },
+	"utm_source": func(c Client) sql.NullString { return c.UTMSource },
+	"utm_medium": func(c Client) sql.NullString { return c.UTMMedium },
+}
+
+// LeadConversion is how many of a group's leads became customers and the premium they bring in.
+type LeadConversion struct {
+	Leads          int     `json:"leads"`
+	Converted      int     `json:"converted"`
+	ConversionRate float64 `json:"conversionRate"` // Percent of the leads converted
+	Premium        float64 `json:"premium"`        // Total premium of the group's policies
+}
+
+type LeadSourceStats struct {
+	Key string `json:"key"` // The source, campaign or UTM value; "unknown" when it wasn't recorded
+	LeadConversion
+}
+
+type ReferrerStats struct {
+	ClientID int64  `json:"clientId"` // The referring client
+	Name     string `json:"name"`
+	LeadConversion
+}
+
+// clientConverted says whether a lead became a customer: it is (or was) Active, or has a policy.
+func clientConverted(client Client, premiums map[int64]float64) bool {
+	_, hasPolicies := premiums[client.ID]
+	return hasPolicies || client.Status == "Active" || client.Status == "Lapsed"
+}
+
+func (c *LeadConversion) add(client Client, premiums map[int64]float64) {
+	c.Leads++
+	if clientConverted(client, premiums) {
+		c.Converted++
+	}
+	c.Premium += premiums[client.ID]
+}
+
+func (c *LeadConversion) finish() {
+	c.Premium = roundMoney(c.Premium)
+	if c.Leads > 0 {
+		c.ConversionRate = roundMoney(float64(c.Converted) * 100 / float64(c.Leads))
+	}
+}
+
+// leadSourceReport groups clients by one of leadReportGroupings, highest premium first.
+// premiums is the total premium of each client's policies, by client ID.
+func leadSourceReport(clients []Client, premiums map[int64]float64, by string) []LeadSourceStats {
+	group := leadReportGroupings[by]
+	byKey := map[string]*LeadSourceStats{}
+	stats := []LeadSourceStats{}
+	keys := []string{}
+	for _, client := range clients {
+		key := leadSourceUnknown
+		if value := group(client); value.Valid {
+			key = value.String
+		}
+		if byKey[key] == nil {
+			byKey[key] = &LeadSourceStats{Key: key}
+			keys = append(keys, key)
+		}
+		byKey[key].add(client, premiums)
+	}
+	for _, key := range keys {
+		byKey[key].finish()
+		stats = append(stats, *byKey[key])
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Premium != stats[j].Premium {
+			return stats[i].Premium > stats[j].Premium
+		}
+		if stats[i].Leads != stats[j].Leads {
+			return stats[i].Leads > stats[j].Leads
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	return stats
+}
+
+// referrerReport credits each client who referred others with those leads, highest premium first.
+func referrerReport(clients []Client, premiums map[int64]float64) []ReferrerStats {
+	names := map[int64]string{}
+	for _, client := range clients {
+		names[client.ID] = client.Name
+	}
+	byReferrer := map[int64]*ReferrerStats{}
+	stats := []ReferrerStats{}
+	referrers := []int64{}
+	for _, client := range clients {
+		name, ok := names[client.ReferredByClientID.Int64]
+		if !client.ReferredByClientID.Valid || !ok {
+			continue
+		}
+		id := client.ReferredByClientID.Int64
+		if byReferrer[id] == nil {
+			byReferrer[id] = &ReferrerStats{ClientID: id, Name: name}
+			referrers = append(referrers, id)
+		}
+		byReferrer[id].add(client, premiums)
+	}
+	for _, id := range referrers {
+		byReferrer[id].finish()
+		stats = append(stats, *byReferrer[id])
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Premium != stats[j].Premium {
+			return stats[i].Premium > stats[j].Premium
+		}
+		return stats[i].Leads > stats[j].Leads
+	})
+	return stats
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLeadReports(t *testing.T) {
+	source := func(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+	referrer := sql.NullInt64{Int64: 1, Valid: true}
+	clients := []Client{
+		{ID: 1, Name: "Asha", Status: "Active", LeadSource: source(leadSourceManual)},
+		{ID: 2, Name: "Ravi", Status: "Lead", LeadSource: source(leadSourceReferral), ReferredByClientID: referrer},
+		{ID: 3, Name: "Meena", Status: "Lead", LeadSource: source(leadSourceReferral), ReferredByClientID: referrer},
+		{ID: 4, Name: "Old", Status: "Lapsed"},
+		{ID: 5, Name: "Gone", Status: "Lead", LeadSource: source(leadSourceReferral), ReferredByClientID: sql.NullInt64{Int64: 99, Valid: true}},
+	}
+	premiums := map[int64]float64{1: 10000, 2: 25000.555}
+
+	var got []string
+	for _, s := range leadSourceReport(clients, premiums, "source") {
+		got = append(got, fmt.Sprintf("%s:%d/%d:%.2f%%:%.2f", s.Key, s.Converted, s.Leads, s.ConversionRate, s.Premium))
+	}
+	if fmt.Sprint(got) != "[referral:1/3:33.33%:25000.56 manual:1/1:100.00%:10000.00 unknown:1/1:100.00%:0.00]" {
+		t.Errorf("lead source report = %v", got)
+	}
+	if stats := leadSourceReport(clients, premiums, "campaign"); len(stats) != 1 || stats[0].Key != leadSourceUnknown || stats[0].Leads != 5 {
+		t.Errorf("campaign report = %+v", stats)
+	}
+	// A referrer that's no longer among the clients isn't credited.
+	if stats := referrerReport(clients, premiums); len(stats) != 1 || stats[0].Name != "Asha" || stats[0].Leads != 2 ||
+		stats[0].Converted != 1 || stats[0].Premium != 25000.56 {
+		t.Errorf("referrer report = %+v", stats)
+	}
+}
+
+func TestLeadAttribution(t *testing.T) {
+	s := newTestServer(t)
+	agent := tokenFor(t, 9, "agent")
+	asha := s.createClient(agent, map[string]interface{}{"name": "Asha", "phone": "9000000001", "status": "Active"})
+	if asha.LeadSource.String != leadSourceManual {
+		t.Fatalf("manual client's source = %+v", asha.LeadSource)
+	}
+
+	// A referred client defaults to the referral source; the referrer must be the agent's own client.
+	ravi := s.createClient(agent, map[string]interface{}{"name": "Ravi", "phone": "9000000002", "referredByClientId": asha.ID})
+	if ravi.LeadSource.String != leadSourceReferral || ravi.ReferredByClientID.Int64 != asha.ID {
+		t.Fatalf("referred client = %+v", ravi)
+	}
+	other := s.createClient(tokenFor(t, 10, "agent"), map[string]interface{}{"name": "Other", "phone": "9000000009"})
+	s.doJSON(http.MethodPost, "/api/clients", agent, map[string]interface{}{"name": "X", "referredByClientId": other.ID}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPost, "/api/clients", agent, map[string]interface{}{"name": "X", "leadSource": "billboard"}, http.StatusBadRequest, nil)
+
+	// The onboarding form passes on its link's UTM parameters and referrer.
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/onboard?agentId=9&utm_source=facebook&utm_medium=cpc&utm_campaign=diwali&ref=%d", asha.ID), "",
+		map[string]interface{}{"name": "Meena", "phone": "9000000003"}, http.StatusCreated, nil)
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/onboard?agentId=9&utm_campaign=diwali&ref=%d", other.ID), "",
+		map[string]interface{}{"name": "Kiran", "phone": "9000000004"}, http.StatusCreated, nil)
+	clients, err := stores.Clients.ListAll(9)
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	byName := map[string]Client{}
+	for _, c := range clients {
+		byName[c.Name] = c
+	}
+	if meena := byName["Meena"]; meena.LeadSource.String != leadSourceReferral || meena.ReferredByClientID.Int64 != asha.ID ||
+		meena.Campaign.String != "diwali" || meena.UTMSource.String != "facebook" || meena.UTMMedium.String != "cpc" {
+		t.Errorf("onboarded referral = %+v", meena)
+	}
+	if kiran := byName["Kiran"]; kiran.LeadSource.String != leadSourceOnboarding || kiran.ReferredByClientID.Valid || kiran.Campaign.String != "diwali" {
+		t.Errorf("onboarded lead with another agent's referrer = %+v", kiran)
+	}
+
+	// Imports default to the bulk import source and take a Lead Source column.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("clientFile", "clients.csv")
+	if err != nil {
+		t.Fatalf("form file: %v", err)
+	}
+	fw.Write([]byte("Name,Phone,Lead Source,Campaign\nSunil,9000000005,,\nLata,9000000006,Campaign,Expo 2026\nBad,9000000007,billboard,\n"))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/clients/bulk-upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+agent)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var list []Client
+	s.doJSON(http.MethodGet, "/api/clients?search=900000000", agent, nil, http.StatusOK, &list)
+	sources := map[string]string{}
+	for _, c := range list {
+		full, err := stores.Clients.GetByID(c.ID, 9)
+		if err != nil {
+			t.Fatalf("get client %d: %v", c.ID, err)
+		}
+		sources[full.Name] = full.LeadSource.String + "/" + full.Campaign.String
+	}
+	if sources["Sunil"] != "bulk_import/" || sources["Lata"] != "campaign/Expo 2026" || sources["Bad"] != "" {
+		t.Errorf("imported sources = %v", sources)
+	}
+
+	// Attribution can be corrected later, without touching the UTM parameters.
+	meena := byName["Meena"]
+	path := fmt.Sprintf("/api/clients/%d/attribution", meena.ID)
+	s.doJSON(http.MethodPut, path, agent, map[string]interface{}{"referredByClientId": meena.ID}, http.StatusBadRequest, nil)
+	var updated Client
+	s.doJSON(http.MethodPut, path, agent, map[string]interface{}{"campaign": "Diwali 2026", "referredByClientId": ravi.ID}, http.StatusOK, &updated)
+	if updated.LeadSource.String != leadSourceReferral || updated.ReferredByClientID.Int64 != ravi.ID || updated.Campaign.String != "Diwali 2026" ||
+		updated.UTMSource.String != "facebook" || updated.Version != meena.Version+1 {
+		t.Fatalf("corrected attribution = %+v", updated)
+	}
+
+	// Reports credit sources and referrers with conversions and premium.
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/policies", ravi.ID), agent, map[string]interface{}{"policyNumber": "P-1", "status": "Active",
+		"startDate": "2026-01-01", "endDate": "2027-01-01", "premium": 25000}, http.StatusCreated, nil)
+	var bySource []LeadSourceStats
+	s.doJSON(http.MethodGet, "/api/reports/lead-sources", agent, nil, http.StatusOK, &bySource)
+	if len(bySource) == 0 || bySource[0].Key != leadSourceReferral || bySource[0].Leads != 2 || bySource[0].Converted != 1 || bySource[0].Premium != 25000 {
+		t.Errorf("lead source report = %+v", bySource)
+	}
+	s.doJSON(http.MethodGet, "/api/reports/lead-sources?by=utm_source", agent, nil, http.StatusOK, &bySource)
+	if len(bySource) != 2 || bySource[0].Key != leadSourceUnknown || bySource[1].Key != "facebook" || bySource[1].Leads != 1 {
+		t.Errorf("report by UTM source = %+v", bySource)
+	}
+	s.doJSON(http.MethodGet, "/api/reports/lead-sources?by=city", agent, nil, http.StatusBadRequest, nil)
+	var referrers []ReferrerStats
+	s.doJSON(http.MethodGet, "/api/reports/referrers", agent, nil, http.StatusOK, &referrers)
+	if len(referrers) != 2 || referrers[0].ClientID != asha.ID || referrers[0].Premium != 25000 || referrers[1].ClientID != ravi.ID {
+		t.Errorf("referrer report = %+v", referrers)
+	}
+
+	// Merging a referrer away credits its referrals to the client it was merged into.
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/merge", ravi.ID), agent, map[string]interface{}{"duplicateId": asha.ID}, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, "/api/reports/referrers", agent, nil, http.StatusOK, &referrers)
+	if len(referrers) != 1 || referrers[0].ClientID != ravi.ID || referrers[0].Leads != 1 {
+		t.Errorf("referrers after merge = %+v", referrers)
+	}
+}
+
+func TestAttributionLengthInCharacters(t *testing.T) {
+	s := newTestServer(t)
+	agent := tokenFor(t, 9, "agent")
+
+	// A long multi-byte campaign is cut to the limit in characters, never mid-character.
+	long := strings.Repeat("दी", maxAttributionLength)
+	s.doJSON(http.MethodPost, "/api/onboard?agentId=9&utm_campaign="+url.QueryEscape(long), "",
+		map[string]interface{}{"name": "Meena", "phone": "9000000003"}, http.StatusCreated, nil)
+	clients, err := stores.Clients.ListAll(9)
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("list clients = %+v, %v", clients, err)
+	}
+	campaign := clients[0].Campaign.String
+	if !utf8.ValidString(campaign) || utf8.RuneCountInString(campaign) != maxAttributionLength || !strings.HasPrefix(long, campaign) {
+		t.Errorf("onboarded campaign = %q", campaign)
+	}
+
+	// The limit counts characters, not bytes.
+	path := fmt.Sprintf("/api/clients/%d/attribution", clients[0].ID)
+	s.doJSON(http.MethodPut, path, agent, map[string]interface{}{"campaign": strings.Repeat("é", maxAttributionLength)}, http.StatusOK, nil)
+	s.doJSON(http.MethodPut, path, agent, map[string]interface{}{"campaign": strings.Repeat("é", maxAttributionLength+1)}, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- LLM Client ---
+// Handlers ask an LLMClient for text and never build provider requests themselves. newLLMClient
+// picks the provider from LLM_PROVIDER and wraps it in a resilientLLMClient, which bounds every
+// call with a deadline, retries rate limits and server errors, and stops calling a provider that
+// keeps failing until it has had time to recover.
+
+// LLMRequest is a single prompt sent to the model.
+type LLMRequest struct {
+	System      string                 // System prompt; optional
+	Prompt      string                 // User prompt
+	JSONSchema  map[string]interface{} // When set, the reply is JSON conforming to this schema
+	Temperature float64
+	MaxTokens   int
+}
+
+// LLMResponse is the model's reply.
+type LLMResponse struct {
+	Text  string
+	Model string // Model that produced the reply, for auditing
+}
+
+// LLMClient generates text. Implementations: Gemini, OpenAI-compatible chat completions, fake.
+type LLMClient interface {
+	Generate(ctx context.Context, req LLMRequest) (LLMResponse, error)
+}
+
+// llm is the client the AI endpoints use.
+var llm LLMClient = unconfiguredLLMClient{}
+
+var (
+	errLLMNotConfigured = errors.New("AI service is not configured")
+	errLLMCircuitOpen   = errors.New("AI service is temporarily unavailable")
+)
+
+// llmStatusError is a non-2xx reply from the provider.
+type llmStatusError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // From the Retry-After header, if any
+}
+
+func (e *llmStatusError) Error() string {
+	return fmt.Sprintf("%s returned HTTP %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// LLMConfig holds the model settings read from the environment.
+type LLMConfig struct {
+	Provider   string        // gemini, openai or fake (LLM_PROVIDER, default gemini)
+	APIKey     string        // LLM_API_KEY
+	BaseURL    string        // LLM_BASE_URL, for OpenAI-compatible servers or a proxy
+	Model      string        // LLM_MODEL
+	Timeout    time.Duration // LLM_TIMEOUT, deadline for one Generate call including retries
+	MaxRetries int           // LLM_MAX_RETRIES, retries after the first attempt
+}
+
+const (
+	defaultGeminiBaseURL = "https://generativelanguage.googleapis.com"
+	defaultGeminiModel   = "gemini-1.5-flash"
+	defaultOpenAIBaseURL = "https://api.openai.com/v1"
+	defaultOpenAIModel   = "gpt-4o-mini"
+
+	llmDefaultTimeout    = 30 * time.Second
+	llmDefaultMaxRetries = 2
+	llmRetryBaseDelay    = 500 * time.Millisecond
+	llmResponseLimit     = 1 << 20 // Bytes read from a provider reply
+	// After this many consecutive transient failures the breaker fails calls fast for llmBreakerCooldown.
+	llmBreakerThreshold = 5
+	llmBreakerCooldown  = 30 * time.Second
+)
+
+// loadLLMConfig reads the model settings and fills in the provider's defaults.
+func loadLLMConfig() LLMConfig {
+	cfg := LLMConfig{
+		Provider:   strings.ToLower(strings.TrimSpace(os.Getenv("LLM_PROVIDER"))),
+		APIKey:     os.Getenv("LLM_API_KEY"),
+		BaseURL:    strings.TrimRight(os.Getenv("LLM_BASE_URL"), "/"),
+		Model:      os.Getenv("LLM_MODEL"),
+		Timeout:    llmDefaultTimeout,
+		MaxRetries: llmDefaultMaxRetries,
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = "gemini"
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("LLM_TIMEOUT")); err == nil && timeout > 0 {
+		cfg.Timeout = timeout
+	}
+	if retries, err := strconv.Atoi(os.Getenv("LLM_MAX_RETRIES")); err == nil && retries >= 0 {
+		cfg.MaxRetries = retries
+	}
+	switch cfg.Provider {
+	case "gemini":
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = defaultGeminiBaseURL
+		}
+		if cfg.Model == "" {
+			cfg.Model = defaultGeminiModel
+		}
+	case "openai":
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = defaultOpenAIBaseURL
+		}
+		if cfg.Model == "" {
+			cfg.Model = defaultOpenAIModel
+		}
+	}
+	return cfg
+}
+
+// newLLMClient builds the client selected by cfg.Provider. Without an API key the AI endpoints
+// answer 503 instead of failing at startup, so the rest of the API stays usable.
+func newLLMClient(cfg LLMConfig) (LLMClient, error) {
+	httpClient := &http.Client{}
+	var provider LLMClient
+	switch cfg.Provider {
+	case "gemini":
+		provider = &geminiClient{apiKey: cfg.APIKey, baseURL: cfg.BaseURL, model: cfg.Model, http: httpClient}
+	case "openai":
+		provider = &openAIClient{apiKey: cfg.APIKey, baseURL: cfg.BaseURL, model: cfg.Model, http: httpClient}
+	case "fake":
+		log.Println("LLM: Using the fake LLM client; AI endpoints return placeholder content.")
+		return &fakeLLMClient{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported LLM_PROVIDER %q (expected gemini, openai or fake)", cfg.Provider)
+	}
+	// OpenAI-compatible servers running locally often need no key.
+	if cfg.APIKey == "" && (cfg.Provider == "gemini" || cfg.BaseURL == defaultOpenAIBaseURL) {
+		log.Println("WARN: LLM_API_KEY is not set; AI endpoints are disabled.")
+		return unconfiguredLLMClient{}, nil
+	}
+	log.Printf("LLM: Using %s model %s (timeout %s, %d retries)\n", cfg.Provider, cfg.Model, cfg.Timeout, cfg.MaxRetries)
+	return newResilientLLMClient(provider, cfg.Timeout, cfg.MaxRetries), nil
+}
+
+// respondLLMError logs a failed Generate call and answers with the matching status.
+func respondLLMError(w http.ResponseWriter, err error) {
+	log.Printf("ERROR: LLM call failed: %v", err)
+	var statusErr *llmStatusError
+	switch {
+	case errors.Is(err, errLLMNotConfigured), errors.Is(err, errLLMCircuitOpen):
+		respondError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		respondError(w, http.StatusGatewayTimeout, "AI service timed out")
+	case errors.As(err, &statusErr):
+		respondError(w, http.StatusBadGateway, fmt.Sprintf("AI service returned error: %d %s", statusErr.StatusCode, http.StatusText(statusErr.StatusCode)))
+	default:
+		respondError(w, http.StatusBadGateway, "Error contacting AI service")
+	}
+}
+
+// postLLMJSON sends payload to url and decodes a 2xx reply into out.
+func postLLMJSON(ctx context.Context, client *http.Client, provider, url string, headers map[string]string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", provider, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build %s request: %w", provider, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", provider, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, llmResponseLimit))
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", provider, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := &llmStatusError{Provider: provider, StatusCode: resp.StatusCode, Body: truncateForLog(string(respBody), 500)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			statusErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return statusErr
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", provider, err)
+	}
+	return nil
+}
+
+func truncateForLog(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit] + "..."
+}
+
+// --- Gemini ---
+
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+	// Add SafetySettings if needed
+}
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+type GeminiPart struct {
+	Text string `json:"text"`
+}
+type GeminiResponse struct {
+	Candidates     []GeminiCandidate     `json:"candidates"`
+	PromptFeedback *GeminiPromptFeedback `json:"promptFeedback,omitempty"`
+	ModelVersion   string                `json:"modelVersion,omitempty"`
+}
+type GeminiCandidate struct {
+	Content       GeminiContent        `json:"content"`
+	FinishReason  string               `json:"finishReason"`
+	Index         int                  `json:"index"`
+	SafetyRatings []GeminiSafetyRating `json:"safetyRatings"`
+}
+type GeminiPromptFeedback struct {
+	BlockReason   string               `json:"blockReason,omitempty"`
+	SafetyRatings []GeminiSafetyRating `json:"safetyRatings"`
+}
+type GeminiSafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+}
+type GeminiGenerationConfig struct {
+	Temperature      float32                `json:"temperature"` // Always sent: 0 is a meaningful setting
+	TopK             int                    `json:"topK,omitempty"`
+	TopP             float32                `json:"topP,omitempty"`
+	MaxOutputTokens  int                    `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string               `json:"stopSequences,omitempty"`
+	ResponseMimeType string                 `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]interface{} `json:"responseSchema,omitempty"`
+}
+
+type geminiClient struct {
+	apiKey  string
+	baseURL string
+	model   string
+	http    *http.Client
+}
+
+func (c *geminiClient) Generate(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	payload := GeminiRequest{
+		Contents:         []GeminiContent{{Role: "user", Parts: []GeminiPart{{Text: req.Prompt}}}},
+		GenerationConfig: &GeminiGenerationConfig{Temperature: float32(req.Temperature), MaxOutputTokens: req.MaxTokens},
+	}
+	if req.System != "" {
+		payload.SystemInstruction = &GeminiContent{Parts: []GeminiPart{{Text: req.System}}}
+	}
+	if req.JSONSchema != nil {
+		payload.GenerationConfig.ResponseMimeType = "application/json"
+		payload.GenerationConfig.ResponseSchema = geminiSchema(req.JSONSchema)
+	}
+
+	// The key goes in a header rather than the query string so it never shows up in logged URLs.
+	url := fmt.Sprintf("%s/v1beta/models/%s:generateContent", c.baseURL, c.model)
+	var resp GeminiResponse
+	if err := postLLMJSON(ctx, c.http, "Gemini", url, map[string]string{"x-goog-api-key": c.apiKey}, payload, &resp); err != nil {
+		return LLMResponse{}, err
+	}
+	if len(resp.Candidates) == 0 {
+		if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+			return LLMResponse{}, fmt.Errorf("Gemini blocked the prompt: %s", resp.PromptFeedback.BlockReason)
+		}
+		return LLMResponse{}, errors.New("Gemini returned no candidates")
+	}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	if text.Len() == 0 {
+		return LLMResponse{}, fmt.Errorf("Gemini returned no text (finish reason %s)", resp.Candidates[0].FinishReason)
+	}
+	model := resp.ModelVersion
+	if model == "" {
+		model = c.model
+	}
+	return LLMResponse{Text: text.String(), Model: model}, nil
+}
+
+// geminiSchema converts a JSON Schema to the OpenAPI subset Gemini's responseSchema accepts:
+// type names are upper case and additionalProperties is not supported.
+func geminiSchema(schema map[string]interface{}) map[string]interface{} {
+	converted := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "additionalProperties", "$schema":
+			continue
+		case "type":
+			if name, ok := value.(string); ok {
+				value = strings.ToUpper(name)
+			}
+		case "items":
+			if items, ok := value.(map[string]interface{}); ok {
+				value = geminiSchema(items)
+			}
+		case "properties":
+			if props, ok := value.(map[string]interface{}); ok {
+				convertedProps := make(map[string]interface{}, len(props))
+				for name, prop := range props {
+					if propSchema, ok := prop.(map[string]interface{}); ok {
+						prop = geminiSchema(propSchema)
+					}
+					convertedProps[name] = prop
+				}
+				value = convertedProps
+			}
+		}
+		converted[key] = value
+	}
+	return converted
+}
+
+// --- OpenAI-compatible ---
+
+type OpenAIChatRequest struct {
+	Model          string                `json:"model"`
+	Messages       []OpenAIChatMessage   `json:"messages"`
+	Temperature    float64               `json:"temperature"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+}
+type OpenAIChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+type OpenAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *OpenAIJSONSchema `json:"json_schema,omitempty"`
+}
+type OpenAIJSONSchema struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+}
+type OpenAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      OpenAIChatMessage `json:"message"`
+		FinishReason string            `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// openAIClient talks to any server implementing the OpenAI chat completions API
+// (OpenAI itself, Azure-style proxies, vLLM, Ollama, ...).
+type openAIClient struct {
+	apiKey  string
+	baseURL string
+	model   string
+	http    *http.Client
+}
+
+func (c *openAIClient) Generate(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	payload := OpenAIChatRequest{Model: c.model, Temperature: req.Temperature, MaxTokens: req.MaxTokens}
+	if req.System != "" {
+		payload.Messages = append(payload.Messages, OpenAIChatMessage{Role: "system", Content: req.System})
+	}
+	payload.Messages = append(payload.Messages, OpenAIChatMessage{Role: "user", Content: req.Prompt})
+	if req.JSONSchema != nil {
+		payload.ResponseFormat = &OpenAIResponseFormat{Type: "json_schema", JSONSchema: &OpenAIJSONSchema{Name: "response", Schema: req.JSONSchema}}
+	}
+
+	headers := map[string]string{}
+	if c.apiKey != "" {
+		headers["Authorization"] = "Bearer " + c.apiKey
+	}
+	var resp OpenAIChatResponse
+	if err := postLLMJSON(ctx, c.http, "OpenAI", c.baseURL+"/chat/completions", headers, payload, &resp); err != nil {
+		return LLMResponse{}, err
+	}
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+		return LLMResponse{}, errors.New("OpenAI returned no message content")
+	}
+	model := resp.Model
+	if model == "" {
+		model = c.model
+	}
+	return LLMResponse{Text: resp.Choices[0].Message.Content, Model: model}, nil
+}
+
+// --- Resilience ---
+
+// resilientLLMClient bounds each call with a deadline, retries transient failures with
+// exponential backoff and fails fast through a circuit breaker while the provider is down.
+type resilientLLMClient struct {
+	next       LLMClient
+	timeout    time.Duration
+	maxRetries int
+	retryDelay time.Duration // Before the first retry; doubled after each one
+	breaker    *circuitBreaker
+}
+
+func newResilientLLMClient(next LLMClient, timeout time.Duration, maxRetries int) *resilientLLMClient {
+	return &resilientLLMClient{
+		next:       next,
+		timeout:    timeout,
+		maxRetries: maxRetries,
+		retryDelay: llmRetryBaseDelay,
+		breaker:    &circuitBreaker{threshold: llmBreakerThreshold, cooldown: llmBreakerCooldown, now: time.Now},
+	}
+}
+
+func (c *resilientLLMClient) Generate(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		if err := c.breaker.allow(); err != nil {
+			return LLMResponse{}, err
+		}
+		resp, err := c.next.Generate(callCtx, req)
+		if ctx.Err() != nil {
+			// The caller went away; that says nothing about the provider's health.
+			return LLMResponse{}, ctx.Err()
+		}
+		transient := err != nil && isTransientLLMError(err)
+		c.breaker.record(transient)
+		if !transient || attempt >= c.maxRetries || callCtx.Err() != nil {
+			return resp, err
+		}
+
+		wait := delay
+		var statusErr *llmStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > wait {
+			wait = statusErr.RetryAfter
+		}
+		if deadline, ok := callCtx.Deadline(); ok && time.Until(deadline) < wait {
+			return LLMResponse{}, err // No time left to wait for another attempt
+		}
+		log.Printf("WARN: LLM attempt %d failed, retrying in %s: %v", attempt+1, wait, err)
+		select {
+		case <-callCtx.Done():
+			return LLMResponse{}, err
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// isTransientLLMError reports whether a failed call may succeed if retried: rate limits,
+// server errors, timeouts and network failures.
+func isTransientLLMError(err error) bool {
+	var statusErr *llmStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// circuitBreaker opens after threshold consecutive transient failures. While open, calls fail
+// with errLLMCircuitOpen; once the cooldown has passed a single trial call is let through and
+// its outcome closes the breaker or keeps it open for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	failures  int       // Consecutive transient failures
+	openUntil time.Time // Calls before this are rejected once failures reaches threshold
+}
+
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.now().Before(b.openUntil) {
+		return errLLMCircuitOpen
+	}
+	// Half-open: this call is the trial. Everyone else waits another cooldown, which also
+	// covers a trial that never reports back.
+	b.openUntil = b.now().Add(b.cooldown)
+	return nil
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if b.failures >= b.threshold {
+			log.Println("LLM: Provider recovered, closing circuit breaker.")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			log.Printf("WARN: LLM failed %d times in a row, pausing calls for %s", b.failures, b.cooldown)
+		}
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// --- Fake and unconfigured clients ---
+
+// fakeLLMClient answers without a network call. Reply, when set, produces the text; otherwise the
+// reply is deterministic: a sample document for JSON requests, a fixed sentence for others.
+// Err, when set, makes every call fail.
+type fakeLLMClient struct {
+	mu       sync.Mutex
+	Reply    func(req LLMRequest) string
+	Err      error
+	requests []LLMRequest
+}
+
+func (f *fakeLLMClient) Generate(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	if f.Err != nil {
+		return LLMResponse{}, f.Err
+	}
+	if f.Reply != nil {
+		return LLMResponse{Text: f.Reply(req), Model: "fake"}, nil
+	}
+	if req.JSONSchema != nil {
+		raw, err := json.Marshal(sampleFromSchema(req.JSONSchema))
+		if err != nil {
+			return LLMResponse{}, fmt.Errorf("failed to build fake JSON reply: %w", err)
+		}
+		return LLMResponse{Text: string(raw), Model: "fake"}, nil
+	}
+	return LLMResponse{Text: fmt.Sprintf("This is a placeholder AI response to a %d-character prompt.", len(req.Prompt)), Model: "fake"}, nil
+}
+
+// Requests returns a copy of the requests received so far.
+func (f *fakeLLMClient) Requests() []LLMRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]LLMRequest(nil), f.requests...)
+}
+
+// sampleFromSchema builds the smallest value conforming to a JSON Schema: the first enum value,
+// one item per array and every declared property.
+func sampleFromSchema(schema map[string]interface{}) interface{} {
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	switch schema["type"] {
+	case "object":
+		value := map[string]interface{}{}
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, prop := range props {
+			if propSchema, ok := prop.(map[string]interface{}); ok {
+				value[name] = sampleFromSchema(propSchema)
+			}
+		}
+		return value
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		return []interface{}{sampleFromSchema(items)}
+	case "string":
+		return "sample"
+	case "number", "integer":
+		return 0
+	case "boolean":
+		return false
+	default:
+		return nil
+	}
+}
+
+// unconfiguredLLMClient is used when no API key is set.
+type unconfiguredLLMClient struct{}
+
+func (unconfiguredLLMClient) Generate(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	return LLMResponse{}, errLLMNotConfigured
+}
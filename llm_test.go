@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var taskListSchema = map[string]interface{}{
+	"type": "array",
+	"items": map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{"description": map[string]interface{}{"type": "string"}},
+		"required":             []string{"description"},
+		"additionalProperties": false,
+	},
+}
+
+func TestGeminiClientRequest(t *testing.T) {
+	var got GeminiRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/gemini-test:generateContent" || r.URL.RawQuery != "" || r.Header.Get("x-goog-api-key") != "secret" {
+			t.Errorf("unexpected request %s?%s (key %q)", r.URL.Path, r.URL.RawQuery, r.Header.Get("x-goog-api-key"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"[{\"description\":"},{"text":"\"Call\"}]"}]},"finishReason":"STOP"}]}`)
+	}))
+	defer server.Close()
+
+	client := &geminiClient{apiKey: "secret", baseURL: server.URL, model: "gemini-test", http: server.Client()}
+	reply, err := client.Generate(context.Background(), LLMRequest{System: "Be brief.", Prompt: "Suggest tasks", JSONSchema: taskListSchema, MaxTokens: 100})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if reply.Text != `[{"description":"Call"}]` || reply.Model != "gemini-test" {
+		t.Fatalf("reply = %+v", reply)
+	}
+	if got.SystemInstruction == nil || got.SystemInstruction.Parts[0].Text != "Be brief." || got.Contents[0].Parts[0].Text != "Suggest tasks" {
+		t.Fatalf("request = %+v", got)
+	}
+	cfg := got.GenerationConfig
+	items, _ := cfg.ResponseSchema["items"].(map[string]interface{})
+	if cfg.ResponseMimeType != "application/json" || cfg.ResponseSchema["type"] != "ARRAY" || items["type"] != "OBJECT" || items["additionalProperties"] != nil {
+		t.Fatalf("generation config = %+v", cfg)
+	}
+}
+
+func TestOpenAIClientRequest(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected request %s (auth %q)", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"model":"gpt-test-0613","choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client := &openAIClient{apiKey: "secret", baseURL: server.URL + "/v1", model: "gpt-test", http: server.Client()}
+	reply, err := client.Generate(context.Background(), LLMRequest{System: "Be brief.", Prompt: "Hi", JSONSchema: taskListSchema, Temperature: 0})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if reply.Text != "Hello" || reply.Model != "gpt-test-0613" {
+		t.Fatalf("reply = %+v", reply)
+	}
+	messages, _ := got["messages"].([]interface{})
+	format, _ := got["response_format"].(map[string]interface{})
+	if len(messages) != 2 || got["temperature"] != 0.0 || format["type"] != "json_schema" {
+		t.Fatalf("request = %v", got)
+	}
+}
+
+func TestLLMRetriesTransientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"ok"}}]}`)
+		}
+	}))
+	defer server.Close()
+
+	client := newResilientLLMClient(&openAIClient{baseURL: server.URL, model: "m", http: server.Client()}, 5*time.Second, 2)
+	client.retryDelay = time.Millisecond
+	reply, err := client.Generate(context.Background(), LLMRequest{Prompt: "Hi"})
+	if err != nil || reply.Text != "ok" || calls.Load() != 3 {
+		t.Fatalf("reply %+v, err %v after %d calls", reply, err, calls.Load())
+	}
+
+	// Client errors are not retried.
+	fake := &fakeLLMClient{Err: &llmStatusError{Provider: "fake", StatusCode: http.StatusBadRequest}}
+	client = newResilientLLMClient(fake, 5*time.Second, 2)
+	if _, err := client.Generate(context.Background(), LLMRequest{Prompt: "Hi"}); err == nil || len(fake.Requests()) != 1 {
+		t.Fatalf("err %v after %d requests, want one failed request", err, len(fake.Requests()))
+	}
+}
+
+func TestLLMCallDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // Never answers in time
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newResilientLLMClient(&openAIClient{baseURL: server.URL, model: "m", http: server.Client()}, 50*time.Millisecond, 3)
+	start := time.Now()
+	_, err := client.Generate(context.Background(), LLMRequest{Prompt: "Hi"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("call took %v despite a 50ms deadline", elapsed)
+	}
+}
+
+func TestLLMCircuitBreaker(t *testing.T) {
+	fake := &fakeLLMClient{Err: &llmStatusError{Provider: "fake", StatusCode: http.StatusBadGateway}}
+	client := newResilientLLMClient(fake, time.Second, 0)
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < llmBreakerThreshold; i++ {
+		client.Generate(context.Background(), LLMRequest{Prompt: "Hi"})
+	}
+	if _, err := client.Generate(context.Background(), LLMRequest{Prompt: "Hi"}); !errors.Is(err, errLLMCircuitOpen) {
+		t.Fatalf("err = %v, want open circuit", err)
+	}
+	if n := len(fake.Requests()); n != llmBreakerThreshold {
+		t.Fatalf("provider called %d times, want %d", n, llmBreakerThreshold)
+	}
+
+	// After the cooldown a single trial goes through; its success closes the breaker.
+	now = now.Add(llmBreakerCooldown)
+	fake.mu.Lock()
+	fake.Err = nil
+	fake.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if _, err := client.Generate(context.Background(), LLMRequest{Prompt: "Hi"}); err != nil {
+			t.Fatalf("call %d after cooldown: %v", i, err)
+		}
+	}
+}
+
+func TestFakeLLMClientIsDeterministic(t *testing.T) {
+	fake := &fakeLLMClient{}
+	first, _ := fake.Generate(context.Background(), LLMRequest{Prompt: "Suggest tasks", JSONSchema: taskListSchema})
+	second, _ := fake.Generate(context.Background(), LLMRequest{Prompt: "Suggest tasks", JSONSchema: taskListSchema})
+	if first.Text != `[{"description":"sample"}]` || second.Text != first.Text {
+		t.Fatalf("replies = %q, %q", first.Text, second.Text)
+	}
+}
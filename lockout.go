@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// --- Login Lockout ---
+// Failed passwords and 2FA codes are counted per account. From loginLockoutThreshold failures on,
+// each further failure locks the account, for loginLockoutBase at first and twice as long each
+// time after, up to loginLockoutMax. A locked account is refused before the password is checked.
+// The count resets only when a login completes, so a correct password alone doesn't clear it
+// while the second factor is being guessed. Per-IP rate limiting (ratelimit.go) covers attackers
+// spreading guesses across accounts.
+
+const (
+	loginLockoutThreshold = 5
+	loginLockoutBase      = time.Minute
+	loginLockoutMax       = time.Hour
+)
+
+// loginLockoutDuration returns how long to lock an account after its failures-th failed attempt.
+func loginLockoutDuration(failures int) time.Duration {
+	if failures < loginLockoutThreshold {
+		return 0
+	}
+	lockout := loginLockoutBase
+	for i := loginLockoutThreshold; i < failures && lockout < loginLockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > loginLockoutMax {
+		lockout = loginLockoutMax
+	}
+	return lockout
+}
+
+// loginLockedFor returns how much longer user is locked out, or 0 if they may try to log in.
+func loginLockedFor(user *User, now time.Time) time.Duration {
+	if !user.LockedUntil.Valid || !user.LockedUntil.Time.After(now) {
+		return 0
+	}
+	return user.LockedUntil.Time.Sub(now)
+}
+
+// recordLoginFailure counts a failed attempt against user and locks the account if it is due.
+func recordLoginFailure(user *User, now time.Time) {
+	failures, err := stores.Users.RecordFailedLogin(user.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to record failed login for user %d: %v", user.ID, err)
+		return
+	}
+	lockout := loginLockoutDuration(failures)
+	if lockout == 0 {
+		return
+	}
+	if err := stores.Users.LockUntil(user.ID, now.Add(lockout)); err != nil {
+		log.Printf("ERROR: Failed to lock user %d: %v", user.ID, err)
+		return
+	}
+	log.Printf("LOGIN: Account %d locked for %s after %d failed attempts", user.ID, lockout, failures)
+}
+
+// clearLoginFailures resets the failure count after a completed login.
+func clearLoginFailures(user *User) {
+	if user.FailedLoginAttempts == 0 && !user.LockedUntil.Valid {
+		return
+	}
+	if err := stores.Users.ResetFailedLogins(user.ID); err != nil {
+		log.Printf("ERROR: Failed to reset failed logins for user %d: %v", user.ID, err)
+	}
+}
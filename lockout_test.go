@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginLockoutDuration(t *testing.T) {
+	cases := map[int]time.Duration{1: 0, 4: 0, 5: time.Minute, 6: 2 * time.Minute, 8: 8 * time.Minute, 12: time.Hour, 100: time.Hour}
+	for failures, want := range cases {
+		if got := loginLockoutDuration(failures); got != want {
+			t.Errorf("loginLockoutDuration(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	s := newTestServer(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret-pass"), bcrypt.MinCost)
+	userID, err := stores.Users.Create(User{Email: "agent@example.com", PasswordHash: string(hash), UserType: "agent", IsVerified: true})
+	if err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	login := func(password string, wantStatus int) {
+		t.Helper()
+		s.doJSON(http.MethodPost, "/login", "", map[string]string{"email": "agent@example.com", "password": password, "userType": "agent"}, wantStatus, nil)
+	}
+
+	// Failures below the threshold are forgotten after a successful login.
+	for i := 0; i < loginLockoutThreshold-1; i++ {
+		login("wrong", http.StatusUnauthorized)
+	}
+	login("s3cret-pass", http.StatusOK)
+	if user, _ := stores.Users.GetByID(userID); user.FailedLoginAttempts != 0 {
+		t.Fatalf("failed attempts = %d after a successful login", user.FailedLoginAttempts)
+	}
+
+	for i := 0; i < loginLockoutThreshold; i++ {
+		login("wrong", http.StatusUnauthorized)
+	}
+	// Locked: even the right password is refused until the lockout ends.
+	rec := s.do(http.MethodPost, "/login", "", map[string]string{"email": "agent@example.com", "password": "s3cret-pass", "userType": "agent"})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("login while locked: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if err := stores.Users.LockUntil(userID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("expire lockout: %v", err)
+	}
+	login("wrong", http.StatusUnauthorized)
+	user, _ := stores.Users.GetByID(userID)
+	if lockedFor := loginLockedFor(user, time.Now()); lockedFor <= time.Minute {
+		t.Fatalf("second lockout lasts %s, want longer than the first", lockedFor)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Email Delivery ---
+// Handlers never talk to a mail server directly. queueEmail writes the message to the
+// email_outbox table and runOutboxWorker delivers it through the configured Mailer,
+// retrying with exponential backoff until it is sent or runs out of attempts.
+
+// EmailMessage is what a Mailer delivers.
+type EmailMessage struct {
+	From     string
+	To       []string
+	Subject  string
+	HTMLBody string
+	TextBody string // Plain-text alternative; optional
+}
+
+// Mailer delivers a single message. Implementations: SMTP, a directory of .eml files, in-memory.
+type Mailer interface {
+	Send(msg EmailMessage) error
+}
+
+// EmailConfig holds the mail settings read from the environment.
+type EmailConfig struct {
+	Transport   string // smtp, file or memory (MAIL_TRANSPORT)
+	SMTPServer  string // SMTP_HOST
+	SMTPPort    string // SMTP_PORT, default 587
+	Username    string // SMTP_USERNAME
+	Password    string // SMTP_PASSWORD
+	EmailFrom   string // MAIL_FROM
+	Dir         string // MAIL_DIR, where the file transport writes .eml files
+	TemplateDir string // MAIL_TEMPLATE_DIR, optional override of the embedded templates/email
+}
+
+// loadEmailConfig reads the mail settings. Without SMTP_HOST mail goes to .eml files so that a
+// development machine never sends real email by accident.
+func loadEmailConfig() EmailConfig {
+	cfg := EmailConfig{
+		Transport:   strings.ToLower(strings.TrimSpace(os.Getenv("MAIL_TRANSPORT"))),
+		SMTPServer:  os.Getenv("SMTP_HOST"),
+		SMTPPort:    os.Getenv("SMTP_PORT"),
+		Username:    os.Getenv("SMTP_USERNAME"),
+		Password:    os.Getenv("SMTP_PASSWORD"),
+		EmailFrom:   os.Getenv("MAIL_FROM"),
+		Dir:         os.Getenv("MAIL_DIR"),
+		TemplateDir: os.Getenv("MAIL_TEMPLATE_DIR"),
+	}
+	if cfg.Transport == "" {
+		cfg.Transport = "file"
+		if cfg.SMTPServer != "" {
+			cfg.Transport = "smtp"
+		}
+	}
+	if cfg.SMTPPort == "" {
+		cfg.SMTPPort = "587"
+	}
+	if cfg.EmailFrom == "" {
+		cfg.EmailFrom = cfg.Username
+	}
+	if cfg.EmailFrom == "" {
+		cfg.EmailFrom = "admin@goclientwise.in"
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "./mail"
+	}
+	return cfg
+}
+
+// newMailer builds the Mailer selected by cfg.Transport.
+func newMailer(cfg EmailConfig) (Mailer, error) {
+	switch cfg.Transport {
+	case "smtp":
+		if cfg.SMTPServer == "" {
+			return nil, errors.New("MAIL_TRANSPORT=smtp requires SMTP_HOST")
+		}
+		return &smtpMailer{cfg: cfg}, nil
+	case "file":
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create mail directory %s: %w", cfg.Dir, err)
+		}
+		log.Printf("MAIL: Writing outgoing email to %s instead of sending it.\n", cfg.Dir)
+		return &fileMailer{dir: cfg.Dir}, nil
+	case "memory":
+		return &memoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported MAIL_TRANSPORT %q (expected smtp, file or memory)", cfg.Transport)
+	}
+}
+
+// buildMIMEMessage renders msg as an RFC 5322 message. With a TextBody it is sent as
+// multipart/alternative so clients that don't render HTML show the plain-text version.
+// Bodies are quoted-printable so long template lines stay within SMTP's line limit.
+func buildMIMEMessage(msg EmailMessage) []byte {
+	var buf bytes.Buffer
+	for _, header := range []string{
+		"From: " + msg.From,
+		"To: " + strings.Join(msg.To, ","),
+		"Subject: " + mime.QEncoding.Encode("UTF-8", msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+	} {
+		buf.WriteString(header + "\r\n")
+	}
+
+	if msg.TextBody == "" {
+		buf.WriteString("Content-Type: text/html; charset=\"UTF-8\"\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(&buf, msg.HTMLBody)
+		return buf.Bytes()
+	}
+
+	parts := multipart.NewWriter(&buf)
+	buf.WriteString("Content-Type: multipart/alternative; boundary=\"" + parts.Boundary() + "\"\r\n\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.TextBody}, // Least preferred alternative first (RFC 2046)
+		{"text/html", msg.HTMLBody},
+	} {
+		w, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=\"UTF-8\""},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		writeQuotedPrintable(w, part.body)
+	}
+	parts.Close()
+	return buf.Bytes()
+}
+
+func writeQuotedPrintable(w io.Writer, body string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(body))
+	qp.Close()
+}
+
+type smtpMailer struct{ cfg EmailConfig }
+
+func (m *smtpMailer) Send(msg EmailMessage) error {
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.SMTPServer)
+	}
+	addr := m.cfg.SMTPServer + ":" + m.cfg.SMTPPort
+	if err := smtp.SendMail(addr, auth, msg.From, msg.To, buildMIMEMessage(msg)); err != nil {
+		return fmt.Errorf("failed to send email via %s: %w", addr, err)
+	}
+	return nil
+}
+
+// fileMailer writes each message to its own .eml file, which any mail client can open.
+type fileMailer struct{ dir string }
+
+func (m *fileMailer) Send(msg EmailMessage) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to name email file: %w", err)
+	}
+	fileName := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix))
+	if err := os.WriteFile(filepath.Join(m.dir, fileName), buildMIMEMessage(msg), 0644); err != nil {
+		return fmt.Errorf("failed to write email file: %w", err)
+	}
+	return nil
+}
+
+// memoryMailer keeps sent messages in memory; Err, when set, makes every Send fail.
+type memoryMailer struct {
+	mu   sync.Mutex
+	sent []EmailMessage
+	Err  error
+}
+
+func (m *memoryMailer) Send(msg EmailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns a copy of the messages delivered so far.
+func (m *memoryMailer) Sent() []EmailMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]EmailMessage(nil), m.sent...)
+}
+
+// --- Email Outbox ---
+
+const (
+	outboxPollInterval = 10 * time.Second
+	outboxBatchSize    = 20
+	outboxMaxAttempts  = 6
+	outboxBaseBackoff  = 30 * time.Second
+	outboxMaxBackoff   = time.Hour
+	// A claimed message whose worker died is picked up again once the lease runs out.
+	outboxClaimLease = 5 * time.Minute
+)
+
+// queueEmail stores a rendered message in the outbox for the worker to deliver. agentUserID is the
+// agent the message is sent on behalf of (who may then query its status), or 0 for account emails.
+// Most callers want queueTemplatedEmail.
+func queueEmail(agentUserID int64, to []string, rendered renderedEmail) (int64, error) {
+	email := OutboxEmail{
+		To:            to,
+		Subject:       rendered.Subject,
+		Body:          rendered.HTML,
+		TextBody:      rendered.Text,
+		Status:        "pending",
+		NextAttemptAt: time.Now(),
+	}
+	if agentUserID != 0 {
+		email.AgentUserID.Int64, email.AgentUserID.Valid = agentUserID, true
+	}
+	id, err := stores.Outbox.Enqueue(email)
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue email %q: %w", rendered.Subject, err)
+	}
+	log.Printf("MAIL: Queued email %d (%s) for %s\n", id, rendered.Subject, strings.Join(to, ","))
+	return id, nil
+}
+
+// outboxBackoff is the delay before retrying a message that has failed attempts times.
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxBackoff)
+}
+
+// deliverOutbox sends every message that is due at now and returns how many were sent.
+func deliverOutbox(outbox OutboxStore, mailer Mailer, from string, now time.Time) int {
+	emails, err := outbox.ClaimDue(now, outboxClaimLease, outboxBatchSize)
+	if err != nil {
+		log.Printf("ERROR: Failed to claim outbox emails: %v", err)
+		return 0
+	}
+	sent := 0
+	for _, email := range emails {
+		sendErr := mailer.Send(EmailMessage{From: from, To: email.To, Subject: email.Subject, HTMLBody: email.Body, TextBody: email.TextBody})
+		switch {
+		case sendErr == nil:
+			err = outbox.MarkSent(email.ID, now)
+			sent++
+		case email.Attempts >= outboxMaxAttempts:
+			log.Printf("ERROR: Giving up on email %d after %d attempts: %v", email.ID, email.Attempts, sendErr)
+			err = outbox.MarkFailed(email.ID, sendErr.Error())
+		default:
+			retryAt := now.Add(outboxBackoff(email.Attempts))
+			log.Printf("WARN: Email %d attempt %d failed, retrying at %s: %v", email.ID, email.Attempts, retryAt.Format(time.RFC3339), sendErr)
+			err = outbox.Reschedule(email.ID, sendErr.Error(), retryAt)
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to record delivery result for email %d: %v", email.ID, err)
+		}
+	}
+	return sent
+}
+
+// runOutboxWorker polls the outbox until ctx is cancelled.
+func runOutboxWorker(ctx context.Context, outbox OutboxStore, mailer Mailer, from string) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		deliverOutbox(outbox, mailer, from, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutboxRetriesWithBackoffThenSends(t *testing.T) {
+	stores = newMemoryStores()
+	mailer := &memoryMailer{Err: errors.New("connection refused")}
+
+	id, err := queueEmail(0, []string{"asha@example.com"}, renderedEmail{Subject: "Hello", HTML: "<p>Hi</p>"})
+	if err != nil {
+		t.Fatalf("queueEmail: %v", err)
+	}
+	now := time.Now()
+	if sent := deliverOutbox(stores.Outbox, mailer, "from@example.com", now); sent != 0 {
+		t.Fatalf("sent = %d with a failing mailer, want 0", sent)
+	}
+	email, _ := stores.Outbox.GetByID(id)
+	if email.Status != "pending" || email.Attempts != 1 || email.LastError.String != "connection refused" {
+		t.Fatalf("after failure: %+v", email)
+	}
+	if want := now.Add(outboxBaseBackoff); !email.NextAttemptAt.Equal(want) {
+		t.Fatalf("next attempt at %v, want %v", email.NextAttemptAt, want)
+	}
+
+	// Not due yet: nothing is claimed even though the mailer has recovered.
+	mailer.Err = nil
+	if sent := deliverOutbox(stores.Outbox, mailer, "from@example.com", now.Add(time.Second)); sent != 0 {
+		t.Fatalf("sent = %d before the backoff elapsed, want 0", sent)
+	}
+	if sent := deliverOutbox(stores.Outbox, mailer, "from@example.com", now.Add(outboxBaseBackoff)); sent != 1 {
+		t.Fatalf("sent = %d after the backoff, want 1", sent)
+	}
+	email, _ = stores.Outbox.GetByID(id)
+	if email.Status != "sent" || email.Attempts != 2 || !email.SentAt.Valid || email.LastError.Valid {
+		t.Fatalf("after success: %+v", email)
+	}
+	msgs := mailer.Sent()
+	if len(msgs) != 1 || msgs[0].From != "from@example.com" || msgs[0].To[0] != "asha@example.com" || msgs[0].Subject != "Hello" {
+		t.Fatalf("delivered messages: %+v", msgs)
+	}
+}
+
+func TestOutboxGivesUpAfterMaxAttempts(t *testing.T) {
+	stores = newMemoryStores()
+	mailer := &memoryMailer{Err: errors.New("mailbox unavailable")}
+	id, _ := queueEmail(0, []string{"asha@example.com"}, renderedEmail{Subject: "Hello", HTML: "<p>Hi</p>"})
+
+	now := time.Now()
+	for i := 0; i < outboxMaxAttempts; i++ {
+		deliverOutbox(stores.Outbox, mailer, "from@example.com", now)
+		now = now.Add(outboxMaxBackoff)
+	}
+	email, _ := stores.Outbox.GetByID(id)
+	if email.Status != "failed" || email.Attempts != outboxMaxAttempts {
+		t.Fatalf("after %d failures: %+v", outboxMaxAttempts, email)
+	}
+	deliverOutbox(stores.Outbox, mailer, "from@example.com", now.Add(outboxMaxBackoff))
+	if email, _ = stores.Outbox.GetByID(id); email.Attempts != outboxMaxAttempts {
+		t.Fatalf("failed email was retried: %+v", email)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 20: time.Hour}
+	for attempts, want := range tests {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestFileMailerWritesEML(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := newMailer(EmailConfig{Transport: "file", Dir: dir})
+	if err != nil {
+		t.Fatalf("newMailer: %v", err)
+	}
+	if err := mailer.Send(EmailMessage{From: "from@example.com", To: []string{"a@example.com", "b@example.com"}, Subject: "Renewal due", HTMLBody: "<p>Soon</p>"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("found %d .eml files, want 1", len(files))
+	}
+	raw, _ := os.ReadFile(files[0])
+	for _, want := range []string{"From: from@example.com", "To: a@example.com,b@example.com", "Subject: Renewal due", "<p>Soon</p>"} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("message is missing %q:\n%s", want, raw)
+		}
+	}
+}
+
+func TestMIMEMessageIsMultipartAlternative(t *testing.T) {
+	raw := buildMIMEMessage(EmailMessage{
+		From: "from@example.com", To: []string{"to@example.com"}, Subject: "Namaste – welcome",
+		TextBody: "Plain " + strings.Repeat("x", 1200), HTMLBody: "<p>Rich</p>",
+	})
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != "Namaste – welcome" {
+		t.Fatalf("subject = %q", subject)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type = %q (%v)", mediaType, err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var types, bodies []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		// multipart.Reader decodes quoted-printable itself and drops the header.
+		body, _ := io.ReadAll(part)
+		types = append(types, part.Header.Get("Content-Type"))
+		bodies = append(bodies, string(body))
+	}
+	if len(types) != 2 || !strings.HasPrefix(types[0], "text/plain") || !strings.HasPrefix(types[1], "text/html") {
+		t.Fatalf("parts = %v", types)
+	}
+	if bodies[0] != "Plain "+strings.Repeat("x", 1200) || bodies[1] != "<p>Rich</p>" {
+		t.Fatalf("bodies = %q", bodies)
+	}
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("line of %d characters exceeds the SMTP limit", len(line))
+		}
+	}
+}
+
+func TestEmailStatusIsScopedToSender(t *testing.T) {
+	s := newTestServer(t)
+	id, err := queueEmail(7, []string{"spoc@insurer.example"}, renderedEmail{Subject: "Proposal", HTML: "<p>Details</p>"})
+	if err != nil {
+		t.Fatalf("queueEmail: %v", err)
+	}
+	accountMail, _ := queueEmail(0, []string{"agent@example.com"}, renderedEmail{Subject: "Welcome", HTML: "<p>Hi</p>"})
+
+	var got OutboxEmail
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/emails/%d", id), tokenFor(t, 7, "agent"), nil, http.StatusOK, &got)
+	if got.Status != "pending" || got.Subject != "Proposal" {
+		t.Fatalf("status response: %+v", got)
+	}
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/emails/%d", id), tokenFor(t, 8, "agent"), nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/emails/%d", accountMail), tokenFor(t, 7, "agent"), nil, http.StatusNotFound, nil)
+}
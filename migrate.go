@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// --- Schema Migrations ---
+
+// Migration files live in db/migrations as <version>_<name>.up.sql / .down.sql
+// and are compiled into the binary so deploys never depend on the working directory.
+// A <version>_<name>.sqlite.up.sql / .sqlite.down.sql file replaces the script on SQLite,
+// for changes translateDDL can't express (SQLite can't alter a constraint, for one).
+//
+//go:embed db/migrations/*.sql
+var migrationFiles embed.FS
+
+const (
+	migrationsDir = "db/migrations"
+	// Advisory lock held for the duration of a migrate run so that two replicas
+	// booting at the same time don't apply the same migration twice.
+	migrationLockName    = "clientwise_schema_migrations"
+	migrationLockTimeout = 60 // seconds
+	// Databases created by the old inline setupDatabase already contain every
+	// table up to and including this version.
+	legacyBaselineVersion int64 = 20250508101349
+)
+
+type migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// SQLiteUp and SQLiteDown, when set, run on SQLite instead of Up and Down.
+	SQLiteUp   string
+	SQLiteDown string
+}
+
+// upScript returns the up script to run against dialect d.
+func (m migration) upScript(d sqlDialect) string {
+	if d == dialectSQLite && m.SQLiteUp != "" {
+		return m.SQLiteUp
+	}
+	return m.Up
+}
+
+// downScript returns the down script to run against dialect d.
+func (m migration) downScript(d sqlDialect) string {
+	if d == dialectSQLite && m.SQLiteDown != "" {
+		return m.SQLiteDown
+	}
+	return m.Down
+}
+
+type migrationRecord struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+// loadMigrations reads the embedded migration files, pairs up/down scripts and sorts by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, migrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base, sqlite := strings.CutSuffix(strings.TrimSuffix(fileName, "."+direction+".sql"), ".sqlite")
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.%s.sql", fileName, direction)
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has an invalid version: %w", fileName, err)
+		}
+		body, err := fs.ReadFile(migrationFiles, path.Join(migrationsDir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", fileName, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d used by both %q and %q", version, m.Name, name)
+		}
+		switch {
+		case direction == "up" && sqlite:
+			m.SQLiteUp = string(body)
+		case direction == "up":
+			m.Up = string(body)
+		case sqlite:
+			m.SQLiteDown = string(body)
+		default:
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitSQLStatements splits a migration script into individual statements at the semicolons
+// outside quotes and comments. "--" comments are dropped; /* */ comments are kept in the
+// statement. A last statement may leave out its semicolon.
+func splitSQLStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'', c == '"', c == '`':
+			// Copy the quoted text through its closing quote; a doubled quote or (except in
+			// identifiers) a backslash escapes one.
+			end := i + 1
+			for ; end < len(script); end++ {
+				if script[end] == '\\' && c != '`' {
+					end++
+				} else if script[end] == c {
+					if end+1 < len(script) && script[end+1] == c {
+						end++
+						continue
+					}
+					break
+				}
+			}
+			end = min(end, len(script)-1)
+			current.WriteString(script[i : end+1])
+			i = end
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+				break
+			}
+			i += end - 1 // Keep the newline
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				end = len(script) - i - 4
+			}
+			current.WriteString(script[i : i+end+4])
+			i += end + 3
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// withMigrationLock runs fn on a dedicated connection while holding the migration advisory lock.
+// GET_LOCK is connection-scoped, so every statement of the run must go through the same *sql.Conn.
+// SQLite has no advisory locks; a SQLite file is only ever served by a single local process.
+func withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire database connection for migrations: %w", err)
+	}
+	defer conn.Close()
+
+	if dbDialect == dialectSQLite {
+		if err := ensureMigrationsTable(ctx, conn); err != nil {
+			return err
+		}
+		return fn(conn)
+	}
+
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, migrationLockTimeout).Scan(&acquired); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return fmt.Errorf("timed out after %ds waiting for migration lock %q", migrationLockTimeout, migrationLockName)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName); err != nil {
+			log.Printf("WARN: Failed to release migration lock: %v", err)
+		}
+	}()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, dbDialect.translateDDL(`CREATE TABLE IF NOT EXISTS schema_migrations (
+        version BIGINT PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    ) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`))
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+func getAppliedMigrations(ctx context.Context, conn *sql.Conn) (map[int64]migrationRecord, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]migrationRecord)
+	for rows.Next() {
+		var rec migrationRecord
+		if err := rows.Scan(&rec.Version, &rec.Name, &rec.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations row: %w", err)
+		}
+		applied[rec.Version] = rec
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schema_migrations rows: %w", err)
+	}
+	return applied, nil
+}
+
+func tableExists(ctx context.Context, conn *sql.Conn, tableName string) (bool, error) {
+	var count int
+	err := conn.QueryRowContext(ctx, dbDialect.tableExistsQuery(), tableName).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for table %s: %w", tableName, err)
+	}
+	return count > 0, nil
+}
+
+// baselineLegacySchema records the migrations that the old inline setupDatabase already
+// applied, so existing deployments don't try to re-create their tables.
+func baselineLegacySchema(ctx context.Context, conn *sql.Conn, migrations []migration, applied map[int64]migrationRecord) error {
+	if len(applied) > 0 {
+		return nil
+	}
+	exists, err := tableExists(ctx, conn, "users")
+	if err != nil || !exists {
+		return err
+	}
+	log.Printf("DATABASE: Existing schema found without migration history, marking migrations up to %d as applied.\n", legacyBaselineVersion)
+	for _, m := range migrations {
+		if m.Version > legacyBaselineVersion {
+			break
+		}
+		if err := recordMigration(ctx, conn, m); err != nil {
+			return err
+		}
+		applied[m.Version] = migrationRecord{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+	}
+	return nil
+}
+
+func recordMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// execMigrationScript runs each statement of a script in order. MySQL commits DDL implicitly,
+// so a failure part-way leaves the earlier statements applied and the migration unrecorded.
+// Not every statement can be guarded (MySQL has no ADD COLUMN or CREATE INDEX IF NOT EXISTS),
+// so such a migration has to be repaired by hand, finishing or undoing what it applied, before
+// migrate runs again. Scripts are written for MySQL and translated for the current dialect
+// (see translateDDL).
+func execMigrationScript(ctx context.Context, conn *sql.Conn, m migration, script string) error {
+	for i, stmt := range splitSQLStatements(script) {
+		if _, err := conn.ExecContext(ctx, dbDialect.translateDDL(stmt)); err != nil {
+			return fmt.Errorf("migration %d_%s failed at statement %d: %w", m.Version, m.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+// migrateUp applies every pending migration in version order.
+func migrateUp() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	return withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := baselineLegacySchema(ctx, conn, migrations, applied); err != nil {
+			return err
+		}
+
+		count := 0
+		for _, m := range migrations {
+			if _, done := applied[m.Version]; done {
+				continue
+			}
+			log.Printf("DATABASE: Applying migration %d_%s...\n", m.Version, m.Name)
+			if err := execMigrationScript(ctx, conn, m, m.upScript(dbDialect)); err != nil {
+				return err
+			}
+			if err := recordMigration(ctx, conn, m); err != nil {
+				return err
+			}
+			count++
+		}
+		if count == 0 {
+			log.Println("DATABASE: Schema is up to date.")
+		} else {
+			log.Printf("DATABASE: Applied %d migration(s).\n", count)
+		}
+		return nil
+	})
+}
+
+// migrateDown rolls back the most recently applied migrations, newest first.
+func migrateDown(steps int) error {
+	if steps <= 0 {
+		return errors.New("number of migrations to roll back must be positive")
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[int64]migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	ctx := context.Background()
+	return withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		if steps > len(versions) {
+			steps = len(versions)
+		}
+
+		for _, v := range versions[:steps] {
+			m, ok := byVersion[v]
+			if !ok {
+				return fmt.Errorf("migration %d_%s is applied but missing from this binary", v, applied[v].Name)
+			}
+			down := m.downScript(dbDialect)
+			if strings.TrimSpace(down) == "" {
+				return fmt.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+			}
+			log.Printf("DATABASE: Rolling back migration %d_%s...\n", m.Version, m.Name)
+			if err := execMigrationScript(ctx, conn, m, down); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+				return fmt.Errorf("failed to remove migration record %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		log.Printf("DATABASE: Rolled back %d migration(s).\n", steps)
+		return nil
+	})
+}
+
+// printMigrationStatus writes one line per known or applied migration.
+func printMigrationStatus() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	return withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+		known := make(map[int64]bool, len(migrations))
+		for _, m := range migrations {
+			known[m.Version] = true
+			status := "pending"
+			if rec, ok := applied[m.Version]; ok {
+				status = "applied " + rec.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, status)
+		}
+		for v, rec := range applied {
+			if !known[v] {
+				fmt.Fprintf(w, "%d\t%s\tapplied %s (missing from binary)\n", v, rec.Name, rec.AppliedAt.Format(time.RFC3339))
+			}
+		}
+		return w.Flush()
+	})
+}
+
+// runMigrateCommand handles `migrate up|down [n]|status`.
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [n]|status")
+	}
+	switch args[0] {
+	case "up":
+		return migrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid number of migrations to roll back: %q", args[1])
+			}
+			steps = n
+		}
+		return migrateDown(steps)
+	case "status":
+		return printMigrationStatus()
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected up, down or status)", args[0])
+	}
+}
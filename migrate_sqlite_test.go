@@ -0,0 +1,137 @@
+//go:build sqlite
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// appliedVersions returns the versions schema_migrations records.
+func appliedVersions(t *testing.T) map[int64]migrationRecord {
+	t.Helper()
+	var applied map[int64]migrationRecord
+	err := withMigrationLock(context.Background(), func(conn *sql.Conn) (err error) {
+		applied, err = getAppliedMigrations(context.Background(), conn)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("applied migrations: %v", err)
+	}
+	return applied
+}
+
+func TestBaselineLegacySchema(t *testing.T) {
+	openSQLiteTestDB(t)
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	ctx := context.Background()
+	baseline := func() {
+		t.Helper()
+		err := withMigrationLock(ctx, func(conn *sql.Conn) error {
+			applied, err := getAppliedMigrations(ctx, conn)
+			if err != nil {
+				return err
+			}
+			return baselineLegacySchema(ctx, conn, migrations, applied)
+		})
+		if err != nil {
+			t.Fatalf("baseline: %v", err)
+		}
+	}
+
+	// A new database has nothing to baseline.
+	baseline()
+	if applied := appliedVersions(t); len(applied) != 0 {
+		t.Fatalf("baselined a new database: %v", applied)
+	}
+
+	// A schema left by the old setupDatabase is marked as migrated up to the baseline.
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	baseline()
+	applied := appliedVersions(t)
+	want := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok != (m.Version <= legacyBaselineVersion) {
+			t.Errorf("migration %d_%s applied = %v", m.Version, m.Name, ok)
+		}
+		if m.Version <= legacyBaselineVersion {
+			want++
+		}
+	}
+	if want == 0 || len(applied) != want {
+		t.Fatalf("baselined %d migrations, want %d", len(applied), want)
+	}
+
+	// Once there is a migration history it is left alone.
+	if _, err := db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, legacyBaselineVersion); err != nil {
+		t.Fatalf("delete migration record: %v", err)
+	}
+	baseline()
+	if applied := appliedVersions(t); len(applied) != want-1 {
+		t.Errorf("baselined again: %d migrations", len(applied))
+	}
+}
+
+func TestMigrateDownLimits(t *testing.T) {
+	openSQLiteTestDB(t)
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if err := migrateUp(); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	// Down steps back from the newest migration, one at a time by default.
+	if err := runMigrateCommand([]string{"down"}); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if err := migrateDown(2); err != nil {
+		t.Fatalf("migrate down 2: %v", err)
+	}
+	applied := appliedVersions(t)
+	if len(applied) != len(migrations)-3 {
+		t.Fatalf("%d migrations applied after rolling back 3 of %d", len(applied), len(migrations))
+	}
+	for _, m := range migrations[len(migrations)-3:] {
+		if _, ok := applied[m.Version]; ok {
+			t.Errorf("migration %d_%s still applied", m.Version, m.Name)
+		}
+	}
+	if err := migrateUp(); err != nil {
+		t.Fatalf("migrate up after rolling back: %v", err)
+	}
+
+	// It won't step past a migration this binary doesn't know.
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, int64(99991231000000), "from_a_newer_binary"); err != nil {
+		t.Fatalf("record unknown migration: %v", err)
+	}
+	if err := migrateDown(1); err == nil || !strings.Contains(err.Error(), "missing from this binary") {
+		t.Fatalf("rolling back an unknown migration: %v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, int64(99991231000000)); err != nil {
+		t.Fatalf("delete unknown migration: %v", err)
+	}
+
+	// More steps than applied migrations stop at an empty schema.
+	if err := migrateDown(len(migrations) + 5); err != nil {
+		t.Fatalf("migrate down past the first migration: %v", err)
+	}
+	if applied := appliedVersions(t); len(applied) != 0 {
+		t.Fatalf("%d migrations still applied", len(applied))
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')`).Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("%d tables left after rolling everything back, %v", tables, err)
+	}
+	if err := migrateDown(1); err != nil {
+		t.Fatalf("migrate down with nothing applied: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"comments", "-- Users\nCREATE TABLE users (\n  id INT, -- The key; never reused\n  name TEXT /* display; name */\n); -- done\n-- trailing\n",
+			[]string{"CREATE TABLE users (\n  id INT, \n  name TEXT /* display; name */\n)"}},
+		{"semicolons in strings", "INSERT INTO t VALUES ('a;b', \"c;\nd\", 'it''s; fine', 'back\\'slash;');\nALTER TABLE `odd;name` ADD COLUMN x INT;",
+			[]string{"INSERT INTO t VALUES ('a;b', \"c;\nd\", 'it''s; fine', 'back\\'slash;')", "ALTER TABLE `odd;name` ADD COLUMN x INT"}},
+		{"comment markers in strings", "UPDATE t SET note = '-- not a comment';", []string{"UPDATE t SET note = '-- not a comment'"}},
+		{"no trailing semicolon", "DROP TABLE a;\nDROP TABLE b", []string{"DROP TABLE a", "DROP TABLE b"}},
+		{"several on a line", "SELECT 1; SELECT 2;;", []string{"SELECT 1", "SELECT 2"}},
+		{"only comments", "-- nothing\n\n  -- to run", nil},
+	}
+	for _, tt := range tests {
+		if got := splitSQLStatements(tt.script); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateCommandArgs(t *testing.T) {
+	for _, args := range [][]string{nil, {"sideways"}, {"down", "0"}, {"down", "-2"}, {"down", "all"}} {
+		if err := runMigrateCommand(args); err == nil {
+			t.Errorf("migrate %q accepted", args)
+		}
+	}
+	if err := migrateDown(0); err == nil {
+		t.Error("migrateDown(0) accepted")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Sales Pipeline ---
+// A client's status says where the relationship stands (Lead, Active, Lapsed); the pipeline tracks
+// the sale. An agent works the pipeline they configured, else their agency's, else the default
+// one. Each stage lists the stages a client can move on to from it. The stages a client goes
+// through are recorded with when it entered and left each one, the deal's expected premium and,
+// for a lost deal, why. Leads that were never moved sit in the first stage, and winning a deal
+// makes the client Active.
+
+// Stage outcomes: a won or lost stage closes the deal.
+const (
+	pipelineOutcomeOpen = "open"
+	pipelineOutcomeWon  = "won"
+	pipelineOutcomeLost = "lost"
+)
+
+var pipelineOutcomes = []string{pipelineOutcomeOpen, pipelineOutcomeWon, pipelineOutcomeLost}
+
+// Where an agent's pipeline comes from.
+const (
+	pipelineSourceOwn     = "own"
+	pipelineSourceAgency  = "agency"
+	pipelineSourceDefault = "default"
+)
+
+const maxPipelineStages = 20
+const maxLostReasonLength = 255
+
+var pipelineStageKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// defaultPipelineStages is the pipeline of agents whose agency configured none either.
+func defaultPipelineStages() []PipelineStage {
+	return []PipelineStage{
+		{Key: "new_lead", Name: "New Lead", Position: 1, Outcome: pipelineOutcomeOpen, NextStages: []string{"contacted", "lost"}},
+		{Key: "contacted", Name: "Contacted", Position: 2, Outcome: pipelineOutcomeOpen, NextStages: []string{"needs_analysis", "lost"}},
+		{Key: "needs_analysis", Name: "Needs Analysis", Position: 3, Outcome: pipelineOutcomeOpen, NextStages: []string{"proposal_sent", "lost"}},
+		{Key: "proposal_sent", Name: "Proposal Sent", Position: 4, Outcome: pipelineOutcomeOpen, NextStages: []string{"won", "lost", "needs_analysis"}},
+		{Key: "won", Name: "Won", Position: 5, Outcome: pipelineOutcomeWon, NextStages: []string{}},
+		{Key: "lost", Name: "Lost", Position: 6, Outcome: pipelineOutcomeLost, NextStages: []string{"new_lead"}},
+	}
+}
+
+type PipelineStagePayload struct {
+	Key        string   `json:"key"`
+	Name       string   `json:"name"`
+	Outcome    string   `json:"outcome"` // Defaults to open
+	NextStages []string `json:"nextStages"`
+}
+
+// PipelinePayload replaces the signed-in user's pipeline; no stages reverts to the one they'd inherit.
+type PipelinePayload struct {
+	Stages []PipelineStagePayload `json:"stages"`
+}
+
+// ClientStagePayload moves a client to a stage. DealValue defaults to the deal's current value;
+// LostReason is required by a lost stage.
+type ClientStagePayload struct {
+	Stage      string   `json:"stage"`
+	DealValue  *float64 `json:"dealValue"`
+	LostReason string   `json:"lostReason"`
+}
+
+// stages validates the payload and returns the pipeline it describes, positioned in order. The
+// first stage, where new leads start, must be open, and some stage must win the deal.
+func (p PipelinePayload) stages() ([]PipelineStage, error) {
+	if len(p.Stages) > maxPipelineStages {
+		return nil, fmt.Errorf("a pipeline has at most %d stages", maxPipelineStages)
+	}
+	stages := make([]PipelineStage, 0, len(p.Stages))
+	for i, sp := range p.Stages {
+		stage := PipelineStage{Key: strings.TrimSpace(sp.Key), Name: strings.TrimSpace(sp.Name), Position: i + 1,
+			Outcome: strings.ToLower(strings.TrimSpace(sp.Outcome)), NextStages: []string{}}
+		if !pipelineStageKeyPattern.MatchString(stage.Key) {
+			return nil, fmt.Errorf("stage key '%s' must start with a lowercase letter and contain only lowercase letters, digits and underscores (at most 50)", stage.Key)
+		}
+		if slices.ContainsFunc(stages, func(s PipelineStage) bool { return s.Key == stage.Key }) {
+			return nil, fmt.Errorf("stage key '%s' is used twice", stage.Key)
+		}
+		if stage.Name == "" {
+			return nil, fmt.Errorf("stage '%s' needs a name", stage.Key)
+		}
+		if stage.Outcome == "" {
+			stage.Outcome = pipelineOutcomeOpen
+		}
+		if !slices.Contains(pipelineOutcomes, stage.Outcome) {
+			return nil, fmt.Errorf("stage outcome must be one of %s", strings.Join(pipelineOutcomes, ", "))
+		}
+		for _, next := range sp.NextStages {
+			if next = strings.TrimSpace(next); !slices.Contains(stage.NextStages, next) {
+				stage.NextStages = append(stage.NextStages, next)
+			}
+		}
+		stages = append(stages, stage)
+	}
+	if len(stages) == 0 {
+		return stages, nil
+	}
+	if stages[0].Outcome != pipelineOutcomeOpen {
+		return nil, fmt.Errorf("the first stage must be open, since new leads start there")
+	}
+	if !slices.ContainsFunc(stages, func(s PipelineStage) bool { return s.Outcome == pipelineOutcomeWon }) {
+		return nil, fmt.Errorf("the pipeline needs a won stage")
+	}
+	for _, stage := range stages {
+		for _, next := range stage.NextStages {
+			if next == stage.Key || pipelineStageIndex(stages, next) < 0 {
+				return nil, fmt.Errorf("stage '%s' can't move on to '%s'", stage.Key, next)
+			}
+		}
+	}
+	return stages, nil
+}
+
+func pipelineStageIndex(stages []PipelineStage, key string) int {
+	return slices.IndexFunc(stages, func(s PipelineStage) bool { return s.Key == key })
+}
+
+// agentPipeline returns the stages of the pipeline a user works and where it comes from.
+func agentPipeline(userID int64) ([]PipelineStage, string, error) {
+	stages, err := stores.Pipeline.ListStages(userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load pipeline of user %d: %w", userID, err)
+	}
+	if len(stages) > 0 {
+		return stages, pipelineSourceOwn, nil
+	}
+	member, err := stores.Agencies.AgencyOf(userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", fmt.Errorf("failed to load agency of user %d: %w", userID, err)
+	}
+	if err == nil {
+		stages, err = stores.Pipeline.ListStages(member.AgencyUserID)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load pipeline of agency %d: %w", member.AgencyUserID, err)
+		}
+		if len(stages) > 0 {
+			return stages, pipelineSourceAgency, nil
+		}
+	}
+	return defaultPipelineStages(), pipelineSourceDefault, nil
+}
+
+// currentClientStage returns the stage the client is in: its latest recorded one, or for a lead
+// that was never moved, the first stage entered when the client was created. It returns nil if
+// the client isn't in the pipeline.
+func currentClientStage(stages []PipelineStage, client Client, history []ClientStage) *ClientStage {
+	if len(history) > 0 && !history[0].LeftAt.Valid {
+		return &history[0]
+	}
+	if len(history) == 0 && client.Status == "Lead" && len(stages) > 0 {
+		return &ClientStage{ClientID: client.ID, AgentUserID: client.AgentUserID, Stage: stages[0].Key, EnteredAt: client.CreatedAt}
+	}
+	return nil
+}
+
+// checkStageTransition returns the stage to move a client in current (nil if it isn't in the
+// pipeline) to. A client outside the pipeline, or in a stage it no longer has, can enter any stage.
+func checkStageTransition(stages []PipelineStage, current *ClientStage, to string) (PipelineStage, error) {
+	i := pipelineStageIndex(stages, to)
+	if i < 0 {
+		return PipelineStage{}, fmt.Errorf("unknown stage '%s'", to)
+	}
+	if current == nil {
+		return stages[i], nil
+	}
+	if current.Stage == to {
+		return PipelineStage{}, fmt.Errorf("the client is already in stage '%s'", to)
+	}
+	from := pipelineStageIndex(stages, current.Stage)
+	if from >= 0 && !slices.Contains(stages[from].NextStages, to) {
+		return PipelineStage{}, fmt.Errorf("a client in stage '%s' can't move to '%s'", current.Stage, to)
+	}
+	return stages[i], nil
+}
+
+// PipelineBoard is an agent's clients by pipeline stage, see buildPipelineBoard.
+type PipelineBoard struct {
+	Source string               `json:"source"` // own, agency or default
+	Stages []PipelineBoardStage `json:"stages"`
+}
+
+type PipelineBoardStage struct {
+	PipelineStage
+	Count   int            `json:"count"`
+	Value   float64        `json:"value"` // Sum of the deal values
+	Clients []PipelineCard `json:"clients"`
+}
+
+type PipelineCard struct {
+	ClientID  int64           `json:"clientId"`
+	Name      string          `json:"name"`
+	Status    string          `json:"status"`
+	EnteredAt time.Time       `json:"enteredAt"`
+	DealValue sql.NullFloat64 `json:"dealValue"`
+}
+
+// buildPipelineBoard puts the agent's clients in their current stages, longest waiting first.
+// Archived clients and clients in stages the pipeline no longer has are left off.
+func buildPipelineBoard(stages []PipelineStage, source string, clients []Client, current []ClientStage) PipelineBoard {
+	byClient := map[int64][]ClientStage{}
+	for _, st := range current {
+		byClient[st.ClientID] = []ClientStage{st}
+	}
+	board := PipelineBoard{Source: source, Stages: make([]PipelineBoardStage, len(stages))}
+	for i, stage := range stages {
+		board.Stages[i] = PipelineBoardStage{PipelineStage: stage, Clients: []PipelineCard{}}
+	}
+	for _, client := range clients {
+		if client.Status == clientStatusArchived {
+			continue
+		}
+		st := currentClientStage(stages, client, byClient[client.ID])
+		if st == nil {
+			continue
+		}
+		if i := pipelineStageIndex(stages, st.Stage); i >= 0 {
+			column := &board.Stages[i]
+			column.Clients = append(column.Clients, PipelineCard{ClientID: client.ID, Name: client.Name, Status: client.Status,
+				EnteredAt: st.EnteredAt, DealValue: st.DealValue})
+			column.Count++
+			column.Value += st.DealValue.Float64
+		}
+	}
+	for i := range board.Stages {
+		column := &board.Stages[i]
+		column.Value = roundMoney(column.Value)
+		sort.SliceStable(column.Clients, func(a, b int) bool { return column.Clients[a].EnteredAt.Before(column.Clients[b].EnteredAt) })
+	}
+	return board
+}
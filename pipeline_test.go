@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPipelinePayload(t *testing.T) {
+	stage := func(key, outcome string, next ...string) PipelineStagePayload {
+		return PipelineStagePayload{Key: key, Name: key, Outcome: outcome, NextStages: next}
+	}
+	stages, err := PipelinePayload{Stages: []PipelineStagePayload{stage("open", "", "won", "won"), stage("won", "WON")}}.stages()
+	if err != nil || len(stages) != 2 || stages[0].Outcome != pipelineOutcomeOpen || stages[1].Outcome != pipelineOutcomeWon ||
+		stages[1].Position != 2 || fmt.Sprint(stages[0].NextStages) != "[won]" {
+		t.Fatalf("stages = %+v, %v", stages, err)
+	}
+	if stages, err := (PipelinePayload{}).stages(); err != nil || len(stages) != 0 {
+		t.Errorf("empty pipeline = %+v, %v", stages, err)
+	}
+	for name, bad := range map[string][]PipelineStagePayload{
+		"bad key":        {stage("New Lead", ""), stage("won", "won")},
+		"duplicate key":  {stage("open", ""), stage("open", "won")},
+		"no name":        {{Key: "open"}, stage("won", "won")},
+		"bad outcome":    {stage("open", "maybe"), stage("won", "won")},
+		"closed first":   {stage("won", "won"), stage("open", "")},
+		"no won stage":   {stage("open", "", "lost"), stage("lost", "lost")},
+		"unknown next":   {stage("open", "", "signed"), stage("won", "won")},
+		"next to itself": {stage("open", "", "open"), stage("won", "won")},
+	} {
+		if _, err := (PipelinePayload{Stages: bad}).stages(); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestCheckStageTransition(t *testing.T) {
+	stages := defaultPipelineStages()
+	lead := Client{ID: 1, Status: "Lead"}
+	current := currentClientStage(stages, lead, nil)
+	if current == nil || current.Stage != "new_lead" {
+		t.Fatalf("never moved lead's stage = %+v", current)
+	}
+	if currentClientStage(stages, Client{ID: 2, Status: "Active"}, nil) != nil {
+		t.Error("active client without stages is in the pipeline")
+	}
+	if _, err := checkStageTransition(stages, current, "contacted"); err != nil {
+		t.Errorf("new_lead -> contacted: %v", err)
+	}
+	for _, to := range []string{"won", "new_lead", "signed"} {
+		if _, err := checkStageTransition(stages, current, to); err == nil {
+			t.Errorf("new_lead -> %s allowed", to)
+		}
+	}
+	if _, err := checkStageTransition(stages, nil, "won"); err != nil {
+		t.Errorf("entering the pipeline as won: %v", err)
+	}
+	if _, err := checkStageTransition(stages, &ClientStage{Stage: "retired"}, "proposal_sent"); err != nil {
+		t.Errorf("leaving a removed stage: %v", err)
+	}
+}
+
+func TestBuildPipelineBoard(t *testing.T) {
+	now := time.Now()
+	clients := []Client{
+		{ID: 1, Name: "New", Status: "Lead", CreatedAt: now},
+		{ID: 2, Name: "Old", Status: "Lead", CreatedAt: now.Add(-48 * time.Hour)},
+		{ID: 3, Name: "Proposal", Status: "Lead"},
+		{ID: 4, Name: "Archived", Status: clientStatusArchived},
+		{ID: 5, Name: "Retired", Status: "Lead"},
+		{ID: 6, Name: "Customer", Status: "Active"},
+	}
+	current := []ClientStage{
+		{ClientID: 3, Stage: "proposal_sent", EnteredAt: now, DealValue: sql.NullFloat64{Float64: 25000.555, Valid: true}},
+		{ClientID: 4, Stage: "proposal_sent", EnteredAt: now, DealValue: sql.NullFloat64{Float64: 10000, Valid: true}},
+		{ClientID: 5, Stage: "retired", EnteredAt: now},
+	}
+	board := buildPipelineBoard(defaultPipelineStages(), pipelineSourceDefault, clients, current)
+	var got []string
+	for _, stage := range board.Stages {
+		got = append(got, fmt.Sprintf("%s:%d:%.2f", stage.Key, stage.Count, stage.Value))
+	}
+	if fmt.Sprint(got) != "[new_lead:2:0.00 contacted:0:0.00 needs_analysis:0:0.00 proposal_sent:1:25000.56 won:0:0.00 lost:0:0.00]" {
+		t.Errorf("board = %v", got)
+	}
+	if cards := board.Stages[0].Clients; cards[0].Name != "Old" || cards[1].Name != "New" {
+		t.Errorf("new leads = %+v", cards)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	s := newTestServer(t)
+	seed := func(email, userType string) int64 {
+		id, err := stores.Users.Create(User{Email: email, UserType: userType, IsVerified: true})
+		if err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+		return id
+	}
+	agencyID, agentID := seed("agency@example.com", "agency"), seed("agent@example.com", "agent")
+	if err := stores.Agencies.AddMember(agencyID, agentID); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	agency, agent := tokenFor(t, agencyID, "agency"), tokenFor(t, agentID, "agent")
+
+	asha := s.createClient(agent, map[string]interface{}{"name": "Asha", "phone": "9000000001", "status": "Lead"})
+	s.createClient(agent, map[string]interface{}{"name": "Ravi", "phone": "9000000002", "status": "Lead"})
+	s.createClient(agent, map[string]interface{}{"name": "Meena", "phone": "9000000003", "status": "Active"})
+	board := func() PipelineBoard {
+		var b PipelineBoard
+		s.doJSON(http.MethodGet, "/api/pipeline", agent, nil, http.StatusOK, &b)
+		return b
+	}
+	if b := board(); b.Source != pipelineSourceDefault || len(b.Stages) != 6 || b.Stages[0].Count != 2 {
+		t.Fatalf("default board = %+v", b)
+	}
+
+	// Moves follow the pipeline's transitions; a lost deal needs a reason, a won one makes a customer.
+	stagePath := fmt.Sprintf("/api/clients/%d/stage", asha.ID)
+	move := func(body map[string]interface{}, want int) ClientStage {
+		var st ClientStage
+		s.doJSON(http.MethodPut, stagePath, agent, body, want, &st)
+		return st
+	}
+	move(map[string]interface{}{"stage": "proposal_sent"}, http.StatusBadRequest)
+	move(map[string]interface{}{"stage": "contacted", "dealValue": -1}, http.StatusBadRequest)
+	if st := move(map[string]interface{}{"stage": "contacted", "dealValue": 30000}, http.StatusOK); st.Stage != "contacted" ||
+		st.DealValue.Float64 != 30000 || st.ChangedBy.Int64 != agentID {
+		t.Fatalf("moved to contacted = %+v", st)
+	}
+	move(map[string]interface{}{"stage": "lost"}, http.StatusBadRequest)
+	move(map[string]interface{}{"stage": "needs_analysis"}, http.StatusOK)
+	if st := move(map[string]interface{}{"stage": "proposal_sent"}, http.StatusOK); st.DealValue.Float64 != 30000 {
+		t.Fatalf("deal value didn't carry over: %+v", st)
+	}
+	b := board()
+	if b.Stages[0].Count != 1 || b.Stages[3].Count != 1 || b.Stages[3].Value != 30000 {
+		t.Fatalf("board after moves = %+v", b)
+	}
+	move(map[string]interface{}{"stage": "won"}, http.StatusOK)
+	var got Client
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d", asha.ID), agent, nil, http.StatusOK, &got)
+	if got.Status != "Active" {
+		t.Fatalf("won client's status = %s", got.Status)
+	}
+
+	var stages []ClientStage
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/stages", asha.ID), agent, nil, http.StatusOK, &stages)
+	if len(stages) != 4 || stages[0].Stage != "won" || stages[0].LeftAt.Valid || !stages[1].LeftAt.Valid {
+		t.Fatalf("stage history = %+v", stages)
+	}
+	var history []EntityChange
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/history", asha.ID), agent, nil, http.StatusOK, &history)
+	if len(history) < 2 || history[0].Field != "status" || history[0].NewValue.String != "Active" || history[1].NewValue.String != "won" {
+		t.Fatalf("history = %+v", history)
+	}
+	s.doJSON(http.MethodPut, stagePath, tokenFor(t, agencyID+agentID, "agent"), map[string]interface{}{"stage": "lost"}, http.StatusNotFound, nil)
+
+	// The agency's pipeline is its agents', until an agent configures their own.
+	s.doJSON(http.MethodPut, "/api/pipeline/stages", agency, map[string]interface{}{"stages": []map[string]interface{}{
+		{"key": "won", "name": "Won", "outcome": "won"},
+	}}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPut, "/api/pipeline/stages", agency, map[string]interface{}{"stages": []map[string]interface{}{
+		{"key": "prospect", "name": "Prospect", "nextStages": []string{"signed", "dropped"}},
+		{"key": "signed", "name": "Signed", "outcome": "won"},
+		{"key": "dropped", "name": "Dropped", "outcome": "lost"},
+	}}, http.StatusOK, nil)
+	if b := board(); b.Source != pipelineSourceAgency || len(b.Stages) != 3 || b.Stages[0].Count != 1 {
+		t.Fatalf("agency board = %+v", b)
+	}
+	s.doJSON(http.MethodPut, "/api/pipeline/stages", agent, map[string]interface{}{"stages": []map[string]interface{}{
+		{"key": "open", "name": "Open", "nextStages": []string{"closed"}},
+		{"key": "closed", "name": "Closed", "outcome": "won"},
+	}}, http.StatusOK, nil)
+	var own struct {
+		Source string          `json:"source"`
+		Stages []PipelineStage `json:"stages"`
+	}
+	s.doJSON(http.MethodGet, "/api/pipeline/stages", agent, nil, http.StatusOK, &own)
+	if own.Source != pipelineSourceOwn || len(own.Stages) != 2 || own.Stages[1].Key != "closed" {
+		t.Fatalf("agent's pipeline = %+v", own)
+	}
+	s.doJSON(http.MethodPut, "/api/pipeline/stages", agent, map[string]interface{}{"stages": []interface{}{}}, http.StatusOK, &own)
+	if own.Source != pipelineSourceAgency {
+		t.Fatalf("pipeline after reverting = %+v", own)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// --- Rate Limiting ---
+// Requests are throttled with token buckets: a bucket holds up to Burst requests and refills at
+// Requests per Per. newRouter applies rateLimitMiddleware per route group, keyed by client IP for
+// public routes and by user for the authenticated API. Buckets live in a RateLimitStore; the
+// default keeps them in process memory, which suits a single instance. Several instances behind a
+// load balancer would plug in a shared store (e.g. Redis) by assigning rateLimiter at startup.
+
+// RateLimit is the size and refill rate of a token bucket.
+type RateLimit struct {
+	Requests int           // Tokens added...
+	Per      time.Duration // ...every Per
+	Burst    int           // Bucket capacity: requests allowed back to back
+}
+
+// RateLimitStore keeps token buckets by key.
+type RateLimitStore interface {
+	// Take spends a token from key's bucket and reports whether there was one; if not, it also
+	// returns how long until there will be.
+	Take(key string, limit RateLimit, now time.Time) (bool, time.Duration)
+}
+
+var rateLimiter RateLimitStore = newMemoryRateLimitStore()
+
+var (
+	authRateLimit    = RateLimit{Requests: 10, Per: time.Minute, Burst: 20}   // Login, and separately its 2FA step, per IP
+	accountRateLimit = RateLimit{Requests: 5, Per: time.Minute, Burst: 10}    // Signup, verification and password reset, per IP
+	onboardRateLimit = RateLimit{Requests: 20, Per: time.Hour, Burst: 5}      // Public onboarding form, per IP
+	portalRateLimit  = RateLimit{Requests: 60, Per: time.Minute, Burst: 30}   // Client portal, per IP
+	apiRateLimit     = RateLimit{Requests: 300, Per: time.Minute, Burst: 120} // Authenticated API, per user
+	// Reset emails sent to one address, whoever asks; keeps /forgot-password from flooding an inbox.
+	resetEmailRateLimit = RateLimit{Requests: 3, Per: time.Hour, Burst: 3}
+)
+
+// rateLimitMiddleware throttles the routes it wraps in buckets named name, one per key(r).
+func rateLimitMiddleware(name string, limit RateLimit, key func(r *http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bucket := name + ":" + key(r)
+			if allowed, retryAfter := rateLimiter.Take(bucket, limit, time.Now()); !allowed {
+				log.Printf("RATE LIMIT: %s exceeded on %s %s", bucket, r.Method, r.URL.Path)
+				respondTooManyRequests(w, retryAfter, "Too many requests. Please try again later.")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitByIP keys buckets by the client's IP address.
+func rateLimitByIP(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// rateLimitByUser keys buckets by the authenticated user, falling back to the IP address.
+func rateLimitByUser(r *http.Request) string {
+	if userID, ok := getUserIDFromContext(r.Context()); ok {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	return rateLimitByIP(r)
+}
+
+// respondTooManyRequests answers 429 with a Retry-After header in whole seconds.
+func respondTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+	respondError(w, http.StatusTooManyRequests, message)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // When the bucket will have refilled completely, after which it can be dropped
+}
+
+// memoryRateLimitStore keeps buckets in a map, dropping ones that have refilled now and then.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+const rateLimitSweepInterval = time.Minute
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (s *memoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= rateLimitSweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	perSecond := float64(limit.Requests) / limit.Per.Seconds()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / perSecond * float64(time.Second)))
+	if !allowed {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	return true, 0
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	store := newMemoryRateLimitStore()
+	limit := RateLimit{Requests: 2, Per: time.Second, Burst: 3}
+	now := time.Unix(1_700_000_000, 0)
+	for i := 0; i < 3; i++ {
+		if ok, _ := store.Take("k", limit, now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := store.Take("k", limit, now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("Take past the burst = %v, %v; want refused, 500ms", ok, wait)
+	}
+	if ok, _ := store.Take("other", limit, now); !ok {
+		t.Fatal("buckets are not separate per key")
+	}
+	if ok, _ := store.Take("k", limit, now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("bucket did not refill")
+	}
+
+	// Refilled buckets are dropped on the next sweep.
+	store.Take("k", limit, now.Add(time.Hour))
+	if len(store.buckets) != 1 {
+		t.Fatalf("%d buckets after sweep, want 1", len(store.buckets))
+	}
+}
+
+func TestPublicRoutesAreRateLimited(t *testing.T) {
+	s := newTestServer(t)
+	form := map[string]interface{}{"name": "Meera Iyer", "email": "meera@example.com", "phone": "9800000000"}
+	for i := 0; i < onboardRateLimit.Burst; i++ {
+		if rec := s.do(http.MethodPost, "/api/onboard?agentId=3", "", form); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("onboarding request %d was rate limited", i+1)
+		}
+	}
+	rec := s.do(http.MethodPost, "/api/onboard?agentId=3", "", form)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("request past the burst: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Other route groups have their own buckets.
+	s.doJSON(http.MethodPost, "/forgot-password", "", map[string]string{"email": "nobody@example.com"}, http.StatusOK, nil)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+)
+
+// --- Roles & Permissions ---
+// Every user acts under one role, and routes demand permissions rather than a user type. Agency
+// accounts are always "owner". Agents are "agent" unless their agency assigns them another role;
+// the assignment is dropped when they leave. The role and its permissions are resolved when an
+// access token is signed and carried in its claims, so a role change takes effect at the next
+// token refresh. The definitions below are seeded into roles/permissions/role_permissions by
+// migration 20261016180000; rbac_test.go keeps the two in step.
+
+const (
+	permClientsRead         = "clients.read"
+	permClientsWrite        = "clients.write"
+	permPoliciesRead        = "policies.read"
+	permPoliciesWrite       = "policies.write"
+	permTasksRead           = "tasks.read"
+	permTasksWrite          = "tasks.write"
+	permCommissionsView     = "commissions.view"
+	permProductsManage      = "products.manage"
+	permMarketingManage     = "marketing.manage"
+	permAgencySettings      = "agency.settings.manage"
+	permAgencyMembersManage = "agency.members.manage"
+	permAgencyBooksRead     = "agency.books.read" // Member agents' clients, policies, tasks and commissions
+)
+
+const (
+	roleOwner      = "owner"
+	roleManager    = "manager"
+	roleAgent      = "agent"
+	roleBackOffice = "back_office"
+	roleAuditor    = "auditor"
+)
+
+// builtinRoles are the roles an agency can hand out, with their permissions.
+var builtinRoles = []Role{
+	{Name: roleAgent, Description: "Works their own book of clients", Permissions: []string{
+		permClientsRead, permClientsWrite, permPoliciesRead, permPoliciesWrite, permTasksRead, permTasksWrite,
+		permCommissionsView, permMarketingManage}},
+	{Name: roleAuditor, Description: "Read-only access for audits and compliance", Permissions: []string{
+		permClientsRead, permPoliciesRead, permTasksRead, permCommissionsView, permAgencyBooksRead}},
+	{Name: roleBackOffice, Description: "Processes clients, policies and tasks across the agency", Permissions: []string{
+		permClientsRead, permClientsWrite, permPoliciesRead, permPoliciesWrite, permTasksRead, permTasksWrite,
+		permAgencyBooksRead}},
+	{Name: roleManager, Description: "Runs the agency's team and products", Permissions: []string{
+		permClientsRead, permClientsWrite, permPoliciesRead, permPoliciesWrite, permTasksRead, permTasksWrite,
+		permCommissionsView, permProductsManage, permMarketingManage, permAgencyMembersManage, permAgencyBooksRead}},
+	{Name: roleOwner, Description: "The agency account, with every permission", Permissions: []string{
+		permClientsRead, permClientsWrite, permPoliciesRead, permPoliciesWrite, permTasksRead, permTasksWrite,
+		permCommissionsView, permProductsManage, permMarketingManage, permAgencySettings, permAgencyMembersManage,
+		permAgencyBooksRead}},
+}
+
+// assignableRoles are the roles an agency may give its member agents; owner belongs to the agency
+// account alone.
+var assignableRoles = map[string]bool{roleManager: true, roleAgent: true, roleBackOffice: true, roleAuditor: true}
+
+const roleKey contextKey = "role"
+const permissionsKey contextKey = "permissions"
+const agencyIDKey contextKey = "agencyID"
+
+// userRole returns the role userID acts under.
+func userRole(userID int64, userType string) (string, error) {
+	if userType == "agency" {
+		return roleOwner, nil
+	}
+	role, err := stores.Roles.UserRole(userID)
+	if err == sql.ErrNoRows {
+		return roleAgent, nil
+	}
+	return role, err
+}
+
+// resolveRole returns userID's role and the permissions it grants.
+func resolveRole(userID int64, userType string) (string, []string, error) {
+	role, err := userRole(userID, userType)
+	if err != nil {
+		return "", nil, err
+	}
+	permissions, err := stores.Roles.Permissions(role)
+	if err != nil {
+		return "", nil, err
+	}
+	return role, permissions, nil
+}
+
+func getRoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleKey).(string)
+	return role, ok
+}
+
+func getPermissionsFromContext(ctx context.Context) ([]string, bool) {
+	permissions, ok := ctx.Value(permissionsKey).([]string)
+	return permissions, ok
+}
+
+func getAgencyIDFromContext(ctx context.Context) (int64, bool) {
+	agencyID, ok := ctx.Value(agencyIDKey).(int64)
+	return agencyID, ok
+}
+
+func hasPermission(ctx context.Context, permission string) bool {
+	permissions, _ := getPermissionsFromContext(ctx)
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePermission lets a request through only if the caller's role grants every permission.
+func requirePermission(permissions ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, permission := range permissions {
+				if !hasPermission(r.Context(), permission) {
+					userID, _ := getUserIDFromContext(r.Context())
+					role, _ := getRoleFromContext(r.Context())
+					log.Printf("AUTH: Forbidden: user %d (role '%s') lacks %s for %s %s", userID, role, permission, r.Method, r.URL.Path)
+					respondError(w, http.StatusForbidden, "Forbidden: missing permission "+permission)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// agencyScopeMiddleware puts the agency the caller acts for in the request context: the agency
+// account itself, or the agency an agent belongs to. Anyone else is refused.
+func agencyScopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := getUserIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusInternalServerError, "Auth error")
+			return
+		}
+		agencyID := userID
+		if userType, _ := getUserTypeFromContext(r.Context()); userType != "agency" {
+			member, err := stores.Agencies.AgencyOf(userID)
+			if err == sql.ErrNoRows {
+				log.Printf("AUTH: Forbidden agency access by user %d, who belongs to no agency", userID)
+				respondError(w, http.StatusForbidden, "Forbidden: Agency access required")
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR: AUTH: Looking up agency of user %d: %v", userID, err)
+				respondError(w, http.StatusInternalServerError, "Could not verify agency membership")
+				return
+			}
+			agencyID = member.AgencyUserID
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), agencyIDKey, agencyID)))
+	})
+}
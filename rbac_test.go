@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestBuiltinRolesMatchMigration(t *testing.T) {
+	raw, err := os.ReadFile("db/migrations/20261016180000_create_roles.up.sql")
+	if err != nil {
+		t.Fatalf("read migration: %v", err)
+	}
+	_, grants, found := strings.Cut(string(raw), "INSERT INTO role_permissions")
+	if !found {
+		t.Fatal("migration seeds no role_permissions")
+	}
+	var seeded []string
+	for _, m := range regexp.MustCompile(`\('([a-z_]+)', '([a-z_.]+)'\)`).FindAllStringSubmatch(grants, -1) {
+		seeded = append(seeded, m[1]+" "+m[2])
+	}
+	var defined []string
+	for _, role := range builtinRoles {
+		for _, p := range role.Permissions {
+			defined = append(defined, role.Name+" "+p)
+		}
+	}
+	sort.Strings(seeded)
+	sort.Strings(defined)
+	if strings.Join(seeded, "\n") != strings.Join(defined, "\n") {
+		t.Fatalf("migration grants\n%s\nbut builtinRoles grant\n%s", strings.Join(seeded, "\n"), strings.Join(defined, "\n"))
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	s := newTestServer(t)
+	seed := func(email, userType string) int64 {
+		id, err := stores.Users.Create(User{Email: email, UserType: userType, IsVerified: true})
+		if err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+		return id
+	}
+	agencyID := seed("agency@example.com", "agency")
+	managerID, auditorID, loneID := seed("manager@example.com", "agent"), seed("auditor@example.com", "agent"), seed("lone@example.com", "agent")
+	for _, id := range []int64{managerID, auditorID} {
+		if err := stores.Agencies.AddMember(agencyID, id); err != nil {
+			t.Fatalf("add member: %v", err)
+		}
+	}
+	agency := tokenFor(t, agencyID, "agency")
+	rolePath := func(id int64) string { return fmt.Sprintf("/api/agency/members/%d/role", id) }
+
+	// Only the agency's role managers hand out roles, and never owner.
+	s.doJSON(http.MethodPut, rolePath(auditorID), tokenFor(t, managerID, "agent"), map[string]string{"role": roleAuditor}, http.StatusForbidden, nil)
+	s.doJSON(http.MethodPut, rolePath(managerID), agency, map[string]string{"role": roleOwner}, http.StatusBadRequest, nil)
+	var member AgencyMember
+	s.doJSON(http.MethodPut, rolePath(managerID), agency, map[string]string{"role": roleManager}, http.StatusOK, &member)
+	if member.Role != roleManager {
+		t.Fatalf("member after assignment = %+v", member)
+	}
+	s.doJSON(http.MethodPut, rolePath(loneID), agency, map[string]string{"role": roleAuditor}, http.StatusNotFound, nil)
+
+	// Roles are read into the token when it is signed.
+	manager := tokenFor(t, managerID, "agent")
+	s.doJSON(http.MethodPut, rolePath(auditorID), manager, map[string]string{"role": roleAuditor}, http.StatusOK, nil)
+	s.doJSON(http.MethodPut, rolePath(managerID), manager, map[string]string{"role": roleAgent}, http.StatusForbidden, nil)
+	s.doJSON(http.MethodPost, "/api/agency/invitations", manager, map[string]string{"email": "new@example.com"}, http.StatusCreated, nil)
+	s.doJSON(http.MethodGet, "/api/agency/settings", manager, nil, http.StatusForbidden, nil)
+
+	// An auditor reads but doesn't write.
+	auditor := tokenFor(t, auditorID, "agent")
+	var roles struct {
+		Role        string
+		Permissions []string
+		Roles       []Role
+	}
+	s.doJSON(http.MethodGet, "/api/roles", auditor, nil, http.StatusOK, &roles)
+	if roles.Role != roleAuditor || len(roles.Roles) != len(builtinRoles) {
+		t.Fatalf("roles = %+v", roles)
+	}
+	s.doJSON(http.MethodGet, "/api/clients", auditor, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, "/api/agency/members", auditor, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/agency/members/%d/commissions", managerID), auditor, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, "/api/clients", auditor, map[string]interface{}{"name": "Asha Rao", "email": "asha@example.com"}, http.StatusForbidden, nil)
+	s.doJSON(http.MethodPut, "/api/task/status", auditor, map[string]interface{}{"taskId": 1, "isCompleted": true}, http.StatusForbidden, nil)
+	s.doJSON(http.MethodGet, "/api/marketing/campaigns", auditor, nil, http.StatusForbidden, nil)
+
+	// Agents outside an agency have no agency scope; products are for product managers.
+	lone := tokenFor(t, loneID, "agent")
+	s.doJSON(http.MethodGet, "/api/agency/members", lone, nil, http.StatusForbidden, nil)
+	s.doJSON(http.MethodPost, "/api/products", lone, map[string]string{"id": "p1", "name": "Term", "category": "Life", "insurer": "Acme"}, http.StatusForbidden, nil)
+
+	// Leaving the agency drops the role it gave.
+	s.doJSON(http.MethodPost, "/api/agents/agency/leave", auditor, nil, http.StatusOK, nil)
+	s.createClient(tokenFor(t, auditorID, "agent"), map[string]interface{}{"name": "Asha Rao", "email": "asha@example.com"})
+
+	// Tokens signed before roles existed get the role resolved on use.
+	now := time.Now()
+	sessionID, err := stores.Sessions.Create(Session{UserID: loneID, RefreshTokenHash: generateSimpleID(32), LastUsedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: loneID, UserType: "agent", SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}}).SignedString(jwtSecretKey)
+	if err != nil {
+		t.Fatalf("sign legacy token: %v", err)
+	}
+	s.doJSON(http.MethodGet, "/api/clients", legacy, nil, http.StatusOK, nil)
+}
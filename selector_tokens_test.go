@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectorTokens(t *testing.T) {
+	s := newTestServer(t)
+	userID, err := stores.Users.Create(User{Email: "agent@example.com", PasswordHash: "x", UserType: "agent", IsVerified: true})
+	if err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	token, err := newSelectorToken()
+	if err != nil {
+		t.Fatalf("newSelectorToken: %v", err)
+	}
+	if err := stores.Tokens.Store(userID, token, "reset", time.Hour); err != nil {
+		t.Fatalf("store token: %v", err)
+	}
+
+	selector, _, _ := strings.Cut(token, ".")
+	forged := selector + "." + strings.Repeat("0", 2*tokenVerifierBytes)
+	for _, bad := range []string{forged, selector, "not-a-token"} {
+		s.doJSON(http.MethodPost, "/reset-password", "", map[string]string{"token": bad, "newPassword": "n3w-pass"}, http.StatusBadRequest, nil)
+	}
+	if _, err := stores.Tokens.Verify(token, "verification"); err == nil {
+		t.Fatal("reset token verified for another purpose")
+	}
+	s.doJSON(http.MethodPost, "/reset-password", "", map[string]string{"token": token, "newPassword": "n3w-pass"}, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, "/reset-password", "", map[string]string{"token": token, "newPassword": "n3w-pass"}, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// --- Sessions ---
+// Login starts a session: a row in sessions plus a short-lived access token (JWT) that names it
+// and a long-lived refresh token. The refresh token is random, stored only as a hash, and replaced
+// on every use; presenting a replaced token again revokes the session, since only a stolen copy
+// would do that. authMiddleware checks the session on every request, so logging out or revoking a
+// device takes effect immediately rather than when the access token expires.
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	maxDeviceNameLength    = 100
+	maxUserAgentLength     = 255
+)
+
+const sessionIDKey contextKey = "sessionID"
+
+func getSessionIDFromContext(ctx context.Context) (int64, bool) {
+	sessionID, ok := ctx.Value(sessionIDKey).(int64)
+	return sessionID, ok
+}
+
+// SessionTokens is what login and refresh return to the client.
+type SessionTokens struct {
+	SessionID        int64  `json:"sessionId"`
+	Token            string `json:"token"`     // Access token for the Authorization header
+	ExpiresAt        int64  `json:"expiresAt"` // Unix seconds
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresAt int64  `json:"refreshExpiresAt"` // Unix seconds
+}
+
+// hashRefreshToken returns the hex SHA-256 of a refresh token. The tokens are 256 random bits, so
+// a fast hash is enough and lets the token be looked up directly.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signAccessToken issues a JWT for the user's session.
+func signAccessToken(userID int64, userType string, sessionID int64, now time.Time) (string, time.Time, error) {
+	role, permissions, err := resolveRole(userID, userType)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to resolve role of user %d: %w", userID, err)
+	}
+	expiresAt := now.Add(config.AccessTokenTTL)
+	claims := &Claims{UserID: userID, UserType: userType, SessionID: sessionID, Role: role, Permissions: permissions, RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    "clientwise",
+		Subject:   fmt.Sprintf("%d", userID),
+	}}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecretKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign access token: %w", err)
+	}
+	return signed, expiresAt, nil
+}
+
+// requestDevice returns the user agent and client IP of r for the session registry.
+func requestDevice(r *http.Request) (userAgent, ipAddress string) {
+	userAgent = r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent, clientIP(r)
+}
+
+// clientIP returns the IP address of the connection's peer; forwarding headers are not trusted.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// startSession records a new session for user signing in through r and returns its tokens.
+func startSession(user *User, r *http.Request, deviceName string) (SessionTokens, error) {
+	refreshToken, err := generateToken(32)
+	if err != nil {
+		return SessionTokens{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	deviceName = strings.TrimSpace(deviceName)
+	if len(deviceName) > maxDeviceNameLength {
+		deviceName = deviceName[:maxDeviceNameLength]
+	}
+	userAgent, ipAddress := requestDevice(r)
+	now := time.Now()
+	session := Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hashRefreshToken(refreshToken),
+		DeviceName:       sql.NullString{String: deviceName, Valid: deviceName != ""},
+		UserAgent:        sql.NullString{String: userAgent, Valid: userAgent != ""},
+		IPAddress:        sql.NullString{String: ipAddress, Valid: ipAddress != ""},
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(config.RefreshTokenTTL),
+	}
+	session.ID, err = stores.Sessions.Create(session)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	accessToken, accessExpiresAt, err := signAccessToken(user.ID, user.UserType, session.ID, now)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	return SessionTokens{
+		SessionID:        session.ID,
+		Token:            accessToken,
+		ExpiresAt:        accessExpiresAt.Unix(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt.Unix(),
+	}, nil
+}
+
+// refreshSession rotates refreshToken and issues a new access token for its session. It returns
+// sql.ErrNoRows for unknown, expired or revoked tokens, errRefreshTokenReused for replayed ones and
+// errTwoFactorSetupRequired (revoking the session) when the user's agency requires 2FA they lack.
+func refreshSession(refreshToken string, r *http.Request) (SessionTokens, error) {
+	newRefreshToken, err := generateToken(32)
+	if err != nil {
+		return SessionTokens{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	userAgent, ipAddress := requestDevice(r)
+	now := time.Now()
+	session, err := stores.Sessions.Rotate(hashRefreshToken(refreshToken), hashRefreshToken(newRefreshToken), now, now.Add(config.RefreshTokenTTL), userAgent, ipAddress)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	user, err := stores.Users.GetByID(session.UserID)
+	if err != nil {
+		return SessionTokens{}, fmt.Errorf("failed to load user %d for session %d: %w", session.UserID, session.ID, err)
+	}
+	// An agency that starts requiring 2FA locks out members who haven't set it up until they log in again
+	enabled, required, err := twoFactorStatus(user)
+	if err != nil {
+		return SessionTokens{}, fmt.Errorf("failed to check 2FA for user %d: %w", user.ID, err)
+	}
+	if required && !enabled {
+		if err := stores.Sessions.Revoke(session.ID, user.ID, now); err != nil {
+			return SessionTokens{}, err
+		}
+		return SessionTokens{}, errTwoFactorSetupRequired
+	}
+	accessToken, accessExpiresAt, err := signAccessToken(user.ID, user.UserType, session.ID, now)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	return SessionTokens{
+		SessionID:        session.ID,
+		Token:            accessToken,
+		ExpiresAt:        accessExpiresAt.Unix(),
+		RefreshToken:     newRefreshToken,
+		RefreshExpiresAt: session.ExpiresAt.Unix(),
+	}, nil
+}
+
+// sessionIsActive reports whether the session a token was issued for may still be used.
+func sessionIsActive(sessionID, userID int64) (bool, error) {
+	session, err := stores.Sessions.GetByID(sessionID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return session.UserID == userID && !session.RevokedAt.Valid && session.ExpiresAt.After(time.Now()), nil
+}
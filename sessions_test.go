@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret-pass"), bcrypt.MinCost) // Login checks it at the cost it was made with
+	userID, err := stores.Users.Create(User{Email: "agent@example.com", PasswordHash: string(hash), UserType: "agent", IsVerified: true})
+	if err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	login := func(device string) SessionTokens {
+		var tokens SessionTokens
+		s.doJSON(http.MethodPost, "/login", "", map[string]string{"email": "agent@example.com", "password": "s3cret-pass", "userType": "agent", "deviceName": device}, http.StatusOK, &tokens)
+		if tokens.Token == "" || tokens.RefreshToken == "" || tokens.SessionID == 0 {
+			t.Fatalf("login returned %+v", tokens)
+		}
+		return tokens
+	}
+	laptop, phone := login("Laptop"), login("Phone")
+
+	var sessions []Session
+	s.doJSON(http.MethodGet, "/auth/sessions", laptop.Token, nil, http.StatusOK, &sessions)
+	if len(sessions) != 2 || sessions[0].UserID != 0 || !sessions[1].Current || sessions[1].DeviceName.String != "Laptop" {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+
+	// Refreshing rotates the token; replaying the old one revokes the session.
+	var refreshed SessionTokens
+	s.doJSON(http.MethodPost, "/auth/refresh", "", map[string]string{"refreshToken": phone.RefreshToken}, http.StatusOK, &refreshed)
+	if refreshed.SessionID != phone.SessionID || refreshed.RefreshToken == phone.RefreshToken {
+		t.Fatalf("refresh = %+v", refreshed)
+	}
+	s.doJSON(http.MethodGet, "/auth/sessions", refreshed.Token, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, "/auth/refresh", "", map[string]string{"refreshToken": phone.RefreshToken}, http.StatusUnauthorized, nil)
+	s.doJSON(http.MethodGet, "/auth/sessions", refreshed.Token, nil, http.StatusUnauthorized, nil)
+	s.doJSON(http.MethodPost, "/auth/refresh", "", map[string]string{"refreshToken": refreshed.RefreshToken}, http.StatusUnauthorized, nil)
+
+	// Revoking another device and logging out take effect immediately.
+	tablet := login("Tablet")
+	s.doJSON(http.MethodDelete, fmt.Sprintf("/auth/sessions/%d", tablet.SessionID), laptop.Token, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, "/api/clients", tablet.Token, nil, http.StatusUnauthorized, nil)
+	s.doJSON(http.MethodDelete, fmt.Sprintf("/auth/sessions/%d", tablet.SessionID), laptop.Token, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodPost, "/auth/logout", laptop.Token, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, "/api/clients", laptop.Token, nil, http.StatusUnauthorized, nil)
+	s.doJSON(http.MethodPost, "/auth/refresh", "", map[string]string{"refreshToken": laptop.RefreshToken}, http.StatusUnauthorized, nil)
+
+	// Sign out everywhere else.
+	desk, other := login("Desk"), login("Other")
+	var revoked struct{ Revoked int }
+	s.doJSON(http.MethodDelete, "/auth/sessions", desk.Token, nil, http.StatusOK, &revoked)
+	if revoked.Revoked != 1 {
+		t.Fatalf("revoked %d other sessions, want 1", revoked.Revoked)
+	}
+	s.doJSON(http.MethodGet, "/api/clients", other.Token, nil, http.StatusUnauthorized, nil)
+	s.doJSON(http.MethodGet, "/api/clients", desk.Token, nil, http.StatusOK, nil)
+
+	// Tokens issued before sessions existed carry no session and are refused.
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: userID, UserType: "agent", RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}).SignedString(jwtSecretKey)
+	s.doJSON(http.MethodGet, "/api/clients", legacy, nil, http.StatusUnauthorized, nil)
+}
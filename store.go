@@ -0,0 +1,456 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// --- Data Stores ---
+// Handlers reach persistence only through these interfaces. The SQL implementations
+// (MySQL or SQLite, see dialect.go) live in store_sql.go; store_memory.go is an in-memory
+// implementation used by the handler tests. "Not found" is always reported as sql.ErrNoRows
+// so handlers can keep comparing against it regardless of the backing store.
+
+// errDuplicateRecord is returned when an insert violates a unique constraint
+// (e.g. same client email/phone for an agent, existing product ID, PAN already used).
+var errDuplicateRecord = errors.New("duplicate record")
+
+// errVersionConflict is returned when a conditional update finds the record changed since the
+// caller read it (see ClientStore.Update).
+var errVersionConflict = errors.New("record has been modified")
+
+// errSuggestionReviewed is returned when accepting or rejecting a suggestion that is no longer pending.
+var errSuggestionReviewed = errors.New("suggestion has already been reviewed")
+
+// errRefreshTokenReused is returned when a refresh token that was already rotated out is presented
+// again, which means it was copied; the session it belonged to is revoked.
+var errRefreshTokenReused = errors.New("refresh token has already been used")
+
+// errAgencyMembership is returned when an agent who already belongs to an agency accepts an
+// invitation; they have to leave first.
+var errAgencyMembership = errors.New("agent already belongs to an agency")
+
+// errNotHouseholdMember is returned when a policy is set to insure someone outside its
+// policyholder's household.
+var errNotHouseholdMember = errors.New("member is not in the policyholder's household")
+
+type UserStore interface {
+	Create(user User) (int64, error)
+	GetByEmail(email string) (*User, error)
+	GetByID(userID int64) (*User, error)
+	MarkVerified(userID int64) error
+	UpdatePassword(userID int64, newPasswordHash string) error
+	// RecordFailedLogin increments the user's failed login count and returns the new count.
+	RecordFailedLogin(userID int64) (int, error)
+	LockUntil(userID int64, until time.Time) error
+	// ResetFailedLogins clears the failed login count and any lockout.
+	ResetFailedLogins(userID int64) error
+}
+
+// TokenStore holds hashed single-use tokens for email verification and password reset.
+type TokenStore interface {
+	Store(userID int64, token string, purpose string, duration time.Duration) error
+	Verify(token string, purpose string) (int64, error)
+	DeleteByUserID(userID int64, purpose string) error
+}
+
+// SessionStore is the registry of signed-in devices behind refresh tokens.
+type SessionStore interface {
+	Create(session Session) (int64, error)
+	// GetByID returns the session even if it has been revoked or has expired; callers check.
+	GetByID(sessionID int64) (*Session, error)
+	// Rotate swaps the refresh token of the active session holding oldHash for newHash and extends
+	// it to expiresAt. Presenting a token that was already rotated out revokes its session and
+	// returns errRefreshTokenReused.
+	Rotate(oldHash, newHash string, now, expiresAt time.Time, userAgent, ipAddress string) (*Session, error)
+	// ListActive returns the user's unrevoked, unexpired sessions, most recently used first.
+	ListActive(userID int64, now time.Time) ([]Session, error)
+	// Revoke returns sql.ErrNoRows if the user has no such unrevoked session.
+	Revoke(sessionID int64, userID int64, now time.Time) error
+	// RevokeAll revokes every session of the user except exceptSessionID (0 for none) and returns
+	// how many were revoked.
+	RevokeAll(userID int64, exceptSessionID int64, now time.Time) (int, error)
+}
+
+// TwoFactorStore holds each user's TOTP secret and hashed recovery codes.
+type TwoFactorStore interface {
+	Get(userID int64) (*TwoFactor, error)
+	// SavePending stores a new secret awaiting confirmation, replacing any earlier pending one.
+	SavePending(userID int64, secret string) error
+	// Enable turns on the pending secret, records the step of the confirming code and replaces the
+	// recovery codes. It returns sql.ErrNoRows if nothing is pending.
+	Enable(userID int64, step int64, recoveryCodeHashes []string, now time.Time) error
+	// Disable removes the secret and the recovery codes.
+	Disable(userID int64) error
+	// UseStep records that the code for step was used and reports false if that step (or a later
+	// one) already was, so each code works only once.
+	UseStep(userID int64, step int64) (bool, error)
+	// UseRecoveryCode marks an unused code as used and reports whether there was one.
+	UseRecoveryCode(userID int64, codeHash string, now time.Time) (bool, error)
+	ReplaceRecoveryCodes(userID int64, codeHashes []string) error
+	RecoveryCodesRemaining(userID int64) (int, error)
+}
+
+// AgencyStore holds agency membership, invitations and agency-wide settings.
+type AgencyStore interface {
+	// GetSettings returns the defaults if the agency has never saved any.
+	GetSettings(agencyUserID int64) (*AgencySettings, error)
+	UpdateSettings(settings AgencySettings) error
+	// AddMember puts the agent under the agency, moving them if they belonged to another.
+	AddMember(agencyUserID, agentUserID int64) error
+	// AgencyOf returns the agent's membership, or sql.ErrNoRows if they belong to no agency.
+	AgencyOf(agentUserID int64) (*AgencyMember, error)
+	ListMembers(agencyUserID int64) ([]AgencyMember, error)
+	// RemoveMember takes the agent out of the agency and, when transferTo is non-zero, moves their
+	// clients and everything filed under them to transferTo in the same transaction. A client
+	// clashing with one of transferTo's (same email or phone) fails it with errDuplicateRecord.
+	// Returns sql.ErrNoRows if the agent isn't a member.
+	RemoveMember(agencyUserID, agentUserID, transferTo int64) error
+	// RequiresTwoFactor reports whether the agent's agency requires 2FA.
+	RequiresTwoFactor(agentUserID int64) (bool, error)
+
+	// CreateInvitation returns errDuplicateRecord if the agency already has a pending, unexpired
+	// invitation for the email.
+	CreateInvitation(invitation AgencyInvitation) (int64, error)
+	ListInvitations(agencyUserID int64) ([]AgencyInvitation, error)
+	// ListInvitationsForEmail returns the pending, unexpired invitations sent to email.
+	ListInvitationsForEmail(email string, now time.Time) ([]AgencyInvitation, error)
+	// RevokeInvitation returns sql.ErrNoRows if the agency has no such pending invitation.
+	RevokeInvitation(invitationID, agencyUserID int64, now time.Time) error
+	// RespondToInvitation accepts or declines a pending, unexpired invitation sent to email;
+	// accepting makes agentUserID a member. Returns sql.ErrNoRows if there is no such invitation
+	// and errAgencyMembership if the agent already belongs to an agency.
+	RespondToInvitation(invitationID int64, email string, agentUserID int64, accept bool, now time.Time) (*AgencyInvitation, error)
+}
+
+// RoleStore holds role definitions and the roles agencies assign to their agents.
+type RoleStore interface {
+	ListRoles() ([]Role, error)
+	Permissions(role string) ([]string, error)
+	// UserRole returns sql.ErrNoRows if the user has no assigned role.
+	UserRole(userID int64) (string, error)
+	AssignRole(userID int64, role string, assignedBy int64) error
+}
+
+// Clients and policies carry their custom field values in CustomFields, by field key. Writes
+// save the values of the fields the agent can see (see CustomFieldStore) and ignore other keys.
+
+type ClientStore interface {
+	Create(client Client) (int64, error)
+	// CreateBatch inserts all clients or none, setting their IDs in clients. On failure it returns
+	// the index of the offending client, or -1 when the error is not tied to a single row (e.g.
+	// the commit failed).
+	CreateBatch(clients []Client) (int, error)
+	GetByID(clientID int64, agentUserID int64) (*Client, error)
+	// Update saves client.CustomFields over the stored values, deleting those set to "". Fields
+	// not in the map keep their values, as does last_contacted_at. When version is not 0 the
+	// client is only updated if it is still at that version, else errVersionConflict is returned.
+	// Every update, status change and merge moves the client to the next version.
+	Update(clientID int64, agentUserID int64, client Client, version int64) error
+	SetStatus(clientID int64, agentUserID int64, status string) error
+	// SetAttribution saves client's lead source, campaign and referrer; the UTM parameters keep
+	// the values the client was created with. Returns sql.ErrNoRows if the agent has no such
+	// client outside the trash.
+	SetAttribution(clientID int64, agentUserID int64, client Client) error
+	// List leaves out archived clients unless statusFilter asks for them. customFields narrows it
+	// to the clients with all of the given values, by field key. sortBy is clientSortNewest or
+	// clientSortScore; each client comes with its lead score.
+	List(agentUserID int64, statusFilter, searchTerm string, customFields map[string]string, sortBy string, limit, offset int) ([]Client, error)
+	// ListSummaries returns id, name and status for every client of the agent.
+	ListSummaries(agentUserID int64) ([]Client, error)
+	// ListIDs returns the agent's client IDs ordered by name.
+	ListIDs(agentUserID int64) ([]int64, error)
+	// ListAll returns every client of the agent outside the trash, archived ones included.
+	ListAll(agentUserID int64) ([]Client, error)
+
+	// Merge folds duplicateID into survivor in one transaction: survivor's fields are saved, the
+	// duplicate's policies, communications, tasks, documents, portal tokens and task suggestions
+	// move to it (as do its change history, and its household membership and custom field values
+	// where survivor has none; survivor's own CustomFields are not saved), clients the duplicate
+	// referred are credited to survivor, the duplicate is deleted and merge is recorded with the
+	// number of records moved.
+	// Returns sql.ErrNoRows if either client isn't the agent's, or errDuplicateRecord if the
+	// survivor's new email or phone belongs to another client.
+	Merge(survivor Client, duplicateID int64, merge ClientMerge) (*ClientMerge, error)
+	// ListMerges returns the agent's merges, newest first.
+	ListMerges(agentUserID int64) ([]ClientMerge, error)
+
+	// SoftDelete moves the client to the trash along with its policies, communications, tasks and
+	// documents. Trashed records are left out of every other read.
+	SoftDelete(clientID int64, agentUserID int64, deletedAt time.Time) error
+	// Restore takes a trashed client out of the trash with the records trashed along with it.
+	// Returns sql.ErrNoRows if the agent has no such client in the trash.
+	Restore(clientID int64, agentUserID int64) error
+	// ListDeleted returns the agent's clients trashed since since, most recently deleted first.
+	ListDeleted(agentUserID int64, since time.Time) ([]Client, error)
+	// PurgeDeleted permanently deletes clients trashed before cutoff and everything filed under
+	// them. It returns how many clients went and the stored paths of their documents and policy
+	// documents, for the caller to remove. Clients they referred are left without a referrer.
+	PurgeDeleted(cutoff time.Time) (int, []string, error)
+}
+
+// Creating a policy, communication, task or document returns sql.ErrNoRows unless its client
+// belongs to its agent and is not in the trash.
+
+type PolicyStore interface {
+	Create(policy Policy) (string, error)
+	ListByClient(clientID int64, agentUserID int64) ([]Policy, error)
+	UpcomingRenewals(agentUserID int64, days int) ([]RenewalPolicyView, error)
+	CommissionRecords(agentUserID int64, dateRangeStart, dateRangeEnd string) ([]Policy, error)
+	MonthlyCounts(agentUserID int64, months int) ([]MonthlySalesData, error)
+	// PremiumByClient returns the total premium of the agent's policies outside the trash, by
+	// client ID; clients without policies are left out.
+	PremiumByClient(agentUserID int64) (map[int64]float64, error)
+}
+
+// HouseholdStore holds the families an agent's clients belong to and the members each policy
+// insures. Households, members and insured links are only reachable through the owning agent.
+type HouseholdStore interface {
+	// Create inserts the household with its members, all or none. Returns sql.ErrNoRows if a
+	// member's client isn't the agent's, or errDuplicateRecord if it is already in a household.
+	Create(household Household) (int64, error)
+	GetByID(householdID int64, agentUserID int64) (*Household, error)
+	// GetByClient returns the household the client is a member of, or sql.ErrNoRows.
+	GetByClient(clientID int64, agentUserID int64) (*Household, error)
+	List(agentUserID int64) ([]Household, error)
+	// Delete removes the household with its members; their clients are kept.
+	Delete(householdID int64, agentUserID int64) error
+	// AddMember fails like Create.
+	AddMember(member HouseholdMember, agentUserID int64) (int64, error)
+	RemoveMember(householdID int64, memberID int64, agentUserID int64) error
+	// SetInsuredMembers replaces the members the client's policy insures and returns the ones it
+	// insured before, in ID order. Returns sql.ErrNoRows if the agent has no such policy for the
+	// client, or errNotHouseholdMember if a member is not in the client's household.
+	SetInsuredMembers(policyID string, clientID int64, agentUserID int64, memberIDs []int64) ([]int64, error)
+	// ListPolicies returns the policies held by or insuring the household's members, latest
+	// ending first.
+	ListPolicies(householdID int64, agentUserID int64) ([]HouseholdPolicy, error)
+}
+
+// CustomFieldStore holds custom field definitions. An agent sees the fields they defined and the
+// ones their agency defined; an agency sees its own.
+type CustomFieldStore interface {
+	// ListDefinitions returns the fields of entity the user sees, in the order they were defined.
+	ListDefinitions(userID int64, entity string) ([]CustomFieldDefinition, error)
+	// CreateDefinition returns errDuplicateRecord if the key is taken for the entity among the
+	// fields the owner sees or, for an agency, among its agents' fields.
+	CreateDefinition(def CustomFieldDefinition) (int64, error)
+	// GetDefinition returns sql.ErrNoRows unless ownerUserID defined the field.
+	GetDefinition(fieldID int64, ownerUserID int64) (*CustomFieldDefinition, error)
+	// UpdateDefinition saves the label, options and required flag; the key, entity and type stay.
+	UpdateDefinition(def CustomFieldDefinition) error
+	// DeleteDefinition deletes the field with every value stored for it.
+	DeleteDefinition(fieldID int64, ownerUserID int64) error
+}
+
+// ChangeStore keeps the field-level history of clients, policies and tasks. A client's changes
+// go with it when it is purged.
+type ChangeStore interface {
+	// Record saves changes, all or none.
+	Record(changes []EntityChange) error
+	// ListByClient returns the changes filed under the client, newest first.
+	ListByClient(clientID int64, agentUserID int64) ([]EntityChange, error)
+}
+
+// PipelineStore keeps the pipeline stages agents and agencies configure and the stages clients go
+// through (pipeline.go). A client's stages go with it when it is merged away or purged.
+type PipelineStore interface {
+	// ListStages returns the stages the user configured, by position; none if they haven't.
+	ListStages(ownerUserID int64) ([]PipelineStage, error)
+	// ReplaceStages replaces the user's stages with stages, all or none.
+	ReplaceStages(ownerUserID int64, stages []PipelineStage) error
+	// CurrentStages returns the current stage of each of the agent's clients outside the trash
+	// that has one.
+	CurrentStages(agentUserID int64) ([]ClientStage, error)
+	// MoveClient ends the client's current stage at stage.EnteredAt and starts stage, in one
+	// transaction. A non-empty clientStatus is set on the client as well. Returns sql.ErrNoRows if
+	// the agent has no such client outside the trash.
+	MoveClient(stage ClientStage, clientStatus string) error
+	// ListClientStages returns the stages the client has been in, newest first.
+	ListClientStages(clientID int64, agentUserID int64) ([]ClientStage, error)
+}
+
+// LeadScoreStore keeps the lead scoring weights agents and agencies configure and each client's
+// latest score (lead_scoring.go). A client's score goes with it when it is merged away or purged.
+type LeadScoreStore interface {
+	// GetWeights returns the weights the user configured, or sql.ErrNoRows if they haven't.
+	GetWeights(ownerUserID int64) (LeadScoreWeights, error)
+	// SetWeights replaces the user's weights; nil weights remove them.
+	SetWeights(ownerUserID int64, weights LeadScoreWeights) error
+	// Save replaces the client's score. Returns sql.ErrNoRows if the agent has no such client
+	// outside the trash.
+	Save(score LeadScore) error
+	// Get returns the client's score, or sql.ErrNoRows if it hasn't been scored.
+	Get(clientID int64, agentUserID int64) (*LeadScore, error)
+}
+
+type CommunicationStore interface {
+	Create(comm Communication) (int64, error)
+	ListByClient(clientID int64, agentUserID int64) ([]Communication, error)
+}
+
+type TaskStore interface {
+	Create(task Task) (int64, error)
+	// ListPendingByClient returns the client's open tasks, urgent first.
+	ListPendingByClient(clientID int64, agentUserID int64) ([]Task, error)
+	// ListByClient returns every task for the client, newest first.
+	ListByClient(clientID int64, agentUserID int64) ([]Task, error)
+	// ListPendingByAgent returns the agent's most pressing open tasks (dashboard).
+	ListPendingByAgent(agentUserID int64, limit int) ([]Task, error)
+	// ListByAgent pages through all of the agent's tasks; statusFilter is "pending", "completed" or anything else for all.
+	ListByAgent(agentUserID int64, statusFilter string, page, pageSize int) ([]Task, int, error)
+	// GetByID returns sql.ErrNoRows unless the task is the agent's and not in the trash.
+	GetByID(taskID int64, agentUserID int64) (*Task, error)
+	// UpdateStatus returns sql.ErrNoRows unless the task is the agent's.
+	UpdateStatus(taskID int64, agentUserID int64, completed bool) error
+}
+
+// TaskSuggestionStore holds AI-proposed tasks until the agent accepts or rejects them.
+type TaskSuggestionStore interface {
+	Create(suggestion TaskSuggestion) (int64, error)
+	GetByID(suggestionID int64, agentUserID int64) (*TaskSuggestion, error)
+	// ListByAgent returns the agent's suggestions, newest first. An empty statusFilter or a zero
+	// clientID matches everything.
+	ListByAgent(agentUserID int64, statusFilter string, clientID int64) ([]TaskSuggestion, error)
+	// Accept creates task from a pending suggestion and marks the suggestion accepted, both or
+	// neither. It returns the new task ID, or errSuggestionReviewed if the suggestion is not pending.
+	Accept(suggestionID int64, agentUserID int64, task Task, edited bool, reviewedAt time.Time) (int64, error)
+	// Reject returns errSuggestionReviewed if the suggestion is not pending.
+	Reject(suggestionID int64, agentUserID int64, reason string, reviewedAt time.Time) error
+	// StatsByPromptVersion counts suggestions per prompt version; agentUserID 0 covers every agent.
+	StatsByPromptVersion(agentUserID int64) ([]SuggestionStats, error)
+}
+
+// GoalPlanStore keeps every goal plan an agent generates.
+type GoalPlanStore interface {
+	Create(plan GoalPlan) (int64, error)
+	GetByID(planID int64, agentUserID int64) (*GoalPlan, error)
+	// ListByAgent returns the agent's most recent plans, newest first.
+	ListByAgent(agentUserID int64, limit int) ([]GoalPlan, error)
+}
+
+// AiRecommendationStore keeps generated portal recommendations per client and input hash.
+type AiRecommendationStore interface {
+	// Create returns errDuplicateRecord if the client already has a recommendation for the hash.
+	Create(rec AiRecommendation) (int64, error)
+	GetByID(recommendationID int64, clientID int64, agentUserID int64) (*AiRecommendation, error)
+	GetByInputHash(clientID int64, inputHash string) (*AiRecommendation, error)
+	// ListByClient returns the client's recommendations, newest first.
+	ListByClient(clientID int64, agentUserID int64) ([]AiRecommendation, error)
+	// Published returns the client's pinned recommendation, else the approved one for inputHash.
+	Published(clientID int64, inputHash string) (*AiRecommendation, error)
+	// Review saves the agent's edited text, approval and pin. Pinning unpins the client's others.
+	Review(rec AiRecommendation) error
+}
+
+type DocumentStore interface {
+	Create(doc Document) (int64, error)
+	ListByClient(clientID int64, agentUserID int64) ([]Document, error)
+}
+
+type ActivityStore interface {
+	Log(agentUserID int64, activityType, description, relatedID string) error
+	Recent(agentUserID int64, limit int) ([]ActivityLog, error)
+	List(agentUserID int64, page, pageSize int) ([]ActivityLog, int, error)
+}
+
+// OutboxStore persists outgoing email until the outbox worker has delivered it.
+type OutboxStore interface {
+	Enqueue(email OutboxEmail) (int64, error)
+	// ClaimDue marks up to limit messages due at now as sending, counts the attempt and returns
+	// them. The claim expires after lease so a crashed worker's messages are retried.
+	ClaimDue(now time.Time, lease time.Duration, limit int) ([]OutboxEmail, error)
+	MarkSent(emailID int64, sentAt time.Time) error
+	Reschedule(emailID int64, lastError string, retryAt time.Time) error
+	MarkFailed(emailID int64, lastError string) error
+	GetByID(emailID int64) (*OutboxEmail, error)
+}
+
+type PortalTokenStore interface {
+	Store(token string, clientID int64, agentUserID int64, duration time.Duration) error
+	Verify(token string) (clientID int64, agentUserID int64, err error)
+	// RecordVisit counts a visit to the portal with the token.
+	RecordVisit(token string, at time.Time) error
+	// CountVisits returns how many times the client visited the portal, over all its tokens.
+	CountVisits(clientID int64, agentUserID int64) (int, error)
+}
+
+type AgentStore interface {
+	GetProfile(userID int64) (*AgentProfile, error)
+	UpsertProfile(profile AgentProfile) error
+	GetGoal(userID int64) (*AgentGoal, error)
+	UpsertGoal(goal AgentGoal) error
+}
+
+// InsurerStore covers the agent <-> insurer/product relations and the legacy contact tables.
+type InsurerStore interface {
+	ListRelations(agentUserID int64) ([]AgentInsurerRelation, error)
+	SetRelations(agentUserID int64, relations []AgentInsurerRelation) error
+	GetRelationByInsurer(agentUserID int64, insurerName string) (*AgentInsurerRelation, error)
+	// SearchProducts lists the products an agent sells, filtered like the product catalogue page.
+	SearchProducts(agentUserID int64, categoryFilter, insurerFilter, searchTerm string) ([]AgentInsurerRelation, error)
+	// ListProductNames returns product id/name pairs for the agent's product dropdown.
+	ListProductNames(agentUserID int64) ([]AgentInsurerRelation, error)
+	SetPOCs(agentUserID int64, pocs []AgentInsurerPOC) error
+	SetDetails(agentUserID int64, details []AgentInsurerDetail) error
+}
+
+type ProductStore interface {
+	Create(product Product) error
+	GetByID(productID string) (*Product, error)
+	ListByInsurer(insurerName string) ([]Product, error)
+	ListInsurers() ([]string, error)
+}
+
+type MarketingStore interface {
+	ListCampaigns(agentUserID int64) ([]MarketingCampaign, error)
+	CreateCampaign(campaign MarketingCampaign) (int64, error)
+	ListTemplates() ([]MarketingTemplate, error)
+	ListContent() ([]MarketingContent, error)
+	ListSegments(agentUserID int64) ([]ClientSegment, error)
+	CreateSegment(segment ClientSegment) (int64, error)
+	GetSegment(segmentID int64, agentUserID int64) (*ClientSegment, error)
+	UpdateSegment(segment ClientSegment) error
+}
+
+type NoticeStore interface {
+	List(categoryFilter string) ([]Notice, error)
+}
+
+type MetricsStore interface {
+	Dashboard(agentUserID int64) (*DashboardMetrics, error)
+}
+
+// Stores bundles every store the handlers use.
+type Stores struct {
+	Users           UserStore
+	Tokens          TokenStore
+	Sessions        SessionStore
+	TwoFactor       TwoFactorStore
+	Agencies        AgencyStore
+	Roles           RoleStore
+	Clients         ClientStore
+	Policies        PolicyStore
+	Households      HouseholdStore
+	CustomFields    CustomFieldStore
+	Changes         ChangeStore
+	Pipeline        PipelineStore
+	LeadScores      LeadScoreStore
+	Communications  CommunicationStore
+	Tasks           TaskStore
+	Suggestions     TaskSuggestionStore
+	GoalPlans       GoalPlanStore
+	Recommendations AiRecommendationStore
+	Documents       DocumentStore
+	Activity        ActivityStore
+	Outbox          OutboxStore
+	PortalTokens    PortalTokenStore
+	Agents          AgentStore
+	Insurers        InsurerStore
+	Products        ProductStore
+	Marketing       MarketingStore
+	Notices         NoticeStore
+	Metrics         MetricsStore
+}
+
+var stores Stores
@@ -0,0 +1,2807 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- In-Memory Stores ---
+// A single mutex-guarded memoryDB backs every store so the handler tests can exercise
+// the router without a database server. Ordering and filtering mirror the SQL in store_sql.go.
+
+type memoryToken struct {
+	UserID    int64
+	Selector  string
+	TokenHash string
+	Purpose   string
+	ExpiresAt time.Time
+}
+
+type memoryDB struct {
+	mu     sync.Mutex
+	nextID int64
+
+	users            []User
+	tokens           []memoryToken
+	sessions         []Session
+	twoFactor        map[int64]TwoFactor
+	recoveryCodes    []memoryRecoveryCode
+	agencyMembers    map[int64]AgencyMember // By agent user ID
+	agencySettings   map[int64]AgencySettings
+	invitations      []AgencyInvitation
+	userRoles        map[int64]string // Assigned roles by user ID
+	clients          []Client
+	merges           []ClientMerge
+	policies         []Policy
+	households       []Household // Members are kept in householdMembers
+	householdMembers []HouseholdMember
+	insuredMembers   []memoryInsuredMember
+	customFields     []CustomFieldDefinition
+	customValues     []memoryCustomFieldValue // Records' CustomFields are filled in from these on read
+	changes          []EntityChange
+	pipelineStages   []PipelineStage
+	clientStages     []ClientStage
+	leadWeights      map[int64]LeadScoreWeights // By owner user ID
+	leadScores       map[int64]LeadScore        // By client ID
+	communications   []Communication
+	tasks            []Task
+	suggestions      []TaskSuggestion
+	goalPlans        []GoalPlan
+	recommendations  []AiRecommendation
+	documents        []Document
+	activity         []ActivityLog
+	outbox           []OutboxEmail
+	portalTokens     []ClientPortalToken
+	profiles         map[int64]AgentProfile
+	goals            map[int64]AgentGoal
+	relations        []AgentInsurerRelation
+	pocs             []AgentInsurerPOC
+	details          []AgentInsurerDetail
+	products         []Product
+	campaigns        []MarketingCampaign
+	templates        []MarketingTemplate
+	content          []MarketingContent
+	segments         []ClientSegment
+	notices          []Notice
+}
+
+// newMemoryStores returns a fresh, empty set of in-memory stores.
+func newMemoryStores() Stores {
+	m := &memoryDB{profiles: map[int64]AgentProfile{}, goals: map[int64]AgentGoal{}, twoFactor: map[int64]TwoFactor{},
+		agencyMembers: map[int64]AgencyMember{}, agencySettings: map[int64]AgencySettings{}, userRoles: map[int64]string{},
+		leadWeights: map[int64]LeadScoreWeights{}, leadScores: map[int64]LeadScore{}}
+	return Stores{
+		Users:           &memoryUserStore{m},
+		Tokens:          &memoryTokenStore{m},
+		Sessions:        &memorySessionStore{m},
+		TwoFactor:       &memoryTwoFactorStore{m},
+		Agencies:        &memoryAgencyStore{m},
+		Roles:           &memoryRoleStore{m},
+		Clients:         &memoryClientStore{m},
+		Policies:        &memoryPolicyStore{m},
+		Households:      &memoryHouseholdStore{m},
+		CustomFields:    &memoryCustomFieldStore{m},
+		Changes:         &memoryChangeStore{m},
+		Pipeline:        &memoryPipelineStore{m},
+		LeadScores:      &memoryLeadScoreStore{m},
+		Communications:  &memoryCommunicationStore{m},
+		Tasks:           &memoryTaskStore{m},
+		Suggestions:     &memoryTaskSuggestionStore{m},
+		GoalPlans:       &memoryGoalPlanStore{m},
+		Recommendations: &memoryAiRecommendationStore{m},
+		Documents:       &memoryDocumentStore{m},
+		Activity:        &memoryActivityStore{m},
+		Outbox:          &memoryOutboxStore{m},
+		PortalTokens:    &memoryPortalTokenStore{m},
+		Agents:          &memoryAgentStore{m},
+		Insurers:        &memoryInsurerStore{m},
+		Products:        &memoryProductStore{m},
+		Marketing:       &memoryMarketingStore{m},
+		Notices:         &memoryNoticeStore{m},
+		Metrics:         &memoryMetricsStore{m},
+	}
+}
+
+// newID hands out auto-increment IDs; callers must hold m.mu.
+func (m *memoryDB) newID() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+// paginate applies LIMIT/OFFSET semantics to n items and returns the slice bounds.
+func paginate(n, limit, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > n {
+		offset = n
+	}
+	end := offset + limit
+	if limit < 0 || end > n {
+		end = n
+	}
+	return offset, end
+}
+
+// --- Users & Tokens ---
+
+type memoryUserStore struct{ m *memoryDB }
+
+func (s *memoryUserStore) Create(user User) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, u := range s.m.users {
+		if u.Email == user.Email {
+			return 0, errDuplicateRecord
+		}
+	}
+	user.ID = s.m.newID()
+	user.CreatedAt = time.Now()
+	s.m.users = append(s.m.users, user)
+	return user.ID, nil
+}
+
+func (s *memoryUserStore) GetByEmail(email string) (*User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, u := range s.m.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryUserStore) GetByID(userID int64) (*User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, u := range s.m.users {
+		if u.ID == userID {
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryUserStore) MarkVerified(userID int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.users {
+		if s.m.users[i].ID == userID {
+			s.m.users[i].IsVerified = true
+		}
+	}
+	return nil
+}
+
+func (s *memoryUserStore) UpdatePassword(userID int64, newPasswordHash string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.users {
+		if s.m.users[i].ID == userID {
+			s.m.users[i].PasswordHash = newPasswordHash
+		}
+	}
+	return nil
+}
+
+func (s *memoryUserStore) RecordFailedLogin(userID int64) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.users {
+		if s.m.users[i].ID == userID {
+			s.m.users[i].FailedLoginAttempts++
+			return s.m.users[i].FailedLoginAttempts, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (s *memoryUserStore) LockUntil(userID int64, until time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.users {
+		if s.m.users[i].ID == userID {
+			s.m.users[i].LockedUntil = sql.NullTime{Time: until, Valid: true}
+		}
+	}
+	return nil
+}
+
+func (s *memoryUserStore) ResetFailedLogins(userID int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.users {
+		if s.m.users[i].ID == userID {
+			s.m.users[i].FailedLoginAttempts, s.m.users[i].LockedUntil = 0, sql.NullTime{}
+		}
+	}
+	return nil
+}
+
+type memoryTokenStore struct{ m *memoryDB }
+
+func (s *memoryTokenStore) Store(userID int64, token string, purpose string, duration time.Duration) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	selector, verifierHash, ok := splitSelectorToken(token)
+	if !ok {
+		return fmt.Errorf("malformed %s token", purpose)
+	}
+	t := memoryToken{UserID: userID, Selector: selector, TokenHash: verifierHash, Purpose: purpose, ExpiresAt: time.Now().Add(duration)}
+	for i := range s.m.tokens {
+		if s.m.tokens[i].UserID == userID && s.m.tokens[i].Purpose == purpose {
+			s.m.tokens[i] = t // (user_id, purpose) is the primary key
+			return nil
+		}
+	}
+	s.m.tokens = append(s.m.tokens, t)
+	return nil
+}
+
+func (s *memoryTokenStore) Verify(token string, purpose string) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	selector, verifierHash, ok := splitSelectorToken(token)
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	now := time.Now()
+	for _, t := range s.m.tokens {
+		if t.Selector == selector && t.Purpose == purpose && t.ExpiresAt.After(now) && verifierMatches(verifierHash, t.TokenHash) {
+			return t.UserID, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (s *memoryTokenStore) DeleteByUserID(userID int64, purpose string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	kept := s.m.tokens[:0]
+	for _, t := range s.m.tokens {
+		if !(t.UserID == userID && t.Purpose == purpose) {
+			kept = append(kept, t)
+		}
+	}
+	s.m.tokens = kept
+	return nil
+}
+
+// --- Clients ---
+
+type memorySessionStore struct{ m *memoryDB }
+
+func (s *memorySessionStore) Create(session Session) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	session.ID = s.m.newID()
+	session.CreatedAt = time.Now()
+	s.m.sessions = append(s.m.sessions, session)
+	return session.ID, nil
+}
+
+func (s *memorySessionStore) GetByID(sessionID int64) (*Session, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, sess := range s.m.sessions {
+		if sess.ID == sessionID {
+			return &sess, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memorySessionStore) Rotate(oldHash, newHash string, now, expiresAt time.Time, userAgent, ipAddress string) (*Session, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.sessions {
+		sess := &s.m.sessions[i]
+		if sess.RefreshTokenHash == oldHash && !sess.RevokedAt.Valid && sess.ExpiresAt.After(now) {
+			sess.RefreshTokenHash, sess.PreviousTokenHash = newHash, sql.NullString{String: oldHash, Valid: true}
+			sess.LastUsedAt, sess.ExpiresAt = now, expiresAt
+			sess.UserAgent = sql.NullString{String: userAgent, Valid: userAgent != ""}
+			sess.IPAddress = sql.NullString{String: ipAddress, Valid: ipAddress != ""}
+			rotated := *sess
+			return &rotated, nil
+		}
+	}
+	for i := range s.m.sessions {
+		sess := &s.m.sessions[i]
+		if sess.PreviousTokenHash.Valid && sess.PreviousTokenHash.String == oldHash && !sess.RevokedAt.Valid {
+			sess.RevokedAt = sql.NullTime{Time: now, Valid: true}
+			return nil, errRefreshTokenReused
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memorySessionStore) ListActive(userID int64, now time.Time) ([]Session, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	sessions := []Session{}
+	for _, sess := range s.m.sessions {
+		if sess.UserID == userID && !sess.RevokedAt.Valid && sess.ExpiresAt.After(now) {
+			sessions = append(sessions, sess)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+	return sessions, nil
+}
+
+func (s *memorySessionStore) Revoke(sessionID int64, userID int64, now time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.sessions {
+		sess := &s.m.sessions[i]
+		if sess.ID == sessionID && sess.UserID == userID && !sess.RevokedAt.Valid {
+			sess.RevokedAt = sql.NullTime{Time: now, Valid: true}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memorySessionStore) RevokeAll(userID int64, exceptSessionID int64, now time.Time) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	revoked := 0
+	for i := range s.m.sessions {
+		sess := &s.m.sessions[i]
+		if sess.UserID == userID && sess.ID != exceptSessionID && !sess.RevokedAt.Valid {
+			sess.RevokedAt = sql.NullTime{Time: now, Valid: true}
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+type memoryRecoveryCode struct {
+	userID   int64
+	codeHash string
+	used     bool
+}
+
+type memoryTwoFactorStore struct{ m *memoryDB }
+
+func (s *memoryTwoFactorStore) Get(userID int64) (*TwoFactor, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	tf, ok := s.m.twoFactor[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &tf, nil
+}
+
+func (s *memoryTwoFactorStore) SavePending(userID int64, secret string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.twoFactor[userID] = TwoFactor{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+	return nil
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes; callers must hold m.mu.
+func (m *memoryDB) replaceRecoveryCodes(userID int64, codeHashes []string) {
+	kept := m.recoveryCodes[:0]
+	for _, code := range m.recoveryCodes {
+		if code.userID != userID {
+			kept = append(kept, code)
+		}
+	}
+	for _, hash := range codeHashes {
+		kept = append(kept, memoryRecoveryCode{userID: userID, codeHash: hash})
+	}
+	m.recoveryCodes = kept
+}
+
+func (s *memoryTwoFactorStore) Enable(userID int64, step int64, recoveryCodeHashes []string, now time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	tf, ok := s.m.twoFactor[userID]
+	if !ok || tf.IsEnabled {
+		return sql.ErrNoRows
+	}
+	tf.IsEnabled, tf.EnabledAt, tf.LastUsedStep = true, sql.NullTime{Time: now, Valid: true}, step
+	s.m.twoFactor[userID] = tf
+	s.m.replaceRecoveryCodes(userID, recoveryCodeHashes)
+	return nil
+}
+
+func (s *memoryTwoFactorStore) Disable(userID int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	delete(s.m.twoFactor, userID)
+	s.m.replaceRecoveryCodes(userID, nil)
+	return nil
+}
+
+func (s *memoryTwoFactorStore) UseStep(userID int64, step int64) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	tf, ok := s.m.twoFactor[userID]
+	if !ok || tf.LastUsedStep >= step {
+		return false, nil
+	}
+	tf.LastUsedStep = step
+	s.m.twoFactor[userID] = tf
+	return true, nil
+}
+
+func (s *memoryTwoFactorStore) UseRecoveryCode(userID int64, codeHash string, now time.Time) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.recoveryCodes {
+		code := &s.m.recoveryCodes[i]
+		if code.userID == userID && code.codeHash == codeHash && !code.used {
+			code.used = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryTwoFactorStore) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+func (s *memoryTwoFactorStore) RecoveryCodesRemaining(userID int64) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	n := 0
+	for _, code := range s.m.recoveryCodes {
+		if code.userID == userID && !code.used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+type memoryAgencyStore struct{ m *memoryDB }
+
+func (s *memoryAgencyStore) GetSettings(agencyUserID int64) (*AgencySettings, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	settings, ok := s.m.agencySettings[agencyUserID]
+	if !ok {
+		settings = AgencySettings{AgencyUserID: agencyUserID, DeparturePolicy: departurePolicyKeep}
+	}
+	return &settings, nil
+}
+
+func (s *memoryAgencyStore) UpdateSettings(settings AgencySettings) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	settings.UpdatedAt = time.Now()
+	s.m.agencySettings[settings.AgencyUserID] = settings
+	return nil
+}
+
+func (s *memoryAgencyStore) AddMember(agencyUserID, agentUserID int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.agencyMembers[agentUserID] = AgencyMember{AgentUserID: agentUserID, AgencyUserID: agencyUserID, JoinedAt: time.Now()}
+	return nil
+}
+
+// userEmail returns the email of a user; callers must hold m.mu.
+func (m *memoryDB) userEmail(userID int64) string {
+	for _, u := range m.users {
+		if u.ID == userID {
+			return u.Email
+		}
+	}
+	return ""
+}
+
+// memberRole returns the role of a member agent; callers must hold m.mu.
+func (m *memoryDB) memberRole(agentUserID int64) string {
+	if role, ok := m.userRoles[agentUserID]; ok {
+		return role
+	}
+	return roleAgent
+}
+
+func (s *memoryAgencyStore) AgencyOf(agentUserID int64) (*AgencyMember, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	member, ok := s.m.agencyMembers[agentUserID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	member.Email, member.Role = s.m.userEmail(agentUserID), s.m.memberRole(agentUserID)
+	return &member, nil
+}
+
+func (s *memoryAgencyStore) ListMembers(agencyUserID int64) ([]AgencyMember, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	members := []AgencyMember{}
+	for _, member := range s.m.agencyMembers {
+		if member.AgencyUserID == agencyUserID {
+			member.Email, member.Role = s.m.userEmail(member.AgentUserID), s.m.memberRole(member.AgentUserID)
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Email < members[j].Email })
+	return members, nil
+}
+
+func (s *memoryAgencyStore) RemoveMember(agencyUserID, agentUserID, transferTo int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	member, ok := s.m.agencyMembers[agentUserID]
+	if !ok || member.AgencyUserID != agencyUserID {
+		return sql.ErrNoRows
+	}
+	if transferTo != 0 {
+		for _, c := range s.m.clients {
+			if c.AgentUserID != agentUserID {
+				continue
+			}
+			moved := c
+			moved.ID, moved.AgentUserID = 0, transferTo
+			if clientConflicts(s.m.clients, moved) {
+				return errDuplicateRecord
+			}
+		}
+		for i := range s.m.clients {
+			if s.m.clients[i].AgentUserID == agentUserID {
+				s.m.clients[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.policies {
+			if s.m.policies[i].AgentUserID == agentUserID {
+				s.m.policies[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.communications {
+			if s.m.communications[i].AgentUserID == agentUserID {
+				s.m.communications[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.tasks {
+			if s.m.tasks[i].AgentUserID == agentUserID {
+				s.m.tasks[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.documents {
+			if s.m.documents[i].AgentUserID == agentUserID {
+				s.m.documents[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.portalTokens {
+			if s.m.portalTokens[i].AgentUserID == agentUserID {
+				s.m.portalTokens[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.suggestions {
+			if s.m.suggestions[i].AgentUserID == agentUserID {
+				s.m.suggestions[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.recommendations {
+			if s.m.recommendations[i].AgentUserID == agentUserID {
+				s.m.recommendations[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.households {
+			if s.m.households[i].AgentUserID == agentUserID {
+				s.m.households[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.changes {
+			if s.m.changes[i].AgentUserID == agentUserID {
+				s.m.changes[i].AgentUserID = transferTo
+			}
+		}
+		for i := range s.m.clientStages {
+			if s.m.clientStages[i].AgentUserID == agentUserID {
+				s.m.clientStages[i].AgentUserID = transferTo
+			}
+		}
+		for id, score := range s.m.leadScores {
+			if score.AgentUserID == agentUserID {
+				score.AgentUserID = transferTo
+				s.m.leadScores[id] = score
+			}
+		}
+	}
+	delete(s.m.agencyMembers, agentUserID)
+	delete(s.m.userRoles, agentUserID)
+	return nil
+}
+
+func (s *memoryAgencyStore) RequiresTwoFactor(agentUserID int64) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	member, ok := s.m.agencyMembers[agentUserID]
+	if !ok {
+		return false, nil
+	}
+	return s.m.agencySettings[member.AgencyUserID].RequireTwoFactor, nil
+}
+
+func (s *memoryAgencyStore) CreateInvitation(invitation AgencyInvitation) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	now := time.Now()
+	for _, inv := range s.m.invitations {
+		if inv.AgencyUserID == invitation.AgencyUserID && inv.Email == invitation.Email && inv.Status == invitationPending && inv.ExpiresAt.After(now) {
+			return 0, errDuplicateRecord
+		}
+	}
+	invitation.ID = s.m.newID()
+	invitation.Status = invitationPending
+	invitation.CreatedAt = now
+	s.m.invitations = append(s.m.invitations, invitation)
+	return invitation.ID, nil
+}
+
+func (s *memoryAgencyStore) ListInvitations(agencyUserID int64) ([]AgencyInvitation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	invitations := []AgencyInvitation{}
+	for i := len(s.m.invitations) - 1; i >= 0; i-- {
+		if s.m.invitations[i].AgencyUserID == agencyUserID {
+			invitations = append(invitations, s.m.invitations[i])
+		}
+	}
+	return invitations, nil
+}
+
+func (s *memoryAgencyStore) ListInvitationsForEmail(email string, now time.Time) ([]AgencyInvitation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	invitations := []AgencyInvitation{}
+	for i := len(s.m.invitations) - 1; i >= 0; i-- {
+		inv := s.m.invitations[i]
+		if inv.Email == email && inv.Status == invitationPending && inv.ExpiresAt.After(now) {
+			inv.AgencyEmail = s.m.userEmail(inv.AgencyUserID)
+			invitations = append(invitations, inv)
+		}
+	}
+	return invitations, nil
+}
+
+func (s *memoryAgencyStore) RevokeInvitation(invitationID, agencyUserID int64, now time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.invitations {
+		inv := &s.m.invitations[i]
+		if inv.ID == invitationID && inv.AgencyUserID == agencyUserID && inv.Status == invitationPending {
+			inv.Status, inv.RespondedAt = invitationRevoked, sql.NullTime{Time: now, Valid: true}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memoryAgencyStore) RespondToInvitation(invitationID int64, email string, agentUserID int64, accept bool, now time.Time) (*AgencyInvitation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.invitations {
+		inv := &s.m.invitations[i]
+		if inv.ID != invitationID || inv.Email != email || inv.Status != invitationPending || !inv.ExpiresAt.After(now) {
+			continue
+		}
+		if accept {
+			if _, member := s.m.agencyMembers[agentUserID]; member {
+				return nil, errAgencyMembership
+			}
+			s.m.agencyMembers[agentUserID] = AgencyMember{AgentUserID: agentUserID, AgencyUserID: inv.AgencyUserID, JoinedAt: now}
+			inv.Status = invitationAccepted
+		} else {
+			inv.Status = invitationDeclined
+		}
+		inv.RespondedAt = sql.NullTime{Time: now, Valid: true}
+		responded := *inv
+		return &responded, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// memoryRoleStore serves the built-in role definitions, as seeded by the roles migration.
+type memoryRoleStore struct{ m *memoryDB }
+
+func (s *memoryRoleStore) ListRoles() ([]Role, error) {
+	roles := []Role{}
+	for _, role := range builtinRoles {
+		role.Permissions = append([]string(nil), role.Permissions...)
+		sort.Strings(role.Permissions)
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (s *memoryRoleStore) Permissions(role string) ([]string, error) {
+	for _, r := range builtinRoles {
+		if r.Name == role {
+			permissions := append([]string(nil), r.Permissions...)
+			sort.Strings(permissions)
+			return permissions, nil
+		}
+	}
+	return []string{}, nil
+}
+
+func (s *memoryRoleStore) UserRole(userID int64) (string, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	role, ok := s.m.userRoles[userID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return role, nil
+}
+
+func (s *memoryRoleStore) AssignRole(userID int64, role string, assignedBy int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.userRoles[userID] = role
+	return nil
+}
+
+// ownsClient reports whether the client belongs to the agent and is not in the trash; callers
+// must hold m.mu.
+func (m *memoryDB) ownsClient(clientID, agentUserID int64) bool {
+	for _, c := range m.clients {
+		if c.ID == clientID && c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryClientStore struct{ m *memoryDB }
+
+// clientConflicts reports whether c would violate UNIQUE(agent_user_id, email) or UNIQUE(agent_user_id, phone).
+func clientConflicts(existing []Client, c Client) bool {
+	for _, e := range existing {
+		if e.ID == c.ID || e.AgentUserID != c.AgentUserID {
+			continue
+		}
+		if c.Email.Valid && e.Email.Valid && e.Email.String == c.Email.String {
+			return true
+		}
+		if c.Phone.Valid && e.Phone.Valid && e.Phone.String == c.Phone.String {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryClientStore) Create(client Client) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	client.ID = 0
+	if clientConflicts(s.m.clients, client) {
+		return 0, errDuplicateRecord
+	}
+	client.ID = s.m.newID()
+	client.CreatedAt, client.Version = time.Now(), 1
+	s.m.saveCustomFieldValues(customFieldEntityClient, fmt.Sprint(client.ID), client.AgentUserID, client.CustomFields)
+	client.CustomFields = nil
+	s.m.clients = append(s.m.clients, client)
+	return client.ID, nil
+}
+
+func (s *memoryClientStore) CreateBatch(clients []Client) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	pending := append([]Client{}, s.m.clients...)
+	for i, c := range clients {
+		c.ID = 0
+		if clientConflicts(pending, c) {
+			return i, errDuplicateRecord
+		}
+		c.ID = -int64(i + 1) // placeholder so later rows in the batch are checked against it
+		pending = append(pending, c)
+	}
+	for i, c := range clients {
+		c.ID, c.Version = s.m.newID(), 1
+		clients[i].ID = c.ID
+		s.m.saveCustomFieldValues(customFieldEntityClient, fmt.Sprint(c.ID), c.AgentUserID, c.CustomFields)
+		c.CustomFields = nil
+		s.m.clients = append(s.m.clients, c)
+	}
+	return 0, nil
+}
+
+func (s *memoryClientStore) GetByID(clientID int64, agentUserID int64) (*Client, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, c := range s.m.clients {
+		if c.ID == clientID && c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			c.CustomFields = s.m.recordCustomFields(customFieldEntityClient, fmt.Sprint(c.ID))
+			return &c, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryClientStore) Update(clientID int64, agentUserID int64, client Client, version int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, c := range s.m.clients {
+		if c.ID != clientID || c.AgentUserID != agentUserID || c.DeletedAt.Valid {
+			continue
+		}
+		if version != 0 && c.Version != version {
+			return errVersionConflict
+		}
+		client.ID, client.AgentUserID, client.CreatedAt, client.LastContactedAt = c.ID, c.AgentUserID, c.CreatedAt, c.LastContactedAt
+		client.LeadSource, client.Campaign, client.ReferredByClientID = c.LeadSource, c.Campaign, c.ReferredByClientID
+		client.UTMSource, client.UTMMedium, client.UTMTerm, client.UTMContent = c.UTMSource, c.UTMMedium, c.UTMTerm, c.UTMContent
+		if clientConflicts(s.m.clients, client) {
+			return errDuplicateRecord
+		}
+		client.Version, client.UpdatedAt = c.Version+1, sql.NullTime{Time: time.Now(), Valid: true}
+		s.m.saveCustomFieldValues(customFieldEntityClient, fmt.Sprint(clientID), agentUserID, client.CustomFields)
+		client.CustomFields = nil
+		s.m.clients[i] = client
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memoryClientStore) List(agentUserID int64, statusFilter, searchTerm string, customFields map[string]string, sortBy string, limit, offset int) ([]Client, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	term := strings.ToLower(searchTerm)
+	clients := []Client{}
+	for _, c := range s.m.clients {
+		if c.AgentUserID != agentUserID || c.DeletedAt.Valid {
+			continue
+		}
+		if statusFilter != "" && statusFilter != "All Statuses" {
+			if c.Status != statusFilter {
+				continue
+			}
+		} else if c.Status == clientStatusArchived {
+			continue
+		}
+		if term != "" && !strings.Contains(strings.ToLower(c.Name), term) &&
+			!strings.Contains(strings.ToLower(c.Email.String), term) &&
+			!strings.Contains(strings.ToLower(c.Phone.String), term) {
+			continue
+		}
+		c.CustomFields = s.m.recordCustomFields(customFieldEntityClient, fmt.Sprint(c.ID))
+		matches := true
+		for key, value := range customFields {
+			matches = matches && c.CustomFields[key] == value
+		}
+		if !matches {
+			continue
+		}
+		if score, ok := s.m.leadScores[c.ID]; ok {
+			c.LeadScore = sql.NullInt64{Int64: int64(score.Score), Valid: true}
+		}
+		clients = append(clients, c)
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		if sortBy == clientSortScore && clients[i].LeadScore != clients[j].LeadScore {
+			if clients[i].LeadScore.Valid != clients[j].LeadScore.Valid {
+				return clients[i].LeadScore.Valid // Unscored clients last
+			}
+			return clients[i].LeadScore.Int64 > clients[j].LeadScore.Int64
+		}
+		if !clients[i].CreatedAt.Equal(clients[j].CreatedAt) {
+			return clients[i].CreatedAt.After(clients[j].CreatedAt)
+		}
+		return clients[i].ID > clients[j].ID
+	})
+	start, end := paginate(len(clients), limit, offset)
+	return clients[start:end], nil
+}
+
+func (s *memoryClientStore) ListSummaries(agentUserID int64) ([]Client, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var clients []Client
+	for _, c := range s.m.clients {
+		if c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			clients = append(clients, Client{ID: c.ID, Name: c.Name, Status: c.Status, AgentUserID: c.AgentUserID})
+		}
+	}
+	return clients, nil
+}
+
+func (s *memoryClientStore) ListIDs(agentUserID int64) ([]int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var clients []Client
+	for _, c := range s.m.clients {
+		if c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			clients = append(clients, c)
+		}
+	}
+	sort.SliceStable(clients, func(i, j int) bool { return clients[i].Name < clients[j].Name })
+	ids := []int64{}
+	for _, c := range clients {
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
+}
+
+func (s *memoryClientStore) ListAll(agentUserID int64) ([]Client, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	clients := []Client{}
+	for _, c := range s.m.clients {
+		if c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			c.CustomFields = s.m.recordCustomFields(customFieldEntityClient, fmt.Sprint(c.ID))
+			clients = append(clients, c)
+		}
+	}
+	return clients, nil
+}
+
+func (s *memoryClientStore) Merge(survivor Client, duplicateID int64, merge ClientMerge) (*ClientMerge, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.ownsClient(duplicateID, survivor.AgentUserID) || !s.m.ownsClient(survivor.ID, survivor.AgentUserID) {
+		return nil, sql.ErrNoRows
+	}
+	remaining := slices.DeleteFunc(slices.Clone(s.m.clients), func(c Client) bool { return c.ID == duplicateID })
+	if clientConflicts(remaining, survivor) {
+		return nil, errDuplicateRecord
+	}
+	s.m.clients = remaining
+	survivor.CustomFields = nil
+	if survivor.ReferredByClientID.Int64 == duplicateID {
+		survivor.ReferredByClientID = sql.NullInt64{}
+	}
+	for i, c := range s.m.clients {
+		if c.ReferredByClientID.Valid && c.ReferredByClientID.Int64 == duplicateID {
+			s.m.clients[i].ReferredByClientID.Int64 = survivor.ID
+		}
+	}
+	for i, c := range s.m.clients {
+		if c.ID == survivor.ID {
+			survivor.Version, survivor.UpdatedAt = c.Version+1, sql.NullTime{Time: time.Now(), Valid: true}
+			s.m.clients[i] = survivor
+		}
+	}
+	merge.RecordsMoved = 0
+	move := func(clientID *int64) {
+		if *clientID == duplicateID {
+			*clientID = survivor.ID
+			merge.RecordsMoved++
+		}
+	}
+	for i := range s.m.policies {
+		move(&s.m.policies[i].ClientID)
+	}
+	for i := range s.m.communications {
+		move(&s.m.communications[i].ClientID)
+	}
+	for i := range s.m.tasks {
+		move(&s.m.tasks[i].ClientID)
+	}
+	for i := range s.m.documents {
+		move(&s.m.documents[i].ClientID)
+	}
+	for i := range s.m.portalTokens {
+		move(&s.m.portalTokens[i].ClientID)
+	}
+	for i := range s.m.suggestions {
+		move(&s.m.suggestions[i].ClientID)
+	}
+	if !slices.ContainsFunc(s.m.householdMembers, func(member HouseholdMember) bool {
+		return member.ClientID.Valid && member.ClientID.Int64 == survivor.ID
+	}) {
+		for i := range s.m.householdMembers {
+			if member := &s.m.householdMembers[i]; member.ClientID.Valid && member.ClientID.Int64 == duplicateID {
+				member.ClientID.Int64 = survivor.ID
+			}
+		}
+	}
+	s.m.unlinkHouseholdMembers(func(clientID int64) bool { return clientID == duplicateID })
+	for i := range s.m.changes {
+		if s.m.changes[i].ClientID == duplicateID {
+			s.m.changes[i].ClientID = survivor.ID
+		}
+	}
+	taken := s.m.recordCustomFields(customFieldEntityClient, fmt.Sprint(survivor.ID))
+	for i, value := range s.m.customValues {
+		if def := s.m.customField(value.FieldID); def.Entity == customFieldEntityClient && value.Record == fmt.Sprint(duplicateID) {
+			if _, ok := taken[def.Key]; !ok {
+				s.m.customValues[i].Record = fmt.Sprint(survivor.ID)
+			}
+		}
+	}
+	s.m.pruneCustomFieldValues()
+	s.m.recommendations = slices.DeleteFunc(s.m.recommendations, func(rec AiRecommendation) bool { return rec.ClientID == duplicateID })
+	s.m.clientStages = slices.DeleteFunc(s.m.clientStages, func(st ClientStage) bool { return st.ClientID == duplicateID })
+	delete(s.m.leadScores, duplicateID)
+	merge.ID = s.m.newID()
+	s.m.merges = append(s.m.merges, merge)
+	return &merge, nil
+}
+
+func (s *memoryClientStore) ListMerges(agentUserID int64) ([]ClientMerge, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	merges := []ClientMerge{}
+	for i := len(s.m.merges) - 1; i >= 0; i-- { // Newest first
+		if s.m.merges[i].AgentUserID == agentUserID {
+			merges = append(merges, s.m.merges[i])
+		}
+	}
+	return merges, nil
+}
+
+func (s *memoryClientStore) SetStatus(clientID int64, agentUserID int64, status string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, c := range s.m.clients {
+		if c.ID == clientID && c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			s.m.clients[i].Status = status
+			s.m.clients[i].Version, s.m.clients[i].UpdatedAt = c.Version+1, sql.NullTime{Time: time.Now(), Valid: true}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memoryClientStore) SetAttribution(clientID int64, agentUserID int64, client Client) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, c := range s.m.clients {
+		if c.ID == clientID && c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			c.LeadSource, c.Campaign, c.ReferredByClientID = client.LeadSource, client.Campaign, client.ReferredByClientID
+			c.Version, c.UpdatedAt = c.Version+1, sql.NullTime{Time: time.Now(), Valid: true}
+			s.m.clients[i] = c
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// setClientDeletedAt stamps (or, with a zero value, clears) deleted_at on the client's records
+// whose deleted_at is currently was; callers must hold m.mu.
+func (m *memoryDB) setClientDeletedAt(clientID int64, was, to sql.NullTime) {
+	for i := range m.policies {
+		if p := &m.policies[i]; p.ClientID == clientID && p.DeletedAt == was {
+			p.DeletedAt = to
+		}
+	}
+	for i := range m.communications {
+		if c := &m.communications[i]; c.ClientID == clientID && c.DeletedAt == was {
+			c.DeletedAt = to
+		}
+	}
+	for i := range m.tasks {
+		if t := &m.tasks[i]; t.ClientID == clientID && t.DeletedAt == was {
+			t.DeletedAt = to
+		}
+	}
+	for i := range m.documents {
+		if d := &m.documents[i]; d.ClientID == clientID && d.DeletedAt == was {
+			d.DeletedAt = to
+		}
+	}
+}
+
+func (s *memoryClientStore) SoftDelete(clientID int64, agentUserID int64, deletedAt time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, c := range s.m.clients {
+		if c.ID == clientID && c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			s.m.clients[i].DeletedAt = sql.NullTime{Time: deletedAt, Valid: true}
+			s.m.setClientDeletedAt(clientID, sql.NullTime{}, s.m.clients[i].DeletedAt)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memoryClientStore) Restore(clientID int64, agentUserID int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, c := range s.m.clients {
+		if c.ID == clientID && c.AgentUserID == agentUserID && c.DeletedAt.Valid {
+			s.m.setClientDeletedAt(clientID, c.DeletedAt, sql.NullTime{})
+			s.m.clients[i].DeletedAt = sql.NullTime{}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memoryClientStore) ListDeleted(agentUserID int64, since time.Time) ([]Client, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	clients := []Client{}
+	for _, c := range s.m.clients {
+		if c.AgentUserID == agentUserID && c.DeletedAt.Valid && !c.DeletedAt.Time.Before(since) {
+			clients = append(clients, c)
+		}
+	}
+	sort.SliceStable(clients, func(i, j int) bool { return clients[i].DeletedAt.Time.After(clients[j].DeletedAt.Time) })
+	return clients, nil
+}
+
+func (s *memoryClientStore) PurgeDeleted(cutoff time.Time) (int, []string, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	expired := map[int64]bool{}
+	for _, c := range s.m.clients {
+		if c.DeletedAt.Valid && c.DeletedAt.Time.Before(cutoff) {
+			expired[c.ID] = true
+		}
+	}
+	var files []string
+	for _, d := range s.m.documents {
+		if expired[d.ClientID] {
+			files = append(files, d.FileURL)
+		}
+	}
+	for _, p := range s.m.policies {
+		if expired[p.ClientID] && p.PolicyDocURL.Valid {
+			files = append(files, p.PolicyDocURL.String)
+		}
+	}
+	s.m.clients = slices.DeleteFunc(s.m.clients, func(c Client) bool { return expired[c.ID] })
+	for i, c := range s.m.clients {
+		if c.ReferredByClientID.Valid && expired[c.ReferredByClientID.Int64] {
+			s.m.clients[i].ReferredByClientID = sql.NullInt64{}
+		}
+	}
+	s.m.policies = slices.DeleteFunc(s.m.policies, func(p Policy) bool { return expired[p.ClientID] })
+	s.m.communications = slices.DeleteFunc(s.m.communications, func(c Communication) bool { return expired[c.ClientID] })
+	s.m.tasks = slices.DeleteFunc(s.m.tasks, func(t Task) bool { return expired[t.ClientID] })
+	s.m.documents = slices.DeleteFunc(s.m.documents, func(d Document) bool { return expired[d.ClientID] })
+	s.m.portalTokens = slices.DeleteFunc(s.m.portalTokens, func(t ClientPortalToken) bool { return expired[t.ClientID] })
+	s.m.suggestions = slices.DeleteFunc(s.m.suggestions, func(ts TaskSuggestion) bool { return expired[ts.ClientID] })
+	s.m.recommendations = slices.DeleteFunc(s.m.recommendations, func(rec AiRecommendation) bool { return expired[rec.ClientID] })
+	s.m.insuredMembers = slices.DeleteFunc(s.m.insuredMembers, func(im memoryInsuredMember) bool {
+		return !slices.ContainsFunc(s.m.policies, func(p Policy) bool { return p.ID == im.PolicyID })
+	})
+	s.m.unlinkHouseholdMembers(func(clientID int64) bool { return expired[clientID] })
+	s.m.pruneCustomFieldValues()
+	s.m.changes = slices.DeleteFunc(s.m.changes, func(c EntityChange) bool { return expired[c.ClientID] })
+	s.m.clientStages = slices.DeleteFunc(s.m.clientStages, func(st ClientStage) bool { return expired[st.ClientID] })
+	maps.DeleteFunc(s.m.leadScores, func(clientID int64, _ LeadScore) bool { return expired[clientID] })
+	return len(expired), files, nil
+}
+
+// unlinkHouseholdMembers turns the members whose client is deleted into plain members, like
+// ON DELETE SET NULL; callers must hold m.mu.
+func (m *memoryDB) unlinkHouseholdMembers(deleted func(clientID int64) bool) {
+	for i, member := range m.householdMembers {
+		if member.ClientID.Valid && deleted(member.ClientID.Int64) {
+			m.householdMembers[i].ClientID = sql.NullInt64{}
+		}
+	}
+}
+
+// clientName returns the name of a client; callers must hold m.mu.
+func (m *memoryDB) clientName(clientID int64) (string, bool) {
+	for _, c := range m.clients {
+		if c.ID == clientID {
+			return c.Name, true
+		}
+	}
+	return "", false
+}
+
+// --- Policies ---
+
+type memoryPolicyStore struct{ m *memoryDB }
+
+func (s *memoryPolicyStore) Create(policy Policy) (string, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if policy.ID == "" {
+		policy.ID = "POL-" + generateSimpleID(8)
+	}
+	if !s.m.ownsClient(policy.ClientID, policy.AgentUserID) {
+		return "", sql.ErrNoRows
+	}
+	for _, p := range s.m.policies {
+		if p.ID == policy.ID {
+			return "", errDuplicateRecord
+		}
+	}
+	policy.CreatedAt = time.Now()
+	s.m.saveCustomFieldValues(customFieldEntityPolicy, policy.ID, policy.AgentUserID, policy.CustomFields)
+	policy.CustomFields = nil
+	s.m.policies = append(s.m.policies, policy)
+	return policy.ID, nil
+}
+
+func (s *memoryPolicyStore) ListByClient(clientID int64, agentUserID int64) ([]Policy, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var policies []Policy
+	for _, p := range s.m.policies {
+		if p.ClientID == clientID && p.AgentUserID == agentUserID && !p.DeletedAt.Valid {
+			p.CustomFields = s.m.recordCustomFields(customFieldEntityPolicy, p.ID)
+			policies = append(policies, p)
+		}
+	}
+	sort.SliceStable(policies, func(i, j int) bool { return policies[i].EndDate.String > policies[j].EndDate.String })
+	return policies, nil
+}
+
+func (s *memoryPolicyStore) UpcomingRenewals(agentUserID int64, days int) ([]RenewalPolicyView, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	now := time.Now()
+	startDate := now.Format("2006-01-02")
+	endDate := now.AddDate(0, 0, days).Format("2006-01-02")
+	var renewals []RenewalPolicyView
+	for _, p := range s.m.policies {
+		if p.AgentUserID != agentUserID || p.DeletedAt.Valid || p.Status != "Active" || !p.EndDate.Valid {
+			continue
+		}
+		if p.EndDate.String < startDate || p.EndDate.String >= endDate {
+			continue
+		}
+		name, ok := s.m.clientName(p.ClientID)
+		if !ok {
+			continue // inner join
+		}
+		renewals = append(renewals, RenewalPolicyView{Policy: p, ClientName: name})
+	}
+	sort.SliceStable(renewals, func(i, j int) bool { return renewals[i].EndDate.String < renewals[j].EndDate.String })
+	return renewals, nil
+}
+
+func (s *memoryPolicyStore) CommissionRecords(agentUserID int64, dateRangeStart, dateRangeEnd string) ([]Policy, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var records []Policy
+	for _, p := range s.m.policies {
+		if p.AgentUserID != agentUserID || p.DeletedAt.Valid {
+			continue
+		}
+		if _, ok := s.m.clientName(p.ClientID); !ok {
+			continue
+		}
+		created := p.CreatedAt.Format("2006-01-02 15:04:05")
+		if dateRangeStart != "" && created < dateRangeStart+" 00:00:00" {
+			continue
+		}
+		if dateRangeEnd != "" && created > dateRangeEnd+" 23:59:59" {
+			continue
+		}
+		records = append(records, p)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	return records, nil
+}
+
+func (s *memoryPolicyStore) MonthlyCounts(agentUserID int64, months int) ([]MonthlySalesData, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	firstOfMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.UTC)
+	startDate := firstOfMonth.AddDate(0, -months, 0).Format("2006-01-02")
+	counts := map[string]int{}
+	for _, p := range s.m.policies {
+		if p.AgentUserID != agentUserID || p.DeletedAt.Valid || !p.StartDate.Valid || len(p.StartDate.String) < 7 || p.StartDate.String < startDate {
+			continue
+		}
+		counts[p.StartDate.String[:7]]++
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > months {
+		keys = keys[:months]
+	}
+	var results []MonthlySalesData
+	for _, k := range keys {
+		month := k
+		results = append(results, MonthlySalesData{Month: &month, Count: counts[k]})
+	}
+	return results, nil
+}
+
+func (s *memoryPolicyStore) PremiumByClient(agentUserID int64) (map[int64]float64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	premiums := map[int64]float64{}
+	for _, p := range s.m.policies {
+		if p.AgentUserID == agentUserID && !p.DeletedAt.Valid {
+			premiums[p.ClientID] += p.Premium
+		}
+	}
+	return premiums, nil
+}
+
+// --- Households ---
+
+type memoryInsuredMember struct {
+	PolicyID string
+	MemberID int64
+}
+
+type memoryHouseholdStore struct{ m *memoryDB }
+
+// addHouseholdMember mirrors insertHouseholdMember; callers must hold m.mu.
+func (m *memoryDB) addHouseholdMember(member HouseholdMember, agentUserID int64) (int64, error) {
+	if !slices.ContainsFunc(m.households, func(h Household) bool { return h.ID == member.HouseholdID && h.AgentUserID == agentUserID }) {
+		return 0, sql.ErrNoRows
+	}
+	if member.ClientID.Valid {
+		if !m.ownsClient(member.ClientID.Int64, agentUserID) {
+			return 0, sql.ErrNoRows
+		}
+		if slices.ContainsFunc(m.householdMembers, func(hm HouseholdMember) bool { return hm.ClientID == member.ClientID }) {
+			return 0, errDuplicateRecord
+		}
+		for _, c := range m.clients {
+			if c.ID == member.ClientID.Int64 {
+				member.Name, member.Dob = c.Name, c.Dob
+			}
+		}
+	}
+	member.ID = m.newID()
+	member.CreatedAt = time.Now()
+	m.householdMembers = append(m.householdMembers, member)
+	return member.ID, nil
+}
+
+// household returns a copy of the household with its members as the SQL store reads them;
+// callers must hold m.mu.
+func (m *memoryDB) household(h Household) Household {
+	h.Members = []HouseholdMember{}
+	for _, member := range m.householdMembers {
+		if member.HouseholdID != h.ID {
+			continue
+		}
+		if member.ClientID.Valid {
+			live := false
+			for _, c := range m.clients {
+				if c.ID == member.ClientID.Int64 && !c.DeletedAt.Valid {
+					member.Name, live = c.Name, true
+					if c.Dob.Valid {
+						member.Dob = c.Dob
+					}
+				}
+			}
+			if !live {
+				member.ClientID = sql.NullInt64{}
+			}
+		}
+		h.Members = append(h.Members, member)
+	}
+	return h
+}
+
+func (s *memoryHouseholdStore) Create(household Household) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	households, members := slices.Clone(s.m.households), slices.Clone(s.m.householdMembers)
+	household.ID = s.m.newID()
+	household.CreatedAt = time.Now()
+	s.m.households = append(s.m.households, Household{ID: household.ID, AgentUserID: household.AgentUserID, Name: household.Name, CreatedAt: household.CreatedAt})
+	for _, member := range household.Members {
+		member.HouseholdID = household.ID
+		if _, err := s.m.addHouseholdMember(member, household.AgentUserID); err != nil {
+			s.m.households, s.m.householdMembers = households, members
+			return 0, err
+		}
+	}
+	return household.ID, nil
+}
+
+func (s *memoryHouseholdStore) GetByID(householdID int64, agentUserID int64) (*Household, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, h := range s.m.households {
+		if h.ID == householdID && h.AgentUserID == agentUserID {
+			household := s.m.household(h)
+			return &household, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryHouseholdStore) GetByClient(clientID int64, agentUserID int64) (*Household, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, h := range s.m.households {
+		if h.AgentUserID != agentUserID {
+			continue
+		}
+		household := s.m.household(h)
+		if slices.ContainsFunc(household.Members, func(member HouseholdMember) bool { return member.ClientID.Valid && member.ClientID.Int64 == clientID }) {
+			return &household, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryHouseholdStore) List(agentUserID int64) ([]Household, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	households := []Household{}
+	for _, h := range s.m.households {
+		if h.AgentUserID == agentUserID {
+			households = append(households, s.m.household(h))
+		}
+	}
+	sort.SliceStable(households, func(i, j int) bool { return households[i].Name < households[j].Name })
+	return households, nil
+}
+
+func (s *memoryHouseholdStore) Delete(householdID int64, agentUserID int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	n := len(s.m.households)
+	s.m.households = slices.DeleteFunc(s.m.households, func(h Household) bool { return h.ID == householdID && h.AgentUserID == agentUserID })
+	if len(s.m.households) == n {
+		return sql.ErrNoRows
+	}
+	for _, member := range s.m.householdMembers {
+		if member.HouseholdID == householdID {
+			s.m.removeHouseholdMember(member.ID)
+		}
+	}
+	return nil
+}
+
+// removeHouseholdMember deletes the member and the policies' links to it; callers must hold m.mu.
+func (m *memoryDB) removeHouseholdMember(memberID int64) {
+	m.householdMembers = slices.DeleteFunc(m.householdMembers, func(member HouseholdMember) bool { return member.ID == memberID })
+	m.insuredMembers = slices.DeleteFunc(m.insuredMembers, func(im memoryInsuredMember) bool { return im.MemberID == memberID })
+}
+
+func (s *memoryHouseholdStore) AddMember(member HouseholdMember, agentUserID int64) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return s.m.addHouseholdMember(member, agentUserID)
+}
+
+func (s *memoryHouseholdStore) RemoveMember(householdID int64, memberID int64, agentUserID int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !slices.ContainsFunc(s.m.households, func(h Household) bool { return h.ID == householdID && h.AgentUserID == agentUserID }) ||
+		!slices.ContainsFunc(s.m.householdMembers, func(member HouseholdMember) bool { return member.ID == memberID && member.HouseholdID == householdID }) {
+		return sql.ErrNoRows
+	}
+	s.m.removeHouseholdMember(memberID)
+	return nil
+}
+
+func (s *memoryHouseholdStore) SetInsuredMembers(policyID string, clientID int64, agentUserID int64, memberIDs []int64) ([]int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !slices.ContainsFunc(s.m.policies, func(p Policy) bool {
+		return p.ID == policyID && p.ClientID == clientID && p.AgentUserID == agentUserID && !p.DeletedAt.Valid
+	}) {
+		return nil, sql.ErrNoRows
+	}
+	var householdID int64
+	for _, member := range s.m.householdMembers {
+		if member.ClientID.Valid && member.ClientID.Int64 == clientID {
+			householdID = member.HouseholdID
+		}
+	}
+	var links []memoryInsuredMember
+	for _, memberID := range memberIDs {
+		if slices.Contains(links, memoryInsuredMember{policyID, memberID}) {
+			continue
+		}
+		if !slices.ContainsFunc(s.m.householdMembers, func(member HouseholdMember) bool { return member.ID == memberID && member.HouseholdID == householdID }) {
+			return nil, errNotHouseholdMember
+		}
+		links = append(links, memoryInsuredMember{policyID, memberID})
+	}
+	previous := []int64{}
+	for _, im := range s.m.insuredMembers {
+		if im.PolicyID == policyID {
+			previous = append(previous, im.MemberID)
+		}
+	}
+	slices.Sort(previous)
+	s.m.insuredMembers = append(slices.DeleteFunc(s.m.insuredMembers, func(im memoryInsuredMember) bool { return im.PolicyID == policyID }), links...)
+	return previous, nil
+}
+
+func (s *memoryHouseholdStore) ListPolicies(householdID int64, agentUserID int64) ([]HouseholdPolicy, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !slices.ContainsFunc(s.m.households, func(h Household) bool { return h.ID == householdID && h.AgentUserID == agentUserID }) {
+		return nil, sql.ErrNoRows
+	}
+	memberClients, inHousehold := map[int64]bool{}, map[int64]bool{}
+	for _, member := range s.m.householdMembers {
+		if member.HouseholdID == householdID {
+			inHousehold[member.ID] = true
+			if member.ClientID.Valid {
+				memberClients[member.ClientID.Int64] = true
+			}
+		}
+	}
+	insured := map[string][]int64{}
+	for _, im := range s.m.insuredMembers {
+		if inHousehold[im.MemberID] {
+			insured[im.PolicyID] = append(insured[im.PolicyID], im.MemberID)
+		}
+	}
+	policies := []HouseholdPolicy{}
+	for _, p := range s.m.policies {
+		if p.AgentUserID != agentUserID || p.DeletedAt.Valid || (!memberClients[p.ClientID] && insured[p.ID] == nil) {
+			continue
+		}
+		members := slices.Sorted(slices.Values(insured[p.ID]))
+		if members == nil {
+			members = []int64{}
+		}
+		p.CustomFields = s.m.recordCustomFields(customFieldEntityPolicy, p.ID)
+		policies = append(policies, HouseholdPolicy{Policy: p, InsuredMemberIDs: members})
+	}
+	sort.SliceStable(policies, func(i, j int) bool { return policies[i].EndDate.String > policies[j].EndDate.String })
+	return policies, nil
+}
+
+// --- Custom Fields ---
+
+// memoryCustomFieldValue is a row of custom_field_values; Record is the client or policy ID as
+// fmt.Sprint gives it, the entity being the field's.
+type memoryCustomFieldValue struct {
+	FieldID int64
+	Record  string
+	Value   string
+}
+
+type memoryCustomFieldStore struct{ m *memoryDB }
+
+// visibleCustomField reports whether userID sees def; callers must hold m.mu.
+func (m *memoryDB) visibleCustomField(def CustomFieldDefinition, userID int64) bool {
+	member, ok := m.agencyMembers[userID]
+	return def.OwnerUserID == userID || (ok && def.OwnerUserID == member.AgencyUserID)
+}
+
+// customField returns the definition with the given ID, or a zero one; callers must hold m.mu.
+func (m *memoryDB) customField(fieldID int64) CustomFieldDefinition {
+	for _, def := range m.customFields {
+		if def.ID == fieldID {
+			return def
+		}
+	}
+	return CustomFieldDefinition{}
+}
+
+// saveCustomFieldValues works like the SQL store's; callers must hold m.mu.
+func (m *memoryDB) saveCustomFieldValues(entity, record string, agentUserID int64, values map[string]string) {
+	for key, value := range values {
+		m.customValues = slices.DeleteFunc(m.customValues, func(v memoryCustomFieldValue) bool {
+			def := m.customField(v.FieldID)
+			return v.Record == record && def.Entity == entity && def.Key == key
+		})
+		if value == "" {
+			continue
+		}
+		for _, def := range m.customFields {
+			if def.Entity == entity && def.Key == key && m.visibleCustomField(def, agentUserID) {
+				m.customValues = append(m.customValues, memoryCustomFieldValue{FieldID: def.ID, Record: record, Value: value})
+			}
+		}
+	}
+}
+
+// recordCustomFields returns the custom field values of a client or policy; callers must hold m.mu.
+func (m *memoryDB) recordCustomFields(entity, record string) map[string]string {
+	values := map[string]string{}
+	for _, v := range m.customValues {
+		if def := m.customField(v.FieldID); v.Record == record && def.Entity == entity {
+			values[def.Key] = v.Value
+		}
+	}
+	return values
+}
+
+// pruneCustomFieldValues drops the values of deleted fields, clients and policies, like the
+// foreign keys' ON DELETE CASCADE; callers must hold m.mu.
+func (m *memoryDB) pruneCustomFieldValues() {
+	m.customValues = slices.DeleteFunc(m.customValues, func(v memoryCustomFieldValue) bool {
+		switch m.customField(v.FieldID).Entity {
+		case customFieldEntityClient:
+			return !slices.ContainsFunc(m.clients, func(c Client) bool { return fmt.Sprint(c.ID) == v.Record })
+		case customFieldEntityPolicy:
+			return !slices.ContainsFunc(m.policies, func(p Policy) bool { return p.ID == v.Record })
+		}
+		return true
+	})
+}
+
+func (s *memoryCustomFieldStore) ListDefinitions(userID int64, entity string) ([]CustomFieldDefinition, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	defs := []CustomFieldDefinition{}
+	for _, def := range s.m.customFields {
+		if def.Entity == entity && s.m.visibleCustomField(def, userID) {
+			defs = append(defs, def)
+		}
+	}
+	return defs, nil
+}
+
+func (s *memoryCustomFieldStore) CreateDefinition(def CustomFieldDefinition) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, existing := range s.m.customFields {
+		if existing.Entity != def.Entity || existing.Key != def.Key {
+			continue
+		}
+		if s.m.visibleCustomField(existing, def.OwnerUserID) || s.m.agencyMembers[existing.OwnerUserID].AgencyUserID == def.OwnerUserID {
+			return 0, errDuplicateRecord
+		}
+	}
+	def.ID = s.m.newID()
+	def.CreatedAt = time.Now()
+	s.m.customFields = append(s.m.customFields, def)
+	return def.ID, nil
+}
+
+func (s *memoryCustomFieldStore) GetDefinition(fieldID int64, ownerUserID int64) (*CustomFieldDefinition, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, def := range s.m.customFields {
+		if def.ID == fieldID && def.OwnerUserID == ownerUserID {
+			return &def, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryCustomFieldStore) UpdateDefinition(def CustomFieldDefinition) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, existing := range s.m.customFields {
+		if existing.ID == def.ID && existing.OwnerUserID == def.OwnerUserID {
+			s.m.customFields[i].Label, s.m.customFields[i].Options, s.m.customFields[i].Required = def.Label, def.Options, def.Required
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *memoryCustomFieldStore) DeleteDefinition(fieldID int64, ownerUserID int64) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	n := len(s.m.customFields)
+	s.m.customFields = slices.DeleteFunc(s.m.customFields, func(def CustomFieldDefinition) bool {
+		return def.ID == fieldID && def.OwnerUserID == ownerUserID
+	})
+	if len(s.m.customFields) == n {
+		return sql.ErrNoRows
+	}
+	s.m.pruneCustomFieldValues()
+	return nil
+}
+
+// --- Change History ---
+
+type memoryChangeStore struct{ m *memoryDB }
+
+func (s *memoryChangeStore) Record(changes []EntityChange) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, c := range changes {
+		c.ID = s.m.newID()
+		s.m.changes = append(s.m.changes, c)
+	}
+	return nil
+}
+
+func (s *memoryChangeStore) ListByClient(clientID int64, agentUserID int64) ([]EntityChange, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	changes := []EntityChange{}
+	for i := len(s.m.changes) - 1; i >= 0; i-- { // Newest first
+		if c := s.m.changes[i]; c.ClientID == clientID && c.AgentUserID == agentUserID {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// --- Pipeline ---
+
+type memoryPipelineStore struct{ m *memoryDB }
+
+func (s *memoryPipelineStore) ListStages(ownerUserID int64) ([]PipelineStage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	stages := []PipelineStage{}
+	for _, stage := range s.m.pipelineStages {
+		if stage.OwnerUserID == ownerUserID {
+			stage.NextStages = slices.Clone(stage.NextStages)
+			stages = append(stages, stage)
+		}
+	}
+	sort.SliceStable(stages, func(i, j int) bool { return stages[i].Position < stages[j].Position })
+	return stages, nil
+}
+
+func (s *memoryPipelineStore) ReplaceStages(ownerUserID int64, stages []PipelineStage) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.pipelineStages = slices.DeleteFunc(s.m.pipelineStages, func(stage PipelineStage) bool { return stage.OwnerUserID == ownerUserID })
+	for _, stage := range stages {
+		stage.ID, stage.OwnerUserID, stage.NextStages = s.m.newID(), ownerUserID, slices.Clone(stage.NextStages)
+		s.m.pipelineStages = append(s.m.pipelineStages, stage)
+	}
+	return nil
+}
+
+func (s *memoryPipelineStore) CurrentStages(agentUserID int64) ([]ClientStage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	stages := []ClientStage{}
+	for _, st := range s.m.clientStages {
+		if st.AgentUserID == agentUserID && !st.LeftAt.Valid && s.m.ownsClient(st.ClientID, agentUserID) {
+			stages = append(stages, st)
+		}
+	}
+	return stages, nil
+}
+
+func (s *memoryPipelineStore) MoveClient(stage ClientStage, clientStatus string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.ownsClient(stage.ClientID, stage.AgentUserID) {
+		return sql.ErrNoRows
+	}
+	for i, st := range s.m.clientStages {
+		if st.ClientID == stage.ClientID && !st.LeftAt.Valid {
+			s.m.clientStages[i].LeftAt = sql.NullTime{Time: stage.EnteredAt, Valid: true}
+		}
+	}
+	stage.ID = s.m.newID()
+	s.m.clientStages = append(s.m.clientStages, stage)
+	for i, c := range s.m.clients {
+		if c.ID == stage.ClientID && clientStatus != "" && c.Status != clientStatus {
+			s.m.clients[i].Status = clientStatus
+			s.m.clients[i].Version, s.m.clients[i].UpdatedAt = c.Version+1, sql.NullTime{Time: stage.EnteredAt, Valid: true}
+		}
+	}
+	return nil
+}
+
+func (s *memoryPipelineStore) ListClientStages(clientID int64, agentUserID int64) ([]ClientStage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	stages := []ClientStage{}
+	for i := len(s.m.clientStages) - 1; i >= 0; i-- { // Newest first
+		if st := s.m.clientStages[i]; st.ClientID == clientID && st.AgentUserID == agentUserID {
+			stages = append(stages, st)
+		}
+	}
+	return stages, nil
+}
+
+// --- Lead Scoring ---
+
+type memoryLeadScoreStore struct{ m *memoryDB }
+
+func (s *memoryLeadScoreStore) GetWeights(ownerUserID int64) (LeadScoreWeights, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	weights, ok := s.m.leadWeights[ownerUserID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return maps.Clone(weights), nil
+}
+
+func (s *memoryLeadScoreStore) SetWeights(ownerUserID int64, weights LeadScoreWeights) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if weights == nil {
+		delete(s.m.leadWeights, ownerUserID)
+	} else {
+		s.m.leadWeights[ownerUserID] = maps.Clone(weights)
+	}
+	return nil
+}
+
+func (s *memoryLeadScoreStore) Save(score LeadScore) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.ownsClient(score.ClientID, score.AgentUserID) {
+		return sql.ErrNoRows
+	}
+	score.Factors = slices.Clone(score.Factors)
+	s.m.leadScores[score.ClientID] = score
+	return nil
+}
+
+func (s *memoryLeadScoreStore) Get(clientID int64, agentUserID int64) (*LeadScore, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	score, ok := s.m.leadScores[clientID]
+	if !ok || score.AgentUserID != agentUserID {
+		return nil, sql.ErrNoRows
+	}
+	score.Factors = slices.Clone(score.Factors)
+	return &score, nil
+}
+
+// --- Communications, Tasks & Documents ---
+
+type memoryCommunicationStore struct{ m *memoryDB }
+
+func (s *memoryCommunicationStore) Create(comm Communication) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.ownsClient(comm.ClientID, comm.AgentUserID) {
+		return 0, sql.ErrNoRows
+	}
+	comm.ID = s.m.newID()
+	comm.CreatedAt = time.Now()
+	s.m.communications = append(s.m.communications, comm)
+	for i, c := range s.m.clients {
+		if c.ID == comm.ClientID && (!c.LastContactedAt.Valid || c.LastContactedAt.Time.Before(comm.Timestamp)) {
+			s.m.clients[i].LastContactedAt = sql.NullTime{Time: comm.Timestamp, Valid: true}
+		}
+	}
+	return comm.ID, nil
+}
+
+func (s *memoryCommunicationStore) ListByClient(clientID int64, agentUserID int64) ([]Communication, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var comms []Communication
+	for _, c := range s.m.communications {
+		if c.ClientID == clientID && c.AgentUserID == agentUserID && !c.DeletedAt.Valid {
+			comms = append(comms, c)
+		}
+	}
+	sort.SliceStable(comms, func(i, j int) bool { return comms[i].Timestamp.After(comms[j].Timestamp) })
+	return comms, nil
+}
+
+type memoryTaskStore struct{ m *memoryDB }
+
+// sortTasks orders tasks urgent first, then by due date (NULL due dates last) and newest first.
+func sortTasks(tasks []Task) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.IsCompleted != b.IsCompleted {
+			return !a.IsCompleted
+		}
+		if a.IsUrgent != b.IsUrgent {
+			return a.IsUrgent
+		}
+		if a.DueDate.Valid != b.DueDate.Valid {
+			return a.DueDate.Valid
+		}
+		if a.DueDate.String != b.DueDate.String {
+			return a.DueDate.String < b.DueDate.String
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+}
+
+func (s *memoryTaskStore) Create(task Task) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.ownsClient(task.ClientID, task.AgentUserID) {
+		return 0, sql.ErrNoRows
+	}
+	task.ID = s.m.newID()
+	task.IsCompleted = false
+	task.CreatedAt = time.Now()
+	s.m.tasks = append(s.m.tasks, task)
+	return task.ID, nil
+}
+
+func (s *memoryTaskStore) ListPendingByClient(clientID int64, agentUserID int64) ([]Task, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var tasks []Task
+	for _, t := range s.m.tasks {
+		if t.ClientID == clientID && t.AgentUserID == agentUserID && !t.DeletedAt.Valid && !t.IsCompleted {
+			tasks = append(tasks, t)
+		}
+	}
+	sortTasks(tasks)
+	return tasks, nil
+}
+
+func (s *memoryTaskStore) ListByClient(clientID int64, agentUserID int64) ([]Task, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var tasks []Task
+	for _, t := range s.m.tasks {
+		if t.ClientID == clientID && t.AgentUserID == agentUserID && !t.DeletedAt.Valid {
+			tasks = append(tasks, t)
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].CreatedAt.After(tasks[j].CreatedAt) })
+	return tasks, nil
+}
+
+func (s *memoryTaskStore) ListPendingByAgent(agentUserID int64, limit int) ([]Task, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var tasks []Task
+	for _, t := range s.m.tasks {
+		if t.AgentUserID == agentUserID && !t.DeletedAt.Valid && !t.IsCompleted {
+			tasks = append(tasks, t)
+		}
+	}
+	sortTasks(tasks)
+	start, end := paginate(len(tasks), limit, 0)
+	return tasks[start:end], nil
+}
+
+func (s *memoryTaskStore) ListByAgent(agentUserID int64, statusFilter string, page, pageSize int) ([]Task, int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var tasks []Task
+	for _, t := range s.m.tasks {
+		if t.AgentUserID != agentUserID || t.DeletedAt.Valid {
+			continue
+		}
+		if (statusFilter == "pending" && t.IsCompleted) || (statusFilter == "completed" && !t.IsCompleted) {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	sortTasks(tasks)
+	start, end := paginate(len(tasks), pageSize, (page-1)*pageSize)
+	return tasks[start:end], len(tasks), nil
+}
+
+func (s *memoryTaskStore) GetByID(taskID int64, agentUserID int64) (*Task, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, t := range s.m.tasks {
+		if t.ID == taskID && t.AgentUserID == agentUserID && !t.DeletedAt.Valid {
+			return &t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryTaskStore) UpdateStatus(taskID int64, agentUserID int64, completed bool) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i := range s.m.tasks {
+		if s.m.tasks[i].ID != taskID || s.m.tasks[i].AgentUserID != agentUserID || s.m.tasks[i].DeletedAt.Valid {
+			continue
+		}
+		s.m.tasks[i].IsCompleted = completed
+		s.m.tasks[i].CompletedAt = sql.NullTime{}
+		if completed {
+			s.m.tasks[i].CompletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+type memoryTaskSuggestionStore struct{ m *memoryDB }
+
+// suggestion returns a pointer to the agent's stored suggestion; callers must hold m.mu.
+func (m *memoryDB) suggestion(suggestionID int64, agentUserID int64) *TaskSuggestion {
+	for i := range m.suggestions {
+		if m.suggestions[i].ID == suggestionID && m.suggestions[i].AgentUserID == agentUserID {
+			return &m.suggestions[i]
+		}
+	}
+	return nil
+}
+
+func (s *memoryTaskSuggestionStore) Create(suggestion TaskSuggestion) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	suggestion.ID = s.m.newID()
+	suggestion.Status = "pending"
+	suggestion.CreatedAt = time.Now()
+	s.m.suggestions = append(s.m.suggestions, suggestion)
+	return suggestion.ID, nil
+}
+
+func (s *memoryTaskSuggestionStore) GetByID(suggestionID int64, agentUserID int64) (*TaskSuggestion, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	ts := s.m.suggestion(suggestionID, agentUserID)
+	if ts == nil {
+		return nil, sql.ErrNoRows
+	}
+	found := *ts
+	return &found, nil
+}
+
+func (s *memoryTaskSuggestionStore) ListByAgent(agentUserID int64, statusFilter string, clientID int64) ([]TaskSuggestion, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	suggestions := []TaskSuggestion{}
+	for i := len(s.m.suggestions) - 1; i >= 0; i-- { // Newest first
+		ts := s.m.suggestions[i]
+		if ts.AgentUserID == agentUserID && (statusFilter == "" || ts.Status == statusFilter) && (clientID == 0 || ts.ClientID == clientID) {
+			suggestions = append(suggestions, ts)
+		}
+	}
+	return suggestions, nil
+}
+
+func (s *memoryTaskSuggestionStore) Accept(suggestionID int64, agentUserID int64, task Task, edited bool, reviewedAt time.Time) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	ts := s.m.suggestion(suggestionID, agentUserID)
+	if ts == nil {
+		return 0, sql.ErrNoRows
+	}
+	if ts.Status != "pending" {
+		return 0, errSuggestionReviewed
+	}
+	task.ID = s.m.newID()
+	task.AgentUserID = agentUserID
+	task.IsCompleted = false
+	task.CreatedAt = time.Now()
+	s.m.tasks = append(s.m.tasks, task)
+	ts.Status, ts.Edited = "accepted", edited
+	ts.TaskID = sql.NullInt64{Int64: task.ID, Valid: true}
+	ts.ReviewedAt = sql.NullTime{Time: reviewedAt, Valid: true}
+	return task.ID, nil
+}
+
+func (s *memoryTaskSuggestionStore) Reject(suggestionID int64, agentUserID int64, reason string, reviewedAt time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	ts := s.m.suggestion(suggestionID, agentUserID)
+	if ts == nil {
+		return sql.ErrNoRows
+	}
+	if ts.Status != "pending" {
+		return errSuggestionReviewed
+	}
+	ts.Status = "rejected"
+	ts.RejectReason = sql.NullString{String: reason, Valid: reason != ""}
+	ts.ReviewedAt = sql.NullTime{Time: reviewedAt, Valid: true}
+	return nil
+}
+
+func (s *memoryTaskSuggestionStore) StatsByPromptVersion(agentUserID int64) ([]SuggestionStats, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	tally := suggestionTally{}
+	for _, ts := range s.m.suggestions {
+		if agentUserID == 0 || ts.AgentUserID == agentUserID {
+			tally.add(ts.PromptVersion, ts.Status, ts.Edited, 1)
+		}
+	}
+	return tally.stats(), nil
+}
+
+type memoryGoalPlanStore struct{ m *memoryDB }
+
+func (s *memoryGoalPlanStore) Create(plan GoalPlan) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	plan.ID = s.m.newID()
+	plan.InsightsError = ""
+	s.m.goalPlans = append(s.m.goalPlans, plan)
+	return plan.ID, nil
+}
+
+func (s *memoryGoalPlanStore) GetByID(planID int64, agentUserID int64) (*GoalPlan, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, gp := range s.m.goalPlans {
+		if gp.ID == planID && gp.AgentUserID == agentUserID {
+			return &gp, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryGoalPlanStore) ListByAgent(agentUserID int64, limit int) ([]GoalPlan, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	plans := []GoalPlan{}
+	for i := len(s.m.goalPlans) - 1; i >= 0 && len(plans) < limit; i-- { // Newest first
+		if s.m.goalPlans[i].AgentUserID == agentUserID {
+			plans = append(plans, s.m.goalPlans[i])
+		}
+	}
+	return plans, nil
+}
+
+type memoryAiRecommendationStore struct{ m *memoryDB }
+
+// find returns a copy of the first matching recommendation; callers must hold m.mu.
+func (s *memoryAiRecommendationStore) find(match func(AiRecommendation) bool) (*AiRecommendation, error) {
+	for _, rec := range s.m.recommendations {
+		if match(rec) {
+			return &rec, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryAiRecommendationStore) Create(rec AiRecommendation) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, existing := range s.m.recommendations {
+		if existing.ClientID == rec.ClientID && existing.InputHash == rec.InputHash {
+			return 0, errDuplicateRecord
+		}
+	}
+	rec.ID = s.m.newID()
+	rec.EditedText, rec.Approved, rec.Pinned, rec.ReviewedAt = sql.NullString{}, false, false, sql.NullTime{}
+	rec.CreatedAt = time.Now()
+	s.m.recommendations = append(s.m.recommendations, rec)
+	return rec.ID, nil
+}
+
+func (s *memoryAiRecommendationStore) GetByID(recommendationID int64, clientID int64, agentUserID int64) (*AiRecommendation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return s.find(func(rec AiRecommendation) bool {
+		return rec.ID == recommendationID && rec.ClientID == clientID && rec.AgentUserID == agentUserID
+	})
+}
+
+func (s *memoryAiRecommendationStore) GetByInputHash(clientID int64, inputHash string) (*AiRecommendation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return s.find(func(rec AiRecommendation) bool { return rec.ClientID == clientID && rec.InputHash == inputHash })
+}
+
+func (s *memoryAiRecommendationStore) Published(clientID int64, inputHash string) (*AiRecommendation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if rec, err := s.find(func(rec AiRecommendation) bool { return rec.ClientID == clientID && rec.Pinned }); err == nil {
+		return rec, nil
+	}
+	return s.find(func(rec AiRecommendation) bool {
+		return rec.ClientID == clientID && rec.InputHash == inputHash && rec.Approved
+	})
+}
+
+func (s *memoryAiRecommendationStore) ListByClient(clientID int64, agentUserID int64) ([]AiRecommendation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	recs := []AiRecommendation{}
+	for i := len(s.m.recommendations) - 1; i >= 0; i-- { // Newest first
+		if rec := s.m.recommendations[i]; rec.ClientID == clientID && rec.AgentUserID == agentUserID {
+			recs = append(recs, rec)
+		}
+	}
+	return recs, nil
+}
+
+func (s *memoryAiRecommendationStore) Review(rec AiRecommendation) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	index := -1
+	for i, existing := range s.m.recommendations {
+		if existing.ID == rec.ID && existing.ClientID == rec.ClientID && existing.AgentUserID == rec.AgentUserID {
+			index = i
+		}
+	}
+	if index < 0 {
+		return sql.ErrNoRows
+	}
+	if rec.Pinned {
+		for i := range s.m.recommendations {
+			if s.m.recommendations[i].ClientID == rec.ClientID {
+				s.m.recommendations[i].Pinned = false
+			}
+		}
+	}
+	stored := &s.m.recommendations[index]
+	stored.EditedText, stored.Approved, stored.Pinned, stored.ReviewedAt = rec.EditedText, rec.Approved, rec.Pinned, rec.ReviewedAt
+	return nil
+}
+
+type memoryDocumentStore struct{ m *memoryDB }
+
+func (s *memoryDocumentStore) Create(doc Document) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.ownsClient(doc.ClientID, doc.AgentUserID) {
+		return 0, sql.ErrNoRows
+	}
+	doc.ID = s.m.newID()
+	doc.UploadedAt = time.Now()
+	s.m.documents = append(s.m.documents, doc)
+	return doc.ID, nil
+}
+
+func (s *memoryDocumentStore) ListByClient(clientID int64, agentUserID int64) ([]Document, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var docs []Document
+	for _, d := range s.m.documents {
+		if d.ClientID == clientID && d.AgentUserID == agentUserID && !d.DeletedAt.Valid {
+			docs = append(docs, d)
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].UploadedAt.After(docs[j].UploadedAt) })
+	return docs, nil
+}
+
+// --- Activity & Portal Tokens ---
+
+type memoryActivityStore struct{ m *memoryDB }
+
+func (s *memoryActivityStore) Log(agentUserID int64, activityType, description, relatedID string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.activity = append(s.m.activity, ActivityLog{
+		ID: s.m.newID(), AgentUserID: agentUserID, Timestamp: time.Now(),
+		ActivityType: activityType, Description: description, RelatedID: relatedID,
+	})
+	return nil
+}
+
+// agentActivity returns the agent's activity newest first; callers must hold m.mu.
+func (m *memoryDB) agentActivity(agentUserID int64) []ActivityLog {
+	var activities []ActivityLog
+	for i := len(m.activity) - 1; i >= 0; i-- {
+		if m.activity[i].AgentUserID == agentUserID {
+			activities = append(activities, m.activity[i])
+		}
+	}
+	return activities
+}
+
+func (s *memoryActivityStore) Recent(agentUserID int64, limit int) ([]ActivityLog, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	activities := s.m.agentActivity(agentUserID)
+	start, end := paginate(len(activities), limit, 0)
+	return activities[start:end], nil
+}
+
+func (s *memoryActivityStore) List(agentUserID int64, page, pageSize int) ([]ActivityLog, int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	activities := s.m.agentActivity(agentUserID)
+	start, end := paginate(len(activities), pageSize, (page-1)*pageSize)
+	return activities[start:end], len(activities), nil
+}
+
+type memoryPortalTokenStore struct{ m *memoryDB }
+
+func (s *memoryPortalTokenStore) Store(token string, clientID int64, agentUserID int64, duration time.Duration) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, t := range s.m.portalTokens {
+		if t.Token == token {
+			return errDuplicateRecord
+		}
+	}
+	now := time.Now()
+	s.m.portalTokens = append(s.m.portalTokens, ClientPortalToken{
+		Token: token, ClientID: clientID, AgentUserID: agentUserID, ExpiresAt: now.Add(duration), CreatedAt: now,
+	})
+	return nil
+}
+
+func (s *memoryPortalTokenStore) Verify(token string) (int64, int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, t := range s.m.portalTokens {
+		if t.Token == token && t.ExpiresAt.After(time.Now()) {
+			return t.ClientID, t.AgentUserID, nil
+		}
+	}
+	return 0, 0, sql.ErrNoRows
+}
+
+func (s *memoryPortalTokenStore) RecordVisit(token string, at time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, t := range s.m.portalTokens {
+		if t.Token == token {
+			s.m.portalTokens[i].VisitCount++
+			s.m.portalTokens[i].LastVisitedAt = sql.NullTime{Time: at, Valid: true}
+		}
+	}
+	return nil
+}
+
+func (s *memoryPortalTokenStore) CountVisits(clientID int64, agentUserID int64) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	visits := 0
+	for _, t := range s.m.portalTokens {
+		if t.ClientID == clientID && t.AgentUserID == agentUserID {
+			visits += t.VisitCount
+		}
+	}
+	return visits, nil
+}
+
+// --- Email Outbox ---
+
+type memoryOutboxStore struct{ m *memoryDB }
+
+// outboxEmail returns a pointer to the stored email; callers must hold m.mu.
+func (m *memoryDB) outboxEmail(emailID int64) *OutboxEmail {
+	for i := range m.outbox {
+		if m.outbox[i].ID == emailID {
+			return &m.outbox[i]
+		}
+	}
+	return nil
+}
+
+func (s *memoryOutboxStore) Enqueue(email OutboxEmail) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	email.ID = s.m.newID()
+	email.To = append([]string(nil), email.To...)
+	email.CreatedAt = time.Now()
+	s.m.outbox = append(s.m.outbox, email)
+	return email.ID, nil
+}
+
+func (s *memoryOutboxStore) ClaimDue(now time.Time, lease time.Duration, limit int) ([]OutboxEmail, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var due []*OutboxEmail
+	for i := range s.m.outbox {
+		e := &s.m.outbox[i]
+		if (e.Status == "pending" || e.Status == "sending") && !e.NextAttemptAt.After(now) {
+			due = append(due, e)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	start, end := paginate(len(due), limit, 0)
+	claimed := make([]OutboxEmail, 0, end-start)
+	for _, e := range due[start:end] {
+		e.Status = "sending"
+		e.Attempts++
+		e.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, *e)
+	}
+	return claimed, nil
+}
+
+func (s *memoryOutboxStore) MarkSent(emailID int64, sentAt time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	e := s.m.outboxEmail(emailID)
+	if e == nil {
+		return sql.ErrNoRows
+	}
+	e.Status, e.SentAt, e.LastError = "sent", sql.NullTime{Time: sentAt, Valid: true}, sql.NullString{}
+	return nil
+}
+
+func (s *memoryOutboxStore) Reschedule(emailID int64, lastError string, retryAt time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	e := s.m.outboxEmail(emailID)
+	if e == nil {
+		return sql.ErrNoRows
+	}
+	e.Status, e.LastError, e.NextAttemptAt = "pending", sql.NullString{String: lastError, Valid: true}, retryAt
+	return nil
+}
+
+func (s *memoryOutboxStore) MarkFailed(emailID int64, lastError string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	e := s.m.outboxEmail(emailID)
+	if e == nil {
+		return sql.ErrNoRows
+	}
+	e.Status, e.LastError = "failed", sql.NullString{String: lastError, Valid: true}
+	return nil
+}
+
+func (s *memoryOutboxStore) GetByID(emailID int64) (*OutboxEmail, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	e := s.m.outboxEmail(emailID)
+	if e == nil {
+		return nil, sql.ErrNoRows
+	}
+	found := *e
+	return &found, nil
+}
+
+// --- Agents, Insurers & Products ---
+
+type memoryAgentStore struct{ m *memoryDB }
+
+func (s *memoryAgentStore) GetProfile(userID int64) (*AgentProfile, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	p, ok := s.m.profiles[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &p, nil
+}
+
+func (s *memoryAgentStore) UpsertProfile(profile AgentProfile) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if profile.PAN.Valid {
+		for uid, p := range s.m.profiles {
+			if uid != profile.UserID && p.PAN.Valid && p.PAN.String == profile.PAN.String {
+				return fmt.Errorf("PAN number already exists for another user")
+			}
+		}
+	}
+	s.m.profiles[profile.UserID] = profile
+	return nil
+}
+
+func (s *memoryAgentStore) GetGoal(userID int64) (*AgentGoal, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	g, ok := s.m.goals[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &g, nil
+}
+
+func (s *memoryAgentStore) UpsertGoal(goal AgentGoal) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.goals[goal.UserID] = goal
+	return nil
+}
+
+type memoryInsurerStore struct{ m *memoryDB }
+
+func (s *memoryInsurerStore) ListRelations(agentUserID int64) ([]AgentInsurerRelation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	relations := []AgentInsurerRelation{}
+	for _, r := range s.m.relations {
+		if r.AgentUserID == agentUserID {
+			relations = append(relations, r)
+		}
+	}
+	sort.SliceStable(relations, func(i, j int) bool { return relations[i].InsurerName < relations[j].InsurerName })
+	return relations, nil
+}
+
+func (s *memoryInsurerStore) SetRelations(agentUserID int64, relations []AgentInsurerRelation) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	kept := []AgentInsurerRelation{}
+	for _, r := range s.m.relations {
+		if r.AgentUserID != agentUserID {
+			kept = append(kept, r)
+		}
+	}
+	seenInsurers := make(map[string]bool)
+	now := time.Now()
+	for i, rel := range relations {
+		if i >= 25 { // same cap and dedupe as the SQL store
+			break
+		}
+		lowerInsurer := strings.ToLower(rel.InsurerName)
+		if rel.InsurerName == "" || seenInsurers[lowerInsurer] {
+			continue
+		}
+		rel.ID = s.m.newID()
+		rel.AgentUserID = agentUserID
+		rel.CreatedAt = now
+		kept = append(kept, rel)
+		seenInsurers[lowerInsurer] = true
+	}
+	s.m.relations = kept
+	return nil
+}
+
+func (s *memoryInsurerStore) GetRelationByInsurer(agentUserID int64, insurerName string) (*AgentInsurerRelation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, r := range s.m.relations {
+		if r.AgentUserID == agentUserID && strings.EqualFold(r.InsurerName, insurerName) {
+			return &r, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryInsurerStore) SearchProducts(agentUserID int64, categoryFilter, insurerFilter, searchTerm string) ([]AgentInsurerRelation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	term := strings.ToLower(searchTerm)
+	products := []AgentInsurerRelation{}
+	for _, r := range s.m.relations {
+		if r.AgentUserID != agentUserID {
+			continue
+		}
+		if categoryFilter != "" && categoryFilter != "All Categories" && r.Category != categoryFilter {
+			continue
+		}
+		if insurerFilter != "" && insurerFilter != "All Insurers" && r.InsurerName != insurerFilter {
+			continue
+		}
+		if term != "" && !strings.Contains(strings.ToLower(r.Name), term) &&
+			!strings.Contains(strings.ToLower(r.InsurerName), term) &&
+			!strings.Contains(strings.ToLower(r.Description.String), term) {
+			continue
+		}
+		products = append(products, r)
+	}
+	sort.SliceStable(products, func(i, j int) bool {
+		if products[i].Category != products[j].Category {
+			return products[i].Category < products[j].Category
+		}
+		return products[i].Name < products[j].Name
+	})
+	return products, nil
+}
+
+func (s *memoryInsurerStore) ListProductNames(agentUserID int64) ([]AgentInsurerRelation, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	products := []AgentInsurerRelation{}
+	for _, r := range s.m.relations {
+		if r.AgentUserID == agentUserID {
+			products = append(products, AgentInsurerRelation{ID: r.ID, Name: r.Name})
+		}
+	}
+	return products, nil
+}
+
+func (s *memoryInsurerStore) SetPOCs(agentUserID int64, pocs []AgentInsurerPOC) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	kept := []AgentInsurerPOC{}
+	for _, p := range s.m.pocs {
+		if p.AgentUserID != agentUserID {
+			kept = append(kept, p)
+		}
+	}
+	seen := map[string]bool{}
+	for i, poc := range pocs {
+		if i >= 6 {
+			break
+		}
+		if poc.InsurerName == "" || poc.PocEmail == "" || seen[poc.InsurerName] {
+			continue
+		}
+		poc.ID = s.m.newID()
+		poc.AgentUserID = agentUserID
+		kept = append(kept, poc)
+		seen[poc.InsurerName] = true
+	}
+	s.m.pocs = kept
+	return nil
+}
+
+func (s *memoryInsurerStore) SetDetails(agentUserID int64, details []AgentInsurerDetail) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	kept := []AgentInsurerDetail{}
+	for _, d := range s.m.details {
+		if d.AgentUserID != agentUserID {
+			kept = append(kept, d)
+		}
+	}
+	seen := map[string]bool{}
+	for i, detail := range details {
+		if i >= 20 {
+			break
+		}
+		if detail.InsurerName == "" || seen[detail.InsurerName] {
+			continue
+		}
+		detail.ID = s.m.newID()
+		detail.AgentUserID = agentUserID
+		kept = append(kept, detail)
+		seen[detail.InsurerName] = true
+	}
+	s.m.details = kept
+	return nil
+}
+
+type memoryProductStore struct{ m *memoryDB }
+
+func (s *memoryProductStore) Create(product Product) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, p := range s.m.products {
+		if p.ID == product.ID {
+			return errDuplicateRecord
+		}
+	}
+	product.CreatedAt = time.Now()
+	s.m.products = append(s.m.products, product)
+	return nil
+}
+
+func (s *memoryProductStore) GetByID(productID string) (*Product, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, p := range s.m.products {
+		if p.ID == productID {
+			return &p, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryProductStore) ListByInsurer(insurerName string) ([]Product, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	want := strings.ToLower(strings.TrimSpace(insurerName))
+	var products []Product
+	for _, p := range s.m.products {
+		if strings.ToLower(strings.TrimSpace(p.Insurer)) == want {
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+func (s *memoryProductStore) ListInsurers() ([]string, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	seen := map[string]bool{}
+	var insurers []string
+	for _, p := range s.m.products {
+		if p.Insurer != "" && !seen[p.Insurer] {
+			seen[p.Insurer] = true
+			insurers = append(insurers, p.Insurer)
+		}
+	}
+	return insurers, nil
+}
+
+// --- Marketing, Notices & Metrics ---
+
+type memoryMarketingStore struct{ m *memoryDB }
+
+func (s *memoryMarketingStore) ListCampaigns(agentUserID int64) ([]MarketingCampaign, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	campaigns := append([]MarketingCampaign(nil), s.m.campaigns...) // not agent-scoped, like the SQL
+	sort.SliceStable(campaigns, func(i, j int) bool { return campaigns[i].CreatedAt.After(campaigns[j].CreatedAt) })
+	return campaigns, nil
+}
+
+func (s *memoryMarketingStore) CreateCampaign(campaign MarketingCampaign) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	campaign.ID = s.m.newID()
+	campaign.CreatedAt = time.Now()
+	s.m.campaigns = append(s.m.campaigns, campaign)
+	return campaign.ID, nil
+}
+
+func (s *memoryMarketingStore) ListTemplates() ([]MarketingTemplate, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	templates := append([]MarketingTemplate(nil), s.m.templates...)
+	sort.SliceStable(templates, func(i, j int) bool {
+		if templates[i].Category != templates[j].Category {
+			return templates[i].Category < templates[j].Category
+		}
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, nil
+}
+
+func (s *memoryMarketingStore) ListContent() ([]MarketingContent, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	contents := append([]MarketingContent(nil), s.m.content...)
+	sort.SliceStable(contents, func(i, j int) bool { return contents[i].CreatedAt.After(contents[j].CreatedAt) })
+	return contents, nil
+}
+
+func (s *memoryMarketingStore) ListSegments(agentUserID int64) ([]ClientSegment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	var segments []ClientSegment
+	for _, seg := range s.m.segments {
+		if seg.AgentUserID == agentUserID {
+			segments = append(segments, seg)
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].Name < segments[j].Name })
+	return segments, nil
+}
+
+func (s *memoryMarketingStore) CreateSegment(segment ClientSegment) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	segment.ID = s.m.newID()
+	segment.CreatedAt = time.Now()
+	s.m.segments = append(s.m.segments, segment)
+	return segment.ID, nil
+}
+
+func (s *memoryMarketingStore) GetSegment(segmentID int64, agentUserID int64) (*ClientSegment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for _, seg := range s.m.segments {
+		if seg.ID == segmentID && seg.AgentUserID == agentUserID {
+			return &seg, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryMarketingStore) UpdateSegment(segment ClientSegment) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, seg := range s.m.segments {
+		if seg.ID == segment.ID && seg.AgentUserID == segment.AgentUserID {
+			s.m.segments[i].Name = segment.Name
+			s.m.segments[i].Criteria = segment.Criteria
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+type memoryNoticeStore struct{ m *memoryDB }
+
+func (s *memoryNoticeStore) List(categoryFilter string) ([]Notice, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	notices := []Notice{}
+	for _, n := range s.m.notices {
+		if categoryFilter != "" && categoryFilter != "All Categories" && n.Category != categoryFilter {
+			continue
+		}
+		notices = append(notices, n)
+	}
+	sort.SliceStable(notices, func(i, j int) bool { return notices[i].CreatedAt.After(notices[j].CreatedAt) })
+	return notices, nil
+}
+
+type memoryMetricsStore struct{ m *memoryDB }
+
+func (s *memoryMetricsStore) Dashboard(agentUserID int64) (*DashboardMetrics, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	metrics := &DashboardMetrics{}
+	now := time.Now()
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	firstOfNextMonth := firstOfMonth.AddDate(0, 1, 0)
+	today := now.Format("2006-01-02")
+	thirtyDaysFromNow := now.AddDate(0, 0, 30).Format("2006-01-02")
+	sevenDaysAgo := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -7)
+
+	for _, p := range s.m.policies {
+		if p.AgentUserID != agentUserID || p.DeletedAt.Valid {
+			continue
+		}
+		if !p.CreatedAt.Before(firstOfMonth) && p.CreatedAt.Before(firstOfNextMonth) {
+			metrics.PoliciesSoldThisMonth++
+			if p.UpfrontCommissionAmount.Valid {
+				metrics.CommissionThisMonth += p.UpfrontCommissionAmount.Float64
+			}
+		}
+		if p.Status == "Active" && p.EndDate.Valid && p.EndDate.String >= today && p.EndDate.String < thirtyDaysFromNow {
+			metrics.UpcomingRenewals30d++
+		}
+	}
+	for _, c := range s.m.clients {
+		if c.AgentUserID == agentUserID && !c.DeletedAt.Valid && c.Status == "Lead" && !c.CreatedAt.Before(sevenDaysAgo) {
+			metrics.NewLeadsThisWeek++
+		}
+	}
+	return metrics, nil
+}
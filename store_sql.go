@@ -0,0 +1,4023 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// --- SQL Stores ---
+
+// newSQLStores wires every store to the given connection pool. Queries are MySQL syntax
+// kept to what SQLite also accepts; the rest goes through the dialect.
+func newSQLStores(db *sql.DB, dialect sqlDialect) Stores {
+	return Stores{
+		Users:           &sqlUserStore{db: db},
+		Tokens:          &sqlTokenStore{db: db, dialect: dialect},
+		Sessions:        &sqlSessionStore{db: db},
+		TwoFactor:       &sqlTwoFactorStore{db: db, dialect: dialect},
+		Agencies:        &sqlAgencyStore{db: db, dialect: dialect},
+		Roles:           &sqlRoleStore{db: db, dialect: dialect},
+		Clients:         &sqlClientStore{db: db},
+		Policies:        &sqlPolicyStore{db: db},
+		Households:      &sqlHouseholdStore{db: db},
+		CustomFields:    &sqlCustomFieldStore{db: db},
+		Changes:         &sqlChangeStore{db: db},
+		Pipeline:        &sqlPipelineStore{db: db},
+		LeadScores:      &sqlLeadScoreStore{db: db, dialect: dialect},
+		Communications:  &sqlCommunicationStore{db: db},
+		Tasks:           &sqlTaskStore{db: db},
+		Suggestions:     &sqlTaskSuggestionStore{db: db},
+		GoalPlans:       &sqlGoalPlanStore{db: db},
+		Recommendations: &sqlAiRecommendationStore{db: db},
+		Documents:       &sqlDocumentStore{db: db},
+		Activity:        &sqlActivityStore{db: db},
+		Outbox:          &sqlOutboxStore{db: db},
+		PortalTokens:    &sqlPortalTokenStore{db: db},
+		Agents:          &sqlAgentStore{db: db, dialect: dialect},
+		Insurers:        &sqlInsurerStore{db: db},
+		Products:        &sqlProductStore{db: db},
+		Marketing:       &sqlMarketingStore{db: db},
+		Notices:         &sqlNoticeStore{db: db},
+		Metrics:         &sqlMetricsStore{db: db},
+	}
+}
+
+// isDuplicateKeyError reports whether err is a unique-constraint violation: MySQL error 1062,
+// or SQLite's "UNIQUE constraint failed" (matched on the message so the driver stays optional).
+func isDuplicateKeyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type sqlUserStore struct{ db *sql.DB }
+
+func (s *sqlUserStore) Create(user User) (int64, error) {
+	stmt, err := s.db.Prepare("INSERT INTO users(email, password_hash, user_type, is_verified) VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert user statement: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(user.Email, user.PasswordHash, user.UserType, user.IsVerified)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert user: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	log.Printf("DATABASE: User created with ID: %d\n", id)
+	return id, nil
+}
+
+func (s *sqlUserStore) GetByEmail(email string) (*User, error) {
+	row := s.db.QueryRow("SELECT id, email, password_hash, user_type, is_verified, created_at, failed_login_attempts, locked_until FROM users WHERE email = ?", email)
+	user := &User{}
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.UserType, &user.IsVerified, &user.CreatedAt, &user.FailedLoginAttempts, &user.LockedUntil)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("ERROR: Failed to scan user row: %v\n", err)
+		} else {
+			log.Printf("DATABASE: User not found: %s\n", email)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *sqlUserStore) GetByID(userID int64) (*User, error) {
+	log.Printf("DATABASE: Getting user by ID: %d\n", userID)
+	row := s.db.QueryRow("SELECT id, email, password_hash, user_type, is_verified, created_at, failed_login_attempts, locked_until FROM users WHERE id = ?", userID)
+	user := &User{}
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.UserType, &user.IsVerified, &user.CreatedAt, &user.FailedLoginAttempts, &user.LockedUntil)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("ERROR: Failed to scan user row for ID %d: %v\n", userID, err)
+		} else {
+			log.Printf("DATABASE: User not found: %d\n", userID)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *sqlUserStore) MarkVerified(userID int64) error {
+	stmt, err := s.db.Prepare("UPDATE users SET is_verified = 1 WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare verify user statement: %w", err)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute verify user: %w", err)
+	}
+	log.Printf("DATABASE: User %d marked as verified\n", userID)
+	return nil
+}
+
+func (s *sqlUserStore) UpdatePassword(userID int64, newPasswordHash string) error {
+	stmt, err := s.db.Prepare("UPDATE users SET password_hash = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare update password statement: %w", err)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(newPasswordHash, userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute update password: %w", err)
+	}
+	log.Printf("DATABASE: Password updated for user %d\n", userID)
+	return nil
+}
+
+func (s *sqlUserStore) RecordFailedLogin(userID int64) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	if _, err = tx.Exec("UPDATE users SET failed_login_attempts = failed_login_attempts + 1 WHERE id = ?", userID); err != nil {
+		return 0, fmt.Errorf("failed to execute record failed login: %w", err)
+	}
+	var failures int
+	if err = tx.QueryRow("SELECT failed_login_attempts FROM users WHERE id = ?", userID).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to scan failed login count: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Failed login %d recorded for user %d\n", failures, userID)
+	return failures, nil
+}
+
+func (s *sqlUserStore) LockUntil(userID int64, until time.Time) error {
+	if _, err := s.db.Exec("UPDATE users SET locked_until = ? WHERE id = ?", until, userID); err != nil {
+		return fmt.Errorf("failed to execute lock user: %w", err)
+	}
+	log.Printf("DATABASE: User %d locked until %s\n", userID, until.Format(time.RFC3339))
+	return nil
+}
+
+func (s *sqlUserStore) ResetFailedLogins(userID int64) error {
+	if _, err := s.db.Exec("UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = ?", userID); err != nil {
+		return fmt.Errorf("failed to execute reset failed logins: %w", err)
+	}
+	return nil
+}
+
+type sqlTokenStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (s *sqlTokenStore) Store(userID int64, token string, purpose string, duration time.Duration) error {
+	selector, verifierHash, ok := splitSelectorToken(token)
+	if !ok {
+		return fmt.Errorf("malformed %s token", purpose)
+	}
+	expiresAt := time.Now().Add(duration)
+	stmt, err := s.db.Prepare("INSERT INTO tokens(selector, user_id, token_hash, purpose, expires_at) VALUES(?, ?, ?, ?, ?) " +
+		s.dialect.upsertClause([]string{"user_id", "purpose"}, "selector", "token_hash", "expires_at"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare store token statement: %w", err)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(selector, userID, verifierHash, purpose, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to execute store token: %w", err)
+	}
+	log.Printf("DATABASE: Token stored successfully for user %d, purpose %s\n", userID, purpose)
+	return nil
+}
+
+func (s *sqlTokenStore) Verify(token string, purpose string) (int64, error) {
+	selector, verifierHash, ok := splitSelectorToken(token)
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	var userID int64
+	var storedHash string
+	err := s.db.QueryRow("SELECT user_id, token_hash FROM tokens WHERE selector = ? AND purpose = ? AND expires_at > ?", selector, purpose, time.Now()).
+		Scan(&userID, &storedHash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return 0, fmt.Errorf("failed to scan token row: %w", err)
+		}
+		log.Printf("DATABASE: Token not found or expired\n")
+		return 0, sql.ErrNoRows
+	}
+	if !verifierMatches(verifierHash, storedHash) {
+		log.Printf("DATABASE: Token verifier mismatch for user %d\n", userID)
+		return 0, sql.ErrNoRows
+	}
+	log.Printf("DATABASE: Token verified for user ID %d\n", userID)
+	return userID, nil
+}
+
+func (s *sqlTokenStore) DeleteByUserID(userID int64, purpose string) error {
+	stmt, err := s.db.Prepare("DELETE FROM tokens WHERE user_id = ? AND purpose = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare delete token statement: %w", err)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(userID, purpose)
+	if err != nil {
+		return fmt.Errorf("failed to execute delete token: %w", err)
+	}
+	log.Printf("DATABASE: Token deleted for user %d, purpose %s\n", userID, purpose)
+	return nil
+}
+
+type sqlSessionStore struct{ db *sql.DB }
+
+const sessionColumns = `id, user_id, refresh_token_hash, previous_token_hash, device_name, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at`
+
+func scanSession(scanner rowScanner) (*Session, error) {
+	sess := &Session{}
+	err := scanner.Scan(&sess.ID, &sess.UserID, &sess.RefreshTokenHash, &sess.PreviousTokenHash, &sess.DeviceName, &sess.UserAgent,
+		&sess.IPAddress, &sess.CreatedAt, &sess.LastUsedAt, &sess.ExpiresAt, &sess.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (s *sqlSessionStore) Create(session Session) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO sessions (user_id, refresh_token_hash, device_name, user_agent, ip_address, last_used_at, expires_at)
+                           VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.UserID, session.RefreshTokenHash, session.DeviceName, session.UserAgent, session.IPAddress, session.LastUsedAt, session.ExpiresAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert session: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID for session: %w", err)
+	}
+	log.Printf("DATABASE: Session %d created for user %d\n", id, session.UserID)
+	return id, nil
+}
+
+func (s *sqlSessionStore) GetByID(sessionID int64) (*Session, error) {
+	sess, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, sessionID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to scan session %d: %w", sessionID, err)
+	}
+	return sess, nil
+}
+
+func (s *sqlSessionStore) Rotate(oldHash, newHash string, now, expiresAt time.Time, userAgent, ipAddress string) (*Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	res, err := tx.Exec(`UPDATE sessions SET refresh_token_hash = ?, previous_token_hash = ?, last_used_at = ?, expires_at = ?, user_agent = ?, ip_address = ?
+                         WHERE refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?`,
+		newHash, oldHash, now, expiresAt, sql.NullString{String: userAgent, Valid: userAgent != ""}, sql.NullString{String: ipAddress, Valid: ipAddress != ""},
+		oldHash, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate session token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		res, err = tx.Exec(`UPDATE sessions SET revoked_at = ? WHERE previous_token_hash = ? AND revoked_at IS NULL`, now, oldHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke session after token reuse: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, sql.ErrNoRows
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, errRefreshTokenReused
+	}
+	sess, err := scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE refresh_token_hash = ?`, newHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan rotated session: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return sess, nil
+}
+
+func (s *sqlSessionStore) ListActive(userID int64, now time.Time) ([]Session, error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+                             ORDER BY last_used_at DESC, id DESC`, userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+	sessions := []Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			log.Printf("ERROR: Scan session row failed: %v", err)
+			continue
+		}
+		sessions = append(sessions, *sess)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *sqlSessionStore) Revoke(sessionID int64, userID int64, now time.Time) error {
+	res, err := s.db.Exec(`UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`, now, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session %d: %w", sessionID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("DATABASE: Session %d revoked for user %d\n", sessionID, userID)
+	return nil
+}
+
+func (s *sqlSessionStore) RevokeAll(userID int64, exceptSessionID int64, now time.Time) (int, error) {
+	res, err := s.db.Exec(`UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL`, now, userID, exceptSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions for user %d: %w", userID, err)
+	}
+	n, _ := res.RowsAffected()
+	log.Printf("DATABASE: Revoked %d sessions for user %d\n", n, userID)
+	return int(n), nil
+}
+
+type sqlTwoFactorStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (s *sqlTwoFactorStore) Get(userID int64) (*TwoFactor, error) {
+	tf := &TwoFactor{}
+	err := s.db.QueryRow(`SELECT user_id, secret, is_enabled, last_used_step, enabled_at, created_at FROM two_factor WHERE user_id = ?`, userID).
+		Scan(&tf.UserID, &tf.Secret, &tf.IsEnabled, &tf.LastUsedStep, &tf.EnabledAt, &tf.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to scan 2FA for user %d: %w", userID, err)
+	}
+	return tf, nil
+}
+
+func (s *sqlTwoFactorStore) SavePending(userID int64, secret string) error {
+	_, err := s.db.Exec(`INSERT INTO two_factor (user_id, secret, is_enabled, last_used_step, enabled_at) VALUES (?, ?, ?, ?, ?) `+
+		s.dialect.upsertClause([]string{"user_id"}, "secret", "is_enabled", "last_used_step", "enabled_at"),
+		userID, secret, false, 0, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save pending 2FA secret: %w", err)
+	}
+	log.Printf("DATABASE: Pending 2FA secret saved for user %d\n", userID)
+	return nil
+}
+
+// insertRecoveryCodes replaces the user's recovery codes within tx.
+func insertRecoveryCodes(tx *sql.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM two_factor_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO two_factor_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlTwoFactorStore) Enable(userID int64, step int64, recoveryCodeHashes []string, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	res, err := tx.Exec(`UPDATE two_factor SET is_enabled = ?, enabled_at = ?, last_used_step = ? WHERE user_id = ? AND is_enabled = ?`, true, now, step, userID, false)
+	if err != nil {
+		return fmt.Errorf("failed to enable 2FA: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err = insertRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: 2FA enabled for user %d\n", userID)
+	return nil
+}
+
+func (s *sqlTwoFactorStore) Disable(userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	if _, err = tx.Exec(`DELETE FROM two_factor_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err = tx.Exec(`DELETE FROM two_factor WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete 2FA: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: 2FA disabled for user %d\n", userID)
+	return nil
+}
+
+func (s *sqlTwoFactorStore) UseStep(userID int64, step int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE two_factor SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`, step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record 2FA step: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *sqlTwoFactorStore) UseRecoveryCode(userID int64, codeHash string, now time.Time) (bool, error) {
+	res, err := s.db.Exec(`UPDATE two_factor_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`, now, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		log.Printf("DATABASE: Recovery code used by user %d\n", userID)
+	}
+	return n > 0, nil
+}
+
+func (s *sqlTwoFactorStore) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	if err = insertRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Recovery codes replaced for user %d\n", userID)
+	return nil
+}
+
+func (s *sqlTwoFactorStore) RecoveryCodesRemaining(userID int64) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM two_factor_recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return n, nil
+}
+
+type sqlAgencyStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (s *sqlAgencyStore) GetSettings(agencyUserID int64) (*AgencySettings, error) {
+	settings := &AgencySettings{AgencyUserID: agencyUserID, DeparturePolicy: departurePolicyKeep}
+	err := s.db.QueryRow(`SELECT require_two_factor, departure_policy, updated_at FROM agency_settings WHERE agency_user_id = ?`, agencyUserID).
+		Scan(&settings.RequireTwoFactor, &settings.DeparturePolicy, &settings.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to scan agency settings for %d: %w", agencyUserID, err)
+	}
+	return settings, nil
+}
+
+func (s *sqlAgencyStore) UpdateSettings(settings AgencySettings) error {
+	_, err := s.db.Exec(`INSERT INTO agency_settings (agency_user_id, require_two_factor, departure_policy, updated_at) VALUES (?, ?, ?, ?) `+
+		s.dialect.upsertClause([]string{"agency_user_id"}, "require_two_factor", "departure_policy", "updated_at"),
+		settings.AgencyUserID, settings.RequireTwoFactor, settings.DeparturePolicy, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to execute upsert agency settings: %w", err)
+	}
+	log.Printf("DATABASE: Agency settings saved for agency %d\n", settings.AgencyUserID)
+	return nil
+}
+
+func (s *sqlAgencyStore) AddMember(agencyUserID, agentUserID int64) error {
+	_, err := s.db.Exec(`INSERT INTO agency_members (agent_user_id, agency_user_id) VALUES (?, ?) `+
+		s.dialect.upsertClause([]string{"agent_user_id"}, "agency_user_id"), agentUserID, agencyUserID)
+	if err != nil {
+		return fmt.Errorf("failed to add agent %d to agency %d: %w", agentUserID, agencyUserID, err)
+	}
+	log.Printf("DATABASE: Agent %d added to agency %d\n", agentUserID, agencyUserID)
+	return nil
+}
+
+func (s *sqlAgencyStore) RequiresTwoFactor(agentUserID int64) (bool, error) {
+	var required bool
+	err := s.db.QueryRow(`SELECT st.require_two_factor FROM agency_members m
+                          JOIN agency_settings st ON st.agency_user_id = m.agency_user_id
+                          WHERE m.agent_user_id = ?`, agentUserID).Scan(&required)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check agency 2FA requirement for agent %d: %w", agentUserID, err)
+	}
+	return required, nil
+}
+
+// agencyMemberColumns go with agencyMemberJoins, which add the member's email and role.
+const agencyMemberColumns = `m.agent_user_id, m.agency_user_id, u.email, COALESCE(ur.role, '` + roleAgent + `'), m.created_at`
+const agencyMemberJoins = `JOIN users u ON u.id = m.agent_user_id LEFT JOIN user_roles ur ON ur.user_id = m.agent_user_id`
+
+func scanAgencyMember(scanner rowScanner) (*AgencyMember, error) {
+	member := &AgencyMember{}
+	if err := scanner.Scan(&member.AgentUserID, &member.AgencyUserID, &member.Email, &member.Role, &member.JoinedAt); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (s *sqlAgencyStore) AgencyOf(agentUserID int64) (*AgencyMember, error) {
+	member, err := scanAgencyMember(s.db.QueryRow(`SELECT `+agencyMemberColumns+` FROM agency_members m `+agencyMemberJoins+`
+                                                 WHERE m.agent_user_id = ?`, agentUserID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to scan agency membership for agent %d: %w", agentUserID, err)
+	}
+	return member, nil
+}
+
+func (s *sqlAgencyStore) ListMembers(agencyUserID int64) ([]AgencyMember, error) {
+	rows, err := s.db.Query(`SELECT `+agencyMemberColumns+` FROM agency_members m `+agencyMemberJoins+`
+                             WHERE m.agency_user_id = ? ORDER BY u.email`, agencyUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agency members: %w", err)
+	}
+	defer rows.Close()
+	members := []AgencyMember{}
+	for rows.Next() {
+		member, err := scanAgencyMember(rows)
+		if err != nil {
+			log.Printf("ERROR: Scan agency member row failed: %v", err)
+			continue
+		}
+		members = append(members, *member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// clientRecordTables hold everything filed under a client, each with client_id and the owning
+// agent_user_id.
+var clientRecordTables = []string{"policies", "communications", "tasks", "documents", "client_portal_tokens", "task_suggestions", "ai_recommendations",
+	"entity_changes", "client_stages", "client_lead_scores"}
+
+// trashedClientTables are the client records that go to the trash along with their client.
+var trashedClientTables = []string{"policies", "communications", "tasks", "documents"}
+
+// agentBookTables hold an agent's clients, everything filed under them and their households.
+var agentBookTables = append([]string{"clients", "households"}, clientRecordTables...)
+
+func (s *sqlAgencyStore) RemoveMember(agencyUserID, agentUserID, transferTo int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	res, err := tx.Exec(`DELETE FROM agency_members WHERE agent_user_id = ? AND agency_user_id = ?`, agentUserID, agencyUserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete agency member: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	// A role the agency gave the agent ends with the membership
+	if _, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = ?`, agentUserID); err != nil {
+		return fmt.Errorf("failed to delete role of agent %d: %w", agentUserID, err)
+	}
+	if transferTo != 0 {
+		for _, table := range agentBookTables {
+			if _, err := tx.Exec(`UPDATE `+table+` SET agent_user_id = ? WHERE agent_user_id = ?`, transferTo, agentUserID); err != nil {
+				if isDuplicateKeyError(err) {
+					return errDuplicateRecord
+				}
+				return fmt.Errorf("failed to transfer %s from agent %d: %w", table, agentUserID, err)
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Agent %d removed from agency %d (transfer to %d)\n", agentUserID, agencyUserID, transferTo)
+	return nil
+}
+
+const agencyInvitationColumns = `i.id, i.agency_user_id, i.email, i.status, i.expires_at, i.created_at, i.responded_at`
+
+func scanAgencyInvitation(scanner rowScanner, extra ...interface{}) (*AgencyInvitation, error) {
+	inv := &AgencyInvitation{}
+	dest := append([]interface{}{&inv.ID, &inv.AgencyUserID, &inv.Email, &inv.Status, &inv.ExpiresAt, &inv.CreatedAt, &inv.RespondedAt}, extra...)
+	if err := scanner.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (s *sqlAgencyStore) CreateInvitation(invitation AgencyInvitation) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	var pending int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM agency_invitations WHERE agency_user_id = ? AND email = ? AND status = ? AND expires_at > ?`,
+		invitation.AgencyUserID, invitation.Email, invitationPending, time.Now()).Scan(&pending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check pending invitations: %w", err)
+	}
+	if pending > 0 {
+		return 0, errDuplicateRecord
+	}
+	res, err := tx.Exec(`INSERT INTO agency_invitations (agency_user_id, email, status, expires_at) VALUES (?, ?, ?, ?)`,
+		invitation.AgencyUserID, invitation.Email, invitationPending, invitation.ExpiresAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert agency invitation: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID for agency invitation: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Agency invitation %d created by agency %d\n", id, invitation.AgencyUserID)
+	return id, nil
+}
+
+func (s *sqlAgencyStore) queryInvitations(query string, args ...interface{}) ([]AgencyInvitation, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agency invitations: %w", err)
+	}
+	defer rows.Close()
+	invitations := []AgencyInvitation{}
+	for rows.Next() {
+		var agencyEmail sql.NullString
+		inv, err := scanAgencyInvitation(rows, &agencyEmail)
+		if err != nil {
+			log.Printf("ERROR: Scan agency invitation row failed: %v", err)
+			continue
+		}
+		inv.AgencyEmail = agencyEmail.String
+		invitations = append(invitations, *inv)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (s *sqlAgencyStore) ListInvitations(agencyUserID int64) ([]AgencyInvitation, error) {
+	return s.queryInvitations(`SELECT `+agencyInvitationColumns+`, NULL FROM agency_invitations i
+                               WHERE i.agency_user_id = ? ORDER BY i.created_at DESC, i.id DESC`, agencyUserID)
+}
+
+func (s *sqlAgencyStore) ListInvitationsForEmail(email string, now time.Time) ([]AgencyInvitation, error) {
+	return s.queryInvitations(`SELECT `+agencyInvitationColumns+`, u.email FROM agency_invitations i JOIN users u ON u.id = i.agency_user_id
+                               WHERE i.email = ? AND i.status = ? AND i.expires_at > ? ORDER BY i.created_at DESC, i.id DESC`,
+		email, invitationPending, now)
+}
+
+func (s *sqlAgencyStore) RevokeInvitation(invitationID, agencyUserID int64, now time.Time) error {
+	res, err := s.db.Exec(`UPDATE agency_invitations SET status = ?, responded_at = ? WHERE id = ? AND agency_user_id = ? AND status = ?`,
+		invitationRevoked, now, invitationID, agencyUserID, invitationPending)
+	if err != nil {
+		return fmt.Errorf("failed to revoke agency invitation %d: %w", invitationID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("DATABASE: Agency invitation %d revoked\n", invitationID)
+	return nil
+}
+
+func (s *sqlAgencyStore) RespondToInvitation(invitationID int64, email string, agentUserID int64, accept bool, now time.Time) (*AgencyInvitation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	status := invitationDeclined
+	if accept {
+		status = invitationAccepted
+	}
+	res, err := tx.Exec(`UPDATE agency_invitations SET status = ?, responded_at = ? WHERE id = ? AND email = ? AND status = ? AND expires_at > ?`,
+		status, now, invitationID, email, invitationPending, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to respond to agency invitation %d: %w", invitationID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	inv, err := scanAgencyInvitation(tx.QueryRow(`SELECT `+agencyInvitationColumns+` FROM agency_invitations i WHERE i.id = ?`, invitationID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan agency invitation %d: %w", invitationID, err)
+	}
+	if accept {
+		_, err = tx.Exec(`INSERT INTO agency_members (agent_user_id, agency_user_id) VALUES (?, ?)`, agentUserID, inv.AgencyUserID)
+		if isDuplicateKeyError(err) {
+			return nil, errAgencyMembership
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to add agent %d to agency %d: %w", agentUserID, inv.AgencyUserID, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Agency invitation %d %s by agent %d\n", invitationID, status, agentUserID)
+	return inv, nil
+}
+
+type sqlRoleStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (s *sqlRoleStore) ListRoles() ([]Role, error) {
+	rows, err := s.db.Query(`SELECT r.name, r.description, rp.permission FROM roles r
+                             LEFT JOIN role_permissions rp ON rp.role = r.name ORDER BY r.name, rp.permission`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %w", err)
+	}
+	defer rows.Close()
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		var permission sql.NullString
+		if err := rows.Scan(&role.Name, &role.Description, &permission); err != nil {
+			log.Printf("ERROR: Scan role row failed: %v", err)
+			continue
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != role.Name {
+			role.Permissions = []string{}
+			roles = append(roles, role)
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (s *sqlRoleStore) Permissions(role string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT permission FROM role_permissions WHERE role = ? ORDER BY permission`, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions of role %s: %w", role, err)
+	}
+	defer rows.Close()
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+func (s *sqlRoleStore) UserRole(userID int64) (string, error) {
+	var role string
+	err := s.db.QueryRow(`SELECT role FROM user_roles WHERE user_id = ?`, userID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", sql.ErrNoRows
+		}
+		return "", fmt.Errorf("failed to scan role of user %d: %w", userID, err)
+	}
+	return role, nil
+}
+
+func (s *sqlRoleStore) AssignRole(userID int64, role string, assignedBy int64) error {
+	_, err := s.db.Exec(`INSERT INTO user_roles (user_id, role, assigned_by, updated_at) VALUES (?, ?, ?, ?) `+
+		s.dialect.upsertClause([]string{"user_id"}, "role", "assigned_by", "updated_at"),
+		userID, role, assignedBy, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to execute upsert user role: %w", err)
+	}
+	log.Printf("DATABASE: User %d assigned role %s by user %d\n", userID, role, assignedBy)
+	return nil
+}
+
+type sqlClientStore struct{ db *sql.DB }
+
+const clientColumns = `id, agent_user_id, name, email, phone, dob, address, status, tags, last_contacted_at, created_at,
+        income, marital_status, city, job_profile, dependents, liability, housing_type,
+        vehicle_count, vehicle_type, vehicle_cost, version, updated_at,
+        lead_source, campaign, utm_source, utm_medium, utm_term, utm_content, referred_by_client_id`
+
+func scanClient(scanner rowScanner) (*Client, error) {
+	client := &Client{}
+	err := scanner.Scan(
+		&client.ID, &client.AgentUserID, &client.Name, &client.Email, &client.Phone, &client.Dob, &client.Address,
+		&client.Status, &client.Tags, &client.LastContactedAt, &client.CreatedAt,
+		&client.Income, &client.MaritalStatus, &client.City, &client.JobProfile, &client.Dependents,
+		&client.Liability, &client.HousingType, &client.VehicleCount, &client.VehicleType, &client.VehicleCost,
+		&client.Version, &client.UpdatedAt,
+		&client.LeadSource, &client.Campaign, &client.UTMSource, &client.UTMMedium, &client.UTMTerm, &client.UTMContent, &client.ReferredByClientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// clientUpdateSet goes with clientUpdateArgs. It moves the client to its next version.
+const clientUpdateSet = `name = ?, email = ?, phone = ?, dob = ?, address = ?, status = ?, tags = ?,
+        income = ?, marital_status = ?, city = ?, job_profile = ?, dependents = ?, liability = ?, housing_type = ?,
+        vehicle_count = ?, vehicle_type = ?, vehicle_cost = ?, version = version + 1, updated_at = ?`
+
+func clientUpdateArgs(client Client) []interface{} {
+	return []interface{}{
+		client.Name, client.Email, client.Phone, client.Dob, client.Address, client.Status, client.Tags,
+		client.Income, client.MaritalStatus, client.City, client.JobProfile, client.Dependents, client.Liability, client.HousingType,
+		client.VehicleCount, client.VehicleType, client.VehicleCost, time.Now(),
+	}
+}
+
+// customFieldValueColumns are the custom_field_values columns holding the record of each entity.
+var customFieldValueColumns = map[string]string{customFieldEntityClient: "client_id", customFieldEntityPolicy: "policy_id"}
+
+// visibleCustomFields matches the definitions d a user sees; it takes the user's ID twice.
+const visibleCustomFields = `(d.owner_user_id = ? OR d.owner_user_id IN (SELECT agency_user_id FROM agency_members WHERE agent_user_id = ?))`
+
+// saveCustomFieldValues saves values, by field key, for the entity's record. "" deletes a value;
+// keys of fields the agent doesn't see are skipped.
+func saveCustomFieldValues(tx *sql.Tx, entity string, recordID interface{}, agentUserID int64, values map[string]string) error {
+	column := customFieldValueColumns[entity]
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		_, err := tx.Exec(`DELETE FROM custom_field_values WHERE `+column+` = ? AND field_id IN (
+                           SELECT d.id FROM custom_field_definitions d WHERE d.entity = ? AND d.field_key = ?)`, recordID, entity, key)
+		if err != nil {
+			return fmt.Errorf("failed to clear custom field '%s': %w", key, err)
+		}
+		if values[key] == "" {
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO custom_field_values (field_id, `+column+`, value)
+                          SELECT d.id, ?, ? FROM custom_field_definitions d WHERE d.entity = ? AND d.field_key = ? AND `+visibleCustomFields,
+			recordID, values[key], entity, key, agentUserID, agentUserID)
+		if err != nil {
+			return fmt.Errorf("failed to save custom field '%s': %w", key, err)
+		}
+	}
+	return nil
+}
+
+// loadCustomFieldValues returns the custom field values of the entity's records, by record ID
+// (as fmt.Sprint gives it) and field key.
+func loadCustomFieldValues(db *sql.DB, entity string, recordIDs []interface{}) (map[string]map[string]string, error) {
+	values := map[string]map[string]string{}
+	if len(recordIDs) == 0 {
+		return values, nil
+	}
+	column := customFieldValueColumns[entity]
+	rows, err := db.Query(`SELECT v.`+column+`, d.field_key, v.value FROM custom_field_values v
+                           JOIN custom_field_definitions d ON d.id = v.field_id
+                           WHERE v.`+column+` IN (?`+strings.Repeat(", ?", len(recordIDs)-1)+`)`, recordIDs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query custom field values: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var recordID, key, value string
+		if err := rows.Scan(&recordID, &key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan custom field value: %w", err)
+		}
+		if values[recordID] == nil {
+			values[recordID] = map[string]string{}
+		}
+		values[recordID][key] = value
+	}
+	return values, rows.Err()
+}
+
+// attachClientCustomFields loads the custom field values of clients into them.
+func attachClientCustomFields(db *sql.DB, clients []Client) error {
+	ids := make([]interface{}, len(clients))
+	for i, client := range clients {
+		ids[i] = client.ID
+	}
+	values, err := loadCustomFieldValues(db, customFieldEntityClient, ids)
+	if err != nil {
+		return err
+	}
+	for i := range clients {
+		clients[i].CustomFields = values[fmt.Sprint(clients[i].ID)]
+		if clients[i].CustomFields == nil {
+			clients[i].CustomFields = map[string]string{}
+		}
+	}
+	return nil
+}
+
+// attachPolicyCustomFields loads the custom field values of policies into them.
+func attachPolicyCustomFields(db *sql.DB, policies []Policy) error {
+	ids := make([]interface{}, len(policies))
+	for i, policy := range policies {
+		ids[i] = policy.ID
+	}
+	values, err := loadCustomFieldValues(db, customFieldEntityPolicy, ids)
+	if err != nil {
+		return err
+	}
+	for i := range policies {
+		policies[i].CustomFields = values[policies[i].ID]
+		if policies[i].CustomFields == nil {
+			policies[i].CustomFields = map[string]string{}
+		}
+	}
+	return nil
+}
+
+func (s *sqlClientStore) Create(client Client) (int64, error) {
+	log.Printf("DATABASE: Creating client '%s' for agent %d\n", client.Name, client.AgentUserID)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO clients (
+        agent_user_id, name, email, phone, dob, address, status, tags, last_contacted_at,
+        income, marital_status, city, job_profile, dependents, liability, housing_type,
+        vehicle_count, vehicle_type, vehicle_cost, created_at,
+        lead_source, campaign, utm_source, utm_medium, utm_term, utm_content, referred_by_client_id
+        ) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert client statement: %w", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(
+		client.AgentUserID, client.Name, client.Email, client.Phone, client.Dob, client.Address,
+		client.Status, client.Tags, client.LastContactedAt,
+		client.Income, client.MaritalStatus, client.City, client.JobProfile, client.Dependents,
+		client.Liability, client.HousingType, client.VehicleCount, client.VehicleType, client.VehicleCost,
+		time.Now(), // Set created_at
+		client.LeadSource, client.Campaign, client.UTMSource, client.UTMMedium, client.UTMTerm, client.UTMContent, client.ReferredByClientID,
+	)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return 0, errDuplicateRecord
+		}
+		return 0, fmt.Errorf("failed to execute insert client: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	if err := saveCustomFieldValues(tx, customFieldEntityClient, id, client.AgentUserID, client.CustomFields); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Client created with ID: %d\n", id)
+	return id, nil
+}
+
+func (s *sqlClientStore) GetByID(clientID int64, agentUserID int64) (*Client, error) {
+	log.Printf("DATABASE: Getting client ID %d for agent %d\n", clientID, agentUserID)
+	client, err := scanClient(s.db.QueryRow(`SELECT `+clientColumns+`
+        FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`, clientID, agentUserID))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("ERROR: Failed to scan client row: %v\n", err)
+		} else {
+			log.Printf("DATABASE: Client %d not found or not owned by agent %d\n", clientID, agentUserID)
+		}
+		return nil, err
+	}
+	clients := []Client{*client}
+	if err := attachClientCustomFields(s.db, clients); err != nil {
+		return nil, err
+	}
+	return &clients[0], nil
+}
+
+func (s *sqlClientStore) Update(clientID int64, agentUserID int64, client Client, version int64) error {
+	log.Printf("DATABASE: Updating client ID %d for agent %d\n", clientID, agentUserID)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`UPDATE clients SET ` + clientUpdateSet + `
+        WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare update client statement: %w", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(append(clientUpdateArgs(client), clientID, agentUserID, version, version)...)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return errDuplicateRecord
+		}
+		return fmt.Errorf("failed to execute update client: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		// Wrong ID or agent, or the client moved past version
+		var current int64
+		err := tx.QueryRow(`SELECT version FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`, clientID, agentUserID).Scan(&current)
+		if err != nil {
+			return err
+		}
+		return errVersionConflict
+	}
+	if err := saveCustomFieldValues(tx, customFieldEntityClient, clientID, agentUserID, client.CustomFields); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Client %d updated successfully by agent %d\n", clientID, agentUserID)
+	return nil
+}
+
+func (s *sqlClientStore) List(agentUserID int64, statusFilter, searchTerm string, customFields map[string]string, sortBy string, limit, offset int) ([]Client, error) {
+	query := `SELECT id, agent_user_id, name, email, phone, dob, address, status, tags, last_contacted_at, created_at, version, updated_at,
+                     (SELECT score FROM client_lead_scores ls WHERE ls.client_id = clients.id) AS lead_score
+              FROM clients WHERE agent_user_id = ? AND deleted_at IS NULL`
+	args := []interface{}{agentUserID}
+	if statusFilter != "" && statusFilter != "All Statuses" {
+		query += " AND status = ?"
+		args = append(args, statusFilter)
+	} else {
+		query += " AND status <> ?"
+		args = append(args, clientStatusArchived)
+	}
+	if searchTerm != "" {
+		query += " AND (name LIKE ? OR email LIKE ? OR phone LIKE ?)"
+		term := "%" + searchTerm + "%"
+		args = append(args, term, term, term)
+	}
+	for _, key := range slices.Sorted(maps.Keys(customFields)) {
+		query += ` AND id IN (SELECT v.client_id FROM custom_field_values v JOIN custom_field_definitions d ON d.id = v.field_id
+                   WHERE d.entity = ? AND d.field_key = ? AND v.value = ?)`
+		args = append(args, customFieldEntityClient, key, customFields[key])
+	}
+	if sortBy == clientSortScore {
+		query += " ORDER BY lead_score DESC, created_at DESC LIMIT ? OFFSET ?" // NULLs, the unscored, sort last
+	} else {
+		query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+	}
+	args = append(args, limit, offset)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("ERROR: Failed to query clients: %v\n", err)
+		return nil, fmt.Errorf("database query error")
+	}
+	defer rows.Close()
+	clients := []Client{}
+	for rows.Next() {
+		var c Client
+		if err := rows.Scan(&c.ID, &c.AgentUserID, &c.Name, &c.Email, &c.Phone, &c.Dob, &c.Address, &c.Status, &c.Tags, &c.LastContactedAt, &c.CreatedAt, &c.Version, &c.UpdatedAt, &c.LeadScore); err != nil {
+			log.Printf("ERROR: Failed to scan client row: %v\n", err)
+			continue
+		}
+		clients = append(clients, c)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("ERROR: Error iterating client rows: %v\n", err)
+		return nil, fmt.Errorf("database iteration error")
+	}
+	if err := attachClientCustomFields(s.db, clients); err != nil {
+		return nil, err
+	}
+	log.Printf("DATABASE: Found %d clients for agent %d.\n", len(clients), agentUserID)
+	return clients, nil
+}
+
+func (s *sqlClientStore) ListSummaries(agentUserID int64) (clients []Client, err error) {
+	rows, err := s.db.Query(`SELECT id, name, status, agent_user_id FROM clients WHERE agent_user_id = ? AND deleted_at IS NULL`, agentUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clientList []Client
+
+	for rows.Next() {
+		var c Client
+		if err := rows.Scan(&c.ID, &c.Name, &c.Status, &c.AgentUserID); err != nil {
+			log.Printf("WARN: Error scanning client: %v", err)
+			continue
+		}
+		clientList = append(clientList, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clientList, nil
+}
+
+func (s *sqlClientStore) CreateBatch(clients []Client) (int, error) {
+	log.Printf("DATABASE: Bulk inserting %d clients\n", len(clients))
+	tx, err := s.db.Begin()
+	if err != nil {
+		return -1, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	stmt, err := tx.Prepare(`INSERT INTO clients (
+		agent_user_id, name, email, phone, dob, address, status, tags,
+		income, marital_status, city, job_profile, dependents, liability, housing_type,
+		vehicle_count, vehicle_type, vehicle_cost, created_at,
+		lead_source, campaign, utm_source, utm_medium, utm_term, utm_content, referred_by_client_id
+		) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return -1, fmt.Errorf("failed to prepare bulk insert client statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, client := range clients {
+		res, err := stmt.Exec(
+			client.AgentUserID, client.Name, client.Email, client.Phone, client.Dob, client.Address,
+			client.Status, client.Tags, client.Income, client.MaritalStatus, client.City,
+			client.JobProfile, client.Dependents, client.Liability, client.HousingType,
+			client.VehicleCount, client.VehicleType, client.VehicleCost, client.CreatedAt,
+			client.LeadSource, client.Campaign, client.UTMSource, client.UTMMedium, client.UTMTerm, client.UTMContent, client.ReferredByClientID,
+		)
+		if err != nil {
+			if isDuplicateKeyError(err) {
+				return i, errDuplicateRecord
+			}
+			return i, fmt.Errorf("failed to execute bulk insert client: %w", err)
+		}
+		if clients[i].ID, err = res.LastInsertId(); err != nil {
+			return i, fmt.Errorf("failed to get last insert ID: %w", err)
+		}
+		if err := saveCustomFieldValues(tx, customFieldEntityClient, clients[i].ID, client.AgentUserID, client.CustomFields); err != nil {
+			return i, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return -1, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return 0, nil
+}
+
+func (s *sqlClientStore) ListIDs(agentUserID int64) ([]int64, error) {
+	rows, err := s.db.Query("SELECT id FROM clients WHERE agent_user_id = ? AND deleted_at IS NULL ORDER BY name ASC", agentUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clientIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("ERROR: Failed to scan client ID for agent %d: %v", agentUserID, err)
+			continue
+		}
+		clientIDs = append(clientIDs, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return clientIDs, nil
+}
+
+func (s *sqlClientStore) ListAll(agentUserID int64) ([]Client, error) {
+	rows, err := s.db.Query(`SELECT `+clientColumns+` FROM clients WHERE agent_user_id = ? AND deleted_at IS NULL ORDER BY id`, agentUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clients of agent %d: %w", agentUserID, err)
+	}
+	defer rows.Close()
+	clients := []Client{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan client: %w", err)
+		}
+		clients = append(clients, *client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return clients, attachClientCustomFields(s.db, clients)
+}
+
+// mergedClientTables are the client records a merge moves to the surviving client. The merged
+// client's AI recommendations are dropped instead: they were generated from its old details.
+var mergedClientTables = []string{"policies", "communications", "tasks", "documents", "client_portal_tokens", "task_suggestions"}
+
+func (s *sqlClientStore) Merge(survivor Client, duplicateID int64, merge ClientMerge) (*ClientMerge, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var id int64
+	err = tx.QueryRow(`SELECT id FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`, duplicateID, survivor.AgentUserID).Scan(&id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to look up client %d: %w", duplicateID, err)
+		}
+		return nil, err
+	}
+	merge.RecordsMoved = 0
+	for _, table := range mergedClientTables {
+		res, err := tx.Exec(`UPDATE `+table+` SET client_id = ? WHERE client_id = ?`, survivor.ID, duplicateID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to move %s of client %d: %w", table, duplicateID, err)
+		}
+		n, _ := res.RowsAffected()
+		merge.RecordsMoved += int(n)
+	}
+	// The survivor takes the duplicate's place in its household unless it has one of its own, in
+	// which case the duplicate stays there as a plain member (ON DELETE SET NULL).
+	var memberships int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM household_members WHERE client_id = ?`, survivor.ID).Scan(&memberships); err != nil {
+		return nil, fmt.Errorf("failed to look up household of client %d: %w", survivor.ID, err)
+	}
+	if memberships == 0 {
+		if _, err := tx.Exec(`UPDATE household_members SET client_id = ? WHERE client_id = ?`, survivor.ID, duplicateID); err != nil {
+			return nil, fmt.Errorf("failed to move household membership of client %d: %w", duplicateID, err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE entity_changes SET client_id = ? WHERE client_id = ?`, survivor.ID, duplicateID); err != nil {
+		return nil, fmt.Errorf("failed to move change history of client %d: %w", duplicateID, err)
+	}
+	// The duplicate's custom field values fill in the survivor's blanks; the rest go with it.
+	_, err = tx.Exec(`UPDATE custom_field_values SET client_id = ? WHERE client_id = ? AND field_id NOT IN (
+                          SELECT field_id FROM (SELECT field_id FROM custom_field_values WHERE client_id = ?) AS taken)`, survivor.ID, duplicateID, survivor.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move custom field values of client %d: %w", duplicateID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM ai_recommendations WHERE client_id = ?`, duplicateID); err != nil {
+		return nil, fmt.Errorf("failed to delete AI recommendations of client %d: %w", duplicateID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM client_lead_scores WHERE client_id = ?`, duplicateID); err != nil {
+		return nil, fmt.Errorf("failed to delete lead score of client %d: %w", duplicateID, err)
+	}
+	// The duplicate's referrals go to the survivor, unless it is the one referred.
+	_, err = tx.Exec(`UPDATE clients SET referred_by_client_id = CASE WHEN id = ? THEN NULL ELSE ? END WHERE referred_by_client_id = ?`,
+		survivor.ID, survivor.ID, duplicateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move referrals of client %d: %w", duplicateID, err)
+	}
+	// Deleted before the survivor is updated, so the survivor can take its email or phone.
+	if _, err := tx.Exec(`DELETE FROM clients WHERE id = ?`, duplicateID); err != nil {
+		return nil, fmt.Errorf("failed to delete merged client %d: %w", duplicateID, err)
+	}
+	res, err := tx.Exec(`UPDATE clients SET `+clientUpdateSet+` WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`,
+		append(clientUpdateArgs(survivor), survivor.ID, survivor.AgentUserID)...)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, errDuplicateRecord
+		}
+		return nil, fmt.Errorf("failed to update surviving client %d: %w", survivor.ID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	res, err = tx.Exec(`INSERT INTO client_merges (agent_user_id, surviving_client_id, merged_client_id, merged_client, fields_taken, records_moved, merged_at)
+                        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		merge.AgentUserID, merge.SurvivingClientID, merge.MergedClientID, string(merge.MergedClient), strings.Join(merge.FieldsTaken, ","), merge.RecordsMoved, merge.MergedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record client merge: %w", err)
+	}
+	if merge.ID, err = res.LastInsertId(); err != nil {
+		return nil, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Client %d merged into client %d by agent %d (%d records moved)\n", duplicateID, survivor.ID, survivor.AgentUserID, merge.RecordsMoved)
+	return &merge, nil
+}
+
+func (s *sqlClientStore) ListMerges(agentUserID int64) ([]ClientMerge, error) {
+	rows, err := s.db.Query(`SELECT id, agent_user_id, surviving_client_id, merged_client_id, merged_client, fields_taken, records_moved, merged_at
+                             FROM client_merges WHERE agent_user_id = ? ORDER BY merged_at DESC, id DESC`, agentUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query client merges: %w", err)
+	}
+	defer rows.Close()
+	merges := []ClientMerge{}
+	for rows.Next() {
+		var m ClientMerge
+		var snapshot, fields string
+		if err := rows.Scan(&m.ID, &m.AgentUserID, &m.SurvivingClientID, &m.MergedClientID, &snapshot, &fields, &m.RecordsMoved, &m.MergedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan client merge: %w", err)
+		}
+		m.MergedClient = json.RawMessage(snapshot)
+		m.FieldsTaken = []string{}
+		if fields != "" {
+			m.FieldsTaken = strings.Split(fields, ",")
+		}
+		merges = append(merges, m)
+	}
+	return merges, rows.Err()
+}
+
+func (s *sqlClientStore) SetStatus(clientID int64, agentUserID int64, status string) error {
+	res, err := s.db.Exec(`UPDATE clients SET status = ?, version = version + 1, updated_at = ? WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`,
+		status, time.Now(), clientID, agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to execute update client status: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("DATABASE: Client %d status set to %s by agent %d\n", clientID, status, agentUserID)
+	return nil
+}
+
+func (s *sqlClientStore) SetAttribution(clientID int64, agentUserID int64, client Client) error {
+	res, err := s.db.Exec(`UPDATE clients SET lead_source = ?, campaign = ?, referred_by_client_id = ?, version = version + 1, updated_at = ?
+                           WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`,
+		client.LeadSource, client.Campaign, client.ReferredByClientID, time.Now(), clientID, agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to execute update client attribution: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("DATABASE: Client %d attributed to %s by agent %d\n", clientID, client.LeadSource.String, agentUserID)
+	return nil
+}
+
+func (s *sqlClientStore) SoftDelete(clientID int64, agentUserID int64, deletedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE clients SET deleted_at = ? WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`, deletedAt, clientID, agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to execute soft delete client: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	for _, table := range trashedClientTables {
+		if _, err := tx.Exec(`UPDATE `+table+` SET deleted_at = ? WHERE client_id = ? AND deleted_at IS NULL`, deletedAt, clientID); err != nil {
+			return fmt.Errorf("failed to soft delete %s of client %d: %w", table, clientID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Client %d moved to trash by agent %d\n", clientID, agentUserID)
+	return nil
+}
+
+func (s *sqlClientStore) Restore(clientID int64, agentUserID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var n int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NOT NULL`, clientID, agentUserID).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("failed to look up deleted client %d: %w", clientID, err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	// Only the records trashed together with the client come back. The stored timestamps are
+	// compared in SQL, as one read back into Go doesn't always bind to the same value.
+	for _, table := range trashedClientTables {
+		if _, err := tx.Exec(`UPDATE `+table+` SET deleted_at = NULL WHERE client_id = ? AND deleted_at = (SELECT deleted_at FROM clients WHERE id = ?)`, clientID, clientID); err != nil {
+			return fmt.Errorf("failed to restore %s of client %d: %w", table, clientID, err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE clients SET deleted_at = NULL WHERE id = ?`, clientID); err != nil {
+		return fmt.Errorf("failed to execute restore client: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Client %d restored from trash by agent %d\n", clientID, agentUserID)
+	return nil
+}
+
+func (s *sqlClientStore) ListDeleted(agentUserID int64, since time.Time) ([]Client, error) {
+	rows, err := s.db.Query(`SELECT id, agent_user_id, name, email, phone, status, created_at, deleted_at FROM clients
+        WHERE agent_user_id = ? AND deleted_at IS NOT NULL AND deleted_at >= ? ORDER BY deleted_at DESC`, agentUserID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deleted clients: %w", err)
+	}
+	defer rows.Close()
+	clients := []Client{}
+	for rows.Next() {
+		var c Client
+		if err := rows.Scan(&c.ID, &c.AgentUserID, &c.Name, &c.Email, &c.Phone, &c.Status, &c.CreatedAt, &c.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan deleted client: %w", err)
+		}
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+func (s *sqlClientStore) PurgeDeleted(cutoff time.Time) (int, []string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	const expired = `SELECT id FROM clients WHERE deleted_at IS NOT NULL AND deleted_at < ?`
+	rows, err := tx.Query(`SELECT file_url FROM documents WHERE client_id IN (`+expired+`)
+        UNION ALL SELECT policy_doc_url FROM policies WHERE policy_doc_url IS NOT NULL AND client_id IN (`+expired+`)`, cutoff, cutoff)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to query files of expired clients: %w", err)
+	}
+	var files []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan file of expired client: %w", err)
+		}
+		files = append(files, file)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	for _, table := range clientRecordTables {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE client_id IN (`+expired+`)`, cutoff); err != nil {
+			return 0, nil, fmt.Errorf("failed to purge %s of expired clients: %w", table, err)
+		}
+	}
+	_, err = tx.Exec(`UPDATE clients SET referred_by_client_id = NULL WHERE referred_by_client_id IN (
+                      SELECT id FROM (`+expired+`) AS purged)`, cutoff)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to clear referrals of expired clients: %w", err)
+	}
+	res, err := tx.Exec(`DELETE FROM clients WHERE deleted_at IS NOT NULL AND deleted_at < ?`, cutoff)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to purge expired clients: %w", err)
+	}
+	purged, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int(purged), files, nil
+}
+
+type sqlPolicyStore struct{ db *sql.DB }
+
+func (s *sqlPolicyStore) Create(policy Policy) (string, error) {
+	if policy.ID == "" {
+		policy.ID = "POL-" + generateSimpleID(8)
+	}
+	policy.CreatedAt = time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO policies (id, client_id, agent_user_id, product_id, policy_number, insurer, premium, sum_insured, start_date, end_date, status, policy_doc_url, upfront_commission_amount, created_at)
+                               SELECT ?, id, agent_user_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare insert policy: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(policy.ID, policy.ProductID, policy.PolicyNumber, policy.Insurer, policy.Premium, policy.SumInsured, policy.StartDate, policy.EndDate, policy.Status, policy.PolicyDocURL, policy.UpfrontCommissionAmount, policy.CreatedAt, policy.ClientID, policy.AgentUserID)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute insert policy: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", sql.ErrNoRows
+	}
+	if err := saveCustomFieldValues(tx, customFieldEntityPolicy, policy.ID, policy.AgentUserID, policy.CustomFields); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Policy created with ID: %s\n", policy.ID)
+	return policy.ID, nil
+}
+
+func (s *sqlPolicyStore) ListByClient(clientID int64, agentUserID int64) ([]Policy, error) {
+	rows, err := s.db.Query(`SELECT id, client_id, agent_user_id, product_id, policy_number, insurer, premium, sum_insured, start_date, end_date, status, policy_doc_url, upfront_commission_amount, created_at, updated_at FROM policies WHERE client_id = ? AND agent_user_id = ? AND deleted_at IS NULL ORDER BY end_date DESC`, clientID, agentUserID)
+	if err != nil {
+		log.Printf("ERROR: Query policies failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var policies []Policy
+	for rows.Next() {
+		var p Policy
+		if err := rows.Scan(&p.ID, &p.ClientID, &p.AgentUserID, &p.ProductID, &p.PolicyNumber, &p.Insurer, &p.Premium, &p.SumInsured, &p.StartDate, &p.EndDate, &p.Status, &p.PolicyDocURL, &p.UpfrontCommissionAmount, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			log.Printf("ERROR: Scan policy row failed: %v", err)
+			continue
+		}
+		policies = append(policies, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachPolicyCustomFields(s.db, policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (s *sqlPolicyStore) UpcomingRenewals(agentUserID int64, days int) ([]RenewalPolicyView, error) {
+	log.Printf("DATABASE: Fetching renewals for agent %d (next %d days)\n", agentUserID, days)
+	now := time.Now()
+	startDate := now.Format("2006-01-02")                   // Today
+	endDate := now.AddDate(0, 0, days).Format("2006-01-02") // X days from now
+
+	query := `SELECT
+                p.id, p.client_id, p.agent_user_id, p.product_id, p.policy_number, p.insurer,
+                p.premium, p.sum_insured, p.start_date, p.end_date, p.status, p.policy_doc_url,
+                p.upfront_commission_amount, p.created_at, p.updated_at,
+                c.name as client_name
+              FROM policies p
+              JOIN clients c ON p.client_id = c.id
+              WHERE p.agent_user_id = ? AND p.deleted_at IS NULL AND p.status = 'Active' AND p.end_date >= ? AND p.end_date < ?
+              ORDER BY p.end_date ASC`
+
+	rows, err := s.db.Query(query, agentUserID, startDate, endDate)
+	if err != nil {
+		log.Printf("ERROR: Query upcoming renewals failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var renewals []RenewalPolicyView
+	for rows.Next() {
+		var r RenewalPolicyView
+		if err := rows.Scan(
+			&r.ID, &r.ClientID, &r.AgentUserID, &r.ProductID, &r.PolicyNumber, &r.Insurer,
+			&r.Premium, &r.SumInsured, &r.StartDate, &r.EndDate, &r.Status, &r.PolicyDocURL,
+			&r.UpfrontCommissionAmount, &r.CreatedAt, &r.UpdatedAt, &r.ClientName,
+		); err != nil {
+			log.Printf("ERROR: Scan renewal row failed: %v", err)
+			continue
+		}
+		renewals = append(renewals, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return renewals, nil
+}
+
+func (s *sqlPolicyStore) CommissionRecords(agentUserID int64, dateRangeStart, dateRangeEnd string) ([]Policy, error) {
+	log.Printf("DATABASE: Fetching commission records for agent %d (Range: %s - %s)\n", agentUserID, dateRangeStart, dateRangeEnd)
+
+	// We select from policies table, joining clients for name, filtering by agent and date range
+	// Date range filtering can be on policy creation date (created_at) or start date etc. Let's use created_at for now.
+	query := `SELECT
+				p.id, p.client_id, p.agent_user_id, p.product_id, p.policy_number, p.insurer,
+				p.premium, p.sum_insured, p.start_date, p.end_date, p.status, p.policy_doc_url,
+				p.upfront_commission_amount, p.created_at, p.updated_at,
+				c.name as client_name -- Include client name
+			  FROM policies p
+			  JOIN clients c ON p.client_id = c.id
+			  WHERE p.agent_user_id = ? AND p.deleted_at IS NULL`
+	args := []interface{}{agentUserID}
+
+	// Add date range filter if provided (assuming YYYY-MM-DD format)
+	if dateRangeStart != "" {
+		query += " AND p.created_at >= ?"
+		args = append(args, dateRangeStart+" 00:00:00") // Start of the day
+	}
+	if dateRangeEnd != "" {
+		query += " AND p.created_at <= ?"
+		args = append(args, dateRangeEnd+" 23:59:59") // End of the day
+	}
+
+	query += " ORDER BY p.created_at DESC" // Order by policy creation date
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("ERROR: Query commission records failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Policy // Reusing Policy struct, might need a dedicated CommissionRecord struct later
+	for rows.Next() {
+		var p Policy
+		var clientName sql.NullString // To scan the joined client name
+		// Scan including the new commission amount and client name
+		if err := rows.Scan(
+			&p.ID, &p.ClientID, &p.AgentUserID, &p.ProductID, &p.PolicyNumber, &p.Insurer,
+			&p.Premium, &p.SumInsured, &p.StartDate, &p.EndDate, &p.Status, &p.PolicyDocURL,
+			&p.UpfrontCommissionAmount, &p.CreatedAt, &p.UpdatedAt, &clientName,
+		); err != nil {
+			log.Printf("ERROR: Scan commission record row failed: %v", err)
+			continue
+		}
+		// We might want to add clientName to the Policy struct or create a new struct
+		// For now, we are fetching it but not directly using it in the return struct `p`
+		log.Printf("Fetched commission record for policy %s, client %s", p.PolicyNumber, clientName.String)
+		records = append(records, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *sqlPolicyStore) MonthlyCounts(agentUserID int64, months int) ([]MonthlySalesData, error) {
+	log.Printf("DATABASE: Fetching monthly policy counts for agent %d (last %d months)\n", agentUserID, months)
+	// Calculate the date 'months' ago from the start of the current month
+	firstOfMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.UTC)
+	startDate := firstOfMonth.AddDate(0, -months, 0)
+
+	// start_date is stored as YYYY-MM-DD text, so the month is its first seven characters on every dialect
+	query := `
+		SELECT SUBSTR(start_date, 1, 7) as month, COUNT(*) as count
+		FROM policies
+		WHERE agent_user_id = ? AND deleted_at IS NULL AND start_date >= ?
+		GROUP BY month
+		ORDER BY month ASC
+		LIMIT ?;
+	`
+	// Limit ensures we don't exceed the number of months requested,
+	// even if data spans longer (e.g., if 'months' is 6 but data exists for 12)
+	rows, err := s.db.Query(query, agentUserID, startDate, months)
+	if err != nil {
+		log.Printf("ERROR: Query monthly policy count failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []MonthlySalesData
+	for rows.Next() {
+		var data MonthlySalesData
+		if err := rows.Scan(&data.Month, &data.Count); err != nil {
+			log.Printf("ERROR: Scan monthly policy count row failed: %v", err)
+			continue
+		}
+		results = append(results, data)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	log.Printf("DATABASE: Found %d months of policy data for agent %d.\n", len(results), agentUserID)
+	return results, nil
+}
+
+func (s *sqlPolicyStore) PremiumByClient(agentUserID int64) (map[int64]float64, error) {
+	rows, err := s.db.Query(`SELECT client_id, COALESCE(SUM(premium), 0) FROM policies
+                             WHERE agent_user_id = ? AND deleted_at IS NULL GROUP BY client_id`, agentUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query premium by client: %w", err)
+	}
+	defer rows.Close()
+	premiums := map[int64]float64{}
+	for rows.Next() {
+		var clientID int64
+		var premium float64
+		if err := rows.Scan(&clientID, &premium); err != nil {
+			return nil, fmt.Errorf("failed to scan premium of client: %w", err)
+		}
+		premiums[clientID] = premium
+	}
+	return premiums, rows.Err()
+}
+
+type sqlHouseholdStore struct{ db *sql.DB }
+
+// insertHouseholdMember adds member to one of the agent's households. A member who is a client
+// takes the client's name and date of birth.
+func insertHouseholdMember(tx *sql.Tx, member HouseholdMember, agentUserID int64) (int64, error) {
+	var res sql.Result
+	var err error
+	if member.ClientID.Valid {
+		res, err = tx.Exec(`INSERT INTO household_members (household_id, client_id, name, relationship, dob)
+                            SELECT h.id, c.id, c.name, ?, c.dob FROM households h
+                            JOIN clients c ON c.id = ? AND c.agent_user_id = h.agent_user_id AND c.deleted_at IS NULL
+                            WHERE h.id = ? AND h.agent_user_id = ?`,
+			member.Relationship, member.ClientID.Int64, member.HouseholdID, agentUserID)
+	} else {
+		res, err = tx.Exec(`INSERT INTO household_members (household_id, name, relationship, dob)
+                            SELECT id, ?, ?, ? FROM households WHERE id = ? AND agent_user_id = ?`,
+			member.Name, member.Relationship, member.Dob, member.HouseholdID, agentUserID)
+	}
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return 0, errDuplicateRecord
+		}
+		return 0, fmt.Errorf("failed to execute insert household member: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID for household member: %w", err)
+	}
+	return id, nil
+}
+
+func (s *sqlHouseholdStore) Create(household Household) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	res, err := tx.Exec(`INSERT INTO households (agent_user_id, name) VALUES (?, ?)`, household.AgentUserID, household.Name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert household: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID for household: %w", err)
+	}
+	for _, member := range household.Members {
+		member.HouseholdID = id
+		if _, err := insertHouseholdMember(tx, member, household.AgentUserID); err != nil {
+			return 0, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Household %d created by agent %d with %d members\n", id, household.AgentUserID, len(household.Members))
+	return id, nil
+}
+
+// A member whose client is in the trash reads as a plain member.
+const householdMemberColumns = `m.id, m.household_id, c.id, COALESCE(c.name, m.name), m.relationship, COALESCE(c.dob, m.dob), m.created_at`
+const householdMembersFrom = `household_members m JOIN households h ON h.id = m.household_id
+                              LEFT JOIN clients c ON c.id = m.client_id AND c.deleted_at IS NULL`
+
+// list returns the households matching where, which may refer to households as h, with their members.
+func (s *sqlHouseholdStore) list(where string, args ...interface{}) ([]Household, error) {
+	rows, err := s.db.Query(`SELECT h.id, h.agent_user_id, h.name, h.created_at FROM households h WHERE `+where+` ORDER BY h.name, h.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query households: %w", err)
+	}
+	defer rows.Close()
+	households := []Household{}
+	index := map[int64]int{}
+	for rows.Next() {
+		h := Household{Members: []HouseholdMember{}}
+		if err := rows.Scan(&h.ID, &h.AgentUserID, &h.Name, &h.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan household: %w", err)
+		}
+		index[h.ID] = len(households)
+		households = append(households, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(households) == 0 {
+		return households, nil
+	}
+
+	memberRows, err := s.db.Query(`SELECT `+householdMemberColumns+` FROM `+householdMembersFrom+` WHERE `+where+` ORDER BY m.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query household members: %w", err)
+	}
+	defer memberRows.Close()
+	for memberRows.Next() {
+		var m HouseholdMember
+		if err := memberRows.Scan(&m.ID, &m.HouseholdID, &m.ClientID, &m.Name, &m.Relationship, &m.Dob, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan household member: %w", err)
+		}
+		if i, ok := index[m.HouseholdID]; ok {
+			households[i].Members = append(households[i].Members, m)
+		}
+	}
+	return households, memberRows.Err()
+}
+
+func (s *sqlHouseholdStore) GetByID(householdID int64, agentUserID int64) (*Household, error) {
+	households, err := s.list(`h.id = ? AND h.agent_user_id = ?`, householdID, agentUserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(households) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &households[0], nil
+}
+
+func (s *sqlHouseholdStore) GetByClient(clientID int64, agentUserID int64) (*Household, error) {
+	households, err := s.list(`h.agent_user_id = ? AND h.id IN (SELECT hm.household_id FROM household_members hm
+                               JOIN clients hc ON hc.id = hm.client_id WHERE hm.client_id = ? AND hc.deleted_at IS NULL)`, agentUserID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if len(households) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &households[0], nil
+}
+
+func (s *sqlHouseholdStore) List(agentUserID int64) ([]Household, error) {
+	return s.list(`h.agent_user_id = ?`, agentUserID)
+}
+
+func (s *sqlHouseholdStore) Delete(householdID int64, agentUserID int64) error {
+	res, err := s.db.Exec(`DELETE FROM households WHERE id = ? AND agent_user_id = ?`, householdID, agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to execute delete household: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("DATABASE: Household %d deleted by agent %d\n", householdID, agentUserID)
+	return nil
+}
+
+func (s *sqlHouseholdStore) AddMember(member HouseholdMember, agentUserID int64) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	id, err := insertHouseholdMember(tx, member, agentUserID)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Member %d added to household %d by agent %d\n", id, member.HouseholdID, agentUserID)
+	return id, nil
+}
+
+func (s *sqlHouseholdStore) RemoveMember(householdID int64, memberID int64, agentUserID int64) error {
+	res, err := s.db.Exec(`DELETE FROM household_members WHERE id = ? AND household_id IN (SELECT id FROM households WHERE id = ? AND agent_user_id = ?)`,
+		memberID, householdID, agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to execute delete household member: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("DATABASE: Member %d removed from household %d by agent %d\n", memberID, householdID, agentUserID)
+	return nil
+}
+
+func (s *sqlHouseholdStore) SetInsuredMembers(policyID string, clientID int64, agentUserID int64, memberIDs []int64) ([]int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var householdID sql.NullInt64
+	err = tx.QueryRow(`SELECT m.household_id FROM policies p LEFT JOIN household_members m ON m.client_id = p.client_id
+                       WHERE p.id = ? AND p.client_id = ? AND p.agent_user_id = ? AND p.deleted_at IS NULL`, policyID, clientID, agentUserID).Scan(&householdID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to look up policy %s: %w", policyID, err)
+		}
+		return nil, err
+	}
+	seen := map[int64]bool{}
+	for _, memberID := range memberIDs {
+		if seen[memberID] {
+			continue
+		}
+		seen[memberID] = true
+		var n int
+		if householdID.Valid {
+			err = tx.QueryRow(`SELECT COUNT(*) FROM household_members WHERE id = ? AND household_id = ?`, memberID, householdID.Int64).Scan(&n)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up household member %d: %w", memberID, err)
+			}
+		}
+		if n == 0 {
+			return nil, errNotHouseholdMember
+		}
+	}
+	previous := []int64{}
+	rows, err := tx.Query(`SELECT member_id FROM policy_insured_members WHERE policy_id = ? ORDER BY member_id`, policyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query insured members of policy %s: %w", policyID, err)
+	}
+	for rows.Next() {
+		var memberID int64
+		if err := rows.Scan(&memberID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan insured member: %w", err)
+		}
+		previous = append(previous, memberID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read insured members of policy %s: %w", policyID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM policy_insured_members WHERE policy_id = ?`, policyID); err != nil {
+		return nil, fmt.Errorf("failed to clear insured members of policy %s: %w", policyID, err)
+	}
+	for memberID := range seen {
+		if _, err := tx.Exec(`INSERT INTO policy_insured_members (policy_id, member_id) VALUES (?, ?)`, policyID, memberID); err != nil {
+			return nil, fmt.Errorf("failed to insert insured member of policy %s: %w", policyID, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Policy %s insures %d household members\n", policyID, len(seen))
+	return previous, nil
+}
+
+func (s *sqlHouseholdStore) ListPolicies(householdID int64, agentUserID int64) ([]HouseholdPolicy, error) {
+	var id int64
+	if err := s.db.QueryRow(`SELECT id FROM households WHERE id = ? AND agent_user_id = ?`, householdID, agentUserID).Scan(&id); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to look up household %d: %w", householdID, err)
+		}
+		return nil, err
+	}
+	insured := map[string][]int64{}
+	rows, err := s.db.Query(`SELECT pim.policy_id, pim.member_id FROM policy_insured_members pim
+                             JOIN household_members m ON m.id = pim.member_id WHERE m.household_id = ? ORDER BY pim.member_id`, householdID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query insured members: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var policyID string
+		var memberID int64
+		if err := rows.Scan(&policyID, &memberID); err != nil {
+			return nil, fmt.Errorf("failed to scan insured member: %w", err)
+		}
+		insured[policyID] = append(insured[policyID], memberID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	policyRows, err := s.db.Query(`SELECT p.id, p.client_id, p.agent_user_id, p.product_id, p.policy_number, p.insurer, p.premium, p.sum_insured, p.start_date, p.end_date, p.status, p.policy_doc_url, p.upfront_commission_amount, p.created_at, p.updated_at
+                                   FROM policies p
+                                   WHERE p.agent_user_id = ? AND p.deleted_at IS NULL AND (
+                                       p.client_id IN (SELECT client_id FROM household_members WHERE household_id = ? AND client_id IS NOT NULL)
+                                       OR p.id IN (SELECT pim.policy_id FROM policy_insured_members pim JOIN household_members m ON m.id = pim.member_id WHERE m.household_id = ?))
+                                   ORDER BY p.end_date DESC`, agentUserID, householdID, householdID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query household policies: %w", err)
+	}
+	defer policyRows.Close()
+	held := []Policy{}
+	for policyRows.Next() {
+		var p Policy
+		if err := policyRows.Scan(&p.ID, &p.ClientID, &p.AgentUserID, &p.ProductID, &p.PolicyNumber, &p.Insurer, &p.Premium, &p.SumInsured, &p.StartDate, &p.EndDate, &p.Status, &p.PolicyDocURL, &p.UpfrontCommissionAmount, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan household policy: %w", err)
+		}
+		held = append(held, p)
+	}
+	if err := policyRows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachPolicyCustomFields(s.db, held); err != nil {
+		return nil, err
+	}
+	policies := []HouseholdPolicy{}
+	for _, p := range held {
+		members := insured[p.ID]
+		if members == nil {
+			members = []int64{}
+		}
+		policies = append(policies, HouseholdPolicy{Policy: p, InsuredMemberIDs: members})
+	}
+	return policies, nil
+}
+
+type sqlCustomFieldStore struct{ db *sql.DB }
+
+const customFieldDefinitionColumns = `d.id, d.owner_user_id, d.entity, d.field_key, d.label, d.field_type, d.options, d.required, d.created_at`
+
+func scanCustomFieldDefinition(scanner rowScanner) (*CustomFieldDefinition, error) {
+	def := &CustomFieldDefinition{}
+	var options sql.NullString
+	if err := scanner.Scan(&def.ID, &def.OwnerUserID, &def.Entity, &def.Key, &def.Label, &def.Type, &options, &def.Required, &def.CreatedAt); err != nil {
+		return nil, err
+	}
+	def.Options = []string{}
+	if options.Valid && options.String != "" {
+		if err := json.Unmarshal([]byte(options.String), &def.Options); err != nil {
+			return nil, fmt.Errorf("failed to decode options of custom field %d: %w", def.ID, err)
+		}
+	}
+	return def, nil
+}
+
+func (s *sqlCustomFieldStore) ListDefinitions(userID int64, entity string) ([]CustomFieldDefinition, error) {
+	rows, err := s.db.Query(`SELECT `+customFieldDefinitionColumns+` FROM custom_field_definitions d
+                             WHERE d.entity = ? AND `+visibleCustomFields+` ORDER BY d.id`, entity, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query custom fields: %w", err)
+	}
+	defer rows.Close()
+	defs := []CustomFieldDefinition{}
+	for rows.Next() {
+		def, err := scanCustomFieldDefinition(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan custom field: %w", err)
+		}
+		defs = append(defs, *def)
+	}
+	return defs, rows.Err()
+}
+
+func (s *sqlCustomFieldStore) CreateDefinition(def CustomFieldDefinition) (int64, error) {
+	var taken int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM custom_field_definitions d WHERE d.entity = ? AND d.field_key = ? AND (`+visibleCustomFields+`
+                          OR d.owner_user_id IN (SELECT agent_user_id FROM agency_members WHERE agency_user_id = ?))`,
+		def.Entity, def.Key, def.OwnerUserID, def.OwnerUserID, def.OwnerUserID).Scan(&taken)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check custom field key: %w", err)
+	}
+	if taken > 0 {
+		return 0, errDuplicateRecord
+	}
+	options, err := json.Marshal(def.Options)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode options: %w", err)
+	}
+	res, err := s.db.Exec(`INSERT INTO custom_field_definitions (owner_user_id, entity, field_key, label, field_type, options, required, created_at)
+                           VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		def.OwnerUserID, def.Entity, def.Key, def.Label, def.Type, string(options), def.Required, time.Now())
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return 0, errDuplicateRecord
+		}
+		return 0, fmt.Errorf("failed to insert custom field: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	log.Printf("DATABASE: Custom field '%s' (%s) created with ID %d by user %d\n", def.Key, def.Entity, id, def.OwnerUserID)
+	return id, nil
+}
+
+func (s *sqlCustomFieldStore) GetDefinition(fieldID int64, ownerUserID int64) (*CustomFieldDefinition, error) {
+	return scanCustomFieldDefinition(s.db.QueryRow(`SELECT `+customFieldDefinitionColumns+` FROM custom_field_definitions d
+                                                    WHERE d.id = ? AND d.owner_user_id = ?`, fieldID, ownerUserID))
+}
+
+func (s *sqlCustomFieldStore) UpdateDefinition(def CustomFieldDefinition) error {
+	options, err := json.Marshal(def.Options)
+	if err != nil {
+		return fmt.Errorf("failed to encode options: %w", err)
+	}
+	res, err := s.db.Exec(`UPDATE custom_field_definitions SET label = ?, options = ?, required = ? WHERE id = ? AND owner_user_id = ?`,
+		def.Label, string(options), def.Required, def.ID, def.OwnerUserID)
+	if err != nil {
+		return fmt.Errorf("failed to update custom field %d: %w", def.ID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// MySQL reports 0 for an update that changed nothing, so check the field exists.
+		if _, err := s.GetDefinition(def.ID, def.OwnerUserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlCustomFieldStore) DeleteDefinition(fieldID int64, ownerUserID int64) error {
+	res, err := s.db.Exec(`DELETE FROM custom_field_definitions WHERE id = ? AND owner_user_id = ?`, fieldID, ownerUserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete custom field %d: %w", fieldID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("DATABASE: Custom field %d deleted by user %d\n", fieldID, ownerUserID)
+	return nil
+}
+
+type sqlChangeStore struct{ db *sql.DB }
+
+func (s *sqlChangeStore) Record(changes []EntityChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO entity_changes (agent_user_id, client_id, entity, entity_id, action, field, old_value, new_value, actor_user_id, source, changed_at)
+                             VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert entity change: %w", err)
+	}
+	defer stmt.Close()
+	for _, c := range changes {
+		_, err := stmt.Exec(c.AgentUserID, c.ClientID, c.Entity, c.EntityID, c.Action, c.Field, c.OldValue, c.NewValue, c.ActorUserID, c.Source, c.ChangedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert change of %s %s: %w", c.Entity, c.EntityID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlChangeStore) ListByClient(clientID int64, agentUserID int64) ([]EntityChange, error) {
+	rows, err := s.db.Query(`SELECT id, agent_user_id, client_id, entity, entity_id, action, field, old_value, new_value, actor_user_id, source, changed_at
+                             FROM entity_changes WHERE client_id = ? AND agent_user_id = ? ORDER BY changed_at DESC, id DESC`, clientID, agentUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query changes of client %d: %w", clientID, err)
+	}
+	defer rows.Close()
+	changes := []EntityChange{}
+	for rows.Next() {
+		var c EntityChange
+		if err := rows.Scan(&c.ID, &c.AgentUserID, &c.ClientID, &c.Entity, &c.EntityID, &c.Action, &c.Field, &c.OldValue, &c.NewValue, &c.ActorUserID, &c.Source, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan entity change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+type sqlPipelineStore struct{ db *sql.DB }
+
+func (s *sqlPipelineStore) ListStages(ownerUserID int64) ([]PipelineStage, error) {
+	rows, err := s.db.Query(`SELECT id, owner_user_id, stage_key, name, position, outcome, next_stages FROM pipeline_stages
+                             WHERE owner_user_id = ? ORDER BY position, id`, ownerUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pipeline stages: %w", err)
+	}
+	defer rows.Close()
+	stages := []PipelineStage{}
+	for rows.Next() {
+		var stage PipelineStage
+		var next sql.NullString
+		if err := rows.Scan(&stage.ID, &stage.OwnerUserID, &stage.Key, &stage.Name, &stage.Position, &stage.Outcome, &next); err != nil {
+			return nil, fmt.Errorf("failed to scan pipeline stage: %w", err)
+		}
+		stage.NextStages = []string{}
+		if next.Valid && next.String != "" {
+			if err := json.Unmarshal([]byte(next.String), &stage.NextStages); err != nil {
+				return nil, fmt.Errorf("failed to decode next stages of pipeline stage %d: %w", stage.ID, err)
+			}
+		}
+		stages = append(stages, stage)
+	}
+	return stages, rows.Err()
+}
+
+func (s *sqlPipelineStore) ReplaceStages(ownerUserID int64, stages []PipelineStage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM pipeline_stages WHERE owner_user_id = ?`, ownerUserID); err != nil {
+		return fmt.Errorf("failed to clear pipeline stages of user %d: %w", ownerUserID, err)
+	}
+	for _, stage := range stages {
+		next, err := json.Marshal(stage.NextStages)
+		if err != nil {
+			return fmt.Errorf("failed to encode next stages: %w", err)
+		}
+		_, err = tx.Exec(`INSERT INTO pipeline_stages (owner_user_id, stage_key, name, position, outcome, next_stages) VALUES (?, ?, ?, ?, ?, ?)`,
+			ownerUserID, stage.Key, stage.Name, stage.Position, stage.Outcome, string(next))
+		if err != nil {
+			if isDuplicateKeyError(err) {
+				return errDuplicateRecord
+			}
+			return fmt.Errorf("failed to insert pipeline stage '%s': %w", stage.Key, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Pipeline of user %d set to %d stages\n", ownerUserID, len(stages))
+	return nil
+}
+
+const clientStageColumns = `s.id, s.client_id, s.agent_user_id, s.stage_key, s.entered_at, s.left_at, s.deal_value, s.lost_reason, s.changed_by`
+
+func scanClientStages(rows *sql.Rows) ([]ClientStage, error) {
+	defer rows.Close()
+	stages := []ClientStage{}
+	for rows.Next() {
+		var st ClientStage
+		if err := rows.Scan(&st.ID, &st.ClientID, &st.AgentUserID, &st.Stage, &st.EnteredAt, &st.LeftAt, &st.DealValue, &st.LostReason, &st.ChangedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan client stage: %w", err)
+		}
+		stages = append(stages, st)
+	}
+	return stages, rows.Err()
+}
+
+func (s *sqlPipelineStore) CurrentStages(agentUserID int64) ([]ClientStage, error) {
+	rows, err := s.db.Query(`SELECT `+clientStageColumns+` FROM client_stages s JOIN clients c ON c.id = s.client_id
+                             WHERE s.agent_user_id = ? AND s.left_at IS NULL AND c.deleted_at IS NULL ORDER BY s.entered_at, s.id`, agentUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query current client stages: %w", err)
+	}
+	return scanClientStages(rows)
+}
+
+func (s *sqlPipelineStore) MoveClient(stage ClientStage, clientStatus string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var clientID int64
+	err = tx.QueryRow(`SELECT id FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`, stage.ClientID, stage.AgentUserID).Scan(&clientID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE client_stages SET left_at = ? WHERE client_id = ? AND left_at IS NULL`, stage.EnteredAt, stage.ClientID); err != nil {
+		return fmt.Errorf("failed to end current stage of client %d: %w", stage.ClientID, err)
+	}
+	_, err = tx.Exec(`INSERT INTO client_stages (client_id, agent_user_id, stage_key, entered_at, deal_value, lost_reason, changed_by)
+                      VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		stage.ClientID, stage.AgentUserID, stage.Stage, stage.EnteredAt, stage.DealValue, stage.LostReason, stage.ChangedBy)
+	if err != nil {
+		return fmt.Errorf("failed to insert stage of client %d: %w", stage.ClientID, err)
+	}
+	if clientStatus != "" {
+		_, err := tx.Exec(`UPDATE clients SET status = ?, version = version + 1, updated_at = ? WHERE id = ? AND status <> ?`,
+			clientStatus, stage.EnteredAt, stage.ClientID, clientStatus)
+		if err != nil {
+			return fmt.Errorf("failed to update status of client %d: %w", stage.ClientID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Client %d moved to stage %s by agent %d\n", stage.ClientID, stage.Stage, stage.AgentUserID)
+	return nil
+}
+
+func (s *sqlPipelineStore) ListClientStages(clientID int64, agentUserID int64) ([]ClientStage, error) {
+	rows, err := s.db.Query(`SELECT `+clientStageColumns+` FROM client_stages s
+                             WHERE s.client_id = ? AND s.agent_user_id = ? ORDER BY s.entered_at DESC, s.id DESC`, clientID, agentUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stages of client %d: %w", clientID, err)
+	}
+	return scanClientStages(rows)
+}
+
+type sqlLeadScoreStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (s *sqlLeadScoreStore) GetWeights(ownerUserID int64) (LeadScoreWeights, error) {
+	var encoded string
+	err := s.db.QueryRow(`SELECT weights FROM lead_scoring_weights WHERE owner_user_id = ?`, ownerUserID).Scan(&encoded)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to query lead scoring weights of user %d: %w", ownerUserID, err)
+	}
+	weights := LeadScoreWeights{}
+	if err := json.Unmarshal([]byte(encoded), &weights); err != nil {
+		return nil, fmt.Errorf("failed to decode lead scoring weights of user %d: %w", ownerUserID, err)
+	}
+	return weights, nil
+}
+
+func (s *sqlLeadScoreStore) SetWeights(ownerUserID int64, weights LeadScoreWeights) error {
+	if weights == nil {
+		if _, err := s.db.Exec(`DELETE FROM lead_scoring_weights WHERE owner_user_id = ?`, ownerUserID); err != nil {
+			return fmt.Errorf("failed to delete lead scoring weights of user %d: %w", ownerUserID, err)
+		}
+		return nil
+	}
+	encoded, err := json.Marshal(weights)
+	if err != nil {
+		return fmt.Errorf("failed to encode lead scoring weights: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO lead_scoring_weights (owner_user_id, weights, updated_at) VALUES (?, ?, ?) `+
+		s.dialect.upsertClause([]string{"owner_user_id"}, "weights", "updated_at"), ownerUserID, string(encoded), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save lead scoring weights of user %d: %w", ownerUserID, err)
+	}
+	log.Printf("DATABASE: Lead scoring weights of user %d saved\n", ownerUserID)
+	return nil
+}
+
+func (s *sqlLeadScoreStore) Save(score LeadScore) error {
+	factors, err := json.Marshal(score.Factors)
+	if err != nil {
+		return fmt.Errorf("failed to encode lead score factors: %w", err)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var clientID int64
+	err = tx.QueryRow(`SELECT id FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`, score.ClientID, score.AgentUserID).Scan(&clientID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO client_lead_scores (client_id, agent_user_id, score, factors, computed_at) VALUES (?, ?, ?, ?, ?) `+
+		s.dialect.upsertClause([]string{"client_id"}, "agent_user_id", "score", "factors", "computed_at"),
+		score.ClientID, score.AgentUserID, score.Score, string(factors), score.ComputedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save lead score of client %d: %w", score.ClientID, err)
+	}
+	return tx.Commit()
+}
+
+func (s *sqlLeadScoreStore) Get(clientID int64, agentUserID int64) (*LeadScore, error) {
+	score := LeadScore{}
+	var factors string
+	err := s.db.QueryRow(`SELECT client_id, agent_user_id, score, factors, computed_at FROM client_lead_scores WHERE client_id = ? AND agent_user_id = ?`,
+		clientID, agentUserID).Scan(&score.ClientID, &score.AgentUserID, &score.Score, &factors, &score.ComputedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to query lead score of client %d: %w", clientID, err)
+	}
+	if err := json.Unmarshal([]byte(factors), &score.Factors); err != nil {
+		return nil, fmt.Errorf("failed to decode lead score factors of client %d: %w", clientID, err)
+	}
+	return &score, nil
+}
+
+type sqlCommunicationStore struct{ db *sql.DB }
+
+func (s *sqlCommunicationStore) Create(comm Communication) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO communications (client_id, agent_user_id, type, timestamp, summary)
+                               SELECT id, agent_user_id, ?, ?, ? FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert communication: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(comm.Type, comm.Timestamp, comm.Summary, comm.ClientID, comm.AgentUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert communication: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE clients SET last_contacted_at = ? WHERE id = ? AND (last_contacted_at IS NULL OR last_contacted_at < ?)`,
+		comm.Timestamp, comm.ClientID, comm.Timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update last contact of client %d: %w", comm.ClientID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Communication log created with ID: %d\n", id)
+	return id, nil
+}
+
+func (s *sqlCommunicationStore) ListByClient(clientID int64, agentUserID int64) ([]Communication, error) {
+	rows, err := s.db.Query(`SELECT id, client_id, agent_user_id, type, timestamp, summary, created_at FROM communications WHERE client_id = ? AND agent_user_id = ? AND deleted_at IS NULL ORDER BY timestamp DESC`, clientID, agentUserID)
+	if err != nil {
+		log.Printf("ERROR: Query communications failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var comms []Communication
+	for rows.Next() {
+		var c Communication
+		if err := rows.Scan(&c.ID, &c.ClientID, &c.AgentUserID, &c.Type, &c.Timestamp, &c.Summary, &c.CreatedAt); err != nil {
+			log.Printf("ERROR: Scan communication row failed: %v", err)
+			continue
+		}
+		comms = append(comms, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return comms, nil
+}
+
+type sqlTaskStore struct{ db *sql.DB }
+
+func (s *sqlTaskStore) Create(task Task) (int64, error) {
+	stmt, err := s.db.Prepare(`INSERT INTO tasks (client_id, agent_user_id, description, due_date, is_urgent, is_completed)
+                               SELECT id, agent_user_id, ?, ?, ?, ? FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert task: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(task.Description, task.DueDate, task.IsUrgent, false, task.ClientID, task.AgentUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert task: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	log.Printf("DATABASE: Task created with ID: %d\n", id)
+	return id, nil
+}
+
+func (s *sqlTaskStore) ListPendingByClient(clientID int64, agentUserID int64) ([]Task, error) {
+	rows, err := s.db.Query(`SELECT id, client_id, agent_user_id, description, due_date, is_urgent, is_completed, created_at, completed_at FROM tasks WHERE client_id = ? AND agent_user_id = ? AND deleted_at IS NULL AND is_completed = 0 ORDER BY is_urgent DESC, due_date ASC, created_at DESC`, clientID, agentUserID)
+	if err != nil {
+		log.Printf("ERROR: Query tasks failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var tasks []Task
+	for rows.Next() {
+		var t Task
+		if err := rows.Scan(&t.ID, &t.ClientID, &t.AgentUserID, &t.Description, &t.DueDate, &t.IsUrgent, &t.IsCompleted, &t.CreatedAt, &t.CompletedAt); err != nil {
+			log.Printf("ERROR: Scan task row failed: %v", err)
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (s *sqlTaskStore) ListByClient(clientID int64, agentUserID int64) ([]Task, error) {
+	log.Printf("DATABASE: Fetching ALL tasks for client %d (agent %d)\n", clientID, agentUserID)
+	// Fetch ALL tasks, order by creation date or due date
+	rows, err := s.db.Query(`SELECT id, client_id, agent_user_id, description, due_date, is_urgent, is_completed, created_at, completed_at
+						   FROM tasks WHERE client_id = ? AND agent_user_id = ? AND deleted_at IS NULL
+						   ORDER BY created_at DESC`, clientID, agentUserID)
+	if err != nil {
+		log.Printf("ERROR: Query all tasks failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var tasks []Task
+	for rows.Next() {
+		var t Task
+		if err := rows.Scan(&t.ID, &t.ClientID, &t.AgentUserID, &t.Description, &t.DueDate, &t.IsUrgent, &t.IsCompleted, &t.CreatedAt, &t.CompletedAt); err != nil {
+			log.Printf("ERROR: Scan all tasks row failed: %v", err)
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (s *sqlTaskStore) ListPendingByAgent(agentUserID int64, limit int) ([]Task, error) {
+	log.Printf("DATABASE: Fetching pending tasks for agent %d (Limit: %d)\n", agentUserID, limit)
+	rows, err := s.db.Query(`SELECT id, client_id, agent_user_id, description, due_date, is_urgent, is_completed, created_at, completed_at
+                            FROM tasks WHERE agent_user_id = ? AND deleted_at IS NULL AND is_completed = 0
+                           ORDER BY is_urgent DESC, (due_date IS NULL) ASC, due_date ASC, created_at DESC LIMIT ?`, agentUserID, limit)
+	if err != nil {
+		log.Printf("ERROR: Query tasks failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var tasks []Task
+	for rows.Next() {
+		var t Task
+		if err := rows.Scan(&t.ID, &t.ClientID, &t.AgentUserID, &t.Description, &t.DueDate, &t.IsUrgent, &t.IsCompleted, &t.CreatedAt, &t.CompletedAt); err != nil {
+			log.Printf("ERROR: Scan task row failed: %v", err)
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (s *sqlTaskStore) ListByAgent(agentUserID int64, statusFilter string, page, pageSize int) ([]Task, int, error) {
+	log.Printf("DATABASE: Fetching all tasks for agent %d (Status: %s, Page: %d, Size: %d)\n", agentUserID, statusFilter, page, pageSize)
+	offset := (page - 1) * pageSize
+
+	// Base query
+	baseQuery := " FROM tasks WHERE agent_user_id = ? AND deleted_at IS NULL "
+	countQuery := "SELECT COUNT(*) " + baseQuery
+	dataQuery := `SELECT id, client_id, agent_user_id, description, due_date, is_urgent, is_completed, created_at, completed_at ` + baseQuery
+
+	args := []interface{}{agentUserID}
+
+	// Apply status filter
+	if statusFilter == "pending" {
+		dataQuery += " AND is_completed = 0"
+		countQuery += " AND is_completed = 0"
+	} else if statusFilter == "completed" {
+		dataQuery += " AND is_completed = 1"
+		countQuery += " AND is_completed = 1"
+	}
+	// Add other filters like date range if needed
+
+	// Get total count for pagination
+	var totalItems int
+	err := s.db.QueryRow(countQuery, args...).Scan(&totalItems)
+	if err != nil {
+		log.Printf("ERROR: Count all tasks failed: %v", err)
+		return nil, 0, err
+	}
+
+	// Add ordering and pagination to data query
+	dataQuery += " ORDER BY is_completed ASC, is_urgent DESC, (due_date IS NULL) ASC, due_date ASC, created_at DESC LIMIT ? OFFSET ?"
+	args = append(args, pageSize, offset)
+
+	// Fetch data
+	rows, err := s.db.Query(dataQuery, args...)
+	if err != nil {
+		log.Printf("ERROR: Query all tasks failed: %v", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		var t Task
+		if err := rows.Scan(&t.ID, &t.ClientID, &t.AgentUserID, &t.Description, &t.DueDate, &t.IsUrgent, &t.IsCompleted, &t.CreatedAt, &t.CompletedAt); err != nil {
+			log.Printf("ERROR: Scan all tasks row failed: %v", err)
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return tasks, totalItems, nil
+}
+
+func (s *sqlTaskStore) GetByID(taskID int64, agentUserID int64) (*Task, error) {
+	var t Task
+	err := s.db.QueryRow(`SELECT id, client_id, agent_user_id, description, due_date, is_urgent, is_completed, created_at, completed_at
+                          FROM tasks WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`, taskID, agentUserID).
+		Scan(&t.ID, &t.ClientID, &t.AgentUserID, &t.Description, &t.DueDate, &t.IsUrgent, &t.IsCompleted, &t.CreatedAt, &t.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateStatus marks a task completed (stamping completed_at) or reopens it.
+func (s *sqlTaskStore) UpdateStatus(taskID int64, agentUserID int64, completed bool) error {
+	query := `UPDATE tasks SET is_completed = ?, completed_at = NULL WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`
+	args := []interface{}{false, taskID, agentUserID}
+	if completed {
+		query = `UPDATE tasks SET is_completed = ?, completed_at = ? WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`
+		args = []interface{}{true, time.Now(), taskID, agentUserID}
+	}
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to execute update task status: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+type sqlTaskSuggestionStore struct{ db *sql.DB }
+
+const taskSuggestionColumns = `id, agent_user_id, client_id, description, due_date, is_urgent, rationale, model, prompt_version, status, edited, reject_reason, task_id, created_at, reviewed_at`
+
+func scanTaskSuggestion(scanner rowScanner) (*TaskSuggestion, error) {
+	ts := &TaskSuggestion{}
+	err := scanner.Scan(&ts.ID, &ts.AgentUserID, &ts.ClientID, &ts.Description, &ts.DueDate, &ts.IsUrgent, &ts.Rationale, &ts.Model,
+		&ts.PromptVersion, &ts.Status, &ts.Edited, &ts.RejectReason, &ts.TaskID, &ts.CreatedAt, &ts.ReviewedAt)
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (s *sqlTaskSuggestionStore) Create(suggestion TaskSuggestion) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO task_suggestions (agent_user_id, client_id, description, due_date, is_urgent, rationale, model, prompt_version, status)
+                           VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'pending')`,
+		suggestion.AgentUserID, suggestion.ClientID, suggestion.Description, suggestion.DueDate, suggestion.IsUrgent,
+		suggestion.Rationale, suggestion.Model, suggestion.PromptVersion)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert task suggestion: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID for task suggestion: %w", err)
+	}
+	return id, nil
+}
+
+func (s *sqlTaskSuggestionStore) GetByID(suggestionID int64, agentUserID int64) (*TaskSuggestion, error) {
+	row := s.db.QueryRow(`SELECT `+taskSuggestionColumns+` FROM task_suggestions WHERE id = ? AND agent_user_id = ?`, suggestionID, agentUserID)
+	ts, err := scanTaskSuggestion(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to scan task suggestion %d: %w", suggestionID, err)
+	}
+	return ts, nil
+}
+
+func (s *sqlTaskSuggestionStore) ListByAgent(agentUserID int64, statusFilter string, clientID int64) ([]TaskSuggestion, error) {
+	query := `SELECT ` + taskSuggestionColumns + ` FROM task_suggestions WHERE agent_user_id = ?`
+	args := []interface{}{agentUserID}
+	if statusFilter != "" {
+		query += ` AND status = ?`
+		args = append(args, statusFilter)
+	}
+	if clientID != 0 {
+		query += ` AND client_id = ?`
+		args = append(args, clientID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query task suggestions: %w", err)
+	}
+	defer rows.Close()
+	suggestions := []TaskSuggestion{}
+	for rows.Next() {
+		ts, err := scanTaskSuggestion(rows)
+		if err != nil {
+			log.Printf("ERROR: Scan task suggestion row failed: %v", err)
+			continue
+		}
+		suggestions = append(suggestions, *ts)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+func (s *sqlTaskSuggestionStore) Accept(suggestionID int64, agentUserID int64, task Task, edited bool, reviewedAt time.Time) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	// Claiming the row first means a concurrent accept of the same suggestion matches nothing.
+	res, err := tx.Exec(`UPDATE task_suggestions SET status = 'accepted', edited = ?, reviewed_at = ?
+                         WHERE id = ? AND agent_user_id = ? AND status = 'pending'`, edited, reviewedAt, suggestionID, agentUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to accept task suggestion %d: %w", suggestionID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, s.notPendingError(tx, suggestionID, agentUserID)
+	}
+	res, err = tx.Exec(`INSERT INTO tasks (client_id, agent_user_id, description, due_date, is_urgent, is_completed) VALUES (?, ?, ?, ?, ?, ?)`,
+		task.ClientID, agentUserID, task.Description, task.DueDate, task.IsUrgent, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert task for suggestion %d: %w", suggestionID, err)
+	}
+	taskID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE task_suggestions SET task_id = ? WHERE id = ?`, taskID, suggestionID); err != nil {
+		return 0, fmt.Errorf("failed to link task suggestion %d: %w", suggestionID, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Task suggestion %d accepted as task %d\n", suggestionID, taskID)
+	return taskID, nil
+}
+
+func (s *sqlTaskSuggestionStore) Reject(suggestionID int64, agentUserID int64, reason string, reviewedAt time.Time) error {
+	res, err := s.db.Exec(`UPDATE task_suggestions SET status = 'rejected', reject_reason = ?, reviewed_at = ?
+                           WHERE id = ? AND agent_user_id = ? AND status = 'pending'`,
+		sql.NullString{String: reason, Valid: reason != ""}, reviewedAt, suggestionID, agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to reject task suggestion %d: %w", suggestionID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return s.notPendingError(s.db, suggestionID, agentUserID)
+	}
+	return nil
+}
+
+// notPendingError explains why an update of a pending suggestion matched no row.
+func (s *sqlTaskSuggestionStore) notPendingError(q rowQuerier, suggestionID int64, agentUserID int64) error {
+	var count int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM task_suggestions WHERE id = ? AND agent_user_id = ?`, suggestionID, agentUserID).Scan(&count); err != nil {
+		return fmt.Errorf("failed to check task suggestion %d: %w", suggestionID, err)
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return errSuggestionReviewed
+}
+
+func (s *sqlTaskSuggestionStore) StatsByPromptVersion(agentUserID int64) ([]SuggestionStats, error) {
+	query := `SELECT prompt_version, status, edited, COUNT(*) FROM task_suggestions`
+	var args []interface{}
+	if agentUserID != 0 {
+		query += ` WHERE agent_user_id = ?`
+		args = append(args, agentUserID)
+	}
+	rows, err := s.db.Query(query+` GROUP BY prompt_version, status, edited`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query task suggestion stats: %w", err)
+	}
+	defer rows.Close()
+	tally := suggestionTally{}
+	for rows.Next() {
+		var version, status string
+		var edited bool
+		var count int
+		if err := rows.Scan(&version, &status, &edited, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan task suggestion stats: %w", err)
+		}
+		tally.add(version, status, edited, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tally.stats(), nil
+}
+
+type sqlGoalPlanStore struct{ db *sql.DB }
+
+const goalPlanColumns = `id, agent_user_id, baseline_json, plan_json, model, prompt_version, created_at`
+
+// scanGoalPlan reads a goal_plans row; the baseline and plan are stored as JSON text.
+func scanGoalPlan(scanner rowScanner) (*GoalPlan, error) {
+	gp := &GoalPlan{}
+	var baselineJSON, planJSON string
+	if err := scanner.Scan(&gp.ID, &gp.AgentUserID, &baselineJSON, &planJSON, &gp.Model, &gp.PromptVersion, &gp.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(baselineJSON), &gp.Baseline); err != nil {
+		return nil, fmt.Errorf("failed to decode goal plan %d baseline: %w", gp.ID, err)
+	}
+	if err := json.Unmarshal([]byte(planJSON), &gp.Plan); err != nil {
+		return nil, fmt.Errorf("failed to decode goal plan %d: %w", gp.ID, err)
+	}
+	return gp, nil
+}
+
+func (s *sqlGoalPlanStore) Create(plan GoalPlan) (int64, error) {
+	baselineJSON, err := json.Marshal(plan.Baseline)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode goal plan baseline: %w", err)
+	}
+	planJSON, err := json.Marshal(plan.Plan)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode goal plan: %w", err)
+	}
+	res, err := s.db.Exec(`INSERT INTO goal_plans (agent_user_id, target_income, target_period, baseline_json, plan_json, model, prompt_version, created_at)
+                           VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		plan.AgentUserID, plan.Baseline.TargetIncome, plan.Baseline.TargetPeriod, string(baselineJSON), string(planJSON),
+		plan.Model, plan.PromptVersion, plan.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert goal plan: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID for goal plan: %w", err)
+	}
+	return id, nil
+}
+
+func (s *sqlGoalPlanStore) GetByID(planID int64, agentUserID int64) (*GoalPlan, error) {
+	row := s.db.QueryRow(`SELECT `+goalPlanColumns+` FROM goal_plans WHERE id = ? AND agent_user_id = ?`, planID, agentUserID)
+	gp, err := scanGoalPlan(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to scan goal plan %d: %w", planID, err)
+	}
+	return gp, nil
+}
+
+func (s *sqlGoalPlanStore) ListByAgent(agentUserID int64, limit int) ([]GoalPlan, error) {
+	rows, err := s.db.Query(`SELECT `+goalPlanColumns+` FROM goal_plans WHERE agent_user_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`, agentUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query goal plans: %w", err)
+	}
+	defer rows.Close()
+	plans := []GoalPlan{}
+	for rows.Next() {
+		gp, err := scanGoalPlan(rows)
+		if err != nil {
+			log.Printf("ERROR: Scan goal plan row failed: %v", err)
+			continue
+		}
+		plans = append(plans, *gp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+type sqlAiRecommendationStore struct{ db *sql.DB }
+
+const aiRecommendationColumns = `id, client_id, agent_user_id, input_hash, generated_text, edited_text, is_approved, is_pinned, model, prompt_version, created_at, reviewed_at`
+
+func scanAiRecommendation(scanner rowScanner) (*AiRecommendation, error) {
+	rec := &AiRecommendation{}
+	err := scanner.Scan(&rec.ID, &rec.ClientID, &rec.AgentUserID, &rec.InputHash, &rec.GeneratedText, &rec.EditedText,
+		&rec.Approved, &rec.Pinned, &rec.Model, &rec.PromptVersion, &rec.CreatedAt, &rec.ReviewedAt)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// getOne runs a single-row recommendation query, passing sql.ErrNoRows through unwrapped.
+func (s *sqlAiRecommendationStore) getOne(query string, args ...interface{}) (*AiRecommendation, error) {
+	rec, err := scanAiRecommendation(s.db.QueryRow(`SELECT `+aiRecommendationColumns+` FROM ai_recommendations `+query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to scan AI recommendation: %w", err)
+	}
+	return rec, nil
+}
+
+func (s *sqlAiRecommendationStore) Create(rec AiRecommendation) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO ai_recommendations (client_id, agent_user_id, input_hash, generated_text, model, prompt_version)
+                           VALUES (?, ?, ?, ?, ?, ?)`,
+		rec.ClientID, rec.AgentUserID, rec.InputHash, rec.GeneratedText, rec.Model, rec.PromptVersion)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return 0, errDuplicateRecord
+		}
+		return 0, fmt.Errorf("failed to execute insert AI recommendation: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID for AI recommendation: %w", err)
+	}
+	return id, nil
+}
+
+func (s *sqlAiRecommendationStore) GetByID(recommendationID int64, clientID int64, agentUserID int64) (*AiRecommendation, error) {
+	return s.getOne(`WHERE id = ? AND client_id = ? AND agent_user_id = ?`, recommendationID, clientID, agentUserID)
+}
+
+func (s *sqlAiRecommendationStore) GetByInputHash(clientID int64, inputHash string) (*AiRecommendation, error) {
+	return s.getOne(`WHERE client_id = ? AND input_hash = ?`, clientID, inputHash)
+}
+
+func (s *sqlAiRecommendationStore) Published(clientID int64, inputHash string) (*AiRecommendation, error) {
+	return s.getOne(`WHERE client_id = ? AND (is_pinned = 1 OR (input_hash = ? AND is_approved = 1))
+                     ORDER BY is_pinned DESC LIMIT 1`, clientID, inputHash)
+}
+
+func (s *sqlAiRecommendationStore) ListByClient(clientID int64, agentUserID int64) ([]AiRecommendation, error) {
+	rows, err := s.db.Query(`SELECT `+aiRecommendationColumns+` FROM ai_recommendations WHERE client_id = ? AND agent_user_id = ?
+                             ORDER BY created_at DESC, id DESC`, clientID, agentUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query AI recommendations: %w", err)
+	}
+	defer rows.Close()
+	recs := []AiRecommendation{}
+	for rows.Next() {
+		rec, err := scanAiRecommendation(rows)
+		if err != nil {
+			log.Printf("ERROR: Scan AI recommendation row failed: %v", err)
+			continue
+		}
+		recs = append(recs, *rec)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+func (s *sqlAiRecommendationStore) Review(rec AiRecommendation) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback by default, commit only on success
+
+	if rec.Pinned {
+		if _, err := tx.Exec(`UPDATE ai_recommendations SET is_pinned = 0 WHERE client_id = ? AND id <> ?`, rec.ClientID, rec.ID); err != nil {
+			return fmt.Errorf("failed to unpin AI recommendations for client %d: %w", rec.ClientID, err)
+		}
+	}
+	res, err := tx.Exec(`UPDATE ai_recommendations SET edited_text = ?, is_approved = ?, is_pinned = ?, reviewed_at = ?
+                         WHERE id = ? AND client_id = ? AND agent_user_id = ?`,
+		rec.EditedText, rec.Approved, rec.Pinned, rec.ReviewedAt, rec.ID, rec.ClientID, rec.AgentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to update AI recommendation %d: %w", rec.ID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+type sqlDocumentStore struct{ db *sql.DB }
+
+func (s *sqlDocumentStore) Create(doc Document) (int64, error) {
+	stmt, err := s.db.Prepare(`INSERT INTO documents (client_id, agent_user_id, title, document_type, file_url)
+                               SELECT id, agent_user_id, ?, ?, ? FROM clients WHERE id = ? AND agent_user_id = ? AND deleted_at IS NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert document: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(doc.Title, doc.DocumentType, doc.FileURL, doc.ClientID, doc.AgentUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert document: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	log.Printf("DATABASE: Document record created with ID: %d\n", id)
+	return id, nil
+}
+
+func (s *sqlDocumentStore) ListByClient(clientID int64, agentUserID int64) ([]Document, error) {
+	rows, err := s.db.Query(`SELECT id, client_id, agent_user_id, title, document_type, file_url, uploaded_at FROM documents WHERE client_id = ? AND agent_user_id = ? AND deleted_at IS NULL ORDER BY uploaded_at DESC`, clientID, agentUserID)
+	if err != nil {
+		log.Printf("ERROR: Query documents failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []Document
+	for rows.Next() {
+		var d Document
+		if err := rows.Scan(&d.ID, &d.ClientID, &d.AgentUserID, &d.Title, &d.DocumentType, &d.FileURL, &d.UploadedAt); err != nil {
+			log.Printf("ERROR: Scan document row failed: %v", err)
+			continue
+		}
+		docs = append(docs, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+type sqlActivityStore struct{ db *sql.DB }
+
+func (s *sqlActivityStore) Log(agentUserID int64, activityType, description, relatedID string) error {
+	stmt, err := s.db.Prepare(`INSERT INTO activity_log (agent_user_id, activity_type, description, related_id) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert activity: %w", err)
+	}
+	defer stmt.Close()
+	if _, err = stmt.Exec(agentUserID, activityType, description, relatedID); err != nil {
+		return fmt.Errorf("failed to execute insert activity: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlActivityStore) Recent(agentUserID int64, limit int) ([]ActivityLog, error) {
+	log.Printf("DATABASE: Fetching recent activity for agent %d (Limit: %d)\n", agentUserID, limit)
+	rows, err := s.db.Query(`SELECT id, agent_user_id, timestamp, activity_type, description, related_id
+                           FROM activity_log WHERE agent_user_id = ?
+                           ORDER BY timestamp DESC LIMIT ?`, agentUserID, limit)
+	if err != nil {
+		log.Printf("ERROR: Query activity log failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var activities []ActivityLog
+	for rows.Next() {
+		var a ActivityLog
+		var related sql.NullString // Handle potentially null related_id
+		if err := rows.Scan(&a.ID, &a.AgentUserID, &a.Timestamp, &a.ActivityType, &a.Description, &related); err != nil {
+			log.Printf("ERROR: Scan activity log row failed: %v", err)
+			continue
+		}
+		if related.Valid {
+			a.RelatedID = related.String
+		}
+		activities = append(activities, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+func (s *sqlActivityStore) List(agentUserID int64, page, pageSize int) ([]ActivityLog, int, error) {
+	log.Printf("DATABASE: Fetching full activity log for agent %d (Page: %d, Size: %d)\n", agentUserID, page, pageSize)
+	offset := (page - 1) * pageSize
+
+	countQuery := "SELECT COUNT(*) FROM activity_log WHERE agent_user_id = ?"
+	dataQuery := `SELECT id, agent_user_id, timestamp, activity_type, description, related_id
+                  FROM activity_log WHERE agent_user_id = ?
+                  ORDER BY timestamp DESC LIMIT ? OFFSET ?`
+	args := []interface{}{agentUserID}
+
+	// Get total count
+	var totalItems int
+	err := s.db.QueryRow(countQuery, args...).Scan(&totalItems)
+	if err != nil {
+		log.Printf("ERROR: Count activity log failed: %v", err)
+		return nil, 0, err
+	}
+
+	// Fetch data
+	pagedArgs := append(args, pageSize, offset)
+	rows, err := s.db.Query(dataQuery, pagedArgs...)
+	if err != nil {
+		log.Printf("ERROR: Query full activity log failed: %v", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var activities []ActivityLog
+	for rows.Next() {
+		var a ActivityLog
+		var related sql.NullString
+		if err := rows.Scan(&a.ID, &a.AgentUserID, &a.Timestamp, &a.ActivityType, &a.Description, &related); err != nil {
+			log.Printf("ERROR: Scan full activity log row failed: %v", err)
+			continue
+		}
+		if related.Valid {
+			a.RelatedID = related.String
+		}
+		activities = append(activities, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return activities, totalItems, nil
+}
+
+type sqlOutboxStore struct{ db *sql.DB }
+
+const outboxColumns = `id, agent_user_id, recipients, subject, body, text_body, status, attempts, last_error, next_attempt_at, created_at, sent_at`
+
+func scanOutboxEmail(scanner rowScanner) (*OutboxEmail, error) {
+	e := &OutboxEmail{}
+	var recipients string
+	err := scanner.Scan(&e.ID, &e.AgentUserID, &recipients, &e.Subject, &e.Body, &e.TextBody, &e.Status, &e.Attempts, &e.LastError, &e.NextAttemptAt, &e.CreatedAt, &e.SentAt)
+	if err != nil {
+		return nil, err
+	}
+	e.To = strings.Split(recipients, ",")
+	return e, nil
+}
+
+func (s *sqlOutboxStore) Enqueue(email OutboxEmail) (int64, error) {
+	stmt, err := s.db.Prepare(`INSERT INTO email_outbox (agent_user_id, recipients, subject, body, text_body, status, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert outbox email: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(email.AgentUserID, strings.Join(email.To, ","), email.Subject, email.Body, email.TextBody, email.Status, email.NextAttemptAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert outbox email: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID for outbox email: %w", err)
+	}
+	return id, nil
+}
+
+func (s *sqlOutboxStore) ClaimDue(now time.Time, lease time.Duration, limit int) ([]OutboxEmail, error) {
+	rows, err := s.db.Query(`SELECT `+outboxColumns+` FROM email_outbox
+                             WHERE status IN ('pending', 'sending') AND next_attempt_at <= ?
+                             ORDER BY next_attempt_at ASC, id ASC LIMIT ?`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due outbox emails: %w", err)
+	}
+	var due []OutboxEmail
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			log.Printf("ERROR: Scan outbox email row failed: %v", err)
+			continue
+		}
+		due = append(due, *e)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The attempt counter doubles as a version: if another worker claimed the row first, it
+	// has already bumped attempts and this update matches nothing.
+	var claimed []OutboxEmail
+	for _, e := range due {
+		res, err := s.db.Exec(`UPDATE email_outbox SET status = 'sending', attempts = attempts + 1, next_attempt_at = ?
+                               WHERE id = ? AND attempts = ?`, now.Add(lease), e.ID, e.Attempts)
+		if err != nil {
+			return claimed, fmt.Errorf("failed to claim outbox email %d: %w", e.ID, err)
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			e.Status = "sending"
+			e.Attempts++
+			claimed = append(claimed, e)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *sqlOutboxStore) MarkSent(emailID int64, sentAt time.Time) error {
+	if _, err := s.db.Exec(`UPDATE email_outbox SET status = 'sent', sent_at = ?, last_error = NULL WHERE id = ?`, sentAt, emailID); err != nil {
+		return fmt.Errorf("failed to mark outbox email %d sent: %w", emailID, err)
+	}
+	return nil
+}
+
+func (s *sqlOutboxStore) Reschedule(emailID int64, lastError string, retryAt time.Time) error {
+	if _, err := s.db.Exec(`UPDATE email_outbox SET status = 'pending', last_error = ?, next_attempt_at = ? WHERE id = ?`, lastError, retryAt, emailID); err != nil {
+		return fmt.Errorf("failed to reschedule outbox email %d: %w", emailID, err)
+	}
+	return nil
+}
+
+func (s *sqlOutboxStore) MarkFailed(emailID int64, lastError string) error {
+	if _, err := s.db.Exec(`UPDATE email_outbox SET status = 'failed', last_error = ? WHERE id = ?`, lastError, emailID); err != nil {
+		return fmt.Errorf("failed to mark outbox email %d failed: %w", emailID, err)
+	}
+	return nil
+}
+
+func (s *sqlOutboxStore) GetByID(emailID int64) (*OutboxEmail, error) {
+	row := s.db.QueryRow(`SELECT `+outboxColumns+` FROM email_outbox WHERE id = ?`, emailID)
+	e, err := scanOutboxEmail(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to scan outbox email %d: %w", emailID, err)
+	}
+	return e, nil
+}
+
+type sqlPortalTokenStore struct{ db *sql.DB }
+
+func (s *sqlPortalTokenStore) Store(token string, clientID int64, agentUserID int64, duration time.Duration) error {
+	log.Printf("DATABASE: Storing portal token for client %d (agent %d)\n", clientID, agentUserID)
+	expiresAt := time.Now().Add(duration)
+	// Using token directly as PK, assuming it's unique enough (generate securely)
+	stmt, err := s.db.Prepare("INSERT INTO client_portal_tokens (token, client_id, agent_user_id, expires_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare store portal token: %w", err)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(token, clientID, agentUserID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to execute store portal token: %w", err)
+	}
+	log.Printf("DATABASE: Portal token stored successfully\n")
+	return nil
+}
+
+func (s *sqlPortalTokenStore) Verify(token string) (clientID int64, agentUserID int64, err error) {
+	log.Printf("DATABASE: Verifying portal token\n")
+	row := s.db.QueryRow("SELECT client_id, agent_user_id FROM client_portal_tokens WHERE token = ? AND expires_at > ?", token, time.Now())
+	err = row.Scan(&clientID, &agentUserID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("ERROR: Failed to scan portal token row: %v\n", err)
+		} else {
+			log.Printf("DATABASE: Portal token not found or expired\n")
+		}
+		return 0, 0, err // Return specific error (sql.ErrNoRows or other)
+	}
+	log.Printf("DATABASE: Portal token verified for client %d (agent %d)\n", clientID, agentUserID)
+	return clientID, agentUserID, nil
+}
+
+func (s *sqlPortalTokenStore) RecordVisit(token string, at time.Time) error {
+	if _, err := s.db.Exec(`UPDATE client_portal_tokens SET visit_count = visit_count + 1, last_visited_at = ? WHERE token = ?`, at, token); err != nil {
+		return fmt.Errorf("failed to record portal visit: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlPortalTokenStore) CountVisits(clientID int64, agentUserID int64) (int, error) {
+	var visits int
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(visit_count), 0) FROM client_portal_tokens WHERE client_id = ? AND agent_user_id = ?`,
+		clientID, agentUserID).Scan(&visits)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count portal visits of client %d: %w", clientID, err)
+	}
+	return visits, nil
+}
+
+type sqlAgentStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (s *sqlAgentStore) GetProfile(userID int64) (*AgentProfile, error) {
+	log.Printf("DATABASE: Getting agent profile for user %d\n", userID)
+	row := s.db.QueryRow(`SELECT user_id, mobile, gender, postal_address, agency_name, pan, bank_name, bank_account_no, bank_ifsc,
+                       logo_url, email_signature
+                       FROM agent_profiles WHERE user_id = ?`, userID)
+	profile := &AgentProfile{}
+	err := row.Scan(
+		&profile.UserID, &profile.Mobile, &profile.Gender, &profile.PostalAddress, &profile.AgencyName,
+		&profile.PAN, &profile.BankName, &profile.BankAccountNo, &profile.BankIFSC,
+		&profile.LogoURL, &profile.EmailSignature,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		} // Return specific error for not found
+		log.Printf("ERROR: Failed to scan agent profile row for user %d: %v\n", userID, err)
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (s *sqlAgentStore) UpsertProfile(profile AgentProfile) error {
+	log.Printf("DATABASE: Upserting agent profile for user %d\n", profile.UserID)
+	// Update in place when the row exists; ON DUPLICATE KEY UPDATE is avoided because a PAN
+	// collision would then overwrite another agent's profile instead of failing.
+	var exists int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM agent_profiles WHERE user_id = ?`, profile.UserID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check existing agent profile: %w", err)
+	}
+	query := `INSERT INTO agent_profiles
+        (mobile, gender, postal_address, agency_name, pan, bank_name, bank_account_no, bank_ifsc, logo_url, email_signature, user_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if exists > 0 {
+		query = `UPDATE agent_profiles SET
+        mobile = ?, gender = ?, postal_address = ?, agency_name = ?, pan = ?, bank_name = ?, bank_account_no = ?, bank_ifsc = ?,
+        logo_url = ?, email_signature = ?
+        WHERE user_id = ?`
+	}
+	_, err := s.db.Exec(query,
+		profile.Mobile, profile.Gender, profile.PostalAddress, profile.AgencyName,
+		profile.PAN, profile.BankName, profile.BankAccountNo, profile.BankIFSC,
+		profile.LogoURL, profile.EmailSignature, profile.UserID,
+	)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return fmt.Errorf("PAN number already exists for another user")
+		}
+		return fmt.Errorf("failed to execute upsert agent profile: %w", err)
+	}
+	log.Printf("DATABASE: Agent profile upserted successfully for user %d\n", profile.UserID)
+	return nil
+}
+
+func (s *sqlAgentStore) GetGoal(userID int64) (*AgentGoal, error) {
+	log.Printf("DATABASE: Getting agent goals for user %d\n", userID)
+	row := s.db.QueryRow(`SELECT user_id, target_income, target_period FROM agent_goals WHERE user_id = ?`, userID)
+	goal := &AgentGoal{}
+	err := row.Scan(&goal.UserID, &goal.TargetIncome, &goal.TargetPeriod)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		log.Printf("ERROR: Failed to scan agent goal row for user %d: %v\n", userID, err)
+		return nil, err
+	}
+	return goal, nil
+}
+
+func (s *sqlAgentStore) UpsertGoal(goal AgentGoal) error {
+	log.Printf("DATABASE: Upserting agent goal for user %d\n", goal.UserID)
+	stmt, err := s.db.Prepare(`INSERT INTO agent_goals (user_id, target_income, target_period) VALUES (?, ?, ?) ` +
+		s.dialect.upsertClause([]string{"user_id"}, "target_income", "target_period"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare upsert agent goal: %w", err)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(goal.UserID, goal.TargetIncome, goal.TargetPeriod)
+	if err != nil {
+		return fmt.Errorf("failed to execute upsert agent goal: %w", err)
+	}
+	log.Printf("DATABASE: Agent goal upserted successfully for user %d\n", goal.UserID)
+	return nil
+}
+
+type sqlInsurerStore struct{ db *sql.DB }
+
+func (s *sqlInsurerStore) ListRelations(agentUserID int64) ([]AgentInsurerRelation, error) {
+	log.Printf("DATABASE: Getting insurer relations for agent %d\n", agentUserID)
+	rows, err := s.db.Query(`SELECT id, agent_user_id, insurer_name, agent_code, spoc_email,
+                           upfront_commission_percentage, trail_commission_percentage
+                       FROM agent_insurer_relations WHERE agent_user_id = ? ORDER BY insurer_name ASC`, agentUserID) // Select new columns
+	if err != nil {
+		log.Printf("ERROR: Query agent relations failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	relations := []AgentInsurerRelation{}
+	for rows.Next() {
+		var rel AgentInsurerRelation
+		// Scan new columns
+		if err := rows.Scan(&rel.ID, &rel.AgentUserID, &rel.InsurerName, &rel.AgentCode, &rel.SpocEmail,
+			&rel.UpfrontCommissionPercentage, &rel.TrailCommissionPercentage); err != nil {
+			log.Printf("ERROR: Scan agent relation row failed: %v", err)
+			continue
+		}
+		relations = append(relations, rel)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return relations, nil
+}
+
+func (s *sqlInsurerStore) SetRelations(agentUserID int64, relations []AgentInsurerRelation) error {
+	log.Printf("DATABASE: Setting insurer relations for agent %d (count: %d)\n", agentUserID, len(relations))
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Delete old relations
+	_, err = tx.Exec("DELETE FROM agent_insurer_relations WHERE agent_user_id = ?", agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete existing relations: %w", err)
+	}
+
+	// Prepare insert
+	stmt, err := tx.Prepare(`
+		INSERT INTO agent_insurer_relations (
+			agent_user_id, insurer_name, agent_code, spoc_email, 
+			upfront_commission_percentage, trail_commission_percentage,
+			name, category, description, status, features, eligibility,
+			term, exclusions, room_rent, premium_indication,
+			insurer_logo_url, brochure_url, wording_url, claim_form_url,
+			created_at,product_id
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert relation: %w", err)
+	}
+	defer stmt.Close()
+
+	insertCount := 0
+	maxRelations := 25
+	seenInsurers := make(map[string]bool)
+
+	now := time.Now()
+
+	for i, rel := range relations {
+		if i >= maxRelations {
+			log.Printf("WARN: Max insurer relations (%d) reached for agent %d.", maxRelations, agentUserID)
+			break
+		}
+		if rel.InsurerName == "" {
+			continue
+		}
+		lowerInsurer := strings.ToLower(rel.InsurerName)
+		if seenInsurers[lowerInsurer] {
+			log.Printf("WARN: Duplicate insurer '%s' in payload for agent %d, skipping.", rel.InsurerName, agentUserID)
+			continue
+		}
+
+		_, err = stmt.Exec(
+			agentUserID,
+			rel.InsurerName,
+			rel.AgentCode,
+			rel.SpocEmail,
+			rel.UpfrontCommissionPercentage,
+			rel.TrailCommissionPercentage,
+			rel.Name,
+			rel.Category,
+			rel.Description,
+			rel.Status,
+			rel.Features,
+			rel.Eligibility,
+			rel.Term,
+			rel.Exclusions,
+			rel.RoomRent,
+			rel.PremiumIndication,
+			rel.InsurerLogoURL,
+			rel.BrochureURL,
+			rel.WordingURL,
+			rel.ClaimFormURL,
+			now,
+			rel.ProductID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert relation for insurer '%s': %w", rel.InsurerName, err)
+		}
+		seenInsurers[lowerInsurer] = true
+		insertCount++
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Successfully set %d insurer relations for agent %d\n", insertCount, agentUserID)
+	return nil
+}
+
+func (s *sqlInsurerStore) GetRelationByInsurer(agentUserID int64, insurerName string) (*AgentInsurerRelation, error) {
+	row := s.db.QueryRow(`SELECT id, agent_user_id, insurer_name, agent_code, spoc_email, upfront_commission_percentage, trail_commission_percentage
+                       FROM agent_insurer_relations
+                       WHERE agent_user_id = ? AND LOWER(insurer_name) = LOWER(?)`,
+		agentUserID, insurerName)
+	detail := &AgentInsurerRelation{}
+	err := row.Scan(&detail.ID, &detail.AgentUserID, &detail.InsurerName, &detail.AgentCode, &detail.SpocEmail, &detail.UpfrontCommissionPercentage, &detail.TrailCommissionPercentage)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		}
+		return nil, err
+	}
+	return detail, nil
+}
+
+func (s *sqlInsurerStore) SearchProducts(userID int64, categoryFilter, insurerFilter, searchTerm string) ([]AgentInsurerRelation, error) {
+	query := `SELECT id, name, category, insurer_name, product_id, description, status, features, eligibility, term, exclusions, room_rent, premium_indication, insurer_logo_url, brochure_url, wording_url, claim_form_url, upfront_commission_percentage, trail_commission_percentage, created_at, updated_at FROM agent_insurer_relations where agent_user_id=?`
+	args := []interface{}{userID}
+	if categoryFilter != "" && categoryFilter != "All Categories" {
+		query += " AND category = ?"
+		args = append(args, categoryFilter)
+	}
+	if insurerFilter != "" && insurerFilter != "All Insurers" {
+		query += " AND insurer_name = ?"
+		args = append(args, insurerFilter)
+	}
+	if searchTerm != "" {
+		query += " AND (name LIKE ? OR insurer_name LIKE ? OR description LIKE ?)"
+		term := "%" + searchTerm + "%"
+		args = append(args, term, term, term)
+	}
+	query += " ORDER BY category, name"
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("ERROR: Failed to query products: %v\n", err)
+		return nil, fmt.Errorf("database query error")
+	}
+	defer rows.Close()
+	products := []AgentInsurerRelation{}
+	for rows.Next() {
+		var p AgentInsurerRelation
+		if err := rows.Scan(&p.ID, &p.Name, &p.Category, &p.InsurerName, &p.ProductID, &p.Description, &p.Status, &p.Features, &p.Eligibility, &p.Term, &p.Exclusions, &p.RoomRent, &p.PremiumIndication, &p.InsurerLogoURL, &p.BrochureURL, &p.WordingURL, &p.ClaimFormURL, &p.UpfrontCommissionPercentage, &p.TrailCommissionPercentage, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			log.Printf("ERROR: Failed to scan product row: %v\n", err)
+			continue
+		}
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("ERROR: Error iterating product rows: %v\n", err)
+		return nil, fmt.Errorf("database iteration error")
+	}
+	log.Printf("DATABASE: Found %d products.\n", len(products))
+	return products, nil
+}
+
+func (s *sqlInsurerStore) ListProductNames(agentUserID int64) ([]AgentInsurerRelation, error) {
+	rows, err := s.db.Query(`SELECT product_id, name FROM agent_insurer_relations WHERE agent_user_id = ?`, agentUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	products := []AgentInsurerRelation{}
+	for rows.Next() {
+		var p AgentInsurerRelation
+		if err := rows.Scan(&p.ID, &p.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan product row: %w", err)
+		}
+		products = append(products, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (s *sqlInsurerStore) SetPOCs(agentUserID int64, pocs []AgentInsurerPOC) error {
+	log.Printf("DATABASE: Setting insurer POCs for agent %d (count: %d)\n", agentUserID, len(pocs))
+	// Use a transaction
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if anything fails
+
+	// 1. Delete existing POCs for the agent
+	_, err = tx.Exec("DELETE FROM agent_insurer_pocs WHERE agent_user_id = ?", agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete existing POCs: %w", err)
+	}
+
+	// 2. Insert new POCs (limit to 6 on backend as well, though frontend should enforce)
+	stmt, err := tx.Prepare("INSERT INTO agent_insurer_pocs (agent_user_id, insurer_name, poc_email) VALUES (?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert POC: %w", err)
+	}
+	defer stmt.Close()
+
+	insertCount := 0
+	for i, poc := range pocs {
+		if i >= 6 { // Enforce limit
+			log.Printf("WARN: Attempted to save more than 6 insurer POCs for agent %d. Truncating.", agentUserID)
+			break
+		}
+		if poc.InsurerName == "" || poc.PocEmail == "" { // Basic validation
+			log.Printf("WARN: Skipping POC entry with empty insurer or email for agent %d.", agentUserID)
+			continue
+		}
+		_, err = stmt.Exec(agentUserID, poc.InsurerName, poc.PocEmail)
+		if err != nil {
+			// Check for unique constraint violation
+			if isDuplicateKeyError(err) {
+				log.Printf("WARN: Duplicate insurer name '%s' skipped for agent %d.", poc.InsurerName, agentUserID)
+				continue // Skip duplicate instead of failing transaction
+			}
+			return fmt.Errorf("failed to insert POC for insurer '%s': %w", poc.InsurerName, err)
+		}
+		insertCount++
+	}
+
+	// Commit transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("DATABASE: Successfully set %d insurer POCs for agent %d\n", insertCount, agentUserID)
+	return nil
+}
+
+func (s *sqlInsurerStore) SetDetails(agentUserID int64, details []AgentInsurerDetail) error {
+	log.Printf("DATABASE: Setting insurer details for agent %d (count: %d)\n", agentUserID, len(details))
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 1. Delete existing details for the agent
+	_, err = tx.Exec("DELETE FROM agent_insurer_details WHERE agent_user_id = ?", agentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete existing insurer details: %w", err)
+	}
+
+	// 2. Insert new details (limit to a reasonable number, e.g., 20?)
+	stmt, err := tx.Prepare(`INSERT INTO agent_insurer_details
+        (agent_user_id, insurer_name, agent_code, spoc_email, commission_percentage)
+        VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert insurer detail: %w", err)
+	}
+	defer stmt.Close()
+
+	insertCount := 0
+	maxDetails := 20 // Set a practical limit
+	for i, detail := range details {
+		if i >= maxDetails {
+			log.Printf("WARN: Attempted to save more than %d insurer details for agent %d. Truncating.", maxDetails, agentUserID)
+			break
+		}
+		// Basic validation
+		if detail.InsurerName == "" {
+			continue
+		} // Insurer name is mandatory
+
+		_, err = stmt.Exec(agentUserID, detail.InsurerName, detail.AgentCode, detail.SpocEmail, detail.CommissionPercentage)
+		if err != nil {
+			if isDuplicateKeyError(err) {
+				log.Printf("WARN: Duplicate insurer name '%s' skipped for agent %d.", detail.InsurerName, agentUserID)
+				continue // Skip duplicate
+			}
+			return fmt.Errorf("failed to insert insurer detail for '%s': %w", detail.InsurerName, err)
+		}
+		insertCount++
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Printf("DATABASE: Successfully set %d insurer details for agent %d\n", insertCount, agentUserID)
+	return nil
+}
+
+type sqlProductStore struct{ db *sql.DB }
+
+func (s *sqlProductStore) Create(product Product) error {
+	stmt, err := s.db.Prepare(`INSERT INTO products (id, name, category, insurer, description, status, features, eligibility, term, exclusions, room_rent, premium_indication, insurer_logo_url, brochure_url, wording_url, claim_form_url, upfront_commission_percentage, trail_commission_percentage, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert product: %w", err)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(product.ID, product.Name, product.Category, product.Insurer, product.Description, product.Status, product.Features, product.Eligibility, product.Term, product.Exclusions, product.RoomRent, product.PremiumIndication, product.InsurerLogoURL, product.BrochureURL, product.WordingURL, product.ClaimFormURL, product.UpfrontCommissionPercentage, product.TrailCommissionPercentage, time.Now())
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return errDuplicateRecord
+		}
+		return fmt.Errorf("failed to execute insert product: %w", err)
+	}
+	log.Printf("DATABASE: Product created with ID: %s\n", product.ID)
+	return nil
+}
+
+func (s *sqlProductStore) GetByID(productID string) (*Product, error) {
+	row := s.db.QueryRow(`SELECT id, name, category, insurer, description, status, features, eligibility, term, exclusions, room_rent, premium_indication, insurer_logo_url, brochure_url, wording_url, claim_form_url, upfront_commission_percentage, trail_commission_percentage, created_at, updated_at FROM products WHERE id = ?`, productID)
+	p := &Product{}
+	err := row.Scan(&p.ID, &p.Name, &p.Category, &p.Insurer, &p.Description, &p.Status, &p.Features, &p.Eligibility, &p.Term, &p.Exclusions, &p.RoomRent, &p.PremiumIndication, &p.InsurerLogoURL, &p.BrochureURL, &p.WordingURL, &p.ClaimFormURL, &p.UpfrontCommissionPercentage, &p.TrailCommissionPercentage, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("ERROR: Failed to scan product row: %v\n", err)
+		} else {
+			log.Printf("DATABASE: Product %s not found\n", productID)
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListByInsurer returns the catalogue products of an insurer (case/whitespace-insensitive match).
+func (s *sqlProductStore) ListByInsurer(insurerName string) ([]Product, error) {
+	rows, err := s.db.Query(`SELECT
+		id, name, category, description, status, features, eligibility, term, exclusions,
+		room_rent, premium_indication, insurer_logo_url, brochure_url, wording_url, claim_form_url
+		FROM products
+		WHERE TRIM(LOWER(insurer)) = TRIM(LOWER(?))`, insurerName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var products []Product
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(
+			&p.ID, &p.Name, &p.Category, &p.Description, &p.Status, &p.Features, &p.Eligibility,
+			&p.Term, &p.Exclusions, &p.RoomRent, &p.PremiumIndication, &p.InsurerLogoURL,
+			&p.BrochureURL, &p.WordingURL, &p.ClaimFormURL,
+		); err != nil {
+			return nil, fmt.Errorf("failed to read product row: %w", err)
+		}
+		p.Insurer = insurerName
+		products = append(products, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (s *sqlProductStore) ListInsurers() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT insurer FROM products WHERE insurer IS NOT NULL AND insurer != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var insurers []string
+	for rows.Next() {
+		var insurer string
+		if err := rows.Scan(&insurer); err != nil {
+			log.Printf("ERROR: Failed to scan insurer: %v\n", err)
+			continue
+		}
+		insurers = append(insurers, insurer)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return insurers, nil
+}
+
+type sqlMarketingStore struct{ db *sql.DB }
+
+func (s *sqlMarketingStore) ListCampaigns(agentUserID int64) ([]MarketingCampaign, error) {
+	rows, err := s.db.Query(`SELECT id, agent_user_id, name, status, target_segment_name, sent_at, stats_opens, stats_clicks, stats_leads, created_at FROM marketing_campaigns ORDER BY created_at DESC`)
+	log.Printf("DATABASE: Fetching campaigns for agent %d\n", agentUserID)
+	if err != nil {
+		log.Printf("ERROR: Query campaigns failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var campaigns []MarketingCampaign
+	for rows.Next() {
+		var c MarketingCampaign
+		if err := rows.Scan(&c.ID, &c.AgentUserID, &c.Name, &c.Status, &c.TargetSegmentName, &c.SentAt, &c.StatsOpens, &c.StatsClicks, &c.StatsLeads, &c.CreatedAt); err != nil {
+			log.Printf("ERROR: Scan campaign row failed: %v", err)
+			continue
+		}
+		campaigns = append(campaigns, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+func (s *sqlMarketingStore) CreateCampaign(campaign MarketingCampaign) (int64, error) {
+	stmt, err := s.db.Prepare(`INSERT INTO marketing_campaigns (agent_user_id, name, status, target_segment_name, created_at) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert campaign: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(campaign.AgentUserID, campaign.Name, campaign.Status, campaign.TargetSegmentName, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert campaign: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	log.Printf("DATABASE: Campaign created with ID: %d\n", id)
+	return id, nil
+}
+
+func (s *sqlMarketingStore) ListTemplates() ([]MarketingTemplate, error) {
+	rows, err := s.db.Query(`SELECT id, name, type, category, preview_text, created_at FROM marketing_templates ORDER BY category, name`)
+	if err != nil {
+		log.Printf("ERROR: Query templates failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var templates []MarketingTemplate
+	for rows.Next() {
+		var t MarketingTemplate
+		if err := rows.Scan(&t.ID, &t.Name, &t.Type, &t.Category, &t.PreviewText, &t.CreatedAt); err != nil {
+			log.Printf("ERROR: Scan template row failed: %v", err)
+			continue
+		}
+		templates = append(templates, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (s *sqlMarketingStore) ListContent() ([]MarketingContent, error) {
+	rows, err := s.db.Query(`SELECT id, title, content_type, description, gcs_url, thumbnail_url, created_at FROM marketing_content ORDER BY created_at DESC`)
+	if err != nil {
+		log.Printf("ERROR: Query content failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var contents []MarketingContent
+	for rows.Next() {
+		var c MarketingContent
+		if err := rows.Scan(&c.ID, &c.Title, &c.ContentType, &c.Description, &c.GCSURL, &c.ThumbnailURL, &c.CreatedAt); err != nil {
+			log.Printf("ERROR: Scan content row failed: %v", err)
+			continue
+		}
+		contents = append(contents, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+func (s *sqlMarketingStore) ListSegments(agentUserID int64) ([]ClientSegment, error) {
+	rows, err := s.db.Query(`SELECT id, agent_user_id, name, criteria, client_count, created_at FROM client_segments WHERE agent_user_id = ? ORDER BY name ASC`, agentUserID)
+	if err != nil {
+		log.Printf("ERROR: Query segments failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var segments []ClientSegment
+	for rows.Next() {
+		var s ClientSegment
+		if err := rows.Scan(&s.ID, &s.AgentUserID, &s.Name, &s.Criteria, &s.ClientCount, &s.CreatedAt); err != nil {
+			log.Printf("ERROR: Scan segment row failed: %v", err)
+			continue
+		}
+		segments = append(segments, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+func (s *sqlMarketingStore) CreateSegment(segment ClientSegment) (int64, error) {
+	stmt, err := s.db.Prepare(`INSERT INTO client_segments (agent_user_id, name, criteria, client_count) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert segment: %w", err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(segment.AgentUserID, segment.Name, segment.Criteria, segment.ClientCount)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute insert segment: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	log.Printf("DATABASE: Client Segment created with ID: %d\n", id)
+	return id, nil
+}
+
+func (s *sqlMarketingStore) GetSegment(segmentID int64, agentUserID int64) (*ClientSegment, error) {
+	log.Printf("DATABASE: Getting segment %d for agent %d\n", segmentID, agentUserID)
+	row := s.db.QueryRow(`SELECT id, agent_user_id, name, criteria, client_count, created_at
+                       FROM client_segments WHERE id = ? AND agent_user_id = ?`, segmentID, agentUserID)
+	segment := &ClientSegment{}
+	err := row.Scan(
+		&segment.ID, &segment.AgentUserID, &segment.Name, &segment.Criteria,
+		&segment.ClientCount, &segment.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, sql.ErrNoRows
+		} // Not found or not owned
+		log.Printf("ERROR: Failed to scan segment row %d: %v\n", segmentID, err)
+		return nil, err
+	}
+	return segment, nil
+}
+
+func (s *sqlMarketingStore) UpdateSegment(segment ClientSegment) error {
+	log.Printf("DATABASE: Updating segment %d for agent %d\n", segment.ID, segment.AgentUserID)
+	stmt, err := s.db.Prepare(`UPDATE client_segments SET name = ?, criteria = ?
+                           WHERE id = ? AND agent_user_id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare update segment: %w", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(segment.Name, segment.Criteria, segment.ID, segment.AgentUserID)
+	if err != nil {
+		return fmt.Errorf("failed to execute update segment: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	} // Indicate not found or wrong owner
+
+	log.Printf("DATABASE: Segment %d updated successfully\n", segment.ID)
+	return nil
+}
+
+type sqlNoticeStore struct{ db *sql.DB }
+
+func (s *sqlNoticeStore) List(categoryFilter string) ([]Notice, error) {
+	query := "SELECT id, title, content, category, posted_by, is_important, created_at FROM notices"
+	args := []interface{}{}
+	if categoryFilter != "" && categoryFilter != "All Categories" {
+		query += " WHERE category = ?"
+		args = append(args, categoryFilter)
+	}
+	query += " ORDER BY created_at DESC"
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("ERROR: Failed to query notices: %v\n", err)
+		return nil, fmt.Errorf("database query error")
+	}
+	defer rows.Close()
+	notices := []Notice{}
+	for rows.Next() {
+		var n Notice
+		var createdAtStr string
+		var category sql.NullString
+		var postedBy sql.NullString
+		if err := rows.Scan(&n.ID, &n.Title, &n.Content, &category, &postedBy, &n.IsImportant, &createdAtStr); err != nil {
+			log.Printf("ERROR: Failed to scan notice row: %v\n", err)
+			continue
+		}
+		if category.Valid {
+			n.Category = category.String
+		}
+		if postedBy.Valid {
+			n.PostedBy = postedBy.String
+		}
+		layout := "2006-01-02 15:04:05"
+		parsedTime, err := time.Parse(layout, createdAtStr)
+		if err != nil {
+			parsedTime, err = time.Parse(time.RFC3339, createdAtStr)
+			if err != nil {
+				log.Printf("WARN: Failed to parse timestamp '%s' for notice %d: %v", createdAtStr, n.ID, err)
+			}
+		}
+		n.CreatedAt = parsedTime
+		notices = append(notices, n)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("ERROR: Error iterating notice rows: %v\n", err)
+		return nil, fmt.Errorf("database iteration error")
+	}
+	log.Printf("DATABASE: Found %d notices.\n", len(notices))
+	return notices, nil
+}
+
+type sqlMetricsStore struct{ db *sql.DB }
+
+func (s *sqlMetricsStore) Dashboard(agentUserID int64) (*DashboardMetrics, error) {
+	metrics := &DashboardMetrics{}
+	now := time.Now()
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	firstOfNextMonth := firstOfMonth.AddDate(0, 1, 0)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	thirtyDaysFromNow := today.AddDate(0, 0, 30)
+	sevenDaysAgo := today.AddDate(0, 0, -7)
+
+	// Policies Sold This Month
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM policies WHERE agent_user_id = ? AND deleted_at IS NULL AND created_at >= ? AND created_at < ?`,
+		agentUserID, firstOfMonth, firstOfNextMonth).Scan(&metrics.PoliciesSoldThisMonth)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("ERROR: DB metrics policies sold: %v", err)
+		return nil, err
+	}
+
+	// Upcoming Renewals (Next 30 days)
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM policies WHERE agent_user_id = ? AND deleted_at IS NULL AND status = 'Active' AND end_date >= ? AND end_date < ?`,
+		agentUserID, today, thirtyDaysFromNow).Scan(&metrics.UpcomingRenewals30d)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("ERROR: DB metrics renewals: %v", err)
+		return nil, err
+	}
+
+	// Commission Earned This Month
+	var commissionThisMonth *float64
+	err = s.db.QueryRow(`SELECT SUM(upfront_commission_amount) FROM policies WHERE agent_user_id = ? AND deleted_at IS NULL AND created_at >= ? AND created_at < ?`,
+		agentUserID, firstOfMonth, firstOfNextMonth).Scan(&commissionThisMonth)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("ERROR: DB metrics commission: %v", err)
+		return nil, err
+	}
+
+	// Handle the case where there's no commission this month (NULL value)
+	if commissionThisMonth != nil {
+		metrics.CommissionThisMonth = *commissionThisMonth
+	} else {
+		metrics.CommissionThisMonth = 0.0 // Or any other appropriate default value
+	}
+
+	// New Leads This Week
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM clients WHERE agent_user_id = ? AND deleted_at IS NULL AND status = 'Lead' AND created_at >= ?`,
+		agentUserID, sevenDaysAgo).Scan(&metrics.NewLeadsThisWeek)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("ERROR: DB metrics new leads: %v", err)
+		return nil, err
+	}
+
+	log.Printf("DATABASE: Fetched dashboard metrics for agent %d", agentUserID)
+	return metrics, nil
+}
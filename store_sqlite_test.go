@@ -0,0 +1,216 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The SQL stores run against SQLite with go test -tags sqlite; the default suite covers the
+// memory stores only.
+
+// openSQLiteTestDB points db at a fresh SQLite database in the test's temp dir.
+func openSQLiteTestDB(t *testing.T) {
+	t.Helper()
+	config.DBDriver = "sqlite"
+	config.DBDSN = "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	if err := openDatabase(); err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		dbDialect = dialectMySQL
+	})
+}
+
+func TestSQLiteStores(t *testing.T) {
+	openSQLiteTestDB(t)
+	if err := migrateUp(); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	stores = newSQLStores(db, dbDialect)
+	seed := func(email string) int64 {
+		id, err := stores.Users.Create(User{Email: email, PasswordHash: "x", UserType: "agent", IsVerified: true})
+		if err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+		return id
+	}
+	agentID, otherID := seed("agent@example.com"), seed("other@example.com")
+
+	clientID, err := stores.Clients.Create(Client{AgentUserID: agentID, Name: "Rajesh", Status: "Lead", CreatedAt: time.Now(),
+		Email: sql.NullString{String: "rajesh@example.com", Valid: true}})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if _, err := stores.Clients.GetByID(clientID, otherID); err != sql.ErrNoRows {
+		t.Fatalf("other agent's read: %v", err)
+	}
+	client, err := stores.Clients.GetByID(clientID, agentID)
+	if err != nil || client.Name != "Rajesh" {
+		t.Fatalf("get client = %+v, %v", client, err)
+	}
+	client.City = sql.NullString{String: "Pune", Valid: true}
+	if err := stores.Clients.Update(clientID, agentID, *client, client.Version); err != nil {
+		t.Fatalf("update client: %v", err)
+	}
+	if err := stores.Clients.Update(clientID, agentID, *client, client.Version); err != errVersionConflict {
+		t.Fatalf("update at a stale version: %v", err)
+	}
+
+	policyID, err := stores.Policies.Create(Policy{ClientID: clientID, AgentUserID: agentID, PolicyNumber: "FF-1", Status: "Active",
+		StartDate: sql.NullString{String: "2026-01-01", Valid: true}, EndDate: sql.NullString{String: "2027-01-01", Valid: true}})
+	if err != nil {
+		t.Fatalf("create policy: %v", err)
+	}
+	if _, err := stores.Tasks.Create(Task{ClientID: clientID, AgentUserID: agentID, Description: "Renewal call", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if _, err := stores.Tasks.Create(Task{ClientID: clientID, AgentUserID: otherID, Description: "Not mine", CreatedAt: time.Now()}); err != sql.ErrNoRows {
+		t.Fatalf("task for another agent's client: %v", err)
+	}
+
+	// Insured members are replaced as a set, reporting the ones they replaced.
+	householdID, err := stores.Households.Create(Household{AgentUserID: agentID, Name: "Sharmas", CreatedAt: time.Now(), Members: []HouseholdMember{
+		{ClientID: sql.NullInt64{Int64: clientID, Valid: true}, Name: "Rajesh", Relationship: "self"},
+		{Name: "Aarav", Relationship: "child"},
+	}})
+	if err != nil {
+		t.Fatalf("create household: %v", err)
+	}
+	household, err := stores.Households.GetByID(householdID, agentID)
+	if err != nil || len(household.Members) != 2 {
+		t.Fatalf("get household = %+v, %v", household, err)
+	}
+	self, child := household.Members[0].ID, household.Members[1].ID
+	if previous, err := stores.Households.SetInsuredMembers(policyID, clientID, agentID, []int64{child, self}); err != nil || len(previous) != 0 {
+		t.Fatalf("first insured members: %v, %v", previous, err)
+	}
+	if previous, err := stores.Households.SetInsuredMembers(policyID, clientID, agentID, []int64{child}); err != nil || fmt.Sprint(previous) != fmt.Sprint([]int64{self, child}) {
+		t.Fatalf("replaced insured members: %v, %v", previous, err)
+	}
+
+	change := EntityChange{AgentUserID: agentID, ClientID: clientID, Entity: changeEntityClient, EntityID: fmt.Sprint(clientID),
+		Action: changeActionUpdate, Field: "city", NewValue: sql.NullString{String: "Pune", Valid: true}, Source: changeSourceAPI, ChangedAt: time.Now()}
+	if err := stores.Changes.Record([]EntityChange{change}); err != nil {
+		t.Fatalf("record change: %v", err)
+	}
+	if changes, err := stores.Changes.ListByClient(clientID, agentID); err != nil || len(changes) != 1 || changes[0].NewValue.String != "Pune" {
+		t.Fatalf("changes = %+v, %v", changes, err)
+	}
+
+	// The trash takes the client's records with it and gives them back on restore.
+	if err := stores.Clients.SoftDelete(clientID, agentID, time.Now()); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	if tasks, err := stores.Tasks.ListByClient(clientID, agentID); err != nil || len(tasks) != 0 {
+		t.Fatalf("tasks in the trash = %+v, %v", tasks, err)
+	}
+	if err := stores.Clients.Restore(clientID, agentID); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if tasks, err := stores.Tasks.ListByClient(clientID, agentID); err != nil || len(tasks) != 1 {
+		t.Fatalf("restored tasks = %+v, %v", tasks, err)
+	}
+	if err := stores.Clients.Restore(clientID, agentID); err != sql.ErrNoRows {
+		t.Fatalf("restore outside the trash: %v", err)
+	}
+
+	if err := stores.Clients.SoftDelete(clientID, agentID, time.Now().AddDate(0, 0, -40)); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	if purged, _, err := stores.Clients.PurgeDeleted(time.Now().AddDate(0, 0, -30)); err != nil || purged != 1 {
+		t.Fatalf("purge = %d, %v", purged, err)
+	}
+	if _, err := stores.Households.GetByClient(clientID, agentID); err != sql.ErrNoRows {
+		t.Fatalf("purged client's household membership: %v", err)
+	}
+}
+
+func TestSQLiteClientTrash(t *testing.T) {
+	openSQLiteTestDB(t)
+	if err := migrateUp(); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	stores = newSQLStores(db, dbDialect)
+	agentID, err := stores.Users.Create(User{Email: "agent@example.com", PasswordHash: "x", UserType: "agent", IsVerified: true})
+	if err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	clientID, err := stores.Clients.Create(Client{AgentUserID: agentID, Name: "Asha Rao", Status: "Active", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	// Archiving passes the status check and keeps the client out of the default list.
+	if err := stores.Clients.SetStatus(clientID, agentID, clientStatusArchived); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if clients, err := stores.Clients.List(agentID, "", "", nil, "", 10, 0); err != nil || len(clients) != 0 {
+		t.Fatalf("default list = %+v, %v", clients, err)
+	}
+	if clients, err := stores.Clients.List(agentID, clientStatusArchived, "", nil, "", 10, 0); err != nil || len(clients) != 1 {
+		t.Fatalf("archived list = %+v, %v", clients, err)
+	}
+	if err := stores.Clients.SetStatus(clientID, agentID, "Active"); err != nil {
+		t.Fatalf("unarchive: %v", err)
+	}
+	if client, err := stores.Clients.GetByID(clientID, agentID); err != nil || client.Status != "Active" {
+		t.Fatalf("unarchived client = %+v, %v", client, err)
+	}
+
+	// Restore brings back only what was trashed together with the client, matched on the
+	// deleted_at stored for it.
+	keptID, err := stores.Tasks.Create(Task{ClientID: clientID, AgentUserID: agentID, Description: "Renewal call", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	droppedID, err := stores.Tasks.Create(Task{ClientID: clientID, AgentUserID: agentID, Description: "Old call", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE tasks SET deleted_at = ? WHERE id = ?`, time.Now().Add(-time.Hour), droppedID); err != nil {
+		t.Fatalf("trash task: %v", err)
+	}
+	deletedAt := time.Now()
+	if err := stores.Clients.SoftDelete(clientID, agentID, deletedAt); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	if _, err := stores.Clients.GetByID(clientID, agentID); err != sql.ErrNoRows {
+		t.Fatalf("trashed client read: %v", err)
+	}
+	if trash, err := stores.Clients.ListDeleted(agentID, deletedAt.Add(-clientTrashRetention)); err != nil || len(trash) != 1 || trash[0].ID != clientID {
+		t.Fatalf("trash = %+v, %v", trash, err)
+	}
+	if err := stores.Clients.Restore(clientID, agentID); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if tasks, err := stores.Tasks.ListByClient(clientID, agentID); err != nil || len(tasks) != 1 || tasks[0].ID != keptID {
+		t.Fatalf("restored tasks = %+v, %v", tasks, err)
+	}
+
+	// The purge job leaves the trash alone until the retention period is over.
+	if _, err := stores.Documents.Create(Document{ClientID: clientID, AgentUserID: agentID, Title: "ID", FileURL: "https://example.com/id.pdf"}); err != nil {
+		t.Fatalf("create document: %v", err)
+	}
+	if err := stores.Clients.SoftDelete(clientID, agentID, deletedAt); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	if n := purgeTrash(stores.Clients, t.TempDir(), deletedAt.Add(clientTrashRetention-time.Minute)); n != 0 {
+		t.Fatalf("purged %d clients inside the retention period", n)
+	}
+	if n := purgeTrash(stores.Clients, t.TempDir(), deletedAt.Add(clientTrashRetention+time.Minute)); n != 1 {
+		t.Fatalf("purged %d clients, want 1", n)
+	}
+	if err := stores.Clients.Restore(clientID, agentID); err != sql.ErrNoRows {
+		t.Fatalf("restored a purged client: %v", err)
+	}
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM tasks WHERE client_id = ?`, clientID).Scan(&left); err != nil || left != 0 {
+		t.Fatalf("%d tasks left after the purge, %v", left, err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// --- Tenant Guard ---
+// Everything under /api/clients/{clientId} runs behind clientScopeMiddleware, which loads the
+// client once, scoped to the calling agent, and answers 404 for anyone else's. Handlers take the
+// client from the request context instead of trusting the path parameter, and write with its
+// agent_user_id. The stores back this up: every method that reads or changes a client's data also
+// takes the agent, and creating policies, tasks, communications or documents fails with
+// sql.ErrNoRows when the client isn't that agent's.
+
+const clientKey contextKey = "client"
+
+// clientScopeMiddleware loads the {clientId} client for the authenticated agent into the context.
+func clientScopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentUserID, ok := getUserIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusInternalServerError, "Auth error")
+			return
+		}
+		clientID, err := strconv.ParseInt(chi.URLParam(r, "clientId"), 10, 64)
+		if err != nil || clientID <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid client ID in URL path")
+			return
+		}
+		client, err := stores.Clients.GetByID(clientID, agentUserID)
+		if err == sql.ErrNoRows {
+			log.Printf("AUTH: Agent %d refused access to client %d (%s %s)", agentUserID, clientID, r.Method, r.URL.Path)
+			respondError(w, http.StatusNotFound, "Client not found or not owned by agent")
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to get client %d: %v", clientID, err)
+			respondError(w, http.StatusInternalServerError, "Failed to retrieve client")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey, client)))
+	})
+}
+
+func getClientFromContext(ctx context.Context) (*Client, bool) {
+	client, ok := ctx.Value(clientKey).(*Client)
+	return client, ok
+}
+
+// scopedClient returns the client clientScopeMiddleware loaded. A handler mounted without the
+// middleware gets a 500 rather than silently running unscoped.
+func scopedClient(w http.ResponseWriter, r *http.Request) (*Client, bool) {
+	client, ok := getClientFromContext(r.Context())
+	if !ok {
+		log.Printf("ERROR: %s %s reached a client handler without clientScopeMiddleware", r.Method, r.URL.Path)
+		respondError(w, http.StatusInternalServerError, "Client scope missing")
+		return nil, false
+	}
+	return client, true
+}
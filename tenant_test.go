@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// TestClientRoutesRefuseOtherAgents walks the router, so a route added under {clientId} is
+// covered without touching the test.
+func TestClientRoutesRefuseOtherAgents(t *testing.T) {
+	s := newTestServer(t)
+	owner, other := tokenFor(t, 1, "agent"), tokenFor(t, 2, "agent")
+	c := s.createClient(owner, map[string]interface{}{"name": "Private Client", "email": "p@example.com"})
+
+	param := regexp.MustCompile(`\{[^}]+\}`)
+	routes := 0
+	err := chi.Walk(s.handler.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if !strings.Contains(route, "{clientId}") {
+			return nil
+		}
+		routes++
+		path := strings.TrimSuffix(strings.Replace(route, "{clientId}", fmt.Sprint(c.ID), 1), "/")
+		path = param.ReplaceAllString(path, "1")
+		rec := s.do(method, path, other, map[string]interface{}{})
+		// The guard's own message, so a typo'd path answered by the router's 404 doesn't pass
+		if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "not owned by agent") {
+			t.Errorf("%s %s by another agent: status %d, body %s", method, route, rec.Code, rec.Body.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk routes: %v", err)
+	}
+	if routes < 15 {
+		t.Fatalf("found only %d client routes", routes)
+	}
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d", c.ID), owner, nil, http.StatusOK, nil)
+}
+
+func TestOwnedRecordsRefuseOtherAgents(t *testing.T) {
+	s := newTestServer(t)
+	const ownerID = 1
+	owner, other := tokenFor(t, ownerID, "agent"), tokenFor(t, 2, "agent")
+	c := s.createClient(owner, map[string]interface{}{"name": "Private Client", "email": "p@example.com"})
+	var task Task
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/%d/tasks", c.ID), owner, map[string]string{"description": "Call back"}, http.StatusCreated, &task)
+	suggestionID, err := stores.Suggestions.Create(TaskSuggestion{AgentUserID: ownerID, ClientID: c.ID, Description: "Review cover", Status: "pending"})
+	if err != nil {
+		t.Fatalf("create suggestion: %v", err)
+	}
+	segmentID, err := stores.Marketing.CreateSegment(ClientSegment{AgentUserID: ownerID, Name: "All"})
+	if err != nil {
+		t.Fatalf("create segment: %v", err)
+	}
+	planID, err := stores.GoalPlans.Create(GoalPlan{AgentUserID: ownerID, PromptVersion: "test"})
+	if err != nil {
+		t.Fatalf("create goal plan: %v", err)
+	}
+	emailID, err := stores.Outbox.Enqueue(OutboxEmail{AgentUserID: sql.NullInt64{Int64: ownerID, Valid: true}, To: []string{"p@example.com"}, Subject: "Hi", NextAttemptAt: time.Now()})
+	if err != nil {
+		t.Fatalf("enqueue email: %v", err)
+	}
+
+	cases := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPut, "/api/task/status", map[string]interface{}{"taskId": task.ID, "status": "completed"}},
+		{http.MethodPost, fmt.Sprintf("/api/task-suggestions/%d/accept", suggestionID), map[string]interface{}{}},
+		{http.MethodPost, fmt.Sprintf("/api/task-suggestions/%d/reject", suggestionID), map[string]interface{}{}},
+		{http.MethodGet, fmt.Sprintf("/api/marketing/%d", segmentID), nil},
+		{http.MethodPut, fmt.Sprintf("/api/marketing/%d", segmentID), map[string]interface{}{"name": "Hijacked"}},
+		{http.MethodGet, fmt.Sprintf("/api/agents/goal-plans/%d", planID), nil},
+		{http.MethodGet, fmt.Sprintf("/api/emails/%d", emailID), nil},
+	}
+	for _, tc := range cases {
+		s.doJSON(tc.method, tc.path, other, tc.body, http.StatusNotFound, nil)
+	}
+
+	// Nothing changed for the owner.
+	var tasks []Task
+	s.doJSON(http.MethodGet, fmt.Sprintf("/api/clients/%d/tasks", c.ID), owner, nil, http.StatusOK, &tasks)
+	if len(tasks) != 1 || tasks[0].IsCompleted {
+		t.Fatalf("owner's tasks = %+v", tasks)
+	}
+	s.doJSON(http.MethodPut, "/api/task/status", owner, map[string]interface{}{"taskId": task.ID, "status": "completed"}, http.StatusOK, nil)
+}
+
+func TestStoresRefuseWritesToOtherAgentsClients(t *testing.T) {
+	newTestServer(t)
+	clientID, err := stores.Clients.Create(Client{AgentUserID: 1, Name: "Private Client", Status: "Lead"})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if _, err := stores.Tasks.Create(Task{ClientID: clientID, AgentUserID: 2, Description: "x"}); err != sql.ErrNoRows {
+		t.Errorf("task for another agent's client: err = %v", err)
+	}
+	if _, err := stores.Policies.Create(Policy{ClientID: clientID, AgentUserID: 2, PolicyNumber: "P-1"}); err != sql.ErrNoRows {
+		t.Errorf("policy for another agent's client: err = %v", err)
+	}
+	if _, err := stores.Communications.Create(Communication{ClientID: clientID, AgentUserID: 2, Type: "Call"}); err != sql.ErrNoRows {
+		t.Errorf("communication for another agent's client: err = %v", err)
+	}
+	if _, err := stores.Documents.Create(Document{ClientID: clientID, AgentUserID: 2, Title: "ID"}); err != sql.ErrNoRows {
+		t.Errorf("document for another agent's client: err = %v", err)
+	}
+	if _, err := stores.Tasks.Create(Task{ClientID: clientID, AgentUserID: 1, Description: "x"}); err != nil {
+		t.Errorf("task for own client: %v", err)
+	}
+}
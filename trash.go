@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Trash & Archive ---
+// Deleting a client only moves it to the trash: the client and its policies, communications,
+// tasks and documents get a deleted_at and drop out of every read. The agent can restore it from
+// the trash for clientTrashRetention; after that the purge job deletes the rows for good and
+// removes their uploaded files. Archiving is different: an archived client is kept and stays
+// readable, it is just left out of the client list unless asked for.
+
+// clientStatusArchived is the status of a client the agent no longer works with. Unlike
+// "Lapsed", which says the client's cover ran out, it is set only by the agent.
+const clientStatusArchived = "Archived"
+
+const clientTrashRetention = 30 * 24 * time.Hour
+const trashPurgeInterval = time.Hour
+
+// purgeTrash permanently deletes clients trashed longer than clientTrashRetention before now and
+// removes their files from uploadDir. It returns how many clients were purged.
+func purgeTrash(clients ClientStore, uploadDir string, now time.Time) int {
+	purged, files, err := clients.PurgeDeleted(now.Add(-clientTrashRetention))
+	if err != nil {
+		log.Printf("ERROR: Failed to purge trashed clients: %v", err)
+		return 0
+	}
+	for _, file := range files {
+		removeUploadedFile(uploadDir, file)
+	}
+	if purged > 0 {
+		log.Printf("TRASH: Purged %d clients and %d files", purged, len(files))
+	}
+	return purged
+}
+
+// removeUploadedFile deletes path if it lies inside uploadDir. Policy documents may link to files
+// hosted elsewhere, which are left alone.
+func removeUploadedFile(uploadDir, path string) {
+	if path == "" || uploadDir == "" {
+		return
+	}
+	rel, err := filepath.Rel(uploadDir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("WARN: Failed to remove purged file %s: %v", path, err)
+	}
+}
+
+// runTrashPurger purges the trash every trashPurgeInterval until ctx is cancelled.
+func runTrashPurger(ctx context.Context, clients ClientStore, uploadDir string) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purgeTrash(clients, uploadDir, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientTrash(t *testing.T) {
+	s := newTestServer(t)
+	const agentID = 1
+	agent := tokenFor(t, agentID, "agent")
+	c := s.createClient(agent, map[string]interface{}{"name": "Asha Rao", "email": "asha@example.com"})
+	clientPath := fmt.Sprintf("/api/clients/%d", c.ID)
+	s.doJSON(http.MethodPost, clientPath+"/tasks", agent, map[string]string{"description": "Call back"}, http.StatusCreated, nil)
+
+	s.doJSON(http.MethodDelete, clientPath, agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, clientPath, agent, nil, http.StatusNotFound, nil)
+	s.doJSON(http.MethodDelete, clientPath, agent, nil, http.StatusNotFound, nil)
+	var clients []Client
+	s.doJSON(http.MethodGet, "/api/clients", agent, nil, http.StatusOK, &clients)
+	var tasks PaginatedResponse
+	s.doJSON(http.MethodGet, "/api/tasks", agent, nil, http.StatusOK, &tasks)
+	if len(clients) != 0 || tasks.TotalItems != 0 {
+		t.Fatalf("after delete: clients %+v, %d tasks", clients, tasks.TotalItems)
+	}
+
+	var trash []TrashedClient
+	s.doJSON(http.MethodGet, "/api/clients/trash", agent, nil, http.StatusOK, &trash)
+	if len(trash) != 1 || trash[0].ID != c.ID || !trash[0].PurgeAt.Equal(trash[0].DeletedAt.Add(clientTrashRetention)) {
+		t.Fatalf("trash = %+v", trash)
+	}
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/trash/%d/restore", c.ID), tokenFor(t, 2, "agent"), nil, http.StatusNotFound, nil)
+
+	// Restoring brings back what was deleted with the client.
+	s.doJSON(http.MethodPost, fmt.Sprintf("/api/clients/trash/%d/restore", c.ID), agent, nil, http.StatusOK, nil)
+	var restored []Task
+	s.doJSON(http.MethodGet, clientPath+"/tasks", agent, nil, http.StatusOK, &restored)
+	if len(restored) != 1 {
+		t.Fatalf("restored tasks = %+v", restored)
+	}
+	s.doJSON(http.MethodGet, "/api/clients/trash", agent, nil, http.StatusOK, &trash)
+	if len(trash) != 0 {
+		t.Fatalf("trash after restore = %+v", trash)
+	}
+
+	// Archived clients stay readable but leave the default list.
+	s.doJSON(http.MethodPost, clientPath+"/archive", agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, clientPath, agent, nil, http.StatusOK, nil)
+	s.doJSON(http.MethodGet, "/api/clients", agent, nil, http.StatusOK, &clients)
+	if len(clients) != 0 {
+		t.Fatalf("default list shows archived clients: %+v", clients)
+	}
+	s.doJSON(http.MethodGet, "/api/clients?status="+clientStatusArchived, agent, nil, http.StatusOK, &clients)
+	if len(clients) != 1 || clients[0].Status != clientStatusArchived {
+		t.Fatalf("archived clients = %+v", clients)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	newTestServer(t)
+	uploadDir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "elsewhere.pdf")
+	file := filepath.Join(uploadDir, "1_1_abc.pdf")
+	for _, path := range []string{file, outside} {
+		if err := os.WriteFile(path, []byte("%PDF"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clientID, err := stores.Clients.Create(Client{AgentUserID: 1, Name: "Asha Rao", Status: "Lead"})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if _, err := stores.Documents.Create(Document{ClientID: clientID, AgentUserID: 1, Title: "ID", FileURL: file}); err != nil {
+		t.Fatalf("create document: %v", err)
+	}
+	if _, err := stores.Documents.Create(Document{ClientID: clientID, AgentUserID: 1, Title: "Linked", FileURL: outside}); err != nil {
+		t.Fatalf("create document: %v", err)
+	}
+	deletedAt := time.Now()
+	if err := stores.Clients.SoftDelete(clientID, 1, deletedAt); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+
+	if n := purgeTrash(stores.Clients, uploadDir, deletedAt.Add(clientTrashRetention-time.Minute)); n != 0 {
+		t.Fatalf("purged %d clients inside the retention period", n)
+	}
+	if n := purgeTrash(stores.Clients, uploadDir, deletedAt.Add(clientTrashRetention+time.Minute)); n != 1 {
+		t.Fatalf("purged %d clients, want 1", n)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("uploaded file survived the purge: %v", err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside the upload directory was touched: %v", err)
+	}
+	if err := stores.Clients.Restore(clientID, 1); err == nil {
+		t.Fatal("restored a purged client")
+	}
+}
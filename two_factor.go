@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// --- Two-Factor Authentication ---
+// Optional TOTP (RFC 6238, HMAC-SHA1, 6 digits, 30 second steps) on top of the password. A user
+// enrolls by scanning the otpauth URI and confirming a first code, which enables 2FA and returns a
+// set of single-use recovery codes (stored only as hashes). Once enabled, handleLogin answers a
+// correct password with a short-lived challenge token instead of a session; the session starts
+// when the challenge is completed with a code at /auth/2fa/login. An agency can require 2FA for
+// its member agents, in which case an agent without it is walked through enrollment at login and
+// can't switch it off.
+
+const (
+	totpIssuer            = "ClientWise"
+	totpDigits            = 6
+	totpPeriod            = 30 // Seconds per step
+	totpSkewSteps         = 1  // Steps accepted either side of now, for clock drift
+	totpSecretBytes       = 20 // 160 bits, as RFC 4226 recommends
+	recoveryCodeCount     = 10
+	recoveryCodeBytes     = 10 // 80 bits, shown as four groups of four characters
+	twoFactorChallengeTTL = 5 * time.Minute
+	twoFactorChallengeAud = "clientwise-2fa-challenge"
+)
+
+// errTwoFactorSetupRequired is returned when a user's agency requires 2FA and they haven't enabled it.
+var errTwoFactorSetupRequired = errors.New("two-factor authentication must be set up")
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorEnrollment is returned when enrollment starts; the app is set up from either field.
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+// newTOTPSecret returns a random secret in the Base32 form authenticator apps expect.
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// totpURI builds the otpauth:// URI (Key Uri Format) shown as a QR code during enrollment.
+func totpURI(email, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+email) + "?" + params.Encode()
+}
+
+// totpStep returns the RFC 6238 time step containing t.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode computes the code for one time step (RFC 4226 dynamic truncation).
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verifyTOTP checks code against secret around now and returns the step it matched. The caller
+// must still record the step (TwoFactorStore.UseStep) so the same code can't be used twice.
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkewSteps; step <= current+totpSkewSteps; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns fresh recovery codes for the user and their hashes for storage.
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		plain := strings.ToLower(base32NoPadding.EncodeToString(raw)) // 16 characters
+		codes = append(codes, plain[0:4]+"-"+plain[4:8]+"-"+plain[8:12]+"-"+plain[12:16])
+		hashes = append(hashes, hashRecoveryCode(plain))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the hex SHA-256 of a recovery code, ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// twoFactorStatus reports whether the user has 2FA enabled and whether their agency requires it.
+func twoFactorStatus(user *User) (enabled, required bool, err error) {
+	tf, err := stores.TwoFactor.Get(user.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return false, false, err
+	}
+	enabled = err == nil && tf.IsEnabled
+	if user.UserType == "agent" {
+		if required, err = stores.Agencies.RequiresTwoFactor(user.ID); err != nil {
+			return false, false, err
+		}
+	}
+	return enabled, required, nil
+}
+
+// checkTwoFactorCode verifies a TOTP code or, failing that, a recovery code against the user's
+// enabled 2FA and consumes it. It reports whether either was accepted.
+func checkTwoFactorCode(userID int64, tf *TwoFactor, code, recoveryCode string, now time.Time) (bool, error) {
+	if code != "" {
+		step, ok := verifyTOTP(tf.Secret, code, now)
+		if !ok {
+			return false, nil
+		}
+		return stores.TwoFactor.UseStep(userID, step)
+	}
+	if recoveryCode != "" {
+		return stores.TwoFactor.UseRecoveryCode(userID, hashRecoveryCode(recoveryCode), now)
+	}
+	return false, nil
+}
+
+// startTwoFactorEnrollment generates a secret for user and saves it pending confirmation.
+func startTwoFactorEnrollment(user *User) (TwoFactorEnrollment, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	if err := stores.TwoFactor.SavePending(user.ID, secret); err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	return TwoFactorEnrollment{Secret: secret, OtpauthURI: totpURI(user.Email, secret)}, nil
+}
+
+// confirmTwoFactor enables a pending enrollment if code matches its secret and returns the new
+// recovery codes, or nil if the code was wrong.
+func confirmTwoFactor(userID int64, tf *TwoFactor, code string, now time.Time) ([]string, error) {
+	step, ok := verifyTOTP(tf.Secret, code, now)
+	if !ok {
+		return nil, nil
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = stores.TwoFactor.Enable(userID, step, hashes, now)
+	if err == sql.ErrNoRows { // Confirmed concurrently, or replaced by a new enrollment
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// twoFactorChallengeClaims identify a user who has passed the password check but not yet 2FA. The
+// audience keeps them from being accepted anywhere else, and they name no session, so
+// authMiddleware refuses them too.
+type twoFactorChallengeClaims struct {
+	UserID     int64  `json:"user_id"`
+	DeviceName string `json:"device_name,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// signTwoFactorChallenge issues the challenge token handleLogin returns when a code is needed.
+func signTwoFactorChallenge(userID int64, deviceName string, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(twoFactorChallengeTTL)
+	claims := &twoFactorChallengeClaims{UserID: userID, DeviceName: deviceName, RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    "clientwise",
+		Audience:  jwt.ClaimStrings{twoFactorChallengeAud},
+		Subject:   fmt.Sprintf("%d", userID),
+	}}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecretKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign 2FA challenge: %w", err)
+	}
+	return signed, expiresAt, nil
+}
+
+// parseTwoFactorChallenge validates a challenge token and returns its claims.
+func parseTwoFactorChallenge(token string) (*twoFactorChallengeClaims, error) {
+	claims := &twoFactorChallengeClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtSecretKey, nil
+	}, jwt.WithAudience(twoFactorChallengeAud), jwt.WithIssuer("clientwise"))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1), truncated to six digits.
+	key := []byte("12345678901234567890")
+	cases := map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"}
+	for unix, want := range cases {
+		if got := totpCode(key, totpStep(time.Unix(unix, 0))); got != want {
+			t.Errorf("totpCode at %d = %s, want %s", unix, got, want)
+		}
+	}
+
+	secret := base32NoPadding.EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+	if step, ok := verifyTOTP(secret, "081 804", now.Add(totpPeriod*time.Second)); !ok || step != totpStep(now) {
+		t.Errorf("verifyTOTP rejected the previous step's code (step %d, ok %v)", step, ok)
+	}
+	if _, ok := verifyTOTP(secret, "081804", now.Add(2*totpPeriod*time.Second)); ok {
+		t.Error("verifyTOTP accepted a code two steps old")
+	}
+
+	uri, err := url.Parse(totpURI("agent@example.com", secret))
+	if err != nil || uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != totpIssuer {
+		t.Fatalf("totpURI = %v (%v)", uri, err)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	s := newTestServer(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret-pass"), bcrypt.MinCost)
+	seed := func(email, userType string) int64 {
+		id, err := stores.Users.Create(User{Email: email, PasswordHash: string(hash), UserType: userType, IsVerified: true})
+		if err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+		return id
+	}
+	agentID := seed("agent@example.com", "agent")
+	type loginResponse struct {
+		SessionTokens
+		TwoFactorRequired      bool
+		TwoFactorSetupRequired bool
+		ChallengeToken         string
+		RecoveryCodes          []string
+	}
+	login := func(email string) loginResponse {
+		var resp loginResponse
+		s.doJSON(http.MethodPost, "/login", "", map[string]string{"email": email, "password": "s3cret-pass", "userType": "agent"}, http.StatusOK, &resp)
+		return resp
+	}
+	codeAt := func(secret string, at time.Time) string {
+		key, _ := base32NoPadding.DecodeString(secret)
+		return totpCode(key, totpStep(at))
+	}
+
+	// Enroll: the secret only takes effect once a code from it is confirmed.
+	plain := login("agent@example.com")
+	var enrollment TwoFactorEnrollment
+	s.doJSON(http.MethodPost, "/auth/2fa/enroll", plain.Token, nil, http.StatusOK, &enrollment)
+	if !strings.HasPrefix(enrollment.OtpauthURI, "otpauth://totp/") || enrollment.Secret == "" {
+		t.Fatalf("enrollment = %+v", enrollment)
+	}
+	if resp := login("agent@example.com"); resp.Token == "" {
+		t.Fatal("pending enrollment already required a code")
+	}
+	now := time.Now()
+	confirmCode := codeAt(enrollment.Secret, now)
+	var confirmed struct{ RecoveryCodes []string }
+	s.doJSON(http.MethodPost, "/auth/2fa/confirm", plain.Token, map[string]string{"code": "000000"}, http.StatusBadRequest, nil)
+	s.doJSON(http.MethodPost, "/auth/2fa/confirm", plain.Token, map[string]string{"code": confirmCode}, http.StatusOK, &confirmed)
+	if len(confirmed.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(confirmed.RecoveryCodes))
+	}
+
+	// Login now stops at a challenge that can't be used as an access token.
+	challenge := login("agent@example.com")
+	if challenge.Token != "" || !challenge.TwoFactorRequired || challenge.ChallengeToken == "" {
+		t.Fatalf("login with 2FA = %+v", challenge)
+	}
+	s.doJSON(http.MethodGet, "/api/clients", challenge.ChallengeToken, nil, http.StatusUnauthorized, nil)
+	s.doJSON(http.MethodPost, "/auth/2fa/login", "", map[string]string{"challengeToken": plain.Token, "code": codeAt(enrollment.Secret, now)}, http.StatusUnauthorized, nil)
+	// The confirming code can't be replayed; the next step's code works.
+	s.doJSON(http.MethodPost, "/auth/2fa/login", "", map[string]string{"challengeToken": challenge.ChallengeToken, "code": confirmCode}, http.StatusUnauthorized, nil)
+	var completed loginResponse
+	s.doJSON(http.MethodPost, "/auth/2fa/login", "", map[string]string{"challengeToken": challenge.ChallengeToken, "code": codeAt(enrollment.Secret, now.Add(totpPeriod*time.Second))}, http.StatusOK, &completed)
+	if completed.Token == "" || completed.RefreshToken == "" {
+		t.Fatalf("2FA login = %+v", completed)
+	}
+	s.doJSON(http.MethodGet, "/api/clients", completed.Token, nil, http.StatusOK, nil)
+
+	// Recovery codes work once each, in any case and spacing.
+	recovery := map[string]string{"challengeToken": login("agent@example.com").ChallengeToken, "recoveryCode": strings.ToUpper(confirmed.RecoveryCodes[0])}
+	s.doJSON(http.MethodPost, "/auth/2fa/login", "", recovery, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, "/auth/2fa/login", "", recovery, http.StatusUnauthorized, nil)
+	var status struct {
+		Enabled                bool
+		RecoveryCodesRemaining int
+	}
+	s.doJSON(http.MethodGet, "/auth/2fa", completed.Token, nil, http.StatusOK, &status)
+	if !status.Enabled || status.RecoveryCodesRemaining != recoveryCodeCount-1 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// An agency requiring 2FA stops its agents from disabling it and locks out those without it.
+	agencyID := seed("agency@example.com", "agency")
+	memberID := seed("member@example.com", "agent")
+	for _, id := range []int64{agentID, memberID} {
+		if err := stores.Agencies.AddMember(agencyID, id); err != nil {
+			t.Fatalf("add member: %v", err)
+		}
+	}
+	member := login("member@example.com")
+	agencyToken := tokenFor(t, agencyID, "agency")
+	s.doJSON(http.MethodPut, "/api/agency/settings", completed.Token, map[string]bool{"requireTwoFactor": true}, http.StatusForbidden, nil)
+	s.doJSON(http.MethodPut, "/api/agency/settings", agencyToken, map[string]bool{"requireTwoFactor": true}, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, "/auth/2fa/disable", completed.Token, map[string]string{"recoveryCode": confirmed.RecoveryCodes[1]}, http.StatusForbidden, nil)
+	s.doJSON(http.MethodPost, "/auth/refresh", "", map[string]string{"refreshToken": member.RefreshToken}, http.StatusForbidden, nil)
+	s.doJSON(http.MethodGet, "/api/clients", member.Token, nil, http.StatusUnauthorized, nil)
+
+	// The member enrolls as part of logging in.
+	setup := login("member@example.com")
+	if setup.Token != "" || !setup.TwoFactorSetupRequired {
+		t.Fatalf("login without required 2FA = %+v", setup)
+	}
+	var memberEnrollment TwoFactorEnrollment
+	s.doJSON(http.MethodPost, "/auth/2fa/login/setup", "", map[string]string{"challengeToken": setup.ChallengeToken}, http.StatusOK, &memberEnrollment)
+	var enrolled loginResponse
+	s.doJSON(http.MethodPost, "/auth/2fa/login", "", map[string]string{"challengeToken": setup.ChallengeToken, "code": codeAt(memberEnrollment.Secret, time.Now())}, http.StatusOK, &enrolled)
+	if enrolled.Token == "" || len(enrolled.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("enforced enrollment login = %+v", enrolled)
+	}
+	s.doJSON(http.MethodPost, "/auth/2fa/login/setup", "", map[string]string{"challengeToken": login("member@example.com").ChallengeToken}, http.StatusConflict, nil)
+
+	// Once the agency relaxes the rule, 2FA can be turned off.
+	s.doJSON(http.MethodPut, "/api/agency/settings", agencyToken, map[string]bool{"requireTwoFactor": false}, http.StatusOK, nil)
+	s.doJSON(http.MethodPost, "/auth/2fa/disable", completed.Token, map[string]string{"recoveryCode": confirmed.RecoveryCodes[1]}, http.StatusOK, nil)
+	if resp := login("agent@example.com"); resp.Token == "" {
+		t.Fatal("login still required a code after 2FA was disabled")
+	}
+}